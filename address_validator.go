@@ -0,0 +1,176 @@
+// -----------------------------------------------------------------------------
+// github.com/balacode/udpt                              /[address_validator.go]
+// (c) balarabe@protonmail.com                                      License: MIT
+// -----------------------------------------------------------------------------
+
+package udpt
+
+// # Type
+//   addressValidator struct
+//
+// # Methods (av *addressValidator)
+//   ) Init(lifetime time.Duration) error
+//   ) MakeCookie(addr net.Addr, now time.Time) []byte
+//   ) Validate(recv []byte, addr net.Addr, now time.Time) ([]byte, bool)
+//   ) ForgetExpired(now time.Time)
+//
+// # Internal Methods (av *addressValidator)
+//   ) cookieMAC(host string, expiry []byte) []byte
+//
+// # Helper Function
+//   addrHost(addr net.Addr) string
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+	"net"
+	"time"
+)
+
+// cookieSize is the size of the token in a tagCookie packet: 8 bytes
+// containing the expiry time and 16 bytes of the HMAC signature.
+const cookieSize = 8 + 16
+
+// cookiePrefixSize is the size of the prefix (tagCookie and the cookie)
+// that a Sender inserts before each packet once it has a cookie
+const cookiePrefixSize = len(tagCookie) + cookieSize
+
+// addressValidator protects the Receiver from being used to reflect
+// and amplify traffic towards third parties using spoofed addresses.
+//
+// Before a Receiver processes packets from an unknown host, it replies
+// with a small signed token (a "cookie", similar to a QUIC retry token).
+// The Sender must prefix the following packets with the cookie, which
+// proves it can receive packets at the address it claims to be using.
+//
+// The cookie is stateless: the Receiver only needs its secret to verify
+// it. Hosts are only remembered after they echo a valid cookie.
+//
+type addressValidator struct {
+	lifetime   time.Duration
+	secret     []byte
+	validHosts map[string]time.Time
+	lastSweep  time.Time
+} //                                                            addressValidator
+
+// -----------------------------------------------------------------------------
+// # Methods (av *addressValidator)
+
+// Init generates a new random secret for signing cookies, forgets
+// all validated hosts and sets the lifetime of new cookies.
+func (av *addressValidator) Init(lifetime time.Duration) error {
+	return av.initDI(lifetime, rand.Reader)
+} //                                                                        Init
+
+// initDI is only used by Init() and provides parameters
+// for dependency injection, to enable mocking during testing.
+func (av *addressValidator) initDI(
+	lifetime time.Duration,
+	random io.Reader,
+) error {
+	secret := make([]byte, 32)
+	_, err := io.ReadFull(random, secret)
+	if err != nil {
+		return makeError(0xEC3D6B, err)
+	}
+	av.lifetime = lifetime
+	av.secret = secret
+	av.validHosts = make(map[string]time.Time)
+	av.lastSweep = time.Time{}
+	return nil
+} //                                                                      initDI
+
+// MakeCookie returns a tagCookie packet containing a token
+// which is valid for the host of 'addr' until lifetime expires.
+func (av *addressValidator) MakeCookie(addr net.Addr, now time.Time) []byte {
+	expiry := make([]byte, 8)
+	binary.BigEndian.PutUint64(expiry, uint64(now.Add(av.lifetime).Unix()))
+	ret := append([]byte(tagCookie), expiry...)
+	ret = append(ret, av.cookieMAC(addrHost(addr), expiry)...)
+	return ret
+} //                                                                  MakeCookie
+
+// Validate checks if the host of 'addr' is validated, either because it
+// recently echoed a valid cookie, or because 'recv' starts with one.
+//
+// Returns 'recv' without the echoed cookie prefix (if there is one)
+// and true if the host is validated, or false if it isn't.
+//
+func (av *addressValidator) Validate(recv []byte, addr net.Addr, now time.Time,
+) ([]byte, bool) {
+	var (
+		host    = addrHost(addr)
+		expiry  = av.validHosts[host]
+		isValid = now.Before(expiry)
+	)
+	if bytes.HasPrefix(recv, []byte(tagCookie)) {
+		token := recv[len(tagCookie):]
+		if len(token) < cookieSize {
+			return recv, isValid
+		}
+		recv = token[cookieSize:]
+		token = token[:cookieSize]
+		mac := av.cookieMAC(host, token[:8])
+		until := time.Unix(int64(binary.BigEndian.Uint64(token[:8])), 0)
+		if hmac.Equal(mac, token[8:]) && now.Before(until) {
+			if until.After(expiry) {
+				av.validHosts[host] = until
+			}
+			isValid = true
+		}
+	}
+	return recv, isValid
+} //                                                                    Validate
+
+// ForgetExpired removes hosts whose validation has expired. To avoid
+// scanning all hosts after every packet, it only runs every quarter
+// of the cookie lifetime.
+func (av *addressValidator) ForgetExpired(now time.Time) {
+	if now.Sub(av.lastSweep) < av.lifetime/4 {
+		return
+	}
+	av.lastSweep = now
+	for host, expiry := range av.validHosts {
+		if !now.Before(expiry) {
+			delete(av.validHosts, host)
+		}
+	}
+} //                                                               ForgetExpired
+
+// -----------------------------------------------------------------------------
+// # Internal Methods (av *addressValidator)
+
+// cookieMAC returns the truncated HMAC-SHA256 signature of a cookie
+// for 'host' expiring at time 'expiry' (Unix seconds, 8 bytes).
+func (av *addressValidator) cookieMAC(host string, expiry []byte) []byte {
+	mac := hmac.New(sha256.New, av.secret)
+	mac.Write([]byte(host))
+	mac.Write(expiry)
+	return mac.Sum(nil)[:cookieSize-8]
+} //                                                                   cookieMAC
+
+// -----------------------------------------------------------------------------
+// # Helper Function
+
+// addrHost returns the IP address (or host name) part of 'addr', without
+// the port number. Cookies are bound to the host only, because a Sender
+// may use a different local port for each Send().
+func addrHost(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	if udpAddr, ok := addr.(*net.UDPAddr); ok {
+		return udpAddr.IP.String()
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+} //                                                                    addrHost
+
+// end
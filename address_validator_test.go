@@ -0,0 +1,169 @@
+// -----------------------------------------------------------------------------
+// github.com/balacode/udpt                         /[address_validator_test.go]
+// (c) balarabe@protonmail.com                                      License: MIT
+// -----------------------------------------------------------------------------
+
+package udpt
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// to run all tests in this file:
+// go test -v -run Test_addressValidator_*
+
+// -----------------------------------------------------------------------------
+
+// newTestAddressValidator creates an initialized addressValidator for testing
+func newTestAddressValidator(t *testing.T) *addressValidator {
+	var av addressValidator
+	err := av.Init(time.Minute)
+	if err != nil {
+		t.Fatal("0xE02091", err)
+	}
+	return &av
+}
+
+// (av *addressValidator) Init(lifetime time.Duration) error
+//
+// go test -run Test_addressValidator_Init_
+//
+func Test_addressValidator_Init_(t *testing.T) {
+	av := newTestAddressValidator(t)
+	if len(av.secret) != 32 || av.lifetime != time.Minute {
+		t.Error("0xEA9C04")
+	}
+	// must fail when random bytes can't be read
+	err := av.initDI(time.Minute, strings.NewReader("short"))
+	if !matchError(err, "EOF") {
+		t.Error("0xE70105", "wrong error:", err)
+	}
+}
+
+// (av *addressValidator) MakeCookie(addr net.Addr, now time.Time) []byte
+// (av *addressValidator) Validate(recv []byte, addr net.Addr, now time.Time,
+// ) ([]byte, bool)
+//
+// go test -run Test_addressValidator_Validate_*
+
+// an echoed cookie must validate the host it was issued for
+func Test_addressValidator_Validate_1(t *testing.T) {
+	var (
+		av    = newTestAddressValidator(t)
+		now   = time.Now()
+		addr1 = &net.UDPAddr{IP: []byte{10, 0, 0, 1}, Port: 1234}
+		addr2 = &net.UDPAddr{IP: []byte{10, 0, 0, 1}, Port: 5678}
+		data  = []byte(tagFragment + "...")
+	)
+	// unknown host without a cookie is not valid
+	recv, ok := av.Validate(data, addr1, now)
+	if ok || !bytes.Equal(recv, data) {
+		t.Error("0xE4EF74")
+	}
+	cookie := av.MakeCookie(addr1, now)
+	if len(cookie) != len(tagCookie)+cookieSize ||
+		!bytes.HasPrefix(cookie, []byte(tagCookie)) {
+		t.Error("0xEAD9BA")
+	}
+	// echoed cookie validates the host and is stripped from the data
+	recv, ok = av.Validate(append(cookie, data...), addr1, now)
+	if !ok || !bytes.Equal(recv, data) {
+		t.Error("0xE39E50")
+	}
+	// the host remains valid, even when it uses a different port
+	recv, ok = av.Validate(data, addr2, now.Add(time.Second))
+	if !ok || !bytes.Equal(recv, data) {
+		t.Error("0xEB0900")
+	}
+	// until the cookie expires
+	_, ok = av.Validate(data, addr1, now.Add(2*time.Minute))
+	if ok {
+		t.Error("0xE302D2")
+	}
+}
+
+// a cookie must not validate a different host, a tampered or expired cookie
+func Test_addressValidator_Validate_2(t *testing.T) {
+	var (
+		av    = newTestAddressValidator(t)
+		now   = time.Now()
+		addr1 = &net.UDPAddr{IP: []byte{10, 0, 0, 1}, Port: 1234}
+		addr2 = &net.UDPAddr{IP: []byte{10, 0, 0, 2}, Port: 1234}
+		data  = []byte(tagFragment + "...")
+	)
+	cookie := av.MakeCookie(addr1, now)
+	//
+	recv, ok := av.Validate(append(cookie, data...), addr2, now)
+	if ok || !bytes.Equal(recv, data) {
+		t.Error("0xE94DBE", "cookie for another host")
+	}
+	tampered := append([]byte{}, cookie...)
+	tampered[len(tampered)-1] ^= 1
+	_, ok = av.Validate(append(tampered, data...), addr1, now)
+	if ok {
+		t.Error("0xE80C99", "tampered cookie")
+	}
+	_, ok = av.Validate(append(cookie, data...), addr1, now.Add(time.Hour))
+	if ok {
+		t.Error("0xE090FB", "expired cookie")
+	}
+	_, ok = av.Validate([]byte(tagCookie+"short"), addr1, now)
+	if ok {
+		t.Error("0xE1FF11", "truncated cookie")
+	}
+	// a cookie signed with another secret is not valid
+	other := newTestAddressValidator(t)
+	_, ok = other.Validate(append(cookie, data...), addr1, now)
+	if ok {
+		t.Error("0xE4593C", "another secret")
+	}
+}
+
+// (av *addressValidator) ForgetExpired(now time.Time)
+//
+// go test -run Test_addressValidator_ForgetExpired_
+//
+func Test_addressValidator_ForgetExpired_(t *testing.T) {
+	var (
+		av   = newTestAddressValidator(t)
+		now  = time.Now()
+		addr = &net.UDPAddr{IP: []byte{10, 0, 0, 1}, Port: 1234}
+	)
+	av.Validate(av.MakeCookie(addr, now), addr, now)
+	av.ForgetExpired(now)
+	if len(av.validHosts) != 1 {
+		t.Error("0xEA8597")
+	}
+	av.ForgetExpired(now.Add(2 * time.Minute))
+	if len(av.validHosts) != 0 {
+		t.Error("0xE86EFE")
+	}
+}
+
+// addrHost(addr net.Addr) string
+//
+// go test -run Test_addrHost_
+//
+func Test_addrHost_(t *testing.T) {
+	for _, tc := range []struct {
+		addr net.Addr
+		want string
+	}{
+		{nil, ""},
+		{&net.UDPAddr{IP: []byte{127, 0, 0, 1}, Port: 9876}, "127.0.0.1"},
+		{&net.UDPAddr{IP: net.ParseIP("::1"), Port: 9876}, "::1"},
+		{&mockNetAddr{network: "udp", addr: "host:123"}, "host"},
+		{&mockNetAddr{network: "udp", addr: "noport"}, "noport"},
+	} {
+		got := addrHost(tc.addr)
+		if got != tc.want {
+			t.Error("0xED9F7E", "want:", tc.want, "got:", got)
+		}
+	}
+}
+
+// end
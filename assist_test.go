@@ -6,10 +6,14 @@
 package udpt
 
 import (
+	"math/rand"
 	"net"
 	"strings"
+	"sync"
 	"testing"
 	"time"
+
+	"github.com/balacode/udpt/udptest"
 )
 
 // makeTestConn creates a UDP connection for testing.
@@ -33,6 +37,68 @@ func matchError(err error, msg string) bool {
 	return err != nil && strings.Contains(err.Error(), msg)
 }
 
+// randomBytes returns 'n' random bytes, which can't be compressed
+func randomBytes(seed int64, n int) []byte {
+	ret := make([]byte, n)
+	_, _ = rand.New(rand.NewSource(seed)).Read(ret)
+	return ret
+}
+
+// -----------------------------------------------------------------------------
+
+// testDeliveries records the items received by each Receiver
+type testDeliveries struct {
+	mu    sync.Mutex
+	items map[string][][]byte // values received, by "address key"
+}
+
+// get returns the values that the Receiver at 'addr' received for key 'k'
+func (td *testDeliveries) get(addr, k string) [][]byte {
+	td.mu.Lock()
+	defer td.mu.Unlock()
+	return td.items[addr+" "+k]
+}
+
+// runTestReceivers starts a Receiver listening on port 9876 on each of
+// the hosts 'ips' of network 'nw'. 'setup' can change each Receiver
+// before it runs. Returns the addresses of the Receivers, their
+// deliveries and a function that stops them.
+func runTestReceivers(
+	nw *udptest.Network,
+	ips []string,
+	setup func(rc *Receiver),
+) (addrs []string, td *testDeliveries, stop func()) {
+	td = &testDeliveries{items: make(map[string][][]byte)}
+	var receivers []*Receiver
+	for _, ip := range ips {
+		addr := ip + ":9876"
+		cf := NewDefaultConfig()
+		cf.Transport = nw.Host(ip)
+		rc := &Receiver{Address: ":9876", CryptoKey: []byte(testAESKey),
+			Config: cf,
+			Receive: func(k string, v []byte) error {
+				td.mu.Lock()
+				td.items[addr+" "+k] = append(td.items[addr+" "+k], v)
+				td.mu.Unlock()
+				return nil
+			},
+		}
+		if setup != nil {
+			setup(rc)
+		}
+		go func() { _ = rc.Run() }()
+		receivers = append(receivers, rc)
+		addrs = append(addrs, addr)
+	}
+	time.Sleep(50 * time.Millisecond)
+	stop = func() {
+		for _, rc := range receivers {
+			rc.Stop()
+		}
+	}
+	return addrs, td, stop
+}
+
 // -----------------------------------------------------------------------------
 
 // mockNetAddr is a mock net.Addr implementation which can
@@ -0,0 +1,263 @@
+// -----------------------------------------------------------------------------
+// github.com/balacode/udpt                               /[checkpoint_store.go]
+// (c) balarabe@protonmail.com                                      License: MIT
+// -----------------------------------------------------------------------------
+
+package udpt
+
+// # checkpointStore Type
+//   checkpointStore struct
+//
+// # Methods (cs *checkpointStore)
+//   ) Init(dir string, lifetime time.Duration) error
+//   ) IsEnabled() bool
+//   ) Load(id string, count int) ([][]byte, error)
+//   ) Save(id string, count, index int, piece []byte) error
+//   ) Release(id string) error
+//   ) Remove(id string) error
+//   ) RemoveExpired(now time.Time)
+//   ) Close()
+//   ) header(id string, count int) string
+//   ) path(id string) string
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// checkpointExt is the file name extension of checkpoint files
+const checkpointExt = ".part"
+
+// -----------------------------------------------------------------------------
+// # checkpointStore Type
+
+// checkpointStore saves the fragments of partially received data items
+// in a directory, one file per item, so a Receiver can resume receiving
+// an item after it restarts, or after it discards the partial item.
+//
+// Each file starts with a header line containing the item's ID and
+// fragment count, followed by a record for each received fragment:
+// "sn:<number> size:<bytes>\n" and the compressed fragment data.
+//
+// Records are not synced to disk after every write, so a record cut short
+// when the program or the system stopped is ignored when the file is
+// loaded, and the Sender sends that fragment again.
+//
+// checkpointStore is not safe for concurrent use: the Receiver only
+// uses it while holding its processMutex.
+//
+type checkpointStore struct {
+	dir       string
+	lifetime  time.Duration
+	files     map[string]*os.File // open files, by item ID
+	lastSweep time.Time
+} //                                                             checkpointStore
+
+// -----------------------------------------------------------------------------
+// # Methods (cs *checkpointStore)
+
+// Init creates directory 'dir' if it doesn't exist, and deletes the
+// checkpoint files in it that are older than 'lifetime'.
+func (cs *checkpointStore) Init(dir string, lifetime time.Duration) error {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return makeError(0xEABA4E, err)
+	}
+	cs.dir = dir
+	cs.lifetime = lifetime
+	cs.files = make(map[string]*os.File)
+	cs.lastSweep = time.Time{}
+	cs.RemoveExpired(time.Now())
+	return nil
+} //                                                                        Init
+
+// IsEnabled returns true if the store has been initialized with Init().
+func (cs *checkpointStore) IsEnabled() bool {
+	return cs.dir != ""
+} //                                                                   IsEnabled
+
+// Load reads the checkpoint file of data item 'id' and returns its 'count'
+// pieces, with nil for each piece that's missing. Returns nil if the item
+// has no checkpoint file, or if the file belongs to a different item.
+//
+// If the file ends with an incomplete record, truncates the file
+// after the last complete record, so new records can be appended.
+//
+func (cs *checkpointStore) Load(id string, count int) ([][]byte, error) {
+	if !cs.IsEnabled() {
+		return nil, nil
+	}
+	path := cs.path(id)
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, makeError(0xE71F3D, err)
+	}
+	var (
+		rd     = bufio.NewReader(file)
+		pieces = make([][]byte, count)
+		valid  int64 // size of the file up to the last complete record
+	)
+	header, err := rd.ReadString('\n')
+	if err != nil || header != cs.header(id, count) {
+		file.Close()
+		// a different item's file (or just a damaged one) is of no use
+		err = os.Remove(path)
+		if err != nil {
+			return nil, makeError(0xE3ADAC, err)
+		}
+		return nil, nil
+	}
+	valid = int64(len(header))
+	for {
+		line, err := rd.ReadString('\n')
+		if err != nil {
+			break
+		}
+		sn, _ := strconv.Atoi(getPart(line, "sn:", " "))
+		size, _ := strconv.Atoi(getPart(line, "size:", "\n"))
+		if sn < 1 || sn > count || size < 1 {
+			break
+		}
+		piece := make([]byte, size)
+		_, err = io.ReadFull(rd, piece)
+		if err != nil {
+			break
+		}
+		pieces[sn-1] = piece
+		valid += int64(len(line) + size)
+	}
+	file.Close()
+	info, err := os.Stat(path)
+	if err == nil && info.Size() > valid {
+		err = os.Truncate(path, valid)
+	}
+	if err != nil {
+		return nil, makeError(0xE40030, err)
+	}
+	return pieces, nil
+} //                                                                        Load
+
+// Save appends piece number 'index' (0-based) of data item 'id', which has
+// 'count' fragments, to the item's checkpoint file. Creates the file if
+// it doesn't exist, and keeps it open until Release() or Remove().
+func (cs *checkpointStore) Save(id string, count, index int, piece []byte,
+) error {
+	if !cs.IsEnabled() {
+		return nil
+	}
+	file := cs.files[id]
+	if file == nil {
+		var err error
+		file, err = os.OpenFile(cs.path(id),
+			os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			return makeError(0xEFC2F5, err)
+		}
+		info, err := file.Stat()
+		if err == nil && info.Size() == 0 {
+			_, err = file.WriteString(cs.header(id, count))
+		}
+		if err != nil {
+			file.Close()
+			return makeError(0xE659DA, err)
+		}
+		cs.files[id] = file
+	}
+	record := fmt.Sprintf("sn:%d size:%d\n", index+1, len(piece))
+	_, err := file.Write(append([]byte(record), piece...))
+	if err != nil {
+		return makeError(0xE24078, err)
+	}
+	return nil
+} //                                                                        Save
+
+// Release closes the checkpoint file of data item 'id',
+// but keeps the file so the item can be resumed later.
+func (cs *checkpointStore) Release(id string) error {
+	file := cs.files[id]
+	if file == nil {
+		return nil
+	}
+	delete(cs.files, id)
+	err := file.Close()
+	if err != nil {
+		return makeError(0xE8AB91, err)
+	}
+	return nil
+} //                                                                     Release
+
+// Remove closes and deletes the checkpoint file of data item 'id', after
+// the item has been received, or rejected. Does nothing if the file
+// doesn't exist.
+func (cs *checkpointStore) Remove(id string) error {
+	if !cs.IsEnabled() {
+		return nil
+	}
+	err := cs.Release(id)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(cs.path(id))
+	if err != nil && !os.IsNotExist(err) {
+		return makeError(0xEE1FA4, err)
+	}
+	return nil
+} //                                                                      Remove
+
+// RemoveExpired deletes the checkpoint files that haven't been written
+// for longer than the store's lifetime, as of time 'now'. Files being
+// written are kept. To avoid listing the directory too often, it only
+// checks files every quarter of the lifetime.
+func (cs *checkpointStore) RemoveExpired(now time.Time) {
+	if !cs.IsEnabled() || cs.lifetime <= 0 ||
+		now.Sub(cs.lastSweep) < cs.lifetime/4 {
+		return
+	}
+	cs.lastSweep = now
+	open := make(map[string]bool, len(cs.files))
+	for id := range cs.files {
+		open[cs.path(id)] = true
+	}
+	infos, _ := ioutil.ReadDir(cs.dir)
+	for _, info := range infos {
+		path := filepath.Join(cs.dir, info.Name())
+		if info.IsDir() || !strings.HasSuffix(path, checkpointExt) ||
+			open[path] || now.Sub(info.ModTime()) < cs.lifetime {
+			continue
+		}
+		os.Remove(path)
+	}
+} //                                                               RemoveExpired
+
+// Close closes all the open checkpoint files, keeping the files.
+func (cs *checkpointStore) Close() {
+	for id := range cs.files {
+		_ = cs.Release(id)
+	}
+} //                                                                       Close
+
+// header returns the first line of the checkpoint
+// file of data item 'id', which has 'count' fragments
+func (cs *checkpointStore) header(id string, count int) string {
+	return fmt.Sprintf("%s count:%d\n", id, count)
+} //                                                                      header
+
+// path returns the path of the checkpoint file of data item 'id'. Since
+// item IDs contain keys, which can have any characters, the file is
+// named with the hash of the ID.
+func (cs *checkpointStore) path(id string) string {
+	name := fmt.Sprintf("%X%s", getHash([]byte(id)), checkpointExt)
+	return filepath.Join(cs.dir, name)
+} //                                                                        path
+
+// end
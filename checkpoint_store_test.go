@@ -0,0 +1,129 @@
+// -----------------------------------------------------------------------------
+// github.com/balacode/udpt                          /[checkpoint_store_test.go]
+// (c) balarabe@protonmail.com                                      License: MIT
+// -----------------------------------------------------------------------------
+
+package udpt
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// to run all tests in this file:
+// go test -v -run Test_checkpointStore_*
+
+// -----------------------------------------------------------------------------
+
+// newTestCheckpointStore returns a checkpointStore in a temporary
+// directory, and a function that closes it and deletes the directory
+func newTestCheckpointStore(t *testing.T) (*checkpointStore, func()) {
+	dir, err := ioutil.TempDir("", "udpt")
+	if err != nil {
+		t.Fatal("0xE34DA2", err)
+	}
+	var cs checkpointStore
+	err = cs.Init(filepath.Join(dir, "checkpoints"), time.Hour)
+	if err != nil {
+		t.Fatal("0xE94F18", err)
+	}
+	return &cs, func() {
+		cs.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+// -----------------------------------------------------------------------------
+// (cs *checkpointStore) Save(id string, count, index int, piece []byte) error
+// (cs *checkpointStore) Load(id string, count int) ([][]byte, error)
+//
+// go test -run Test_checkpointStore_*
+
+// must load the saved pieces, after the file is closed
+func Test_checkpointStore_1(t *testing.T) {
+	cs, remove := newTestCheckpointStore(t)
+	defer remove()
+	pieces, err := cs.Load("item", 4)
+	if pieces != nil || err != nil {
+		t.Error("0xE3832D", "loaded missing file:", pieces, err)
+	}
+	_ = cs.Save("item", 4, 1, []byte("bb"))
+	_ = cs.Save("item", 4, 3, []byte("d"))
+	_ = cs.Save("other", 2, 0, []byte("x"))
+	if err := cs.Release("item"); err != nil {
+		t.Error("0xE24C94", err)
+	}
+	pieces, err = cs.Load("item", 4)
+	if fmt.Sprintf("%q", pieces) != `["" "bb" "" "d"]` || err != nil {
+		t.Error("0xE9B056", "wrong pieces:", fmt.Sprintf("%q", pieces), err)
+	}
+	// more pieces must be appended to the same file
+	_ = cs.Save("item", 4, 0, []byte("a"))
+	cs.Close()
+	pieces, _ = cs.Load("item", 4)
+	if fmt.Sprintf("%q", pieces) != `["a" "bb" "" "d"]` {
+		t.Error("0xE2967E", "wrong pieces:", fmt.Sprintf("%q", pieces))
+	}
+}
+
+// must ignore a record cut short, and a file with a different fragment count
+func Test_checkpointStore_2(t *testing.T) {
+	cs, remove := newTestCheckpointStore(t)
+	defer remove()
+	_ = cs.Save("item", 3, 0, []byte("aaa"))
+	_ = cs.Save("item", 3, 1, []byte("bbb"))
+	cs.Close()
+	path := cs.path("item")
+	info, _ := os.Stat(path)
+	_ = os.Truncate(path, info.Size()-1)
+	pieces, _ := cs.Load("item", 3)
+	if fmt.Sprintf("%q", pieces) != `["aaa" "" ""]` {
+		t.Error("0xEF7784", "wrong pieces:", fmt.Sprintf("%q", pieces))
+	}
+	// the incomplete record must be truncated
+	_ = cs.Save("item", 3, 2, []byte("ccc"))
+	cs.Close()
+	pieces, _ = cs.Load("item", 3)
+	if fmt.Sprintf("%q", pieces) != `["aaa" "" "ccc"]` {
+		t.Error("0xEB2C49", "wrong pieces:", fmt.Sprintf("%q", pieces))
+	}
+	pieces, err := cs.Load("item", 4)
+	if pieces != nil || err != nil {
+		t.Error("0xEB554A", "loaded wrong file:", pieces, err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("0xEE46F1", "wrong file not deleted:", err)
+	}
+}
+
+// (cs *checkpointStore) Remove(id string) error
+// (cs *checkpointStore) RemoveExpired(now time.Time)
+//
+// must delete removed and expired files, but not files being written
+func Test_checkpointStore_3(t *testing.T) {
+	cs, remove := newTestCheckpointStore(t)
+	defer remove()
+	for _, id := range []string{"a", "b", "c"} {
+		_ = cs.Save(id, 1, 0, []byte(id))
+	}
+	if err := cs.Remove("a"); err != nil {
+		t.Error("0xEFB0E9", err)
+	}
+	if err := cs.Remove("a"); err != nil {
+		t.Error("0xEB7CC5", "removing a missing file failed:", err)
+	}
+	_ = cs.Release("b")
+	cs.RemoveExpired(time.Now().Add(2 * time.Hour))
+	for id, want := range map[string]bool{"a": false, "b": false, "c": true} {
+		_, err := os.Stat(cs.path(id))
+		if (err == nil) != want {
+			t.Error("0xEDFD22", id, "wrong existence:", err)
+		}
+	}
+}
+
+// end
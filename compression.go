@@ -5,6 +5,14 @@
 
 package udpt
 
+import (
+	"errors"
+)
+
+// errUncompressedSize occurs when an item's uncompressed data
+// would exceed Configuration.MaxUncompressedSize.
+var errUncompressedSize = errors.New("uncompressed size exceeds limit")
+
 // Compression implements functions to compress and uncompress byte slices.
 type Compression interface {
 
@@ -17,4 +25,14 @@ type Compression interface {
 	Uncompress(comp []byte) ([]byte, error)
 } //                                                                 Compression
 
+// limitedUncompressor is implemented by compressors that can refuse to
+// uncompress more than 'limit' bytes before they allocate the result.
+// For other compressors, the size is only checked after uncompressing.
+type limitedUncompressor interface {
+
+	// uncompressLimit works like Uncompress, but returns
+	// errUncompressedSize if the result would exceed 'limit' bytes.
+	uncompressLimit(comp []byte, limit int) ([]byte, error)
+} //                                                         limitedUncompressor
+
 // end
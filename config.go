@@ -29,6 +29,21 @@ type Configuration struct {
 	// Compressor handles compression and uncompression.
 	Compressor Compression
 
+	// ReplyCipher is the object that encrypts and decrypts the replies
+	// sent by the Receiver back to the Sender. It is only used when
+	// Sender.ReplyCryptoKey and Receiver.ReplyCryptoKey are specified,
+	// otherwise replies are encrypted with Cipher, like all other packets.
+	//
+	// It must be a different instance from Cipher, since each
+	// SymmetricCipher instance holds only one encryption key.
+	//
+	ReplyCipher SymmetricCipher
+
+	// Transport creates the packet connections used by Sender and
+	// Receiver. If you leave it nil, they create ordinary UDP sockets.
+	// See the Transport interface and NewConnTransport().
+	Transport Transport
+
 	// -------------------------------------------------------------------------
 	// Limits:
 
@@ -51,6 +66,8 @@ type Configuration struct {
 	// PacketPayloadSize is the size of a single packet's payload, in bytes.
 	// That is the part of the packet that contains actual useful data.
 	// PacketPayloadSize must always be smaller that PacketSizeLimit.
+	// When PathMTUDiscovery is enabled, the Sender uses it until it
+	// finds a larger size that reaches the Receiver.
 	PacketPayloadSize int
 
 	// SendBufferSize is size of the write buffer used by Send(), in bytes.
@@ -60,9 +77,254 @@ type Configuration struct {
 	// Send() to retry sending lost packets.
 	SendRetries int
 
+	// -------------------------------------------------------------------------
+	// Receiver Limits:
+	//
+	// These limits protect the Receiver from running out of memory when a
+	// Sender (or a bug) announces huge or numerous data items. Items that
+	// exceed a limit are discarded and rejected with a tagRejection reply.
+	// Set a limit to zero to disable it.
+
+	// MaxItemSize is the maximum size, in bytes, of the compressed
+	// data of a single data item that the Receiver will accept.
+	MaxItemSize int
+
+	// MaxUncompressedSize is the maximum size, in bytes, of a data item
+	// after the Receiver uncompresses it. It's checked against the size
+	// the Sender declares before any memory is allocated for the data,
+	// and while uncompressing, so small items can't expand without limit.
+	MaxUncompressedSize int
+
+	// MaxFragmentCount is the maximum number of fragments (packets)
+	// that a single data item may be split into.
+	MaxFragmentCount int
+
+	// MaxPartialItems is the maximum number of partially
+	// received data items the Receiver will hold at once.
+	MaxPartialItems int
+
+	// MaxReassemblyMemory is the maximum total size, in bytes, of the
+	// fragments held by the Receiver for all partially received items.
+	// It includes 24 bytes for each fragment of every item, which the
+	// Receiver sets aside as soon as the item's first packet arrives.
+	MaxReassemblyMemory int
+
+	// -------------------------------------------------------------------------
+	// Receive Queue:
+	//
+	// By default, the Receiver calls Receive (or Handler) in the goroutine
+	// that reads packets, so no packets are processed until it returns,
+	// and a slow callback makes Senders time out and resend. With
+	// ReceiveWorkers, fully received items are queued instead, and
+	// their last fragment is confirmed as soon as they are queued.
+
+	// ReceiveWorkers is the number of goroutines that call Receive (or
+	// Handler) for queued items. Set it to zero to call it directly.
+	// If it's more than 1, Receive must be safe for concurrent use.
+	//
+	// Since the Sender is told the item was delivered before it's
+	// handled, a Reject() returned by a queued handler can't be sent
+	// back to the Sender, and is only logged. The same goes for any
+	// other error, and for ReceiveMux's "key not found": the Sender's
+	// Send() succeeds and the item is lost. Leave ReceiveWorkers at
+	// zero if Senders must learn that their items were refused.
+	//
+	ReceiveWorkers int
+
+	// ReceiveQueueSize is the number of fully received items
+	// that can wait for a free worker.
+	//
+	// Queued items are delivered at most once. An item is confirmed to
+	// its Sender and marked in Receiver.Deliveries when it's queued, so
+	// if the handler then fails or panics, the item is lost: the Sender
+	// won't resend it. Failures are logged and counted in
+	// ReceiverStats.FailedQueuedItems.
+	//
+	// The items of a batch are queued together, only when the queue has
+	// room for all of them. So with QueueReject or QueueDrop, a batch of
+	// more than ReceiveQueueSize items is never delivered: make the queue
+	// larger than the number of small items that fit in a packet.
+	//
+	ReceiveQueueSize int
+
+	// ReceiveQueuePolicy specifies what to do with a fully received item
+	// when the queue is full: wait for room (QueueBlock), reject it
+	// (QueueReject), or drop it until the Sender resends it (QueueDrop).
+	ReceiveQueuePolicy QueuePolicy
+
+	// -------------------------------------------------------------------------
+	// Flow Control:
+
+	// ReceiveWindow is the largest number of fragments of each data item
+	// which the Receiver allows a Sender to have in flight: sent, but not
+	// yet confirmed. The Receiver advertises its window in every
+	// confirmation, reduced when MaxReassemblyMemory is nearly used up,
+	// and zero while the receive queue is full. The Sender waits while
+	// the window is full, and while it's zero, sends one fragment every
+	// SendRetryInterval as a probe, to learn when the window opens.
+	// Set it to zero to let Senders send without a window.
+	ReceiveWindow int
+
+	// -------------------------------------------------------------------------
+	// Address Validation:
+
+	// ValidateAddresses specifies if the Receiver must validate the address
+	// of each Sender before processing its packets. It prevents spoofed
+	// packets from making the Receiver send replies to third parties.
+	//
+	// The Receiver first replies to an unknown address with a small signed
+	// cookie, which the Sender echoes in all its following packets. Until
+	// the address is validated, no reply is larger than the request.
+	//
+	// It's disabled by default, since Senders from earlier versions of
+	// this package don't understand the cookie, and fail to send any
+	// item. Enable it once all your Senders have been upgraded. Until
+	// then, the Receiver offers no protection against reflection: a
+	// spoofed packet sent with the CryptoKey (for example, a replayed
+	// one) still gets its reply sent to the spoofed address.
+	//
+	ValidateAddresses bool
+
+	// CookieLifetime is the time for which an address validation
+	// cookie is valid, after which the Sender needs a new cookie.
+	CookieLifetime time.Duration
+
+	// -------------------------------------------------------------------------
+	// Source Filtering:
+	//
+	// The Receiver checks these settings before it decrypts each packet,
+	// so that unwanted or excessive traffic costs as little as possible.
+	// Dropped packets are counted in ReceiverStats.
+
+	// AllowedNetworks lists the networks from which the Receiver accepts
+	// packets, in CIDR notation like "10.0.0.0/8" or "fd00::/8". Single IP
+	// addresses are also accepted. If empty, all networks are allowed.
+	AllowedNetworks []string
+
+	// DeniedNetworks lists the networks from which the Receiver drops
+	// all packets. It takes precedence over AllowedNetworks.
+	DeniedNetworks []string
+
+	// SourcePacketRate is the maximum number of packets per second the
+	// Receiver accepts from each source host. Set it to zero for no limit.
+	SourcePacketRate float64
+
+	// SourcePacketBurst is the number of packets a source host can send
+	// in a burst above SourcePacketRate, after being idle for a while.
+	SourcePacketBurst int
+
+	// SourceByteRate is the maximum number of bytes per second the
+	// Receiver accepts from each source host. Set it to zero for no limit.
+	SourceByteRate float64
+
+	// SourceByteBurst is the number of bytes a source host can send in a
+	// burst above SourceByteRate. It must be at least PacketSizeLimit.
+	SourceByteBurst int
+
+	// -------------------------------------------------------------------------
+	// Outbox:
+
+	// OutboxSegmentSize is the size in bytes after which an Outbox starts
+	// writing to a new segment file. Segment files are deleted once all
+	// their items are delivered. Set it to zero to use a single file.
+	OutboxSegmentSize int
+
+	// OutboxRetryInterval is the time for which an Outbox waits after
+	// failing to send an item, before it tries again. The wait doubles
+	// after each failure, up to OutboxMaxRetryInterval.
+	OutboxRetryInterval time.Duration
+
+	// OutboxMaxRetryInterval is the longest time an Outbox
+	// waits between attempts to send an undelivered item.
+	OutboxMaxRetryInterval time.Duration
+
+	// -------------------------------------------------------------------------
+	// Resumable Transfers:
+	//
+	// Resumable items must still fit in the Receiver's MaxItemSize and
+	// MaxReassemblyMemory, since they are reassembled in memory.
+
+	// ResumeTransfers makes the Sender ask the Receiver which fragments of
+	// a resumable item (see ResumeMinFragments) it already has, before
+	// sending the item, and send only the rest. This costs a round trip
+	// for each such item, or SendRetryInterval if the Receiver is from
+	// an earlier version that doesn't answer, so it's off by default.
+	// Enable it on Senders whose Receivers specify a CheckpointDir.
+	ResumeTransfers bool
+
+	// ResumeMinFragments is the number of fragments from which a data item
+	// is resumable. When ResumeTransfers is true, the Sender asks the
+	// Receiver which fragments of such items it already has. A Receiver
+	// with a CheckpointDir saves the fragments of such items to disk as
+	// they arrive. Set it to zero to disable resuming.
+	ResumeMinFragments int
+
+	// CheckpointDir is the directory in which the Receiver saves the
+	// fragments of partially received resumable items, so it can resume
+	// them after it is restarted, or after it discards a partial item
+	// because of PartialItemTimeout. Leave it blank to keep partially
+	// received items only in memory.
+	CheckpointDir string
+
+	// CheckpointLifetime is the time after which the Receiver deletes
+	// the checkpoint file of an item that is not being received.
+	CheckpointLifetime time.Duration
+
+	// -------------------------------------------------------------------------
+	// Batching:
+
+	// BatchLinger is the longest time for which a Sender holds a small item,
+	// waiting for more items to send in the same packet. When it is set,
+	// Sender.Send() sends items that fit in PacketPayloadSize bytes in
+	// batches, and the Receiver passes each item in a batch to the Handler
+	// separately. Leave it zero to send every item on its own.
+	BatchLinger time.Duration
+
+	// -------------------------------------------------------------------------
+	// Path MTU Discovery:
+
+	// PathMTUDiscovery makes the Sender find the largest payload size that
+	// reaches each Receiver without being fragmented, in the manner of
+	// RFC 8899 (DPLPMTUD). After connecting to a Receiver, the Sender
+	// sends it padded probe packets, with the "don't fragment" flag set
+	// where the operating system allows, and searches for the largest
+	// acknowledged size between PacketPayloadSize and PacketSizeLimit
+	// (less 200 bytes for headers). Items sent after the search use
+	// the size found, instead of PacketPayloadSize.
+	//
+	// To use jumbo frames, raise PacketSizeLimit on both the Sender and
+	// the Receiver. PacketPayloadSize must still be small enough for
+	// every path, since it's used until the search ends.
+	//
+	PathMTUDiscovery bool
+
+	// PathMTUTTL is the time for which a Sender uses the payload size found
+	// for a Receiver, after which it searches again. Set it to zero to keep
+	// using the size found, as long as the Sender exists.
+	PathMTUTTL time.Duration
+
 	// -------------------------------------------------------------------------
 	// Timeouts and Intervals:
 
+	// ConnectionTTL is the time for which a Sender keeps using the same
+	// connection. After it, the next send opens a new connection and
+	// resolves the Receiver's address again. Set it to zero to keep the
+	// connection until a send fails.
+	ConnectionTTL time.Duration
+
+	// KeepaliveInterval makes a Sender ping the Receiver (see Sender.Ping)
+	// when it has received no reply over its connection for this long.
+	// If the Receiver doesn't answer within ReplyTimeout, the Sender
+	// reports it to its Unreachable callback, and the next send opens a
+	// new connection. Leave it zero to send no keepalives.
+	KeepaliveInterval time.Duration
+
+	// PartialItemTimeout is the time after which the Receiver discards
+	// a partially received data item if no more of its fragments arrive,
+	// for example because the Sender stopped in the middle of a transfer.
+	// Set it to zero to keep partial items until they are completed.
+	PartialItemTimeout time.Duration
+
 	// ReplyTimeout is the maximum time to wait for reply
 	// datagram(s) to arrive in a UDP connection.
 	ReplyTimeout time.Duration
@@ -120,8 +382,9 @@ func NewDefaultConfig() *Configuration {
 	return &Configuration{
 		//
 		// Components:
-		Cipher:     &aesCipher{},
-		Compressor: &zlibCompressor{},
+		Cipher:      &aesCipher{},
+		Compressor:  &zlibCompressor{},
+		ReplyCipher: &aesCipher{},
 		//
 		// Limits:
 		PacketSizeLimit:   1450,
@@ -129,7 +392,40 @@ func NewDefaultConfig() *Configuration {
 		SendBufferSize:    16 * 1024 * 2014, // 16 MiB
 		SendRetries:       10,
 		//
+		// Receiver Limits:
+		MaxItemSize:         64 * 1024 * 1024,  // 64 MiB
+		MaxUncompressedSize: 256 * 1024 * 1024, // 256 MiB
+		MaxFragmentCount:    64 * 1024,
+		MaxPartialItems:     64,
+		MaxReassemblyMemory: 256 * 1024 * 1024, // 256 MiB
+		//
+		// Receive Queue: (default zero values: no queue)
+		//
+		// Flow Control:
+		ReceiveWindow: 256,
+		//
+		// Address Validation: (disabled by default)
+		CookieLifetime: 10 * time.Minute,
+		//
+		// Source Filtering: (default nil/zero values: no filtering)
+		//
+		// Outbox:
+		OutboxSegmentSize:      16 * 1024 * 1024, // 16 MiB
+		OutboxRetryInterval:    1 * time.Second,
+		OutboxMaxRetryInterval: 1 * time.Minute,
+		//
+		// Resumable Transfers: (no queries or checkpoint files by default)
+		ResumeMinFragments: 64,
+		CheckpointLifetime: 24 * time.Hour,
+		//
+		// Batching: (default zero value: no batching)
+		//
+		// Path MTU Discovery: (disabled by default)
+		PathMTUTTL: 10 * time.Minute,
+		//
 		// Timeouts and Intervals:
+		ConnectionTTL:      5 * time.Minute,
+		PartialItemTimeout: 1 * time.Minute,
 		ReplyTimeout:       10 * time.Second,
 		SendPacketInterval: 1 * time.Millisecond,
 		SendRetryInterval:  250 * time.Millisecond,
@@ -161,7 +457,7 @@ func (cf *Configuration) Validate() error {
 			"invalid Configuration.PacketSizeLimit:", n)
 	}
 	n = cf.PacketPayloadSize
-	if n < 1 || n > (cf.PacketSizeLimit-200) {
+	if n < 1 || n > (cf.PacketSizeLimit-maxPacketOverhead) {
 		return makeError(0xE54BF4,
 			"invalid Configuration.PacketPayloadSize:", n)
 	}
@@ -175,6 +471,118 @@ func (cf *Configuration) Validate() error {
 		return makeError(0xE47C83,
 			"invalid Configuration.SendRetries:", n)
 	}
+	// Receiver Limits:
+	for _, limit := range []struct {
+		name string
+		n    int
+	}{
+		{"MaxItemSize", cf.MaxItemSize},
+		{"MaxUncompressedSize", cf.MaxUncompressedSize},
+		{"MaxFragmentCount", cf.MaxFragmentCount},
+		{"MaxPartialItems", cf.MaxPartialItems},
+		{"MaxReassemblyMemory", cf.MaxReassemblyMemory},
+	} {
+		if limit.n < 0 {
+			return makeError(0xE0A94D,
+				"invalid Configuration."+limit.name+":", limit.n)
+		}
+	}
+	// Receive Queue:
+	if cf.ReceiveWorkers < 0 {
+		return makeError(0xE23AB0,
+			"invalid Configuration.ReceiveWorkers:", cf.ReceiveWorkers)
+	}
+	if cf.ReceiveQueueSize < 0 {
+		return makeError(0xE39583,
+			"invalid Configuration.ReceiveQueueSize:", cf.ReceiveQueueSize)
+	}
+	if cf.ReceiveQueuePolicy < QueueBlock || cf.ReceiveQueuePolicy > QueueDrop {
+		return makeError(0xE8AAA3,
+			"invalid Configuration.ReceiveQueuePolicy:", cf.ReceiveQueuePolicy)
+	}
+	// Flow Control:
+	if cf.ReceiveWindow < 0 {
+		return makeError(0xE96610,
+			"invalid Configuration.ReceiveWindow:", cf.ReceiveWindow)
+	}
+	// Address Validation:
+	if cf.ValidateAddresses && cf.CookieLifetime < time.Second {
+		return makeError(0xE1C388,
+			"invalid Configuration.CookieLifetime:", cf.CookieLifetime)
+	}
+	// Source Filtering:
+	_, err := parseNetworks(cf.AllowedNetworks)
+	if err != nil {
+		return makeError(0xEC115B,
+			"invalid Configuration.AllowedNetworks:", err)
+	}
+	_, err = parseNetworks(cf.DeniedNetworks)
+	if err != nil {
+		return makeError(0xECD159,
+			"invalid Configuration.DeniedNetworks:", err)
+	}
+	if cf.SourcePacketRate < 0 {
+		return makeError(0xE02D0B,
+			"invalid Configuration.SourcePacketRate:", cf.SourcePacketRate)
+	}
+	if cf.SourcePacketRate > 0 && cf.SourcePacketBurst < 1 {
+		return makeError(0xEB6A01,
+			"invalid Configuration.SourcePacketBurst:", cf.SourcePacketBurst)
+	}
+	if cf.SourceByteRate < 0 {
+		return makeError(0xE53B96,
+			"invalid Configuration.SourceByteRate:", cf.SourceByteRate)
+	}
+	if cf.SourceByteRate > 0 && cf.SourceByteBurst < cf.PacketSizeLimit {
+		return makeError(0xE7C5C2,
+			"invalid Configuration.SourceByteBurst:", cf.SourceByteBurst)
+	}
+	// Outbox:
+	if cf.OutboxSegmentSize < 0 {
+		return makeError(0xE8A964,
+			"invalid Configuration.OutboxSegmentSize:", cf.OutboxSegmentSize)
+	}
+	if cf.OutboxRetryInterval < 0 {
+		return makeError(0xE1C471, "invalid Configuration.OutboxRetryInterval:",
+			cf.OutboxRetryInterval)
+	}
+	if cf.OutboxMaxRetryInterval < cf.OutboxRetryInterval {
+		return makeError(0xE3DE80,
+			"invalid Configuration.OutboxMaxRetryInterval:",
+			cf.OutboxMaxRetryInterval)
+	}
+	// Resumable Transfers:
+	if cf.ResumeMinFragments < 0 {
+		return makeError(0xE70401,
+			"invalid Configuration.ResumeMinFragments:", cf.ResumeMinFragments)
+	}
+	if cf.CheckpointLifetime < 0 {
+		return makeError(0xE81A24,
+			"invalid Configuration.CheckpointLifetime:", cf.CheckpointLifetime)
+	}
+	// Batching:
+	if cf.BatchLinger < 0 {
+		return makeError(0xE672EF,
+			"invalid Configuration.BatchLinger:", cf.BatchLinger)
+	}
+	// Path MTU Discovery:
+	if cf.PathMTUTTL < 0 {
+		return makeError(0xEF1BF7,
+			"invalid Configuration.PathMTUTTL:", cf.PathMTUTTL)
+	}
+	// Timeouts and Intervals:
+	if cf.ConnectionTTL < 0 {
+		return makeError(0xE2A475,
+			"invalid Configuration.ConnectionTTL:", cf.ConnectionTTL)
+	}
+	if cf.KeepaliveInterval < 0 {
+		return makeError(0xE0F07D,
+			"invalid Configuration.KeepaliveInterval:", cf.KeepaliveInterval)
+	}
+	if cf.PartialItemTimeout < 0 {
+		return makeError(0xE95930,
+			"invalid Configuration.PartialItemTimeout:", cf.PartialItemTimeout)
+	}
 	return nil
 } //                                                                    Validate
 
@@ -143,6 +143,210 @@ func Test_config_Configuration_Validate_(t *testing.T) {
 			t.Error("0xE0DE62", "wrong error:", err)
 		}
 	}
+	{
+		var cf = makeValidConfig()
+		cf.MaxItemSize = -1
+		err := cf.Validate()
+		if !matchError(err, "invalid Configuration.MaxItemSize") {
+			t.Error("0xE8D707", "wrong error:", err)
+		}
+	}
+	{
+		var cf = makeValidConfig()
+		cf.MaxFragmentCount = -1
+		err := cf.Validate()
+		if !matchError(err, "invalid Configuration.MaxFragmentCount") {
+			t.Error("0xEDE074", "wrong error:", err)
+		}
+	}
+	{
+		var cf = makeValidConfig()
+		cf.MaxPartialItems = -1
+		err := cf.Validate()
+		if !matchError(err, "invalid Configuration.MaxPartialItems") {
+			t.Error("0xE6E5EA", "wrong error:", err)
+		}
+	}
+	{
+		var cf = makeValidConfig()
+		cf.MaxReassemblyMemory = -1
+		err := cf.Validate()
+		if !matchError(err, "invalid Configuration.MaxReassemblyMemory") {
+			t.Error("0xEF1327", "wrong error:", err)
+		}
+	}
+	{
+		var cf = makeValidConfig()
+		cf.BatchLinger = -time.Second
+		err := cf.Validate()
+		if !matchError(err, "invalid Configuration.BatchLinger") {
+			t.Error("0xEEE8B7", "wrong error:", err)
+		}
+	}
+	{
+		var cf = makeValidConfig()
+		cf.PathMTUTTL = -time.Second
+		err := cf.Validate()
+		if !matchError(err, "invalid Configuration.PathMTUTTL") {
+			t.Error("0xE242D7", "wrong error:", err)
+		}
+	}
+	{
+		var cf = makeValidConfig()
+		cf.ConnectionTTL = -time.Second
+		err := cf.Validate()
+		if !matchError(err, "invalid Configuration.ConnectionTTL") {
+			t.Error("0xEF3722", "wrong error:", err)
+		}
+	}
+	{
+		var cf = makeValidConfig()
+		cf.KeepaliveInterval = -time.Second
+		err := cf.Validate()
+		if !matchError(err, "invalid Configuration.KeepaliveInterval") {
+			t.Error("0xEF5A21", "wrong error:", err)
+		}
+	}
+	{
+		var cf = makeValidConfig()
+		cf.PartialItemTimeout = -time.Second
+		err := cf.Validate()
+		if !matchError(err, "invalid Configuration.PartialItemTimeout") {
+			t.Error("0xE93B94", "wrong error:", err)
+		}
+	}
+	{
+		var cf = makeValidConfig()
+		cf.ValidateAddresses = true
+		cf.CookieLifetime = 0
+		err := cf.Validate()
+		if !matchError(err, "invalid Configuration.CookieLifetime") {
+			t.Error("0xEEC9CF", "wrong error:", err)
+		}
+	}
+	{
+		var cf = makeValidConfig()
+		cf.AllowedNetworks = []string{"10.0.0.0/8", "bad"}
+		err := cf.Validate()
+		if !matchError(err, "invalid Configuration.AllowedNetworks") {
+			t.Error("0xEA58CF", "wrong error:", err)
+		}
+	}
+	{
+		var cf = makeValidConfig()
+		cf.DeniedNetworks = []string{"10.0.0.0/99"}
+		err := cf.Validate()
+		if !matchError(err, "invalid Configuration.DeniedNetworks") {
+			t.Error("0xED48EF", "wrong error:", err)
+		}
+	}
+	{
+		var cf = makeValidConfig()
+		cf.SourcePacketRate = -1
+		err := cf.Validate()
+		if !matchError(err, "invalid Configuration.SourcePacketRate") {
+			t.Error("0xE7DAE7", "wrong error:", err)
+		}
+	}
+	{
+		var cf = makeValidConfig()
+		cf.SourcePacketRate = 100
+		cf.SourcePacketBurst = 0
+		err := cf.Validate()
+		if !matchError(err, "invalid Configuration.SourcePacketBurst") {
+			t.Error("0xE4BB62", "wrong error:", err)
+		}
+	}
+	{
+		var cf = makeValidConfig()
+		cf.SourceByteRate = -1
+		err := cf.Validate()
+		if !matchError(err, "invalid Configuration.SourceByteRate") {
+			t.Error("0xE634C3", "wrong error:", err)
+		}
+	}
+	{
+		var cf = makeValidConfig()
+		cf.SourceByteRate = 100000
+		cf.SourceByteBurst = cf.PacketSizeLimit - 1
+		err := cf.Validate()
+		if !matchError(err, "invalid Configuration.SourceByteBurst") {
+			t.Error("0xEEBFE0", "wrong error:", err)
+		}
+	}
+	{
+		var cf = makeValidConfig()
+		cf.ReceiveWorkers = -1
+		err := cf.Validate()
+		if !matchError(err, "invalid Configuration.ReceiveWorkers") {
+			t.Error("0xE07C23", "wrong error:", err)
+		}
+	}
+	{
+		var cf = makeValidConfig()
+		cf.ReceiveQueueSize = -1
+		err := cf.Validate()
+		if !matchError(err, "invalid Configuration.ReceiveQueueSize") {
+			t.Error("0xEE3A88", "wrong error:", err)
+		}
+	}
+	{
+		var cf = makeValidConfig()
+		cf.ReceiveQueuePolicy = QueueDrop + 1
+		err := cf.Validate()
+		if !matchError(err, "invalid Configuration.ReceiveQueuePolicy") {
+			t.Error("0xECC22B", "wrong error:", err)
+		}
+	}
+	{
+		var cf = makeValidConfig()
+		cf.ReceiveWindow = -1
+		err := cf.Validate()
+		if !matchError(err, "invalid Configuration.ReceiveWindow") {
+			t.Error("0xE49D8C", "wrong error:", err)
+		}
+	}
+	{
+		var cf = makeValidConfig()
+		cf.OutboxSegmentSize = -1
+		err := cf.Validate()
+		if !matchError(err, "invalid Configuration.OutboxSegmentSize") {
+			t.Error("0xE5EBD1", "wrong error:", err)
+		}
+	}
+	{
+		var cf = makeValidConfig()
+		cf.OutboxRetryInterval = -1
+		err := cf.Validate()
+		if !matchError(err, "invalid Configuration.OutboxRetryInterval") {
+			t.Error("0xE555A5", "wrong error:", err)
+		}
+	}
+	{
+		var cf = makeValidConfig()
+		cf.OutboxRetryInterval = time.Second
+		cf.OutboxMaxRetryInterval = time.Millisecond
+		err := cf.Validate()
+		if !matchError(err, "invalid Configuration.OutboxMaxRetryInterval") {
+			t.Error("0xE9F785", "wrong error:", err)
+		}
+	}
+	{
+		var cf = makeValidConfig()
+		cf.ResumeMinFragments = -1
+		err := cf.Validate()
+		if !matchError(err, "invalid Configuration.ResumeMinFragments") {
+			t.Error("0xEEF530", "wrong error:", err)
+		}
+	}
+	{
+		var cf = makeValidConfig()
+		cf.CheckpointLifetime = -1
+		err := cf.Validate()
+		if !matchError(err, "invalid Configuration.CheckpointLifetime") {
+			t.Error("0xEC5BEB", "wrong error:", err)
+		}
+	}
 }
 
 // end
@@ -11,6 +11,76 @@ const tagFragment = "FRAG:"
 
 // tagConfirmation tag prefixes a UDP packet sent back by the
 // receiver confirming a tagFragment packet sent by the sender.
+// The tag is followed by the hash of the confirmed packet. If the
+// fragment's header has the flag "flow:1", the hash may be followed
+// by the receiver's window for the data item, for example "win:64",
+// which is the number of fragments the sender may have in flight.
 const tagConfirmation = "CONF:"
 
+// tagWindow prefixes a UDP packet sent back by the receiver when it has
+// no room for a tagFragment packet, which it didn't store. The tag is
+// followed by the hash of the packet and the receiver's window for
+// the data item, like in tagConfirmation, usually "win:0".
+const tagWindow = "WIND:"
+
+// tagRejection prefixes a UDP packet sent back by the receiver when it
+// refuses a data item, for example because the item exceeds one of the
+// receiver's limits. The tag is followed by the hash of the rejected
+// tagFragment packet and a description of the reason for rejection.
+const tagRejection = "RJCT:"
+
+// tagCookie prefixes a UDP packet sent by the receiver to a sender
+// whose address has not been validated yet. The tag is followed by a
+// signed token, which the sender must echo by prefixing all its next
+// packets with the same tag and token. See addressValidator.
+const tagCookie = "COOK:"
+
+// tagStatus prefixes a UDP packet sent by a MulticastSender to ask the
+// receivers which fragments of a data item they are missing. It has the
+// same header as tagFragment, without 'sn' and without any data.
+const tagStatus = "STAT:"
+
+// tagNack prefixes a UDP packet sent back by a receiver in reply to a
+// tagStatus packet, when some fragments of the data item are missing.
+// The tag is followed by the hash of the data item and the ranges of
+// missing fragment numbers, for example "1-4,9" (see encodeRanges).
+const tagNack = "NACK:"
+
+// tagDone prefixes a UDP packet sent back by a receiver in reply to a
+// tagStatus packet, when it has received the whole data item. The tag
+// is followed by the hash of the data item.
+const tagDone = "DONE:"
+
+// tagQuery prefixes a UDP packet sent by the sender before it sends a
+// large data item, to ask the receiver which fragments it already has
+// (for example from an earlier, interrupted transfer). It has the
+// same header as tagStatus.
+const tagQuery = "QURY:"
+
+// tagHave prefixes a UDP packet sent back by the receiver in reply to
+// a tagQuery packet. The tag is followed by the hash of the tagQuery
+// packet, which tells apart items with the same data sent at the same
+// time, and the ranges of fragment numbers which the receiver already
+// has, for example "1-4,9" (see encodeRanges). The list may be empty.
+const tagHave = "HAVE:"
+
+// tagProbe prefixes a UDP packet sent by the sender to find out if
+// packets of a given size reach the receiver (see discoverPathMTU). It
+// has a header line "size:<size>\n" followed by padding up to the size.
+const tagProbe = "PROB:"
+
+// tagProbeAck prefixes a UDP packet sent back by the receiver in reply to
+// a tagProbe packet. The tag is followed by the hash of the probe packet.
+const tagProbeAck = "PACK:"
+
+// tagPing prefixes a UDP packet sent by the sender to check if the receiver
+// is up and measure the round-trip time (see Sender.Ping). The tag is
+// followed by a header line "id:<random ID>\n", which makes every
+// ping packet unique.
+const tagPing = "PING:"
+
+// tagPong prefixes a UDP packet sent back by the receiver in reply to
+// a tagPing packet. The tag is followed by the hash of the ping packet.
+const tagPong = "PONG:"
+
 // end
@@ -9,8 +9,14 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"time"
 )
 
+// pieceIndexSize is the memory used by each entry of
+// dataItem.CompressedPieces (a slice header on 64-bit platforms),
+// before any data arrives for it
+const pieceIndexSize = 24
+
 // dataItem holds a data item being received by a Receiver. A data item
 // is just a sequence of bytes being transferred. It could be a file,
 // a JSON string or any other resource.
@@ -20,14 +26,22 @@ import (
 //
 type dataItem struct {
 	Key                  string
+	MessageID            string // blank if the Sender didn't send it
 	Hash                 []byte
 	CompressedPieces     [][]byte
 	CompressedSizeInfo   int
 	UncompressedSizeInfo int
+	ReceivedSize         int
+	FirstActivity        time.Time // when the first fragment arrived
+	LastActivity         time.Time
+	DuplicateCount       int  // number of fragments received again
+	Multicast            bool // set when sent by a MulticastSender
+	Batch                bool // set when it's a batch of small items
+	Flow                 bool // set when the Sender uses flow control
 } //                                                                    dataItem
 
 // -----------------------------------------------------------------------------
-// # Property
+// # Properties
 
 // IsLoaded returns true if the current data item has been
 // fully received (all its pieces have been collected).
@@ -43,6 +57,24 @@ func (di *dataItem) IsLoaded() bool {
 	return len(di.CompressedPieces) > 0
 } //                                                                    IsLoaded
 
+// MemorySize returns the memory held by the data item, in bytes: the size
+// of the pieces collected so far plus pieceIndexSize for each piece, since
+// the list of pieces is allocated for all of them when the item starts.
+func (di *dataItem) MemorySize() int {
+	return di.ReceivedSize + len(di.CompressedPieces)*pieceIndexSize
+} //                                                                  MemorySize
+
+// ReceivedCount returns the number of pieces collected so far.
+func (di *dataItem) ReceivedCount() int {
+	ret := 0
+	for _, piece := range di.CompressedPieces {
+		if len(piece) > 0 {
+			ret++
+		}
+	}
+	return ret
+} //                                                               ReceivedCount
+
 // -----------------------------------------------------------------------------
 // # Methods
 
@@ -64,10 +96,18 @@ func (di *dataItem) LogStats(tag string, w io.Writer) {
 // Reset discards the contents of the data item and clears its key and hash.
 func (di *dataItem) Reset() {
 	di.Key = ""
+	di.MessageID = ""
 	di.Hash = nil
 	di.CompressedPieces = nil
 	di.CompressedSizeInfo = 0
 	di.UncompressedSizeInfo = 0
+	di.ReceivedSize = 0
+	di.FirstActivity = time.Time{}
+	di.LastActivity = time.Time{}
+	di.DuplicateCount = 0
+	di.Multicast = false
+	di.Batch = false
+	di.Flow = false
 } //                                                                       Reset
 
 // Retain changes the Key, Hash, and empties CompressedPieces when the passed
@@ -83,11 +123,20 @@ func (di *dataItem) Retain(k string, hash []byte, packetCount int) {
 	di.CompressedPieces = make([][]byte, packetCount)
 	di.CompressedSizeInfo = 0
 	di.UncompressedSizeInfo = 0
+	di.ReceivedSize = 0
+	di.FirstActivity = time.Time{}
+	di.DuplicateCount = 0
 } //                                                                      Retain
 
 // UnpackBytes joins CompressedPieces and uncompresses
 // the resulting bytes to get the original data item.
-func (di *dataItem) UnpackBytes(compressor Compression) ([]byte, error) {
+//
+// If 'limit' is more than zero and the uncompressed data would exceed
+// 'limit' bytes, returns errUncompressedSize.
+//
+func (di *dataItem) UnpackBytes(compressor Compression, limit int) (
+	[]byte, error,
+) {
 	//
 	// join pieces (provided all have been collected) to get compressed data
 	if !di.IsLoaded() {
@@ -97,7 +146,16 @@ func (di *dataItem) UnpackBytes(compressor Compression) ([]byte, error) {
 	di.CompressedSizeInfo = len(comp)
 	//
 	// uncompress data
-	ret, err := compressor.Uncompress(comp)
+	var ret []byte
+	var err error
+	if lu, ok := compressor.(limitedUncompressor); ok && limit > 0 {
+		ret, err = lu.uncompressLimit(comp, limit)
+	} else {
+		ret, err = compressor.Uncompress(comp)
+	}
+	if err == errUncompressedSize || limit > 0 && len(ret) > limit {
+		return nil, errUncompressedSize
+	}
 	if err != nil {
 		return nil, makeError(0xE95DFB, err)
 	}
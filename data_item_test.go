@@ -11,10 +11,11 @@ import (
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 )
 
 // -----------------------------------------------------------------------------
-// # Property
+// # Properties
 
 // (di *dataItem) IsLoaded() bool
 //
@@ -37,6 +38,21 @@ func Test_dataItem_IsLoaded_(t *testing.T) {
 	}
 }
 
+// (di *dataItem) ReceivedCount() int
+//
+// go test -run Test_dataItem_ReceivedCount_
+//
+func Test_dataItem_ReceivedCount_(t *testing.T) {
+	var di dataItem
+	if di.ReceivedCount() != 0 {
+		t.Error("0xE70A32")
+	}
+	di.CompressedPieces = [][]byte{{1}, nil, {2, 3}, {}}
+	if di.ReceivedCount() != 2 {
+		t.Error("0xE68747")
+	}
+}
+
 // -----------------------------------------------------------------------------
 // # Methods
 
@@ -83,6 +99,9 @@ func Test_dataItem_Reset_(t *testing.T) {
 		CompressedPieces:     [][]byte{{6}, {7, 8}, {9, 10, 11}},
 		CompressedSizeInfo:   20,
 		UncompressedSizeInfo: 50,
+		ReceivedSize:         6,
+		FirstActivity:        time.Now(),
+		DuplicateCount:       2,
 	}
 	di.Reset()
 	if di.Key != "" {
@@ -100,6 +119,12 @@ func Test_dataItem_Reset_(t *testing.T) {
 	if di.UncompressedSizeInfo != 0 {
 		t.Error("0xE22CD6", "UncompressedSizeInfo not reset")
 	}
+	if di.ReceivedSize != 0 {
+		t.Error("0xE208D8", "ReceivedSize not reset")
+	}
+	if !di.FirstActivity.IsZero() || di.DuplicateCount != 0 {
+		t.Error("0xE2A6F4", "FirstActivity or DuplicateCount not reset")
+	}
 }
 
 // (di *dataItem) Retain(k string, hash []byte, packetCount int)
@@ -176,7 +201,7 @@ func Test_dataItem_Retain_(t *testing.T) {
 }
 
 // - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
-// (di *dataItem) UnpackBytes(compressor Compression) ([]byte, error)
+// (di *dataItem) UnpackBytes(compressor Compression, limit int) ( . . .
 //
 // go test -run Test_dataItem_UnpackBytes_*
 
@@ -205,7 +230,7 @@ func Test_dataItem_UnpackBytes_1(t *testing.T) {
 	}
 	var di = dataItem{Hash: hash, CompressedPieces: compPieces}
 	// ------------------------------
-	uncomp, err := di.UnpackBytes(zc)
+	uncomp, err := di.UnpackBytes(zc, 0)
 	// ------------------------------
 	if err != nil {
 		t.Error("0xEF6D12", err)
@@ -228,7 +253,7 @@ func Test_dataItem_UnpackBytes_1(t *testing.T) {
 func Test_dataItem_UnpackBytes_2(t *testing.T) {
 	zc := &zlibCompressor{}
 	var di0 dataItem
-	data, err := di0.UnpackBytes(zc)
+	data, err := di0.UnpackBytes(zc, 0)
 	if data != nil {
 		t.Error("0xED52E6")
 	}
@@ -263,7 +288,7 @@ func Test_dataItem_UnpackBytes_3(t *testing.T) {
 	zc = &zlibCompressor{}
 	// ------------------------------
 	di.Hash = []byte{0} // <- this must cause it to fail
-	uncomp, err := di.UnpackBytes(zc)
+	uncomp, err := di.UnpackBytes(zc, 0)
 	// ------------------------------
 	if uncomp != nil {
 		t.Error("0xED14FA")
@@ -283,7 +308,7 @@ func Test_dataItem_UnpackBytes_4(t *testing.T) {
 		}},
 	}
 	zc := &zlibCompressor{}
-	uncomp, err := di.UnpackBytes(zc)
+	uncomp, err := di.UnpackBytes(zc, 0)
 	if uncomp != nil {
 		t.Error("0xE59B01")
 	}
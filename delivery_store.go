@@ -0,0 +1,284 @@
+// -----------------------------------------------------------------------------
+// github.com/balacode/udpt                                 /[delivery_store.go]
+// (c) balarabe@protonmail.com                                      License: MIT
+// -----------------------------------------------------------------------------
+
+package udpt
+
+// # DeliveryStore Interface
+//   DeliveryStore interface
+//
+// # MemoryDeliveryStore Type
+//   MemoryDeliveryStore struct
+//   NewMemoryDeliveryStore(capacity int) *MemoryDeliveryStore
+//   ) Delivered(id string) (bool, error)
+//   ) MarkDelivered(id string) error
+//   ) Len() int
+//   ) add(id string) (added bool)
+//   ) ids() []string
+//
+// # FileDeliveryStore Type
+//   FileDeliveryStore struct
+//   OpenFileDeliveryStore(path string, capacity int,
+//   ) (*FileDeliveryStore, error)
+//   ) Delivered(id string) (bool, error)
+//   ) MarkDelivered(id string) error
+//   ) Len() int
+//   ) Close() error
+//   ) compact() error
+
+import (
+	"bufio"
+	"container/list"
+	"os"
+	"sync"
+)
+
+// defaultDeliveryHistory is the number of message IDs remembered by the
+// MemoryDeliveryStore which Receiver.Run() creates if Deliveries is nil
+const defaultDeliveryHistory = 10000
+
+// -----------------------------------------------------------------------------
+// # DeliveryStore Interface
+
+// DeliveryStore remembers the message IDs of the data items which a
+// Receiver has delivered, so that when a Sender resends an item (for
+// example because the confirmation of its last fragment was lost),
+// the Receiver confirms it again without calling Receive.
+//
+// Assign a DeliveryStore to Receiver.Deliveries. Its methods must be
+// safe for concurrent use.
+//
+type DeliveryStore interface {
+
+	// Delivered returns true if the item with message
+	// ID 'id' has been marked as delivered.
+	Delivered(id string) (bool, error)
+
+	// MarkDelivered records that the item with message ID 'id' has been
+	// delivered. The store may forget the oldest IDs to limit its size.
+	MarkDelivered(id string) error
+} //                                                               DeliveryStore
+
+// -----------------------------------------------------------------------------
+// # MemoryDeliveryStore Type
+
+// MemoryDeliveryStore is a DeliveryStore that keeps message IDs in memory.
+// When it's full, it forgets the least recently used ID. Since it's lost
+// when the Receiver stops, use FileDeliveryStore to remember delivered
+// items after a restart.
+type MemoryDeliveryStore struct {
+	mutex    sync.Mutex
+	capacity int
+	order    *list.List               // from the most recently used ID
+	elements map[string]*list.Element // elements of 'order' by ID
+} //                                                         MemoryDeliveryStore
+
+// NewMemoryDeliveryStore creates a MemoryDeliveryStore
+// that remembers up to 'capacity' message IDs.
+func NewMemoryDeliveryStore(capacity int) *MemoryDeliveryStore {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &MemoryDeliveryStore{
+		capacity: capacity,
+		order:    list.New(),
+		elements: make(map[string]*list.Element),
+	}
+} //                                                      NewMemoryDeliveryStore
+
+// Delivered implements DeliveryStore.Delivered().
+func (ms *MemoryDeliveryStore) Delivered(id string) (bool, error) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	el, found := ms.elements[id]
+	if found {
+		ms.order.MoveToFront(el)
+	}
+	return found, nil
+} //                                                                   Delivered
+
+// MarkDelivered implements DeliveryStore.MarkDelivered().
+func (ms *MemoryDeliveryStore) MarkDelivered(id string) error {
+	ms.add(id)
+	return nil
+} //                                                               MarkDelivered
+
+// Len returns the number of message IDs in the store.
+func (ms *MemoryDeliveryStore) Len() int {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	return ms.order.Len()
+} //                                                                         Len
+
+// add adds 'id' to the store, or makes it the most recently used ID if
+// it's already there. Returns true if the ID wasn't in the store.
+func (ms *MemoryDeliveryStore) add(id string) (added bool) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	if el, found := ms.elements[id]; found {
+		ms.order.MoveToFront(el)
+		return false
+	}
+	ms.elements[id] = ms.order.PushFront(id)
+	for ms.order.Len() > ms.capacity {
+		oldest := ms.order.Back()
+		ms.order.Remove(oldest)
+		delete(ms.elements, oldest.Value.(string))
+	}
+	return true
+} //                                                                         add
+
+// ids returns the message IDs in the store, from the least recently used
+func (ms *MemoryDeliveryStore) ids() []string {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	ret := make([]string, 0, ms.order.Len())
+	for el := ms.order.Back(); el != nil; el = el.Prev() {
+		ret = append(ret, el.Value.(string))
+	}
+	return ret
+} //                                                                         ids
+
+// -----------------------------------------------------------------------------
+// # FileDeliveryStore Type
+
+// FileDeliveryStore is a DeliveryStore that also keeps message IDs in a
+// file, one per line, so a Receiver remembers delivered items after it
+// restarts. New IDs are appended to the file and synced to disk before
+// MarkDelivered() returns. When the file has twice as many IDs as the
+// store's capacity, it's rewritten with only the retained IDs.
+type FileDeliveryStore struct {
+	mutex  sync.Mutex
+	path   string
+	file   *os.File
+	lines  int // number of IDs in the file
+	memory *MemoryDeliveryStore
+} //                                                           FileDeliveryStore
+
+// OpenFileDeliveryStore opens (or creates) the file at 'path' and loads
+// the last 'capacity' message IDs written to it. Call Close() when the
+// store is no longer needed.
+func OpenFileDeliveryStore(path string, capacity int,
+) (*FileDeliveryStore, error) {
+	fs := &FileDeliveryStore{
+		path:   path,
+		memory: NewMemoryDeliveryStore(capacity),
+	}
+	file, err := os.Open(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, makeError(0xEC7D3B, err)
+	}
+	if err == nil {
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			// skip blank lines, and a line cut short by a crash
+			if id := scanner.Text(); validateMessageID(id) == nil {
+				fs.memory.add(id)
+			}
+		}
+		err = scanner.Err()
+		file.Close()
+		if err != nil {
+			return nil, makeError(0xECAF3A, err)
+		}
+	}
+	err = fs.compact()
+	if err != nil {
+		return nil, err
+	}
+	return fs, nil
+} //                                                       OpenFileDeliveryStore
+
+// Delivered implements DeliveryStore.Delivered().
+func (fs *FileDeliveryStore) Delivered(id string) (bool, error) {
+	return fs.memory.Delivered(id)
+} //                                                                   Delivered
+
+// MarkDelivered implements DeliveryStore.MarkDelivered().
+func (fs *FileDeliveryStore) MarkDelivered(id string) error {
+	err := validateMessageID(id)
+	if err != nil {
+		return err
+	}
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	if fs.file == nil {
+		return makeError(0xEB2FEC, "FileDeliveryStore is closed")
+	}
+	if !fs.memory.add(id) {
+		return nil
+	}
+	_, err = fs.file.WriteString(id + "\n")
+	if err == nil {
+		err = fs.file.Sync()
+	}
+	if err != nil {
+		return makeError(0xE9C712, err)
+	}
+	fs.lines++
+	if fs.lines > 2*fs.memory.capacity {
+		return fs.compact()
+	}
+	return nil
+} //                                                               MarkDelivered
+
+// Len returns the number of message IDs in the store.
+func (fs *FileDeliveryStore) Len() int {
+	return fs.memory.Len()
+} //                                                                         Len
+
+// Close closes the store's file.
+func (fs *FileDeliveryStore) Close() error {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	if fs.file == nil {
+		return nil
+	}
+	err := fs.file.Close()
+	fs.file = nil
+	if err != nil {
+		return makeError(0xE6F7EC, err)
+	}
+	return nil
+} //                                                                       Close
+
+// compact rewrites the store's file with only the IDs kept in memory,
+// then reopens it for appending. Writes a temporary file and renames
+// it, so the old file remains intact if writing fails.
+func (fs *FileDeliveryStore) compact() error {
+	if fs.file != nil {
+		fs.file.Close()
+		fs.file = nil
+	}
+	tmpPath := fs.path + ".tmp"
+	tmp, err := os.Create(tmpPath)
+	if err != nil {
+		return makeError(0xE258CB, err)
+	}
+	ids := fs.memory.ids()
+	wr := bufio.NewWriter(tmp)
+	for _, id := range ids {
+		wr.WriteString(id + "\n")
+	}
+	err = wr.Flush()
+	if err == nil {
+		err = tmp.Sync()
+	}
+	tmp.Close()
+	if err == nil {
+		err = os.Rename(tmpPath, fs.path)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return makeError(0xEA5C5D, err)
+	}
+	fs.file, err = os.OpenFile(fs.path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return makeError(0xEB452B, err)
+	}
+	fs.lines = len(ids)
+	return nil
+} //                                                                     compact
+
+// end
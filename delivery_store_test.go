@@ -0,0 +1,129 @@
+// -----------------------------------------------------------------------------
+// github.com/balacode/udpt                            /[delivery_store_test.go]
+// (c) balarabe@protonmail.com                                      License: MIT
+// -----------------------------------------------------------------------------
+
+package udpt
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// to run all tests in this file:
+// go test -v -run Test_DeliveryStore_*
+
+// -----------------------------------------------------------------------------
+
+// checkDelivered checks if 'ds' has exactly the IDs in 'want',
+// out of IDs "a" to "f"
+func checkDelivered(t *testing.T, errID string, ds DeliveryStore,
+	want string,
+) {
+	var got []string
+	for _, id := range []string{"a", "b", "c", "d", "e", "f"} {
+		delivered, err := ds.Delivered(id)
+		if err != nil {
+			t.Error(errID, err)
+		}
+		if delivered {
+			got = append(got, id)
+		}
+	}
+	if strings.Join(got, " ") != want {
+		t.Error(errID, "want:", want, "got:", got)
+	}
+}
+
+// -----------------------------------------------------------------------------
+// (ms *MemoryDeliveryStore) Delivered(id string) (bool, error)
+// (ms *MemoryDeliveryStore) MarkDelivered(id string) error
+//
+// go test -run Test_DeliveryStore_MemoryDeliveryStore_
+//
+func Test_DeliveryStore_MemoryDeliveryStore_(t *testing.T) {
+	ms := NewMemoryDeliveryStore(3)
+	checkDelivered(t, "0xEB780A", ms, "")
+	for _, id := range []string{"a", "b", "c"} {
+		_ = ms.MarkDelivered(id)
+	}
+	checkDelivered(t, "0xE8025B", ms, "a b c")
+	//
+	// must forget the least recently used ID ("b", since "a" was looked up)
+	_, _ = ms.Delivered("a")
+	_ = ms.MarkDelivered("d")
+	checkDelivered(t, "0xEEC923", ms, "a c d")
+	//
+	// marking an ID again must not add it twice
+	_ = ms.MarkDelivered("c")
+	if ms.Len() != 3 || fmt.Sprint(ms.ids()) != "[a d c]" {
+		t.Error("0xE37722", ms.Len(), ms.ids())
+	}
+}
+
+// -----------------------------------------------------------------------------
+// OpenFileDeliveryStore(path string, capacity int,
+// ) (*FileDeliveryStore, error)
+//
+// go test -run Test_DeliveryStore_FileDeliveryStore_*
+
+// must remember IDs after reopening, and compact the file
+func Test_DeliveryStore_FileDeliveryStore_1(t *testing.T) {
+	dir, err := ioutil.TempDir("", "udpt")
+	if err != nil {
+		t.Fatal("0xEC299E", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "delivered.txt")
+	fs, err := OpenFileDeliveryStore(path, 2)
+	if err != nil {
+		t.Fatal("0xE58C03", err)
+	}
+	for _, id := range []string{"a", "b", "a", "c", "d", "e"} {
+		err = fs.MarkDelivered(id)
+		if err != nil {
+			t.Error("0xE9BECA", err)
+		}
+	}
+	checkDelivered(t, "0xE2D33C", fs, "d e")
+	// 4 IDs were written, then the file was rewritten with 2 on the 5th
+	data, _ := ioutil.ReadFile(path)
+	if string(data) != "d\ne\n" {
+		t.Error("0xE6BA19", "wrong file:", string(data))
+	}
+	_ = fs.Close()
+	if err := fs.MarkDelivered("f"); !matchError(err, "closed") {
+		t.Error("0xE84258", "wrong error:", err)
+	}
+	// a line cut short by a crash must be ignored
+	_ = ioutil.WriteFile(path, []byte("c\nd\ne\nf f"), 0600)
+	fs, err = OpenFileDeliveryStore(path, 10)
+	if err != nil {
+		t.Fatal("0xE9D369", err)
+	}
+	defer fs.Close()
+	checkDelivered(t, "0xE66E9F", fs, "c d e")
+}
+
+// must fail when the file can't be written
+func Test_DeliveryStore_FileDeliveryStore_2(t *testing.T) {
+	path := filepath.Join(os.DevNull, "no", "such", "dir", "delivered.txt")
+	fs, err := OpenFileDeliveryStore(path, 10)
+	if fs != nil || err == nil {
+		t.Error("0xE1E438", "expected an error")
+	}
+	// invalid IDs must not be written to the file
+	dir, _ := ioutil.TempDir("", "udpt")
+	defer os.RemoveAll(dir)
+	fs, _ = OpenFileDeliveryStore(filepath.Join(dir, "d.txt"), 10)
+	defer fs.Close()
+	if err := fs.MarkDelivered("a\nb"); !matchError(err, "invalid") {
+		t.Error("0xE8B0DB", "wrong error:", err)
+	}
+}
+
+// end
@@ -0,0 +1,217 @@
+// -----------------------------------------------------------------------------
+// github.com/balacode/udpt                                     /[derive_key.go]
+// (c) balarabe@protonmail.com                                      License: MIT
+// -----------------------------------------------------------------------------
+
+package udpt
+
+// # Constants
+//   DefaultKeyIterations
+//
+// # KeyParams Type
+//   KeyParams struct
+//   NewKeyParams() (*KeyParams, error)
+//   ParseKeyParams(s string) (*KeyParams, error)
+//
+// # Methods (kp *KeyParams)
+//   ) DeriveKey(passphrase string) ([]byte, error)
+//   ) DeriveKeys(passphrase string) (sendKey, replyKey []byte, err error)
+//   ) String() string
+//   ) Validate() error
+//
+// # Internal Functions
+//   expandKey(masterKey []byte, info string) ([]byte, error)
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/hkdf"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// DefaultKeyIterations is the number of PBKDF2 iterations used by
+// NewKeyParams(). It follows the OWASP recommendation for
+// PBKDF2-HMAC-SHA256 and takes a fraction of a second to compute.
+const DefaultKeyIterations = 600000
+
+// keyParamsAlgorithm identifies the key derivation
+// algorithm in the text form of KeyParams.
+const keyParamsAlgorithm = "pbkdf2-sha256"
+
+// keySaltSize is the size of the random salt generated by NewKeyParams()
+const keySaltSize = 16
+
+// keySize is the size of keys returned by DeriveKey() and DeriveKeys().
+// It matches the size of the key required by the default AES-256 cipher.
+const keySize = 32
+
+// infoSendKey and infoReplyKey are the HKDF 'info' strings which
+// separate the subkey used to encrypt packets sent by the Sender
+// from the subkey used to encrypt replies sent by the Receiver.
+const (
+	infoSendKey  = "udpt sender-to-receiver key"
+	infoReplyKey = "udpt receiver-to-sender key"
+)
+
+// -----------------------------------------------------------------------------
+// # KeyParams Type
+
+// KeyParams contains the parameters used to derive a CryptoKey from a
+// human-readable passphrase. The Sender and the Receiver must use the
+// same passphrase and the same KeyParams to derive the same key.
+//
+// KeyParams are not secret: you can store or distribute them alongside
+// your configuration in the text form returned by String(), which looks
+// like "pbkdf2-sha256:600000:0123456789ABCDEF0123456789ABCDEF", then
+// read them back on each side with ParseKeyParams().
+//
+type KeyParams struct {
+
+	// Salt is a random value that makes the derived key unique
+	// even when the same passphrase is used in different places.
+	Salt []byte
+
+	// Iterations is the number of PBKDF2 iterations. More iterations
+	// make brute-forcing the passphrase slower, but also take
+	// longer to derive the key when starting a Sender or Receiver.
+	Iterations int
+} //                                                                   KeyParams
+
+// NewKeyParams creates KeyParams with a new random
+// salt and DefaultKeyIterations iterations.
+func NewKeyParams() (*KeyParams, error) {
+	return newKeyParamsDI(rand.Reader)
+} //                                                                NewKeyParams
+
+// newKeyParamsDI is only used by NewKeyParams() and provides parameters
+// for dependency injection, to enable mocking during testing.
+func newKeyParamsDI(random io.Reader) (*KeyParams, error) {
+	salt := make([]byte, keySaltSize)
+	_, err := io.ReadFull(random, salt)
+	if err != nil {
+		return nil, makeError(0xE01618, err)
+	}
+	return &KeyParams{Salt: salt, Iterations: DefaultKeyIterations}, nil
+} //                                                              newKeyParamsDI
+
+// ParseKeyParams reads KeyParams from the text form returned by String().
+func ParseKeyParams(s string) (*KeyParams, error) {
+	parts := strings.Split(strings.TrimSpace(s), ":")
+	if len(parts) != 3 {
+		return nil, makeError(0xE74731, "invalid KeyParams:", s)
+	}
+	if parts[0] != keyParamsAlgorithm {
+		return nil, makeError(0xEF4286,
+			"unsupported KeyParams algorithm:", parts[0])
+	}
+	iterations, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return nil, makeError(0xEF8FC9, "invalid KeyParams iterations:", err)
+	}
+	salt, err := hex.DecodeString(parts[2])
+	if err != nil {
+		return nil, makeError(0xE83EF1, "invalid KeyParams salt:", err)
+	}
+	kp := &KeyParams{Salt: salt, Iterations: iterations}
+	err = kp.Validate()
+	if err != nil {
+		return nil, err
+	}
+	return kp, nil
+} //                                                              ParseKeyParams
+
+// -----------------------------------------------------------------------------
+// # Methods (kp *KeyParams)
+
+// DeriveKey derives a 32-byte key from 'passphrase' using PBKDF2 with
+// HMAC-SHA256. The key can be used as Sender.CryptoKey and
+// Receiver.CryptoKey with the default AES-256 cipher.
+func (kp *KeyParams) DeriveKey(passphrase string) ([]byte, error) {
+	err := kp.Validate()
+	if err != nil {
+		return nil, err
+	}
+	if passphrase == "" {
+		return nil, makeError(0xE94798, "empty passphrase")
+	}
+	ret := pbkdf2.Key([]byte(passphrase), kp.Salt, kp.Iterations, keySize,
+		sha256.New)
+	return ret, nil
+} //                                                                   DeriveKey
+
+// DeriveKeys derives a separate key for each direction of a transfer.
+//
+// It derives a master key from 'passphrase' with DeriveKey(), then
+// expands it with HKDF into two independent 32-byte subkeys:
+//
+// sendKey encrypts packets sent by the Sender to the Receiver.
+// Assign it to Sender.CryptoKey and Receiver.CryptoKey.
+//
+// replyKey encrypts replies sent by the Receiver back to the Sender.
+// Assign it to Sender.ReplyCryptoKey and Receiver.ReplyCryptoKey.
+//
+func (kp *KeyParams) DeriveKeys(passphrase string) (
+	sendKey, replyKey []byte, err error,
+) {
+	masterKey, err := kp.DeriveKey(passphrase)
+	if err != nil {
+		return nil, nil, err
+	}
+	sendKey, err = expandKey(masterKey, infoSendKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	replyKey, err = expandKey(masterKey, infoReplyKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	return sendKey, replyKey, nil
+} //                                                                  DeriveKeys
+
+// String returns the text form of KeyParams, which can
+// be read back with ParseKeyParams(). For example:
+// "pbkdf2-sha256:600000:0123456789ABCDEF0123456789ABCDEF"
+func (kp *KeyParams) String() string {
+	return fmt.Sprintf("%s:%d:%X", keyParamsAlgorithm, kp.Iterations, kp.Salt)
+} //                                                                      String
+
+// Validate returns nil if the parameters can be used
+// to derive a key, or an error describing the problem.
+func (kp *KeyParams) Validate() error {
+	if kp == nil {
+		return makeError(0xED3231, "nil KeyParams")
+	}
+	if len(kp.Salt) < 8 {
+		return makeError(0xE52187,
+			"KeyParams.Salt must be at least 8 bytes long")
+	}
+	if kp.Iterations < 1000 {
+		return makeError(0xE5760B,
+			"invalid KeyParams.Iterations:", kp.Iterations)
+	}
+	return nil
+} //                                                                    Validate
+
+// -----------------------------------------------------------------------------
+// # Internal Functions
+
+// expandKey derives a keySize-byte subkey from 'masterKey' using the
+// HKDF-Expand step of RFC 5869 with SHA-256, where 'info' separates
+// the subkeys. The master key is the output of PBKDF2, which is
+// already uniformly random, so the HKDF-Extract step is not needed.
+func expandKey(masterKey []byte, info string) ([]byte, error) {
+	ret := make([]byte, keySize)
+	_, err := io.ReadFull(hkdf.Expand(sha256.New, masterKey, []byte(info)), ret)
+	if err != nil {
+		return nil, makeError(0xE0AF79, err)
+	}
+	return ret, nil
+} //                                                                   expandKey
+
+// end
@@ -0,0 +1,225 @@
+// -----------------------------------------------------------------------------
+// github.com/balacode/udpt                                /[derive_key_test.go]
+// (c) balarabe@protonmail.com                                      License: MIT
+// -----------------------------------------------------------------------------
+
+package udpt
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+	"time"
+)
+
+// to run all tests in this file:
+// go test -v -run Test_KeyParams_*
+
+// -----------------------------------------------------------------------------
+
+// NewKeyParams() (*KeyParams, error)
+//
+// go test -run Test_KeyParams_NewKeyParams_
+//
+func Test_KeyParams_NewKeyParams_(t *testing.T) {
+	kp, err := NewKeyParams()
+	if err != nil {
+		t.Error("0xED893C", err)
+	}
+	if len(kp.Salt) != keySaltSize {
+		t.Error("0xEE943E")
+	}
+	if kp.Iterations != DefaultKeyIterations {
+		t.Error("0xE1466C")
+	}
+	// must fail when random bytes can't be read
+	kp, err = newKeyParamsDI(strings.NewReader("short"))
+	if kp != nil {
+		t.Error("0xEA8D8F")
+	}
+	if !matchError(err, "EOF") {
+		t.Error("0xEA3212", "wrong error:", err)
+	}
+}
+
+// ParseKeyParams(s string) (*KeyParams, error)
+//
+// go test -run Test_KeyParams_ParseKeyParams_
+//
+func Test_KeyParams_ParseKeyParams_(t *testing.T) {
+	want := KeyParams{
+		Salt:       []byte("0123456789ABCDEF"),
+		Iterations: 2000,
+	}
+	s := want.String()
+	if s != "pbkdf2-sha256:2000:30313233343536373839414243444546" {
+		t.Error("0xEB66D9", "wrong String():", s)
+	}
+	got, err := ParseKeyParams(" " + s + "\n")
+	if err != nil {
+		t.Error("0xE2C407", err)
+	}
+	if !bytes.Equal(got.Salt, want.Salt) || got.Iterations != 2000 {
+		t.Error("0xE28966")
+	}
+	for _, tc := range []struct {
+		s   string
+		err string
+	}{
+		{"", "invalid KeyParams"},
+		{"pbkdf2-sha256:2000", "invalid KeyParams"},
+		{"scrypt:2000:3031323334353637", "unsupported KeyParams algorithm"},
+		{"pbkdf2-sha256:many:3031323334353637", "invalid KeyParams iterations"},
+		{"pbkdf2-sha256:2000:ZZ", "invalid KeyParams salt"},
+		{"pbkdf2-sha256:2000:3031", "at least 8 bytes"},
+		{"pbkdf2-sha256:999:3031323334353637", "invalid KeyParams.Iterations"},
+	} {
+		kp, err := ParseKeyParams(tc.s)
+		if kp != nil {
+			t.Error("0xEA2EDB", tc.s)
+		}
+		if !matchError(err, tc.err) {
+			t.Error("0xEA38EE", tc.s, "wrong error:", err)
+		}
+	}
+}
+
+// -----------------------------------------------------------------------------
+// # Methods (kp *KeyParams)
+
+// (kp *KeyParams) DeriveKey(passphrase string) ([]byte, error)
+//
+// go test -run Test_KeyParams_DeriveKey_
+//
+func Test_KeyParams_DeriveKey_(t *testing.T) {
+	kp := KeyParams{Salt: []byte("salt-salt"), Iterations: 1000}
+	key1, err := kp.DeriveKey("correct horse battery staple")
+	if err != nil {
+		t.Error("0xE52324", err)
+	}
+	if len(key1) != 32 {
+		t.Error("0xE7875F")
+	}
+	if (&aesCipher{}).ValidateKey(key1) != nil {
+		t.Error("0xEAB0D2")
+	}
+	// the same passphrase and parameters must derive the same key
+	key2, _ := kp.DeriveKey("correct horse battery staple")
+	if !bytes.Equal(key1, key2) {
+		t.Error("0xED8931")
+	}
+	// a different salt must derive a different key
+	kp.Salt = []byte("pepper-pepper")
+	key2, _ = kp.DeriveKey("correct horse battery staple")
+	if bytes.Equal(key1, key2) {
+		t.Error("0xEAA4F2")
+	}
+	// must match a published PBKDF2-HMAC-SHA256 test vector
+	kp = KeyParams{Salt: []byte("saltSALTsaltSALTsaltSALTsaltSALTsalt"),
+		Iterations: 4096}
+	key2, _ = kp.DeriveKey("passwordPASSWORDpassword")
+	want := "348c89dbcbd32b2f32d814b8116e84cf" +
+		"2b17347ebc1800181c4e2a1fb8dd53e1"
+	if got := hex.EncodeToString(key2); got != want {
+		t.Error("0xEF45E6", "\n", "want:", want, "\n", " got:", got)
+	}
+	// must fail with a blank passphrase or invalid parameters
+	_, err = kp.DeriveKey("")
+	if !matchError(err, "empty passphrase") {
+		t.Error("0xE9FAC4", "wrong error:", err)
+	}
+	var nilParams *KeyParams
+	_, err = nilParams.DeriveKey("abc")
+	if !matchError(err, "nil KeyParams") {
+		t.Error("0xE5FA2E", "wrong error:", err)
+	}
+}
+
+// (kp *KeyParams) DeriveKeys(passphrase string) (
+//     sendKey, replyKey []byte, err error)
+//
+// go test -run Test_KeyParams_DeriveKeys_*
+
+// must derive two different keys for each direction
+func Test_KeyParams_DeriveKeys_1(t *testing.T) {
+	kp := KeyParams{Salt: []byte("salt-salt"), Iterations: 1000}
+	masterKey, _ := kp.DeriveKey("passphrase")
+	sendKey, replyKey, err := kp.DeriveKeys("passphrase")
+	if err != nil {
+		t.Error("0xE7B2C1", err)
+	}
+	if len(sendKey) != 32 || len(replyKey) != 32 {
+		t.Error("0xED40D5")
+	}
+	if bytes.Equal(sendKey, replyKey) ||
+		bytes.Equal(sendKey, masterKey) ||
+		bytes.Equal(replyKey, masterKey) {
+		t.Error("0xE41D15")
+	}
+	_, _, err = kp.DeriveKeys("")
+	if !matchError(err, "empty passphrase") {
+		t.Error("0xEF1FEF", "wrong error:", err)
+	}
+}
+
+// must transfer data when each direction is encrypted with its own key
+func Test_KeyParams_DeriveKeys_2(t *testing.T) {
+	kp := KeyParams{Salt: []byte("salt-salt"), Iterations: 1000}
+	sendKey, replyKey, _ := kp.DeriveKeys("passphrase")
+	//
+	_, rc, td := makeConfigAndReceiver(sendKey)
+	rc.ReplyCryptoKey = replyKey
+	go func() { _ = rc.Run() }()
+	defer func() { rc.Stop() }()
+	time.Sleep(200 * time.Millisecond)
+	//
+	// each Sender needs its own Configuration, since
+	// the Receiver's ciphers hold different keys
+	newSender := func(replyKey []byte) *Sender {
+		cf := NewDefaultConfig()
+		cf.ReplyTimeout = 250 * time.Millisecond
+		cf.WriteTimeout = 250 * time.Millisecond
+		cf.SendRetries = 1
+		return &Sender{Address: "127.0.0.1:9876", CryptoKey: sendKey,
+			ReplyCryptoKey: replyKey, Config: cf}
+	}
+	sd := newSender(replyKey)
+	defer func() { _ = sd.Close() }()
+	err := sd.SendString("k", "directional")
+	if err != nil {
+		t.Error("0xE30D3F", err)
+	}
+	if got := td.get("", "k"); len(got) != 1 || string(got[0]) != "directional" {
+		t.Error("0xE67C50")
+	}
+	// the Sender can't read replies if it uses the wrong reply key
+	wrong := newSender(sendKey)
+	defer func() { _ = wrong.Close() }()
+	err = wrong.SendString("k2", "wrong reply key")
+	if !matchError(err, "undelivered packets") {
+		t.Error("0xE86B71", "wrong error:", err)
+	}
+}
+
+// -----------------------------------------------------------------------------
+// # Internal Functions
+
+// expandKey(masterKey []byte, info string) ([]byte, error)
+//
+// go test -run Test_expandKey_
+//
+func Test_expandKey_(t *testing.T) {
+	// RFC 5869, Appendix A.1, Test Case 1 (the first keySize bytes)
+	prk, _ := hex.DecodeString("077709362c2e32df0ddc3f0dc47bba63" +
+		"90b6c73bb50f9c3122ec844ad7c2b3e5")
+	info, _ := hex.DecodeString("f0f1f2f3f4f5f6f7f8f9")
+	want := "3cb25f25faacd57a90434f64d0362f2a" +
+		"2d2d0a90cf1a5a4c5db02d56ecc4c5bf"
+	got, err := expandKey(prk, string(info))
+	if hex.EncodeToString(got) != want || err != nil {
+		t.Error("0xE9ABB1", "\n", "want:", want, "\n", " got:", got, err)
+	}
+}
+
+// end
@@ -0,0 +1,49 @@
+// -----------------------------------------------------------------------------
+// github.com/balacode/udpt                            /[dont_fragment_linux.go]
+// (c) balarabe@protonmail.com                                      License: MIT
+// -----------------------------------------------------------------------------
+
+//go:build linux
+// +build linux
+
+package udpt
+
+import (
+	"syscall"
+)
+
+// setDontFragment sets the "don't fragment" (DF) flag on the packets
+// sent through 'conn', so that packets too large for the path are
+// dropped instead of being fragmented at the IP level. This makes path
+// MTU probes fail when they exceed the path MTU (see discoverPathMTU).
+//
+// Does nothing if 'conn' is not a socket, for example if it was created
+// by a Transport for testing. Returns an error only if neither the IPv4
+// nor the IPv6 option could be set.
+//
+func setDontFragment(conn interface{}) error {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return nil
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return makeError(0xE03CB8, err)
+	}
+	var err4, err6 error
+	err = raw.Control(func(fd uintptr) {
+		err4 = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP,
+			syscall.IP_MTU_DISCOVER, syscall.IP_PMTUDISC_DO)
+		err6 = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6,
+			syscall.IPV6_MTU_DISCOVER, syscall.IPV6_PMTUDISC_DO)
+	})
+	if err != nil {
+		return makeError(0xEACBBC, err)
+	}
+	if err4 != nil && err6 != nil {
+		return makeError(0xEBE9A0, "can't set DF flag:", err4)
+	}
+	return nil
+} //                                                             setDontFragment
+
+// end
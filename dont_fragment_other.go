@@ -0,0 +1,19 @@
+// -----------------------------------------------------------------------------
+// github.com/balacode/udpt                            /[dont_fragment_other.go]
+// (c) balarabe@protonmail.com                                      License: MIT
+// -----------------------------------------------------------------------------
+
+//go:build !linux
+// +build !linux
+
+package udpt
+
+// setDontFragment does nothing on this operating system, where the
+// "don't fragment" flag can't be set portably. Path MTU probes are then
+// sent without it, so a probe that is fragmented at the IP level, but
+// reassembled by the Receiver, counts as delivered.
+func setDontFragment(conn interface{}) error {
+	return nil
+} //                                                             setDontFragment
+
+// end
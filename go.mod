@@ -7,4 +7,9 @@ module github.com/balacode/udpt
 
 go 1.16
 
+// x/crypto provides PBKDF2 and HKDF (see derive_key.go), which the standard
+// library doesn't have in Go 1.16. v0.14.0 is the latest tagged release
+// that still supports Go 1.16; only its pbkdf2 and hkdf packages are used.
+require golang.org/x/crypto v0.14.0
+
 // end
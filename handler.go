@@ -0,0 +1,209 @@
+// -----------------------------------------------------------------------------
+// github.com/balacode/udpt                                        /[handler.go]
+// (c) balarabe@protonmail.com                                      License: MIT
+// -----------------------------------------------------------------------------
+
+package udpt
+
+// # Request Type
+//   Request struct
+//   ) Duration() time.Duration
+//
+// # Handler Interface
+//   Handler interface
+//   HandlerFunc func(req *Request) error
+//   ) HandleItem(req *Request) error
+//   ReceiveHandler(receive func(k string, v []byte) error) Handler
+//
+// # Middleware
+//   Middleware func(next Handler) Handler
+//   Chain(handler Handler, middleware ...Middleware) Handler
+//   RecoverPanics(w io.Writer) Middleware
+//   Timing(observe func(req *Request, elapsed time.Duration, err error),
+//   ) Middleware
+//   Logging(w io.Writer) Middleware
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"runtime/debug"
+	"time"
+)
+
+// -----------------------------------------------------------------------------
+// # Request Type
+
+// Request is a data item received by a Receiver, with details
+// about the transfer, which the Receiver passes to a Handler.
+//
+// To receive these details with a callback instead of Receive, assign
+// a HandlerFunc to Receiver.Handler:
+//
+//     rc.Handler = udpt.HandlerFunc(func(req *udpt.Request) error {
+//         log.Println(req.Key, "from", req.Addr, "in", req.Duration())
+//         return nil
+//     })
+//
+type Request struct {
+
+	// Key and Value are the key and value sent by Sender.Send(), etc.
+	Key   string
+	Value []byte
+
+	// MessageID is the unique ID which the Sender gave the item, or a
+	// blank string if the Sender is an older version that sends no IDs.
+	// See Sender.SendWithID(). For an item sent in a batch (see
+	// Configuration.BatchLinger), it's the batch's ID followed by
+	// a dot and the item's number in the batch.
+	MessageID string
+
+	// Addr is the address of the Sender that sent the item's last
+	// fragment. For an item sent by a MulticastSender, it's the
+	// MulticastSender's address.
+	Addr net.Addr
+
+	// Hash is the SHA-256 hash of Value, which the Sender sent with every
+	// fragment, and which the Receiver checked after reassembling Value.
+	Hash []byte
+
+	// CompressedSize is the size of the item as it was transferred, in
+	// bytes. UncompressedSize is the size of Value when it was received.
+	// For an item sent in a batch, CompressedSize is the batch's size.
+	CompressedSize   int
+	UncompressedSize int
+
+	// FragmentCount is the number of fragments the item was split
+	// into. For an item sent in a batch, it's the batch's count.
+	FragmentCount int
+
+	// DuplicateFragments is the number of fragments received more than
+	// once, usually because the Sender resent them after confirmations
+	// were lost or delayed.
+	DuplicateFragments int
+
+	// FirstFragmentTime and ReceivedTime are the times
+	// the first and the last fragment of the item arrived.
+	FirstFragmentTime time.Time
+	ReceivedTime      time.Time
+} //                                                                     Request
+
+// Duration returns the time taken to receive all the
+// fragments of the item, from the first to the last.
+func (req *Request) Duration() time.Duration {
+	return req.ReceivedTime.Sub(req.FirstFragmentTime)
+} //                                                                    Duration
+
+// -----------------------------------------------------------------------------
+// # Handler Interface
+
+// Handler handles the data items received by a Receiver. Assign it to
+// Receiver.Handler, as an alternative to the Receive callback.
+//
+// HandleItem is called when a data item has been fully received. Like
+// Receive, it can return Reject(reason) to refuse the item and send
+// the reason back to the Sender.
+//
+type Handler interface {
+	HandleItem(req *Request) error
+} //                                                                     Handler
+
+// HandlerFunc is an adapter that allows a function to be used as a Handler.
+type HandlerFunc func(req *Request) error
+
+// HandleItem implements Handler by calling fn(req).
+func (fn HandlerFunc) HandleItem(req *Request) error {
+	return fn(req)
+} //                                                                  HandleItem
+
+// ReceiveHandler returns a Handler that calls a function with the same
+// signature as Receiver.Receive (or ReceiveMux.Receive), so the function
+// can be wrapped with middleware.
+func ReceiveHandler(receive func(k string, v []byte) error) Handler {
+	return HandlerFunc(func(req *Request) error {
+		return receive(req.Key, req.Value)
+	})
+} //                                                              ReceiveHandler
+
+// -----------------------------------------------------------------------------
+// # Middleware
+
+// Middleware wraps a Handler to add behavior before or after it, such as
+// logging, measuring, authorizing or recovering from panics. It returns
+// the wrapping Handler, which should normally call next.HandleItem().
+type Middleware func(next Handler) Handler
+
+// Chain wraps 'handler' with the given middleware and returns the result.
+// The first middleware is the outermost, so it's the first to see each
+// request:
+//
+//     rc.Handler = udpt.Chain(mux, udpt.RecoverPanics(nil),
+//         udpt.Logging(os.Stdout))
+//
+// calls RecoverPanics, which calls Logging, which calls mux.
+//
+func Chain(handler Handler, middleware ...Middleware) Handler {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+	return handler
+} //                                                                       Chain
+
+// RecoverPanics returns a Middleware that recovers from a panic in
+// the handlers it wraps, and returns the panic as an error instead.
+// If 'w' is not nil, the panic's stack trace is written to it.
+//
+// A Receiver recovers from panics in its Handler even without this
+// middleware. Use RecoverPanics to get the stack trace, or to let
+// the middleware that wraps it see the panic as an error.
+//
+func RecoverPanics(w io.Writer) Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(req *Request) (err error) {
+			defer func() {
+				r := recover()
+				if r == nil {
+					return
+				}
+				err = makeError(0xE58650, "panic handling item:", req.Key, r)
+				if w != nil {
+					fmt.Fprintf(w, "%s\n%s", err, debug.Stack())
+				}
+			}()
+			return next.HandleItem(req)
+		})
+	}
+} //                                                               RecoverPanics
+
+// Timing returns a Middleware that measures how long the handlers it
+// wraps take to handle each item, and passes the elapsed time and
+// their result to 'observe', for example to record metrics.
+func Timing(
+	observe func(req *Request, elapsed time.Duration, err error),
+) Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(req *Request) error {
+			t0 := time.Now()
+			err := next.HandleItem(req)
+			observe(req, time.Since(t0), err)
+			return err
+		})
+	}
+} //                                                                      Timing
+
+// Logging returns a Middleware that writes a line to 'w' for every item
+// handled by the handlers it wraps, with the item's key, size, Sender's
+// address, the time taken and the error, if any.
+func Logging(w io.Writer) Middleware {
+	return Timing(func(req *Request, elapsed time.Duration, err error) {
+		result := "ok"
+		if err != nil {
+			result = err.Error()
+		}
+		fmt.Fprintf(w, "handled: %s size: %d from: %v time: %0.1f ms %s\n",
+			req.Key, len(req.Value), req.Addr,
+			float64(elapsed)/float64(time.Millisecond), result)
+	})
+} //                                                                     Logging
+
+// end
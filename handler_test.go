@@ -0,0 +1,244 @@
+// -----------------------------------------------------------------------------
+// github.com/balacode/udpt                                   /[handler_test.go]
+// (c) balarabe@protonmail.com                                      License: MIT
+// -----------------------------------------------------------------------------
+
+package udpt
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/balacode/udpt/udptest"
+)
+
+// to run all tests in this file:
+// go test -v -run Test_Handler_*
+
+// -----------------------------------------------------------------------------
+
+// makeTestMiddleware returns a Middleware that appends
+// 'name' to 'calls' before and after calling the next Handler
+func makeTestMiddleware(name string, calls *[]string) Middleware {
+	return func(next Handler) Handler {
+		return HandlerFunc(func(req *Request) error {
+			*calls = append(*calls, name)
+			err := next.HandleItem(req)
+			*calls = append(*calls, "/"+name)
+			return err
+		})
+	}
+}
+
+// -----------------------------------------------------------------------------
+// Chain(handler Handler, middleware ...Middleware) Handler
+//
+// go test -run Test_Handler_Chain_
+
+// must call the middleware in order, the first being the outermost
+func Test_Handler_Chain_(t *testing.T) {
+	var calls []string
+	h := Chain(
+		HandlerFunc(func(req *Request) error {
+			calls = append(calls, "handler "+req.Key)
+			return nil
+		}),
+		makeTestMiddleware("a", &calls),
+		makeTestMiddleware("b", &calls),
+	)
+	err := h.HandleItem(&Request{Key: "k"})
+	if err != nil || fmt.Sprint(calls) != "[a b handler k /b /a]" {
+		t.Error("0xE1C7B2", err, calls)
+	}
+	// without middleware, must return the handler itself
+	calls = nil
+	_ = Chain(ReceiveHandler(func(k string, v []byte) error {
+		calls = append(calls, k+"="+string(v))
+		return nil
+	})).HandleItem(&Request{Key: "k", Value: []byte("v")})
+	if fmt.Sprint(calls) != "[k=v]" {
+		t.Error("0xE68305", calls)
+	}
+}
+
+// -----------------------------------------------------------------------------
+// RecoverPanics(w io.Writer) Middleware
+//
+// go test -run Test_Handler_RecoverPanics_
+
+// must return a panic as an error and write the stack trace
+func Test_Handler_RecoverPanics_(t *testing.T) {
+	var tlog strings.Builder
+	h := Chain(HandlerFunc(func(req *Request) error {
+		if req.Key == "bad" {
+			panic("boom")
+		}
+		return errors.New("failed")
+	}), RecoverPanics(&tlog))
+	err := h.HandleItem(&Request{Key: "bad"})
+	if !matchError(err, "panic handling item: bad boom") {
+		t.Error("0xE3545D", "wrong error:", err)
+	}
+	if !strings.Contains(tlog.String(), "goroutine") {
+		t.Error("0xEC7540", "missing stack trace:", tlog.String())
+	}
+	// must return errors unchanged
+	err = h.HandleItem(&Request{Key: "good"})
+	if err == nil || err.Error() != "failed" {
+		t.Error("0xE73EFD", "wrong error:", err)
+	}
+	// the stack trace is optional
+	h = Chain(HandlerFunc(func(req *Request) error { panic("boom") }),
+		RecoverPanics(nil))
+	if !matchError(h.HandleItem(&Request{}), "boom") {
+		t.Error("0xE67162")
+	}
+}
+
+// -----------------------------------------------------------------------------
+// Timing(observe func(req *Request, elapsed time.Duration, err error),
+// ) Middleware
+// Logging(w io.Writer) Middleware
+//
+// go test -run Test_Handler_Timing_
+
+// must report the time taken and the result of each item
+func Test_Handler_Timing_(t *testing.T) {
+	var (
+		gotReq     *Request
+		gotElapsed time.Duration
+		gotErr     error
+	)
+	h := Chain(HandlerFunc(func(req *Request) error {
+		time.Sleep(20 * time.Millisecond)
+		return Reject("no")
+	}), Timing(func(req *Request, elapsed time.Duration, err error) {
+		gotReq, gotElapsed, gotErr = req, elapsed, err
+	}))
+	req := &Request{Key: "k"}
+	err := h.HandleItem(req)
+	if gotReq != req || gotElapsed < 20*time.Millisecond ||
+		gotErr != err || !matchError(err, "rejected: no") {
+		t.Error("0xEEB028", gotReq, gotElapsed, gotErr)
+	}
+}
+
+// must write a line for each item
+func Test_Handler_Logging_(t *testing.T) {
+	var tlog strings.Builder
+	h := Chain(HandlerFunc(func(req *Request) error {
+		if req.Key == "bad" {
+			return errors.New("failed")
+		}
+		return nil
+	}), Logging(&tlog))
+	addr := &mockNetAddr{network: "udp", addr: "10.0.0.1:5000"}
+	_ = h.HandleItem(&Request{Key: "good", Value: []byte("abc"), Addr: addr})
+	_ = h.HandleItem(&Request{Key: "bad", Addr: addr})
+	lines := strings.Split(strings.TrimSpace(tlog.String()), "\n")
+	if len(lines) != 2 ||
+		!strings.HasPrefix(lines[0],
+			"handled: good size: 3 from: 10.0.0.1:5000 time: ") ||
+		!strings.HasSuffix(lines[0], " ms ok") ||
+		!strings.HasSuffix(lines[1], " ms failed") {
+		t.Error("0xE29DA7", "wrong log:", tlog.String())
+	}
+}
+
+// -----------------------------------------------------------------------------
+// # Receiver.Handler
+
+// a Receiver must pass the Sender's address to its Handler,
+// and keep running after a recovered panic
+func Test_Handler_Receiver_(t *testing.T) {
+	var (
+		mu   sync.Mutex
+		reqs []*Request
+	)
+	h := Chain(HandlerFunc(func(req *Request) error {
+		if req.Key == "bad" {
+			panic("boom")
+		}
+		mu.Lock()
+		reqs = append(reqs, req)
+		mu.Unlock()
+		return nil
+	}), RecoverPanics(nil))
+	nw := udptest.NewNetwork()
+	addrs, _, stop := runTestReceivers(nw, []string{"10.0.0.11"},
+		func(rc *Receiver) { rc.Receive, rc.Handler = nil, h })
+	defer stop()
+	cf := NewDefaultConfig()
+	cf.Transport = nw.Host("10.0.0.1")
+	cf.ReplyTimeout = 100 * time.Millisecond
+	cf.SendRetries = 2
+	sd := Sender{Address: addrs[0], CryptoKey: []byte(testAESKey), Config: cf}
+	t0 := time.Now()
+	if err := sd.SendString("bad", "v"); err == nil {
+		t.Error("0xE8082C", "the item must not be delivered")
+	}
+	if err := sd.SendString("good", "v"); err != nil {
+		t.Error("0xE38E16", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(reqs) != 1 || reqs[0].Key != "good" ||
+		string(reqs[0].Value) != "v" || reqs[0].Addr == nil ||
+		!strings.HasPrefix(reqs[0].Addr.String(), "10.0.0.1:") ||
+		reqs[0].ReceivedTime.Before(t0) {
+		t.Error("0xEAC817", reqs)
+	}
+}
+
+// a Receiver must keep running after a panic in a handler without
+// RecoverPanics, whether or not it uses a receive queue
+func Test_Handler_Receiver_panic_(t *testing.T) {
+	for _, workers := range []int{0, 2} {
+		var (
+			mu   sync.Mutex
+			good int
+		)
+		receive := func(k string, v []byte) error {
+			if k == "bad" {
+				panic("boom")
+			}
+			mu.Lock()
+			good++
+			mu.Unlock()
+			return nil
+		}
+		nw := udptest.NewNetwork()
+		addrs, _, stop := runTestReceivers(nw, []string{"10.0.0.11"},
+			func(rc *Receiver) {
+				rc.Receive = receive
+				rc.Config.ReceiveWorkers = workers
+			})
+		cf := NewDefaultConfig()
+		cf.Transport = nw.Host("10.0.0.1")
+		cf.ReplyTimeout = 100 * time.Millisecond
+		cf.SendRetries = 2
+		sd := Sender{Address: addrs[0], CryptoKey: []byte(testAESKey),
+			Config: cf}
+		err := sd.SendString("bad", "v")
+		if workers == 0 && !matchError(err, "undelivered packets") {
+			t.Error("0xEF6C39", "wrong error:", err)
+		}
+		if err := sd.SendString("good", "v"); err != nil {
+			t.Error("0xE41A5C", workers, err)
+		}
+		stop()
+		time.Sleep(50 * time.Millisecond) // let queued items be handled
+		mu.Lock()
+		if good != 1 {
+			t.Error("0xE12B40", workers, "good items:", good)
+		}
+		mu.Unlock()
+		_ = sd.Close()
+	}
+}
+
+// end
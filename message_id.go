@@ -0,0 +1,63 @@
+// -----------------------------------------------------------------------------
+// github.com/balacode/udpt                                     /[message_id.go]
+// (c) balarabe@protonmail.com                                      License: MIT
+// -----------------------------------------------------------------------------
+
+package udpt
+
+// # Functions
+//   NewMessageID() (string, error)
+//   validateMessageID(id string) error
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+)
+
+// maxMessageIDSize is the maximum length of a message ID, in bytes
+const maxMessageIDSize = 64
+
+// NewMessageID returns a new random message ID, made of 32 hex digits.
+//
+// Sender.Send() gives every data item a new message ID, which the
+// Receiver uses to recognize an item it has already delivered. Use
+// Sender.SendWithID() to send an item with an ID of your own, for
+// example an ID stored with the item, so the item is delivered
+// only once even if you send it again after a restart.
+//
+func NewMessageID() (string, error) {
+	return newMessageIDDI(rand.Reader)
+} //                                                                NewMessageID
+
+// newMessageIDDI is only used by NewMessageID() and provides parameters
+// for dependency injection, to enable mocking during testing.
+func newMessageIDDI(random io.Reader) (string, error) {
+	ar := make([]byte, 16)
+	_, err := io.ReadFull(random, ar)
+	if err != nil {
+		return "", makeError(0xE58868, err)
+	}
+	return hex.EncodeToString(ar), nil
+} //                                                              newMessageIDDI
+
+// validateMessageID returns an error if 'id' is blank, longer than
+// maxMessageIDSize, or contains spaces or non-printable characters,
+// which can't be sent in a fragment's header.
+func validateMessageID(id string) error {
+	if id == "" {
+		return makeError(0xE744A8, "blank message ID")
+	}
+	if len(id) > maxMessageIDSize {
+		return makeError(0xE66741, "message ID exceeds",
+			maxMessageIDSize, "bytes")
+	}
+	for _, ch := range []byte(id) {
+		if ch <= ' ' || ch > '~' {
+			return makeError(0xEF9B49, "invalid message ID:", id)
+		}
+	}
+	return nil
+} //                                                           validateMessageID
+
+// end
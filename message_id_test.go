@@ -0,0 +1,62 @@
+// -----------------------------------------------------------------------------
+// github.com/balacode/udpt                                /[message_id_test.go]
+// (c) balarabe@protonmail.com                                      License: MIT
+// -----------------------------------------------------------------------------
+
+package udpt
+
+import (
+	"strings"
+	"testing"
+)
+
+// to run all tests in this file:
+// go test -v -run Test_MessageID_*
+
+// -----------------------------------------------------------------------------
+
+// NewMessageID() (string, error)
+//
+// go test -run Test_MessageID_NewMessageID_
+//
+func Test_MessageID_NewMessageID_(t *testing.T) {
+	id1, err1 := NewMessageID()
+	id2, err2 := NewMessageID()
+	if err1 != nil || err2 != nil {
+		t.Error("0xE223A2", err1, err2)
+	}
+	if len(id1) != 32 || id1 == id2 || validateMessageID(id1) != nil {
+		t.Error("0xEBBE6A", "bad IDs:", id1, id2)
+	}
+	// must fail when random bytes can't be read
+	id, err := newMessageIDDI(strings.NewReader("short"))
+	if id != "" || !matchError(err, "EOF") {
+		t.Error("0xE36A78", "wrong error:", err)
+	}
+}
+
+// validateMessageID(id string) error
+//
+// go test -run Test_MessageID_validateMessageID_
+//
+func Test_MessageID_validateMessageID_(t *testing.T) {
+	for _, test := range []struct {
+		id   string
+		want string
+	}{
+		{"order-1234", ""},
+		{strings.Repeat("x", 64), ""},
+		{"", "blank message ID"},
+		{strings.Repeat("x", 65), "message ID exceeds 64 bytes"},
+		{"two words", "invalid message ID"},
+		{"tab\t", "invalid message ID"},
+		{"é", "invalid message ID"},
+	} {
+		err := validateMessageID(test.id)
+		if !matchError(err, test.want) {
+			t.Error("0xE0DB0C", test.id, "wrong error:", err)
+		}
+	}
+}
+
+// end
@@ -0,0 +1,148 @@
+// -----------------------------------------------------------------------------
+// github.com/balacode/udpt                                   /[multi_sender.go]
+// (c) balarabe@protonmail.com                                      License: MIT
+// -----------------------------------------------------------------------------
+
+package udpt
+
+// # MultiSender Type
+//   MultiSender struct
+//
+// # Main Methods (ms *MultiSender)
+//   ) Send(k string, v []byte) (map[string]error, error)
+//   ) SendString(k, v string) (map[string]error, error)
+//
+// # Internal Methods (ms *MultiSender)
+//   ) newSender(addr string) *Sender
+
+import (
+	"sync"
+)
+
+// -----------------------------------------------------------------------------
+// # MultiSender Type
+
+// MultiSender sends the same data item to several Receivers, using a
+// separate unicast transfer to each Receiver. Use it when the network
+// doesn't support multicast (otherwise see MulticastSender).
+//
+// The item is compressed and split into packets only once. Each Receiver
+// then gets its own copy of the packets, which are sent, confirmed and
+// resent independently, so a slow or unreachable Receiver doesn't hold
+// back the others. All Receivers must use the same CryptoKey (and
+// ReplyCryptoKey, if specified).
+//
+type MultiSender struct {
+
+	// Addresses lists the Receivers to which data items are sent,
+	// each with a port number. For example: "10.0.0.5:9876"
+	Addresses []string
+
+	// CryptoKey is the secret symmetric encryption key that
+	// must be shared by the MultiSender and all Receivers.
+	CryptoKey []byte
+
+	// ReplyCryptoKey is an optional secret key used to decrypt replies
+	// sent back by the Receivers. See Sender.ReplyCryptoKey.
+	ReplyCryptoKey []byte
+
+	// Config contains UDP and other configuration settings.
+	// These settings normally don't need to be changed.
+	Config *Configuration
+} //                                                                 MultiSender
+
+// -----------------------------------------------------------------------------
+// # Main Methods (ms *MultiSender)
+
+// Send transfers a key-value to every Receiver in Addresses, at the same
+// time. See Sender.Send() for a description of 'k' and 'v'.
+//
+// Returns the result for each address: nil if the Receiver has the whole
+// item, or the error that a Sender would have returned for it. Also
+// returns an error if the transfer could not start, or if any of
+// the Receivers doesn't have the item.
+//
+func (ms *MultiSender) Send(k string, v []byte) (map[string]error, error) {
+	if ms.Config == nil {
+		ms.Config = NewDefaultConfig()
+	}
+	var (
+		ret    = make(map[string]error, len(ms.Addresses))
+		valid  []string
+		packer *Sender
+	)
+	for _, addr := range ms.Addresses {
+		if _, dup := ret[addr]; dup {
+			continue
+		}
+		sd := ms.newSender(addr)
+		err := sd.validateAddress()
+		if err != nil {
+			ret[addr] = sd.logError(0xE0C14A, err)
+			continue
+		}
+		ret[addr] = nil
+		valid = append(valid, addr)
+		if packer == nil {
+			packer = sd
+		}
+	}
+	if packer == nil {
+		return ret, makeError(0xE1EFFE, "no valid MultiSender.Addresses")
+	}
+	// compress and split the item only once
+	it, err := packer.beginSend("", k, v)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		mutex sync.Mutex
+		wg    sync.WaitGroup
+	)
+	for _, addr := range valid {
+		sd := ms.newSender(addr)
+		wg.Add(1)
+		go func(addr string, sd *Sender, it *senderItem) {
+			defer wg.Done()
+			defer func() { _ = sd.Close() }()
+			err := sd.deliverPackets(it, sd.connect, sd.sendUndeliveredPackets)
+			mutex.Lock()
+			ret[addr] = err
+			mutex.Unlock()
+		}(addr, sd, it.clone())
+	}
+	wg.Wait()
+	failed := 0
+	for _, err := range ret {
+		if err != nil {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return ret, packer.logError(0xE4FCBD, "failed to deliver to",
+			failed, "of", len(ret), "Receivers")
+	}
+	return ret, nil
+} //                                                                        Send
+
+// SendString transfers a key and value string to every Receiver
+// in Addresses. See Send() for a description of the results.
+func (ms *MultiSender) SendString(k, v string) (map[string]error, error) {
+	return ms.Send(k, []byte(v))
+} //                                                                  SendString
+
+// -----------------------------------------------------------------------------
+// # Internal Methods (ms *MultiSender)
+
+// newSender returns a Sender for the Receiver at 'addr'. All
+// the Senders share the MultiSender's keys and Config.
+func (ms *MultiSender) newSender(addr string) *Sender {
+	return &Sender{
+		Address:        addr,
+		CryptoKey:      ms.CryptoKey,
+		ReplyCryptoKey: ms.ReplyCryptoKey,
+		Config:         ms.Config,
+	}
+} //                                                                   newSender
+
+// end
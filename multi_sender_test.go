@@ -0,0 +1,154 @@
+// -----------------------------------------------------------------------------
+// github.com/balacode/udpt                              /[multi_sender_test.go]
+// (c) balarabe@protonmail.com                                      License: MIT
+// -----------------------------------------------------------------------------
+
+package udpt
+
+import (
+	"bytes"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/balacode/udpt/udptest"
+)
+
+// to run all tests in this file:
+// go test -v -run Test_MultiSender_*
+
+// -----------------------------------------------------------------------------
+
+// countingCompressor is a Compression that counts calls to Compress()
+type countingCompressor struct {
+	zlibCompressor
+	calls int32
+}
+
+// Compress implements Compression.Compress().
+func (cc *countingCompressor) Compress(data []byte) ([]byte, error) {
+	atomic.AddInt32(&cc.calls, 1)
+	return cc.zlibCompressor.Compress(data)
+}
+
+// newTestMultiSender returns a MultiSender on host 10.0.0.1
+// of network 'nw', using Transport 'tr' if not nil
+func newTestMultiSender(nw *udptest.Network, tr Transport,
+	addrs []string,
+) *MultiSender {
+	cf := NewDefaultConfig()
+	cf.Transport = tr
+	if tr == nil {
+		cf.Transport = nw.Host("10.0.0.1")
+	}
+	cf.ReplyTimeout = 200 * time.Millisecond
+	cf.SendRetryInterval = 20 * time.Millisecond
+	return &MultiSender{Addresses: addrs, CryptoKey: []byte(testAESKey),
+		Config: cf}
+}
+
+// -----------------------------------------------------------------------------
+// (ms *MultiSender) Send(k string, v []byte) (map[string]error, error)
+//
+// go test -run Test_MultiSender_Send_*
+
+// must deliver an item to every Receiver, compressing it only once
+func Test_MultiSender_Send_1(t *testing.T) {
+	nw := udptest.NewNetwork()
+	addrs, td, stop := runTestReceivers(nw,
+		[]string{"10.0.0.11", "10.0.0.12", "10.0.0.13"}, nil)
+	defer stop()
+	ms := newTestMultiSender(nw, nil, append(addrs, addrs[0]))
+	cc := &countingCompressor{}
+	ms.Config.Compressor = cc
+	v := randomBytes(1, 10*1000)
+	results, err := ms.Send("k", v)
+	if err != nil || len(results) != 3 {
+		t.Error("0xE95DF6", err, results)
+	}
+	for _, addr := range addrs {
+		if results[addr] != nil {
+			t.Error("0xEE50DA", addr, results[addr])
+		}
+		got := td.get(addr, "k")
+		if len(got) != 1 || !bytes.Equal(got[0], v) {
+			t.Error("0xEC0DEF", addr, len(got))
+		}
+	}
+	if atomic.LoadInt32(&cc.calls) != 1 {
+		t.Error("0xEE9F50", "compressed", cc.calls, "times")
+	}
+}
+
+// must confirm each Receiver separately under packet loss
+func Test_MultiSender_Send_2(t *testing.T) {
+	nw := udptest.NewNetwork()
+	addrs, td, stop := runTestReceivers(nw,
+		[]string{"10.0.0.11", "10.0.0.12", "10.0.0.13"}, nil)
+	defer stop()
+	tr := udptest.ImpairTransport(nw.Host("10.0.0.1"),
+		udptest.Impairment{Loss: 0.2, Seed: 1})
+	ms := newTestMultiSender(nw, tr, addrs)
+	ms.Config.SendRetries = 20
+	v := randomBytes(2, 20*1000)
+	results, err := ms.Send("k", v)
+	if err != nil {
+		t.Error("0xEC1095", err, results)
+	}
+	for _, addr := range addrs {
+		got := td.get(addr, "k")
+		if len(got) != 1 || !bytes.Equal(got[0], v) {
+			t.Error("0xEE27E0", addr, len(got))
+		}
+	}
+}
+
+// must report each Receiver that doesn't get the item
+func Test_MultiSender_Send_3(t *testing.T) {
+	nw := udptest.NewNetwork()
+	addrs, _, stop := runTestReceivers(nw, []string{"10.0.0.11"}, nil)
+	defer stop()
+	_, _, stop2 := runTestReceivers(nw, []string{"10.0.0.12"},
+		func(rc *Receiver) { rc.Config.MaxItemSize = 10 })
+	defer stop2()
+	var (
+		missing  = "10.0.0.99:9876"
+		rejector = "10.0.0.12:9876"
+		noPort   = "10.0.0.13"
+	)
+	ms := newTestMultiSender(nw, nil,
+		[]string{addrs[0], missing, rejector, noPort})
+	ms.Config.SendRetries = 2
+	results, err := ms.Send("k", randomBytes(3, 100))
+	if !matchError(err, "failed to deliver to 3 of 4 Receivers") {
+		t.Error("0xEA977F", "wrong error:", err)
+	}
+	for addr, want := range map[string]string{
+		addrs[0]: "",
+		missing:  "undelivered packets",
+		rejector: "rejected by Receiver: item size exceeds limit 10",
+		noPort:   "invalid port",
+	} {
+		if !matchError(results[addr], want) {
+			t.Error("0xE5DE7B", addr, "wrong error:", results[addr])
+		}
+	}
+}
+
+// must fail without any valid address, or with an invalid key
+func Test_MultiSender_Send_4(t *testing.T) {
+	nw := udptest.NewNetwork()
+	ms := newTestMultiSender(nw, nil, nil)
+	_, err := ms.SendString("k", "v")
+	if !matchError(err, "no valid MultiSender.Addresses") {
+		t.Error("0xEC6D3F", "wrong error:", err)
+	}
+	ms = newTestMultiSender(nw, nil, []string{"10.0.0.11:9876"})
+	ms.CryptoKey = []byte("short")
+	results, err := ms.SendString("k", "v")
+	if results != nil || !matchError(err, "invalid Sender.CryptoKey") {
+		t.Error("0xE0D4B6", "wrong error:", err)
+	}
+}
+
+// end
@@ -0,0 +1,582 @@
+// -----------------------------------------------------------------------------
+// github.com/balacode/udpt                               /[multicast_sender.go]
+// (c) balarabe@protonmail.com                                      License: MIT
+// -----------------------------------------------------------------------------
+
+package udpt
+
+// # MulticastSender Type
+//   MulticastSender struct
+//
+// # Main Methods (ms *MulticastSender)
+//   ) Send(k string, v []byte) (map[string]error, error)
+//   ) SendString(k, v string) (map[string]error, error)
+//
+// # Internal Methods (ms *MulticastSender)
+//   ) beginSend(k string, v []byte) error
+//   ) connect() (netUDPConn, net.Addr, error)
+//   ) collectReplies(conn netUDPConn, done <-chan struct{})
+//   ) receiveReply(recv []byte, addr net.Addr)
+//   ) waitForReplies()
+//   ) isFinished() bool
+//   ) repair()
+//   ) results() (map[string]error, error)
+//   ) receiver(addr net.Addr) *multicastReceiver
+//   ) findReceiver(addr net.Addr) *multicastReceiver
+//   ) writeTo(addr net.Addr, data, prefix []byte) error
+//
+// # multicastReceiver Type
+//   multicastReceiver struct
+//   ) isFinished() bool
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// -----------------------------------------------------------------------------
+// # MulticastSender Type
+
+// MulticastSender sends the same data item to many Receivers at once,
+// by transmitting its fragments only once to a UDP multicast group.
+// Each Receiver must join the group by specifying the same address
+// in Receiver.MulticastGroup.
+//
+// Send() can be called from many goroutines, but each
+// MulticastSender sends only one data item at a time.
+//
+// Fragments sent to the group are not confirmed one by one. Instead,
+// the MulticastSender asks the Receivers which fragments they are
+// missing, and each Receiver replies from its own address with a list
+// of missing fragments, which are then resent only to that Receiver.
+// This repeats until every Receiver has the item, or until
+// Config.SendRetries rounds have been made.
+//
+// The item is compressed, split into fragments and encrypted only once,
+// whatever the number of Receivers. All Receivers must use the same
+// CryptoKey (and ReplyCryptoKey, if specified).
+//
+type MulticastSender struct {
+
+	// Group is the multicast group address ("ip:port") to which data
+	// items are sent, for example "239.1.2.3:9877". It must match
+	// the MulticastGroup of the Receivers.
+	//
+	// The multicast TTL is the operating system's default (usually 1),
+	// so packets don't leave the local network unless you use a Transport
+	// that sets a different TTL.
+	//
+	Group string
+
+	// Receivers optionally lists the addresses of the Receivers expected
+	// to receive every data item, in "host:port" form. These are the
+	// addresses from which the Receivers reply, i.e. each Receiver's own
+	// port on its host's IP address, for example "10.0.0.5:9876".
+	//
+	// When specified, Send() finishes as soon as every listed Receiver
+	// has the item, and reports the listed Receivers that don't have it.
+	//
+	// When not specified, Send() can't know how many Receivers there are,
+	// so it waits for the full Config.ReplyTimeout after every request
+	// for missing fragments, and reports the Receivers that replied.
+	//
+	Receivers []string
+
+	// CryptoKey is the secret symmetric encryption key that
+	// must be shared by the MulticastSender and all Receivers.
+	CryptoKey []byte
+
+	// ReplyCryptoKey is an optional secret key used to decrypt replies
+	// sent back by the Receivers. See Sender.ReplyCryptoKey.
+	ReplyCryptoKey []byte
+
+	// Config contains UDP and other configuration settings.
+	// These settings normally don't need to be changed.
+	Config *Configuration
+
+	// -------------------------------------------------------------------------
+
+	// sendMutex is held during Send(), since every
+	// call replaces the state of the item being sent
+	sendMutex sync.Mutex
+
+	// sender splits the data item into packets and holds the ciphers
+	sender *Sender
+
+	// item contains the packets of the data item being sent
+	item *senderItem
+
+	// conn is the connection used to send packets to the group
+	// and to the Receivers, and to receive their replies
+	conn netUDPConn
+
+	// status contains a tagStatus packet, which asks the
+	// Receivers which fragments of the data item they are missing
+	status []byte
+
+	// mutex guards receivers, cookies and round,
+	// which are changed while replies are being received
+	mutex sync.Mutex
+
+	// receivers contains the progress of each Receiver,
+	// mapped by the address from which it replies
+	receivers map[string]*multicastReceiver
+
+	// cookies contains the cookies sent by addresses that have not yet
+	// replied with a valid NACK or DONE, so they are not Receivers yet
+	cookies map[string][]byte
+
+	// round is the number of status requests sent to the group so far
+	round int
+} //                                                             MulticastSender
+
+// -----------------------------------------------------------------------------
+// # Main Methods (ms *MulticastSender)
+
+// Send transfers a key-value to all the Receivers that joined the
+// multicast Group. See Sender.Send() for a description of 'k' and 'v'.
+//
+// Returns the result for each Receiver, mapped by its address: nil if the
+// Receiver has the whole item, or an error describing why it doesn't.
+// Also returns an error if the transfer could not start, or if any of
+// the Receivers doesn't have the item.
+//
+func (ms *MulticastSender) Send(k string, v []byte) (map[string]error, error) {
+	ms.sendMutex.Lock()
+	defer ms.sendMutex.Unlock()
+	if ms.Config == nil {
+		ms.Config = NewDefaultConfig()
+	}
+	err := ms.beginSend(k, v)
+	if err != nil {
+		return nil, err
+	}
+	conn, group, err := ms.connect()
+	if err != nil {
+		return nil, ms.sender.logError(0xE07BF6, err)
+	}
+	ms.conn = conn
+	done := make(chan struct{})
+	defer func() {
+		close(done)
+		err := conn.Close()
+		if err != nil {
+			_ = ms.sender.logError(0xEDCEE5, err)
+		}
+	}()
+	go ms.collectReplies(conn, done)
+	//
+	// send every fragment to the group once, then repair what's missing
+	for _, pk := range ms.item.packets {
+		time.Sleep(ms.Config.SendPacketInterval)
+		err = ms.writeTo(group, pk.data, nil)
+		if err != nil {
+			return nil, ms.sender.logError(0xEA04C1, err)
+		}
+	}
+	for round := 1; round <= ms.Config.SendRetries; round++ {
+		ms.mutex.Lock()
+		ms.round = round
+		ms.mutex.Unlock()
+		err = ms.writeTo(group, ms.status, nil)
+		if err != nil {
+			return nil, ms.sender.logError(0xE92C63, err)
+		}
+		ms.waitForReplies()
+		if ms.isFinished() || round == ms.Config.SendRetries {
+			break
+		}
+		ms.repair()
+		time.Sleep(ms.Config.SendRetryInterval)
+	}
+	return ms.results()
+} //                                                                        Send
+
+// SendString transfers a key and value string to all the Receivers that
+// joined the multicast Group. See Send() for a description of the results.
+func (ms *MulticastSender) SendString(k, v string) (map[string]error, error) {
+	return ms.Send(k, []byte(v))
+} //                                                                  SendString
+
+// -----------------------------------------------------------------------------
+// # Internal Methods (ms *MulticastSender)
+
+// beginSend compresses and splits the data item into packets using a
+// Sender, prepares the status request and the list of Receivers
+func (ms *MulticastSender) beginSend(k string, v []byte) error {
+	sd := &Sender{
+		Address:        ms.Group,
+		CryptoKey:      ms.CryptoKey,
+		ReplyCryptoKey: ms.ReplyCryptoKey,
+		Config:         ms.Config,
+	}
+	ms.sender = sd
+	it, err := sd.beginSend("", k, v)
+	if err != nil {
+		return err
+	}
+	ms.item = it
+	ms.status = []byte(tagStatus + fmt.Sprintf(
+		"key:%s id:%s hash:%X count:%d\n",
+		k, it.messageID, it.dataHash, len(it.packets),
+	))
+	ms.round = 0
+	ms.receivers = make(map[string]*multicastReceiver)
+	ms.cookies = make(map[string][]byte)
+	for _, name := range ms.Receivers {
+		addr, err := net.ResolveUDPAddr("udp", name)
+		if err != nil {
+			return sd.logError(0xE79F35, "invalid MulticastSender.Receivers:",
+				err)
+		}
+		rcv := ms.receiver(addr)
+		rcv.name = name
+		rcv.isListed = true
+	}
+	return nil
+} //                                                                   beginSend
+
+// connect returns a connection for sending packets to the multicast
+// Group using Config.Transport (or UDP sockets if it's nil), and the
+// resolved group address. The connection isn't connected to the group,
+// so it can also exchange packets with each Receiver.
+func (ms *MulticastSender) connect() (netUDPConn, net.Addr, error) {
+	tr := ms.Config.Transport
+	if tr == nil {
+		tr = UDPTransport{}
+	}
+	pc, group, err := tr.DialPacket(ms.Group)
+	if err != nil {
+		return nil, nil, err
+	}
+	if pc == nil || group == nil {
+		if pc != nil {
+			_ = pc.Close()
+		}
+		return nil, nil, makeError(0xEEE7AE,
+			"nil connection or address from Transport")
+	}
+	if udpAddr, ok := group.(*net.UDPAddr); ok && !udpAddr.IP.IsMulticast() {
+		_ = pc.Close()
+		return nil, nil, makeError(0xE87CF9,
+			"MulticastSender.Group is not a multicast address:", ms.Group)
+	}
+	conn := newPacketConnAdapter(pc, group)
+	err = conn.SetWriteBuffer(ms.Config.SendBufferSize)
+	if err != nil {
+		_ = conn.Close()
+		return nil, nil, err
+	}
+	return conn, group, nil
+} //                                                                     connect
+
+// collectReplies receives replies from the Receivers
+// until 'conn' is closed or 'done' is closed.
+func (ms *MulticastSender) collectReplies(
+	conn netUDPConn,
+	done <-chan struct{},
+) {
+	encReply := make([]byte, ms.Config.PacketSizeLimit)
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+		// 'encReply' is overwritten after every readAndDecrypt
+		recv, addr, err := readAndDecrypt(conn, ms.Config.ReplyTimeout,
+			ms.sender.replyCipher(), encReply)
+		if err == errClosed {
+			return
+		}
+		if err == errTimeout {
+			continue
+		}
+		if err != nil {
+			_ = ms.sender.logError(0xEC5142, err)
+			continue
+		}
+		ms.receiveReply(recv, addr)
+	}
+} //                                                              collectReplies
+
+// receiveReply updates the progress of the Receiver at 'addr' from
+// its reply 'recv', which is one of: tagNack, tagDone, tagRejection
+// or tagCookie. Confirmations of resent fragments are ignored.
+//
+// A Receiver is only added once its reply is valid and refers to the
+// current item, so that other packets don't add phantom Receivers.
+//
+func (ms *MulticastSender) receiveReply(recv []byte, addr net.Addr) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	var (
+		sd     = ms.sender
+		it     = ms.item
+		hasTag = func(tag string) bool {
+			return bytes.HasPrefix(recv, []byte(tag))
+		}
+		itemHash = func(tag string) []byte {
+			if len(recv) < len(tag)+32 {
+				return nil
+			}
+			return recv[len(tag) : len(tag)+32]
+		}
+	)
+	switch {
+	case hasTag(tagConfirmation), hasTag(tagWindow):
+		return
+	//
+	case hasTag(tagNack):
+		if !bytes.Equal(itemHash(tagNack), it.dataHash) {
+			return
+		}
+		missing, err := decodeRanges(string(recv[len(tagNack)+32:]),
+			len(it.packets))
+		if err != nil {
+			_ = sd.logError(0xE62763, "bad NACK from", addr, err)
+			return
+		}
+		rcv := ms.receiver(addr)
+		rcv.missing = missing
+		rcv.round = ms.round
+	//
+	case hasTag(tagDone):
+		if !bytes.Equal(itemHash(tagDone), it.dataHash) {
+			return
+		}
+		rcv := ms.receiver(addr)
+		rcv.missing = nil
+		rcv.round = ms.round
+		rcv.isDone = true
+	//
+	case hasTag(tagRejection):
+		rejectedHash := itemHash(tagRejection)
+		if rejectedHash == nil {
+			return
+		}
+		isCurrent := bytes.Equal(rejectedHash, getHash(ms.status))
+		for _, pk := range it.packets {
+			isCurrent = isCurrent || bytes.Equal(rejectedHash, pk.sentHash)
+		}
+		if !isCurrent {
+			return
+		}
+		rcv := ms.receiver(addr)
+		rcv.rejection = string(recv[len(tagRejection)+32:])
+		if rcv.rejection == "" {
+			rcv.rejection = "no reason given"
+		}
+		rcv.round = ms.round
+	//
+	case hasTag(tagCookie):
+		// the Receiver hasn't validated our address: repeat the
+		// status request to it alone, echoing its cookie
+		cookie := recv[len(tagCookie):]
+		if len(cookie) != cookieSize {
+			_ = sd.logError(0xEAF5C0, "bad cookie reply")
+			return
+		}
+		cookie = append([]byte(tagCookie), cookie...)
+		if rcv := ms.findReceiver(addr); rcv != nil {
+			rcv.cookie = cookie
+		} else {
+			ms.cookies[addr.String()] = cookie
+		}
+		err := ms.writeTo(addr, ms.status, cookie)
+		if err != nil {
+			_ = sd.logError(0xEA999F, err)
+		}
+	//
+	default:
+		_ = sd.logError(0xE3FCB5, "bad reply header from", addr)
+	}
+} //                                                                receiveReply
+
+// waitForReplies waits for the Receivers to reply to the current status
+// request, for up to Config.ReplyTimeout. If Receivers are listed, it
+// stops waiting as soon as every listed Receiver has replied.
+func (ms *MulticastSender) waitForReplies() {
+	t0 := time.Now()
+	for time.Since(t0) < ms.Config.ReplyTimeout {
+		time.Sleep(ms.Config.SendWaitInterval)
+		if len(ms.Receivers) == 0 {
+			continue
+		}
+		ms.mutex.Lock()
+		replied := true
+		for _, rcv := range ms.receivers {
+			if rcv.isListed && rcv.round < ms.round && !rcv.isFinished() {
+				replied = false
+				break
+			}
+		}
+		ms.mutex.Unlock()
+		if replied {
+			break
+		}
+	}
+} //                                                              waitForReplies
+
+// isFinished returns true if there's nothing more to send: every listed
+// Receiver (or if none are listed, every Receiver that replied) has the
+// item or has rejected it
+func (ms *MulticastSender) isFinished() bool {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	n := 0
+	for _, rcv := range ms.receivers {
+		if len(ms.Receivers) > 0 && !rcv.isListed {
+			continue
+		}
+		if !rcv.isFinished() {
+			return false
+		}
+		n++
+	}
+	return n > 0
+} //                                                                  isFinished
+
+// repair resends the missing fragments reported by each Receiver
+// directly to that Receiver, prefixed with its cookie (if any)
+func (ms *MulticastSender) repair() {
+	type repairJob struct {
+		addr    net.Addr
+		cookie  []byte
+		missing []int
+	}
+	var jobs []repairJob
+	ms.mutex.Lock()
+	for _, rcv := range ms.receivers {
+		if rcv.isFinished() || len(rcv.missing) == 0 {
+			continue
+		}
+		jobs = append(jobs, repairJob{rcv.addr, rcv.cookie, rcv.missing})
+		rcv.missing = nil
+	}
+	ms.mutex.Unlock()
+	for _, job := range jobs {
+		if ms.Config.VerboseSender {
+			ms.sender.logInfo("MulticastSender resending", len(job.missing),
+				"fragments to", job.addr)
+		}
+		for _, i := range job.missing {
+			time.Sleep(ms.Config.SendPacketInterval)
+			err := ms.writeTo(job.addr, ms.item.packets[i].data, job.cookie)
+			if err != nil {
+				_ = ms.sender.logError(0xED4A7A, err)
+			}
+		}
+	}
+} //                                                                      repair
+
+// results returns the result for each Receiver, and an error
+// if no Receiver replied or any Receiver doesn't have the item
+func (ms *MulticastSender) results() (map[string]error, error) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	var (
+		ret    = make(map[string]error, len(ms.receivers))
+		failed = 0
+	)
+	for _, rcv := range ms.receivers {
+		var err error
+		switch {
+		case rcv.isDone:
+		case rcv.rejection != "":
+			err = makeError(0xE15830, "rejected by Receiver:", rcv.rejection)
+		case rcv.round == 0:
+			err = makeError(0xE55BA1, "no reply from Receiver")
+		default:
+			err = makeError(0xE3F37B, "undelivered packets")
+		}
+		ret[rcv.name] = err
+		if err != nil {
+			failed++
+		}
+	}
+	if len(ret) == 0 {
+		return ret, ms.sender.logError(0xE14557, "no Receivers replied")
+	}
+	if failed > 0 {
+		return ret, ms.sender.logError(0xE0A46D, "failed to deliver to",
+			failed, "of", len(ret), "Receivers")
+	}
+	if ms.Config.VerboseSender {
+		ms.sender.logInfo("MulticastSender delivered to", len(ret),
+			"Receivers")
+	}
+	return ret, nil
+} //                                                                     results
+
+// receiver returns the progress of the Receiver
+// at 'addr', adding it if it's not known yet
+func (ms *MulticastSender) receiver(addr net.Addr) *multicastReceiver {
+	if rcv := ms.findReceiver(addr); rcv != nil {
+		return rcv
+	}
+	key := addr.String()
+	rcv := &multicastReceiver{name: key, addr: addr, cookie: ms.cookies[key]}
+	ms.receivers[key] = rcv
+	delete(ms.cookies, key)
+	return rcv
+} //                                                                    receiver
+
+// findReceiver returns the progress of the Receiver
+// at 'addr', or nil if it's not known yet
+func (ms *MulticastSender) findReceiver(addr net.Addr) *multicastReceiver {
+	if rcv := ms.receivers[addr.String()]; rcv != nil {
+		return rcv
+	}
+	// the same Receiver may reply from an address written differently
+	for _, rcv := range ms.receivers {
+		if sameAddress(rcv.addr, addr) {
+			return rcv
+		}
+	}
+	return nil
+} //                                                                findReceiver
+
+// writeTo encrypts 'data', prefixed with 'prefix' (if any),
+// and writes it to 'addr', which can be the group's address
+func (ms *MulticastSender) writeTo(addr net.Addr, data, prefix []byte) error {
+	if len(prefix) > 0 {
+		data = append(append(make([]byte, 0, len(prefix)+len(data)),
+			prefix...), data...)
+	}
+	ciphertext, err := ms.Config.Cipher.Encrypt(data)
+	if err != nil {
+		return makeError(0xE57AE3, err)
+	}
+	_, err = ms.conn.WriteTo(ciphertext, addr)
+	if err != nil {
+		return makeError(0xECB4C7, err)
+	}
+	return nil
+} //                                                                     writeTo
+
+// -----------------------------------------------------------------------------
+// # multicastReceiver Type
+
+// multicastReceiver contains the progress of a Receiver
+// of a MulticastSender's current data item
+type multicastReceiver struct {
+	name      string   // the address listed in Receivers, or addr
+	addr      net.Addr // the address from which the Receiver replies
+	isListed  bool     // true if listed in MulticastSender.Receivers
+	cookie    []byte   // tagCookie and the Receiver's cookie, if any
+	missing   []int    // fragment indexes in the Receiver's last NACK
+	round     int      // status request round of the last reply
+	isDone    bool     // true when the Receiver has the whole item
+	rejection string   // the reason if the Receiver rejected the item
+} //                                                           multicastReceiver
+
+// isFinished returns true if the Receiver has the item or rejected it
+func (rcv *multicastReceiver) isFinished() bool {
+	return rcv.isDone || rcv.rejection != ""
+} //                                                                  isFinished
+
+// end
@@ -0,0 +1,276 @@
+// -----------------------------------------------------------------------------
+// github.com/balacode/udpt                          /[multicast_sender_test.go]
+// (c) balarabe@protonmail.com                                      License: MIT
+// -----------------------------------------------------------------------------
+
+package udpt
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/balacode/udpt/udptest"
+)
+
+// to run all tests in this file:
+// go test -v -run Test_MulticastSender_*
+
+// -----------------------------------------------------------------------------
+
+// testMulticastGroup is the group joined by the Receivers in these tests
+const testMulticastGroup = "239.1.2.3:9877"
+
+// runMulticastReceivers starts a Receiver that joins testMulticastGroup
+// on each of the hosts 'ips' of network 'nw'. See runTestReceivers().
+func runMulticastReceivers(
+	nw *udptest.Network,
+	ips []string,
+	setup func(rc *Receiver),
+) (addrs []string, td *testDeliveries, stop func()) {
+	return runTestReceivers(nw, ips, func(rc *Receiver) {
+		rc.MulticastGroup = testMulticastGroup
+		if setup != nil {
+			setup(rc)
+		}
+	})
+}
+
+// newTestMulticastSender returns a MulticastSender on host
+// 10.0.0.1 of network 'nw', using Transport 'tr' if not nil
+func newTestMulticastSender(nw *udptest.Network, tr Transport,
+	receivers []string,
+) *MulticastSender {
+	cf := NewDefaultConfig()
+	cf.Transport = tr
+	if tr == nil {
+		cf.Transport = nw.Host("10.0.0.1")
+	}
+	cf.ReplyTimeout = 200 * time.Millisecond
+	cf.SendRetryInterval = 20 * time.Millisecond
+	return &MulticastSender{Group: testMulticastGroup, Receivers: receivers,
+		CryptoKey: []byte(testAESKey), Config: cf}
+}
+
+// -----------------------------------------------------------------------------
+// # Transfers
+
+// must deliver an item to every listed Receiver exactly once
+func Test_MulticastSender_Send_1(t *testing.T) {
+	nw := udptest.NewNetwork()
+	addrs, td, stop := runMulticastReceivers(nw,
+		[]string{"10.0.0.11", "10.0.0.12", "10.0.0.13"}, nil)
+	defer stop()
+	ms := newTestMulticastSender(nw, nil, addrs)
+	results, err := ms.SendString("k", "to everyone")
+	if err != nil || len(results) != 3 {
+		t.Error("0xE8F1D2", err, results)
+	}
+	for _, addr := range addrs {
+		if results[addr] != nil {
+			t.Error("0xE1A0C7", addr, results[addr])
+		}
+		got := td.get(addr, "k")
+		if len(got) != 1 || string(got[0]) != "to everyone" {
+			t.Error("0xE5C6E9", addr, len(got))
+		}
+	}
+	// the next item must also be delivered
+	_, err = ms.SendString("k2", "again")
+	if err != nil || len(td.get(addrs[2], "k2")) != 1 {
+		t.Error("0xE9473A", err)
+	}
+}
+
+// must repair lost fragments with unicast retransmissions
+func Test_MulticastSender_Send_2(t *testing.T) {
+	nw := udptest.NewNetwork()
+	addrs, td, stop := runMulticastReceivers(nw,
+		[]string{"10.0.0.11", "10.0.0.12", "10.0.0.13", "10.0.0.14"}, nil)
+	defer stop()
+	tr := udptest.ImpairTransport(nw.Host("10.0.0.1"),
+		udptest.Impairment{Loss: 0.3, Seed: 1})
+	ms := newTestMulticastSender(nw, tr, addrs)
+	ms.Config.SendRetries = 30   // status requests and replies also get lost
+	v := randomBytes(1, 40*1000) // about 40 fragments
+	results, err := ms.Send("big", v)
+	if err != nil {
+		t.Error("0xE8F4A3", err, results)
+	}
+	for _, addr := range addrs {
+		got := td.get(addr, "big")
+		if len(got) != 1 || !bytes.Equal(got[0], v) {
+			t.Error("0xE3B0E1", addr, len(got))
+		}
+	}
+	if tr.Stats().Dropped == 0 {
+		t.Error("0xE7C09A", "no packets were lost")
+	}
+}
+
+// must discover Receivers that are not listed
+func Test_MulticastSender_Send_3(t *testing.T) {
+	nw := udptest.NewNetwork()
+	addrs, td, stop := runMulticastReceivers(nw,
+		[]string{"10.0.0.11", "10.0.0.12"}, nil)
+	defer stop()
+	ms := newTestMulticastSender(nw, nil, nil)
+	results, err := ms.SendString("k", "v")
+	if err != nil {
+		t.Error("0xE6D217", err)
+	}
+	var got []string
+	for addr, err := range results {
+		got = append(got, fmt.Sprint(addr, " ", err))
+	}
+	sort.Strings(got)
+	if fmt.Sprint(got) != fmt.Sprintf("[%s <nil> %s <nil>]",
+		addrs[0], addrs[1]) {
+		t.Error("0xE4A6D2", got)
+	}
+	if len(td.get(addrs[0], "k")) != 1 || len(td.get(addrs[1], "k")) != 1 {
+		t.Error("0xE2B9E5")
+	}
+}
+
+// must report Receivers that don't reply or reject the item
+func Test_MulticastSender_Send_4(t *testing.T) {
+	nw := udptest.NewNetwork()
+	addrs, _, stop := runMulticastReceivers(nw,
+		[]string{"10.0.0.11", "10.0.0.12"}, nil)
+	defer stop()
+	_, _, stop2 := runMulticastReceivers(nw, []string{"10.0.0.13"},
+		func(rc *Receiver) { rc.Config.MaxItemSize = 10 })
+	defer stop2()
+	missing := "10.0.0.99:9876"
+	ms := newTestMulticastSender(nw, nil,
+		append(addrs, missing, "10.0.0.13:9876"))
+	ms.Config.SendRetries = 2
+	results, err := ms.Send("k", randomBytes(2, 100))
+	if !matchError(err, "failed to deliver to 2 of 4 Receivers") {
+		t.Error("0xE0F6B8", "wrong error:", err)
+	}
+	if results[addrs[0]] != nil || results[addrs[1]] != nil {
+		t.Error("0xEB3F0E", results)
+	}
+	if !matchError(results[missing], "no reply from Receiver") {
+		t.Error("0xE6C5F1", results[missing])
+	}
+	if !matchError(results["10.0.0.13:9876"], "rejected by Receiver") {
+		t.Error("0xE0E77C", results["10.0.0.13:9876"])
+	}
+}
+
+// must deliver when Receivers don't validate addresses
+func Test_MulticastSender_Send_5(t *testing.T) {
+	nw := udptest.NewNetwork()
+	addrs, td, stop := runMulticastReceivers(nw, []string{"10.0.0.11"},
+		func(rc *Receiver) { rc.Config.ValidateAddresses = false })
+	defer stop()
+	ms := newTestMulticastSender(nw, nil, addrs)
+	_, err := ms.SendString("k", "v")
+	if err != nil || len(td.get(addrs[0], "k")) != 1 {
+		t.Error("0xE5D9F7", err)
+	}
+}
+
+// -----------------------------------------------------------------------------
+// # Errors
+
+// must fail when the transfer can't start
+func Test_MulticastSender_Send_6(t *testing.T) {
+	nw := udptest.NewNetwork()
+	//
+	// the group must be a multicast address
+	ms := newTestMulticastSender(nw, nil, nil)
+	ms.Group = "10.0.0.2:9877"
+	_, err := ms.SendString("k", "v")
+	if !matchError(err, "not a multicast address") {
+		t.Error("0xE8C6A9", "wrong error:", err)
+	}
+	// the listed Receivers must be valid addresses
+	ms = newTestMulticastSender(nw, nil, []string{"10.0.0.2"})
+	_, err = ms.SendString("k", "v")
+	if !matchError(err, "invalid MulticastSender.Receivers") {
+		t.Error("0xE31B65", "wrong error:", err)
+	}
+	// must report when no Receivers reply
+	ms = newTestMulticastSender(nw, nil, nil)
+	ms.Config.SendRetries = 1
+	results, err := ms.SendString("k", "v")
+	if len(results) != 0 || !matchError(err, "no Receivers replied") {
+		t.Error("0xEF7B4E", "wrong error:", err)
+	}
+	// must fail with an invalid key
+	ms = newTestMulticastSender(nw, nil, nil)
+	ms.CryptoKey = []byte("short")
+	_, err = ms.SendString("k", "v")
+	if !matchError(err, "invalid Sender.CryptoKey") {
+		t.Error("0xE1F6F9", "wrong error:", err)
+	}
+}
+
+// -----------------------------------------------------------------------------
+// # Internal Methods
+
+// (ms *MulticastSender) receiveReply(recv []byte, addr net.Addr)
+//
+// go test -run Test_MulticastSender_receiveReply_
+//
+// must only add a Receiver when its reply is valid for the current item
+func Test_MulticastSender_receiveReply_(t *testing.T) {
+	ms := newTestMulticastSender(udptest.NewNetwork(), nil, nil)
+	ms.Config.LogWriter = nil
+	err := ms.beginSend("k", []byte("v"))
+	if err != nil {
+		t.Error("0xE9D5E8", err)
+		return
+	}
+	addr := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 11), Port: 9876}
+	otherHash := make([]byte, 32)
+	for _, recv := range [][]byte{
+		[]byte(tagConfirmation + "x"),
+		[]byte("BOGUS:"),
+		append([]byte(tagNack), otherHash...),
+		append([]byte(tagDone), otherHash...),
+		append([]byte(tagRejection), otherHash...),
+	} {
+		ms.receiveReply(recv, addr)
+	}
+	if len(ms.receivers) != 0 {
+		t.Error("0xEA6A59", "must not add a Receiver:", len(ms.receivers))
+	}
+	ms.receiveReply(append([]byte(tagDone), ms.item.dataHash...), addr)
+	rcv := ms.receivers[addr.String()]
+	if len(ms.receivers) != 1 || rcv == nil || !rcv.isDone {
+		t.Error("0xE5BA53", "must add the Receiver")
+	}
+}
+
+// (ms *MulticastSender) receiver(addr net.Addr) *multicastReceiver
+//
+// go test -run Test_MulticastSender_receiver_
+//
+// must match a known Receiver by its IP address and port
+func Test_MulticastSender_receiver_(t *testing.T) {
+	ms := &MulticastSender{receivers: map[string]*multicastReceiver{}}
+	rcv := ms.receiver(&net.UDPAddr{IP: net.IPv4(10, 0, 0, 11), Port: 9876})
+	if rcv.name != "10.0.0.11:9876" || len(ms.receivers) != 1 {
+		t.Error("0xE8F3D2", "wrong Receiver:", rcv.name)
+	}
+	// the same address, written as an IPv4-mapped IPv6 address
+	mapped := &mockNetAddr{network: "udp", addr: "[::ffff:10.0.0.11]:9876"}
+	if ms.receiver(mapped) != rcv || len(ms.receivers) != 1 {
+		t.Error("0xE75967", "must match the known Receiver")
+	}
+	// a different port is a different Receiver
+	other := ms.receiver(&net.UDPAddr{IP: net.IPv4(10, 0, 0, 11), Port: 9877})
+	if other == rcv || len(ms.receivers) != 2 {
+		t.Error("0xE3EAF4", "must add a new Receiver")
+	}
+}
+
+// end
@@ -0,0 +1,591 @@
+// -----------------------------------------------------------------------------
+// github.com/balacode/udpt                                         /[outbox.go]
+// (c) balarabe@protonmail.com                                      License: MIT
+// -----------------------------------------------------------------------------
+
+package udpt
+
+// # Outbox Type
+//   Outbox struct
+//
+// # Main Methods (ob *Outbox)
+//   ) Open() error
+//   ) Put(k string, v []byte) error
+//   ) PutString(k, v string) error
+//   ) Len() int
+//   ) OldestAge() time.Duration
+//   ) Close() error
+//
+// # Background Sending (ob *Outbox)
+//   ) run(stop <-chan struct{})
+//   ) front() *outboxItem
+//   ) send(item *outboxItem) bool
+//   ) readValue(item *outboxItem) ([]byte, error)
+//   ) remove(item *outboxItem)
+//
+// # Segment Files (ob *Outbox)
+//   ) load() error
+//   ) loadSegment(seg int, loaded *[]*outboxItem,
+//       live map[string]*outboxItem) (validSize int64, err error)
+//   ) openSegment(seg int) error
+//   ) writeRecord(rec []byte) error
+//   ) rotateSegment()
+//   ) removeSegments()
+//   ) segmentPath(seg int) string
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// outboxSegmentExt is the file name extension of an Outbox's segment files
+const outboxSegmentExt = ".outbox"
+
+// -----------------------------------------------------------------------------
+// # Outbox Type
+
+// Outbox is a persistent queue of data items, which it sends to a Receiver
+// in the background. Put() stores each item in a file in Dir before it
+// returns, so items that were not delivered yet are sent after the
+// program restarts, even if the Receiver was unreachable for a while.
+//
+// Items are sent one at a time, in the order they were put, using a
+// Sender. When sending fails, the Outbox waits and tries again, doubling
+// the wait after each failure (see Config.OutboxRetryInterval). Items
+// are removed after the Receiver confirms them, or if it rejects them.
+//
+// Each item is sent with the same message ID every time, so the Receiver
+// delivers it only once, even if the Outbox stops after sending an item
+// but before removing it. See Receiver.Deliveries.
+//
+// Items are appended to segment files, which are deleted when all their
+// items are delivered. Only one Outbox may use a directory at a time.
+//
+type Outbox struct {
+
+	// Dir is the directory where the Outbox keeps its segment files.
+	// It is created by Open() if it doesn't exist.
+	Dir string
+
+	// Address is the address of the Receiver, with a port
+	// number. For example: "10.0.0.5:9876". See Sender.Address.
+	Address string
+
+	// CryptoKey is the secret symmetric encryption key that
+	// must be shared by the Outbox and the Receiver.
+	CryptoKey []byte
+
+	// ReplyCryptoKey is an optional secret key used to decrypt replies
+	// sent back by the Receiver. See Sender.ReplyCryptoKey.
+	ReplyCryptoKey []byte
+
+	// Config contains UDP and other configuration settings.
+	// These settings normally don't need to be changed.
+	Config *Configuration
+
+	// Rejected is an optional callback function, which the Outbox calls
+	// when the Receiver rejects an item, with the item's key, value and
+	// the reason given by the Receiver. The item is removed either way.
+	Rejected func(k string, v []byte, reason string)
+
+	// -------------------------------------------------------------------------
+
+	// mutex guards the following fields, except 'sender' and the channels
+	mutex sync.Mutex
+
+	// sender sends the items from run(), and logs errors
+	sender *Sender
+
+	// items contains the undelivered items, from the oldest
+	items []*outboxItem
+
+	// segments contains the numbers of the segment files, from the oldest;
+	// pending contains the number of undelivered items in each segment
+	segments []int
+	pending  map[int]int
+
+	// file is the last segment file, to which new records are appended,
+	// and fileSize is its size; 'file' is nil when the Outbox is closed
+	file     *os.File
+	fileSize int64
+
+	// wake tells run() that an item was put, stop tells it to
+	// return, and done is closed by run() when it returns
+	wake chan struct{}
+	stop chan struct{}
+	done chan struct{}
+} //                                                                      Outbox
+
+// outboxItem is an undelivered item in an Outbox. Its value is
+// not kept in memory, but read from its segment file when sent.
+type outboxItem struct {
+	id      string    // message ID with which the item is sent
+	key     string    // key 'k' of the item
+	created time.Time // when the item was put
+	segment int       // number of the segment file holding the item
+	offset  int64     // position of the value in the segment file
+	size    int       // size of the value, in bytes
+} //                                                                  outboxItem
+
+// -----------------------------------------------------------------------------
+// # Main Methods (ob *Outbox)
+
+// Open loads the undelivered items from the segment files in Dir, and
+// starts sending them in the background. Call Close() to stop sending.
+func (ob *Outbox) Open() error {
+	if ob.Config == nil {
+		ob.Config = NewDefaultConfig()
+	}
+	err := ob.Config.Validate()
+	if err != nil {
+		return makeError(0xE61DC6, "invalid Outbox.Config:", err)
+	}
+	if ob.Dir == "" {
+		return makeError(0xEA2B9B, "missing Outbox.Dir")
+	}
+	sd := &Sender{
+		Address:        ob.Address,
+		CryptoKey:      ob.CryptoKey,
+		ReplyCryptoKey: ob.ReplyCryptoKey,
+		Config:         ob.Config,
+	}
+	err = sd.validateAddress()
+	if err != nil {
+		return makeError(0xE04256, err)
+	}
+	ob.mutex.Lock()
+	defer ob.mutex.Unlock()
+	if ob.file != nil {
+		return makeError(0xE07F64, "Outbox is already open")
+	}
+	err = os.MkdirAll(ob.Dir, 0700)
+	if err != nil {
+		return makeError(0xEC0D1F, err)
+	}
+	ob.sender = sd
+	err = ob.load()
+	if err != nil {
+		return err
+	}
+	ob.wake = make(chan struct{}, 1)
+	ob.stop = make(chan struct{})
+	ob.done = make(chan struct{})
+	go ob.run(ob.stop)
+	return nil
+} //                                                                        Open
+
+// Put stores a key-value in the Outbox, to be sent to the Receiver.
+// The item is written to disk before Put returns. See Sender.Send()
+// for a description of 'k' and 'v'.
+func (ob *Outbox) Put(k string, v []byte) error {
+	id, err := NewMessageID()
+	if err != nil {
+		return err
+	}
+	crc := crc32.Update(crc32.ChecksumIEEE([]byte(k)), crc32.IEEETable, v)
+	now := time.Now()
+	header := fmt.Sprintf("PUT id:%s time:%d key:%d value:%d crc:%08X\n",
+		id, now.UnixNano(), len(k), len(v), crc)
+	rec := make([]byte, 0, len(header)+len(k)+len(v))
+	rec = append(append(append(rec, header...), k...), v...)
+	//
+	ob.mutex.Lock()
+	defer ob.mutex.Unlock()
+	if ob.file == nil {
+		return makeError(0xE2D12F, "Outbox is not open")
+	}
+	item := &outboxItem{
+		id:      id,
+		key:     k,
+		created: now,
+		segment: ob.segments[len(ob.segments)-1],
+		offset:  ob.fileSize + int64(len(header)+len(k)),
+		size:    len(v),
+	}
+	err = ob.writeRecord(rec)
+	if err != nil {
+		return err
+	}
+	ob.items = append(ob.items, item)
+	ob.pending[item.segment]++
+	select {
+	case ob.wake <- struct{}{}:
+	default:
+	}
+	return nil
+} //                                                                         Put
+
+// PutString stores a key and value string in the Outbox, like Put().
+func (ob *Outbox) PutString(k, v string) error {
+	return ob.Put(k, []byte(v))
+} //                                                                   PutString
+
+// Len returns the number of items in the Outbox
+// that have not been delivered yet.
+func (ob *Outbox) Len() int {
+	ob.mutex.Lock()
+	defer ob.mutex.Unlock()
+	return len(ob.items)
+} //                                                                         Len
+
+// OldestAge returns the time elapsed since the oldest undelivered
+// item was put in the Outbox, or zero if the Outbox is empty.
+func (ob *Outbox) OldestAge() time.Duration {
+	ob.mutex.Lock()
+	defer ob.mutex.Unlock()
+	if len(ob.items) == 0 {
+		return 0
+	}
+	return time.Since(ob.items[0].created)
+} //                                                                   OldestAge
+
+// Close stops sending items, and closes the Sender's connection and the
+// segment file. An item being sent fails at once, since its connection
+// is closed, so Close doesn't wait for the Sender's retries. Undelivered
+// items are sent when the Outbox is opened again.
+func (ob *Outbox) Close() error {
+	ob.mutex.Lock()
+	if ob.file == nil || ob.stop == nil {
+		ob.mutex.Unlock()
+		return nil // not open, or being closed
+	}
+	close(ob.stop)
+	ob.stop = nil
+	ob.mutex.Unlock()
+	_ = ob.sender.Close() // errors are logged by the Sender
+	<-ob.done
+	// run() may have started sending another item before it saw 'stop'
+	_ = ob.sender.Close()
+	//
+	ob.mutex.Lock()
+	defer ob.mutex.Unlock()
+	err := ob.file.Close()
+	ob.file = nil
+	if err != nil {
+		return makeError(0xEF88BE, err)
+	}
+	return nil
+} //                                                                       Close
+
+// -----------------------------------------------------------------------------
+// # Background Sending (ob *Outbox)
+
+// run sends the items in the Outbox until 'stop' is closed by Close(),
+// waiting longer after each failed attempt
+func (ob *Outbox) run(stop <-chan struct{}) {
+	defer close(ob.done)
+	var wait time.Duration
+	for {
+		select {
+		case <-stop:
+			return
+		case <-time.After(wait):
+		}
+		item := ob.front()
+		if item == nil {
+			select {
+			case <-stop:
+				return
+			case <-ob.wake:
+			}
+			continue
+		}
+		if ob.send(item) {
+			wait = 0
+			continue
+		}
+		cf := ob.Config
+		wait *= 2
+		if wait < cf.OutboxRetryInterval {
+			wait = cf.OutboxRetryInterval
+		}
+		if wait > cf.OutboxMaxRetryInterval {
+			wait = cf.OutboxMaxRetryInterval
+		}
+	}
+} //                                                                         run
+
+// front returns the oldest undelivered item, or nil if there are none
+func (ob *Outbox) front() *outboxItem {
+	ob.mutex.Lock()
+	defer ob.mutex.Unlock()
+	if len(ob.items) == 0 {
+		return nil
+	}
+	return ob.items[0]
+} //                                                                       front
+
+// send sends 'item' to the Receiver and removes it from the Outbox if
+// it's delivered or rejected. Returns false if the item has to be resent.
+func (ob *Outbox) send(item *outboxItem) bool {
+	sd := ob.sender
+	v, err := ob.readValue(item)
+	if err != nil {
+		// the item can never be sent, so don't block the other items
+		_ = sd.logError(0xE05CD8, "discarded unreadable item:", item.key, err)
+		ob.remove(item)
+		return true
+	}
+	err = sd.SendWithID(item.id, item.key, v)
+	var rejection *RejectionError
+	if errors.As(err, &rejection) {
+		ob.remove(item)
+		if ob.Rejected != nil {
+			ob.Rejected(item.key, v, rejection.Reason)
+		}
+		return true
+	}
+	if err != nil {
+		return false
+	}
+	ob.remove(item)
+	return true
+} //                                                                        send
+
+// readValue reads the value of 'item' from its segment file
+func (ob *Outbox) readValue(item *outboxItem) ([]byte, error) {
+	file, err := os.Open(ob.segmentPath(item.segment))
+	if err != nil {
+		return nil, makeError(0xE3F449, err)
+	}
+	defer file.Close()
+	ret := make([]byte, item.size)
+	_, err = file.ReadAt(ret, item.offset)
+	if err != nil {
+		return nil, makeError(0xECD799, err)
+	}
+	return ret, nil
+} //                                                                   readValue
+
+// remove removes the oldest item, 'item', from the Outbox, and deletes
+// segment files that no longer hold undelivered items. If writing the
+// removal fails, the item is sent again (with the same message ID)
+// the next time the Outbox is opened.
+func (ob *Outbox) remove(item *outboxItem) {
+	ob.mutex.Lock()
+	defer ob.mutex.Unlock()
+	if ob.file != nil {
+		err := ob.writeRecord([]byte("DEL id:" + item.id + "\n"))
+		if err != nil {
+			_ = ob.sender.logError(0xED33EB, err)
+		}
+	}
+	ob.items = ob.items[1:]
+	ob.pending[item.segment]--
+	ob.removeSegments()
+} //                                                                      remove
+
+// -----------------------------------------------------------------------------
+// # Segment Files (ob *Outbox)
+//
+// Each segment file contains a sequence of records. A PUT record stores
+// an item, and a DEL record removes an item stored by an earlier PUT
+// record in the same or an older segment file:
+//
+//     PUT id:<message ID> time:<Unix ns> key:<size> value:<size> crc:<CRC>\n
+//     <key><value>
+//     DEL id:<message ID>\n
+//
+// CRC is the CRC-32 (IEEE) of the key and value, in 8 hex digits. Since
+// segment files are deleted from the oldest, a DEL record is never kept
+// without its PUT record.
+
+// load reads all the segment files in Dir, to find the undelivered items,
+// then opens the last segment file for appending, creating it if needed.
+// An incomplete record at the end of the last file (left when the
+// program stopped while writing it) is truncated.
+func (ob *Outbox) load() error {
+	paths, err := filepath.Glob(filepath.Join(ob.Dir, "*"+outboxSegmentExt))
+	if err != nil {
+		return makeError(0xE95975, err)
+	}
+	var segments []int
+	for _, path := range paths {
+		name := strings.TrimSuffix(filepath.Base(path), outboxSegmentExt)
+		if seg, err := strconv.Atoi(name); err == nil && seg > 0 {
+			segments = append(segments, seg)
+		}
+	}
+	sort.Ints(segments)
+	if len(segments) == 0 {
+		segments = []int{1}
+	}
+	var (
+		loaded []*outboxItem
+		live   = make(map[string]*outboxItem)
+	)
+	for i, seg := range segments {
+		validSize, err := ob.loadSegment(seg, &loaded, live)
+		if err != nil {
+			return err
+		}
+		if i == len(segments)-1 {
+			err = os.Truncate(ob.segmentPath(seg), validSize)
+			if err != nil && !os.IsNotExist(err) {
+				return makeError(0xE2055A, err)
+			}
+		}
+	}
+	ob.items = nil
+	ob.pending = make(map[int]int)
+	for _, item := range loaded {
+		if live[item.id] == item {
+			ob.items = append(ob.items, item)
+			ob.pending[item.segment]++
+		}
+	}
+	ob.segments = segments
+	err = ob.openSegment(segments[len(segments)-1])
+	if err != nil {
+		return err
+	}
+	ob.removeSegments()
+	return nil
+} //                                                                        load
+
+// loadSegment reads the records in segment file 'seg', appending items
+// to 'loaded' and setting or deleting them in 'live' by their ID.
+// Returns the size of the file up to the last complete record.
+func (ob *Outbox) loadSegment(seg int, loaded *[]*outboxItem,
+	live map[string]*outboxItem,
+) (validSize int64, err error) {
+	file, err := os.Open(ob.segmentPath(seg))
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, makeError(0xEFCA3C, err)
+	}
+	defer file.Close()
+	rd := bufio.NewReader(file)
+	for {
+		header, err := rd.ReadString('\n')
+		if err != nil {
+			break
+		}
+		id := getPart(header, " id:", " ")
+		if strings.HasPrefix(header, "DEL ") {
+			delete(live, strings.TrimSuffix(getPart(header, " id:", ""), "\n"))
+			validSize += int64(len(header))
+			continue
+		}
+		if !strings.HasPrefix(header, "PUT ") {
+			break
+		}
+		var (
+			nanos, _ = strconv.ParseInt(getPart(header, " time:", " "), 10, 64)
+			kSize, _ = strconv.Atoi(getPart(header, " key:", " "))
+			vSize, _ = strconv.Atoi(getPart(header, " value:", " "))
+			crc      = getPart(header, " crc:", "\n")
+		)
+		if validateMessageID(id) != nil || kSize < 0 || vSize < 0 {
+			break
+		}
+		data := make([]byte, kSize+vSize)
+		_, err = io.ReadFull(rd, data)
+		if err != nil || crc != fmt.Sprintf("%08X", crc32.ChecksumIEEE(data)) {
+			break
+		}
+		item := &outboxItem{
+			id:      id,
+			key:     string(data[:kSize]),
+			created: time.Unix(0, nanos),
+			segment: seg,
+			offset:  validSize + int64(len(header)+kSize),
+			size:    vSize,
+		}
+		*loaded = append(*loaded, item)
+		live[id] = item
+		validSize += int64(len(header) + len(data))
+	}
+	return validSize, nil
+} //                                                                 loadSegment
+
+// openSegment opens segment file 'seg' for appending, creating it if needed
+func (ob *Outbox) openSegment(seg int) error {
+	file, err := os.OpenFile(ob.segmentPath(seg),
+		os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return makeError(0xE946B7, err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return makeError(0xE3135E, err)
+	}
+	ob.file = file
+	ob.fileSize = info.Size()
+	return nil
+} //                                                                 openSegment
+
+// writeRecord appends 'rec' to the last segment file and syncs it to disk.
+// If writing fails, truncates the incomplete record. Starts a new segment
+// file when the last one reaches Config.OutboxSegmentSize.
+func (ob *Outbox) writeRecord(rec []byte) error {
+	_, err := ob.file.Write(rec)
+	if err == nil {
+		err = ob.file.Sync()
+	}
+	if err != nil {
+		_ = ob.file.Truncate(ob.fileSize)
+		return makeError(0xEC58F5, err)
+	}
+	ob.fileSize += int64(len(rec))
+	ob.rotateSegment()
+	return nil
+} //                                                                 writeRecord
+
+// rotateSegment starts a new segment file if the last one has reached
+// Config.OutboxSegmentSize. The record that filled the last file is
+// already on disk, so if the new file can't be opened, the error is only
+// logged, and records are still appended to the last file until it can.
+func (ob *Outbox) rotateSegment() {
+	limit := int64(ob.Config.OutboxSegmentSize)
+	if limit == 0 || ob.fileSize < limit {
+		return
+	}
+	last := ob.file
+	seg := ob.segments[len(ob.segments)-1] + 1
+	err := ob.openSegment(seg) // only replaces ob.file if it succeeds
+	if err != nil {
+		_ = ob.sender.logError(0xE8CE4C, "can't start segment file:", err)
+		return
+	}
+	ob.segments = append(ob.segments, seg)
+	err = last.Close()
+	if err != nil {
+		_ = ob.sender.logError(0xEF6C59, err)
+	}
+} //                                                               rotateSegment
+
+// removeSegments deletes the oldest segment files while they have no
+// undelivered items, except for the last file, which is still written
+func (ob *Outbox) removeSegments() {
+	for len(ob.segments) > 1 && ob.pending[ob.segments[0]] == 0 {
+		seg := ob.segments[0]
+		err := os.Remove(ob.segmentPath(seg))
+		if err != nil && !os.IsNotExist(err) {
+			_ = ob.sender.logError(0xED73B5, err)
+			return
+		}
+		delete(ob.pending, seg)
+		ob.segments = ob.segments[1:]
+	}
+} //                                                              removeSegments
+
+// segmentPath returns the path of segment file 'seg'
+func (ob *Outbox) segmentPath(seg int) string {
+	return filepath.Join(ob.Dir, fmt.Sprintf("%08d", seg)+outboxSegmentExt)
+} //                                                                 segmentPath
+
+// end
@@ -0,0 +1,277 @@
+// -----------------------------------------------------------------------------
+// github.com/balacode/udpt                                    /[outbox_test.go]
+// (c) balarabe@protonmail.com                                      License: MIT
+// -----------------------------------------------------------------------------
+
+package udpt
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/balacode/udpt/udptest"
+)
+
+// to run all tests in this file:
+// go test -v -run Test_Outbox_*
+
+// -----------------------------------------------------------------------------
+
+// newTestOutbox returns an Outbox on host 10.0.0.1 of network 'nw', which
+// sends to a Receiver at 10.0.0.11, and keeps its files in directory 'dir'
+func newTestOutbox(nw *udptest.Network, dir string) *Outbox {
+	cf := NewDefaultConfig()
+	cf.Transport = nw.Host("10.0.0.1")
+	cf.ReplyTimeout = 100 * time.Millisecond
+	cf.SendRetryInterval = 10 * time.Millisecond
+	cf.SendRetries = 2
+	cf.OutboxRetryInterval = 10 * time.Millisecond
+	cf.OutboxMaxRetryInterval = 50 * time.Millisecond
+	return &Outbox{Dir: dir, Address: "10.0.0.11:9876",
+		CryptoKey: []byte(testAESKey), Config: cf}
+}
+
+// makeTestOutboxDir creates a temporary directory for an Outbox's
+// files, and returns its path and a function that deletes it
+func makeTestOutboxDir(t *testing.T) (dir string, remove func()) {
+	dir, err := ioutil.TempDir("", "udpt")
+	if err != nil {
+		t.Fatal("0xEB0BF2", err)
+	}
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+// waitForEmptyOutbox waits up to 5 seconds until 'ob' is empty
+func waitForEmptyOutbox(ob *Outbox) bool {
+	for i := 0; i < 500 && ob.Len() > 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	return ob.Len() == 0
+}
+
+// -----------------------------------------------------------------------------
+// (ob *Outbox) Put(k string, v []byte) error
+//
+// go test -run Test_Outbox_*
+
+// must keep items until the Receiver is reachable, then deliver them in order
+func Test_Outbox_1(t *testing.T) {
+	dir, remove := makeTestOutboxDir(t)
+	defer remove()
+	nw := udptest.NewNetwork()
+	ob := newTestOutbox(nw, dir)
+	if err := ob.Open(); err != nil {
+		t.Fatal("0xE7FBB9", err)
+	}
+	defer ob.Close()
+	if ob.Len() != 0 || ob.OldestAge() != 0 {
+		t.Error("0xEF4CB1", ob.Len(), ob.OldestAge())
+	}
+	values := map[string][]byte{
+		"a": randomBytes(1, 10), "b": randomBytes(2, 5000), "c": nil,
+	}
+	for _, k := range []string{"a", "b", "c"} {
+		if err := ob.Put(k, values[k]); err != nil {
+			t.Error("0xEDB49C", err)
+		}
+	}
+	time.Sleep(200 * time.Millisecond)
+	if ob.Len() != 3 || ob.OldestAge() < 200*time.Millisecond {
+		t.Error("0xEB9A32", ob.Len(), ob.OldestAge())
+	}
+	var order []string
+	addrs, td, stop := runTestReceivers(nw, []string{"10.0.0.11"},
+		func(rc *Receiver) {
+			receive := rc.Receive
+			rc.Receive = func(k string, v []byte) error {
+				order = append(order, k)
+				return receive(k, v)
+			}
+		})
+	defer stop()
+	if !waitForEmptyOutbox(ob) {
+		t.Fatal("0xECB1C2", "undelivered items:", ob.Len())
+	}
+	if fmt.Sprint(order) != "[a b c]" {
+		t.Error("0xEFBE5B", "wrong order:", order)
+	}
+	for k, v := range values {
+		got := td.get(addrs[0], k)
+		if len(got) != 1 || !bytes.Equal(got[0], v) {
+			t.Error("0xE59901", k, len(got))
+		}
+	}
+}
+
+// must send undelivered items after reopening, and delete segment files
+func Test_Outbox_2(t *testing.T) {
+	dir, remove := makeTestOutboxDir(t)
+	defer remove()
+	nw := udptest.NewNetwork()
+	ob := newTestOutbox(nw, dir)
+	ob.Config.OutboxSegmentSize = 300
+	_ = ob.Open()
+	for i := 0; i < 10; i++ {
+		_ = ob.PutString(fmt.Sprint("k", i), strings.Repeat("v", 100))
+	}
+	_ = ob.Close()
+	if err := ob.Put("k", nil); !matchError(err, "Outbox is not open") {
+		t.Error("0xEC6217", "wrong error:", err)
+	}
+	paths, _ := filepath.Glob(filepath.Join(dir, "*.outbox"))
+	if len(paths) < 3 {
+		t.Error("0xEA1834", "too few segment files:", paths)
+	}
+	// a record cut short when the program stopped must be ignored
+	last := paths[len(paths)-1]
+	f, _ := os.OpenFile(last, os.O_APPEND|os.O_WRONLY, 0600)
+	_, _ = f.WriteString("PUT id:x time:1 key:1 value:9 crc:00000000\nk")
+	f.Close()
+	//
+	_, td, stop := runTestReceivers(nw, []string{"10.0.0.11"}, nil)
+	defer stop()
+	ob = newTestOutbox(nw, dir)
+	ob.Config.OutboxSegmentSize = 300
+	if err := ob.Open(); err != nil {
+		t.Fatal("0xE98E90", err)
+	}
+	defer ob.Close()
+	if !waitForEmptyOutbox(ob) {
+		t.Fatal("0xE5C6F9", "undelivered items:", ob.Len())
+	}
+	td.mu.Lock()
+	n := len(td.items)
+	td.mu.Unlock()
+	if n != 10 {
+		t.Error("0xE3483F", "delivered", n, "items")
+	}
+	paths, _ = filepath.Glob(filepath.Join(dir, "*.outbox"))
+	if len(paths) != 1 {
+		t.Error("0xE0A208", "segment files not deleted:", paths)
+	}
+	if data, _ := ioutil.ReadFile(paths[0]); bytes.Contains(data, []byte("x")) {
+		t.Error("0xEF7839", "incomplete record not truncated")
+	}
+}
+
+// must remove rejected items and report them
+func Test_Outbox_3(t *testing.T) {
+	dir, remove := makeTestOutboxDir(t)
+	defer remove()
+	nw := udptest.NewNetwork()
+	addrs, td, stop := runTestReceivers(nw, []string{"10.0.0.11"},
+		func(rc *Receiver) { rc.Config.MaxItemSize = 100 })
+	defer stop()
+	ob := newTestOutbox(nw, dir)
+	rejected := make(chan string, 1)
+	ob.Rejected = func(k string, v []byte, reason string) {
+		rejected <- k + ": " + reason
+	}
+	_ = ob.Open()
+	defer ob.Close()
+	_ = ob.Put("big", randomBytes(3, 1000))
+	_ = ob.PutString("small", "v")
+	if !waitForEmptyOutbox(ob) {
+		t.Fatal("0xE70DB2", "undelivered items:", ob.Len())
+	}
+	if got := <-rejected; got != "big: item size exceeds limit 100" {
+		t.Error("0xE2A4D9", "wrong rejection:", got)
+	}
+	if len(td.get(addrs[0], "small")) != 1 {
+		t.Error("0xE84C8F", "item not delivered")
+	}
+}
+
+// must keep an item that was written when a new segment file can't be
+// started, and keep appending to the last segment file until it can
+func Test_Outbox_5(t *testing.T) {
+	dir, remove := makeTestOutboxDir(t)
+	defer remove()
+	ob := newTestOutbox(udptest.NewNetwork(), dir) // no Receiver
+	ob.Config.LogWriter = nil
+	ob.Config.OutboxSegmentSize = 1
+	if err := ob.Open(); err != nil {
+		t.Fatal("0xED0491", err)
+	}
+	// a directory in place of the next segment file makes opening it fail
+	next := ob.segmentPath(2)
+	if err := os.Mkdir(next, 0700); err != nil {
+		t.Fatal("0xE33AA5", err)
+	}
+	for _, k := range []string{"a", "b"} {
+		if err := ob.PutString(k, k); err != nil {
+			t.Error("0xE85E54", k, err)
+		}
+	}
+	if ob.Len() != 2 || fmt.Sprint(ob.segments) != "[1]" {
+		t.Error("0xE02501", ob.Len(), ob.segments)
+	}
+	_ = os.Remove(next)
+	_ = ob.PutString("c", "c")
+	_ = ob.Close()
+	//
+	ob = newTestOutbox(udptest.NewNetwork(), dir)
+	ob.Config.LogWriter = nil
+	_ = ob.Open()
+	defer ob.Close()
+	if ob.Len() != 3 {
+		t.Error("0xE771DC", "reloaded", ob.Len(), "items")
+	}
+}
+
+// (ob *Outbox) Open() error
+//
+// must fail when not configured properly
+func Test_Outbox_4(t *testing.T) {
+	nw := udptest.NewNetwork()
+	ob := newTestOutbox(nw, "")
+	if err := ob.Open(); !matchError(err, "missing Outbox.Dir") {
+		t.Error("0xE631DF", "wrong error:", err)
+	}
+	dir, remove := makeTestOutboxDir(t)
+	defer remove()
+	ob = newTestOutbox(nw, dir)
+	ob.Address = "10.0.0.11"
+	if err := ob.Open(); !matchError(err, "invalid port") {
+		t.Error("0xE21A7A", "wrong error:", err)
+	}
+	ob = newTestOutbox(nw, dir)
+	_ = ob.Open()
+	defer ob.Close()
+	if err := ob.Open(); !matchError(err, "Outbox is already open") {
+		t.Error("0xEA3B92", "wrong error:", err)
+	}
+}
+
+// (ob *Outbox) Close() error
+//
+// must not wait for the Sender's retries of the item being sent
+func Test_Outbox_6(t *testing.T) {
+	dir, remove := makeTestOutboxDir(t)
+	defer remove()
+	ob := newTestOutbox(udptest.NewNetwork(), dir) // no Receiver
+	ob.Config.LogWriter = nil
+	ob.Config.ReplyTimeout = time.Second
+	ob.Config.SendRetries = 10
+	_ = ob.Open()
+	_ = ob.PutString("k", "v")
+	time.Sleep(50 * time.Millisecond) // the item is being sent
+	t0 := time.Now()
+	if err := ob.Close(); err != nil {
+		t.Error("0xE048B5", err)
+	}
+	if elapsed := time.Since(t0); elapsed > 500*time.Millisecond {
+		t.Error("0xE7FE61", "Close() took", elapsed)
+	}
+	if ob.Len() != 1 {
+		t.Error("0xEBB18B", "the undelivered item must be kept")
+	}
+}
+
+// end
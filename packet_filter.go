@@ -0,0 +1,220 @@
+// -----------------------------------------------------------------------------
+// github.com/balacode/udpt                                  /[packet_filter.go]
+// (c) balarabe@protonmail.com                                      License: MIT
+// -----------------------------------------------------------------------------
+
+package udpt
+
+// # Type
+//   packetFilter struct
+//
+// # Methods (pf *packetFilter)
+//   ) Init(cf *Configuration) error
+//   ) IsAllowed(addr net.Addr) bool
+//   ) TakeRate(addr net.Addr, size int, now time.Time) bool
+//   ) ForgetIdleSources(now time.Time)
+//
+// # Helper Functions
+//   addrIP(addr net.Addr) net.IP
+//   parseNetworks(list []string) ([]*net.IPNet, error)
+//   sameAddress(a, b net.Addr) bool
+
+import (
+	"net"
+	"strings"
+	"time"
+)
+
+// maxRateSources is the maximum number of source hosts for which
+// packetFilter keeps rate limiting state. Once reached, packets from
+// new sources are dropped until idle sources are forgotten, so spoofed
+// source addresses can't make the Receiver use unlimited memory.
+const maxRateSources = 64 * 1024
+
+// packetFilter decides which packets a Receiver accepts before it spends
+// any effort decrypting them. It checks the source address against the
+// allowed and denied networks in Configuration, and limits the rate of
+// packets and bytes received from each source host.
+type packetFilter struct {
+	allowed     []*net.IPNet
+	denied      []*net.IPNet
+	packetRate  float64
+	packetBurst float64
+	byteRate    float64
+	byteBurst   float64
+	sources     map[string]*sourceBuckets
+	lastSweep   time.Time
+} //                                                                packetFilter
+
+// sourceBuckets holds the rate limiting state of a single source host.
+type sourceBuckets struct {
+	packets tokenBucket
+	bytes   tokenBucket
+} //                                                               sourceBuckets
+
+// -----------------------------------------------------------------------------
+// # Methods (pf *packetFilter)
+
+// Init sets up the filter using the networks and
+// rate limits specified in configuration 'cf'.
+func (pf *packetFilter) Init(cf *Configuration) error {
+	allowed, err := parseNetworks(cf.AllowedNetworks)
+	if err != nil {
+		return makeError(0xEEEB70, "invalid Configuration.AllowedNetworks:",
+			err)
+	}
+	denied, err := parseNetworks(cf.DeniedNetworks)
+	if err != nil {
+		return makeError(0xE548DD, "invalid Configuration.DeniedNetworks:",
+			err)
+	}
+	*pf = packetFilter{
+		allowed:     allowed,
+		denied:      denied,
+		packetRate:  cf.SourcePacketRate,
+		packetBurst: float64(cf.SourcePacketBurst),
+		byteRate:    cf.SourceByteRate,
+		byteBurst:   float64(cf.SourceByteBurst),
+		sources:     make(map[string]*sourceBuckets),
+	}
+	return nil
+} //                                                                        Init
+
+// IsAllowed returns true if a packet from 'addr' may be processed. Denied
+// networks take precedence over allowed networks. When the list of allowed
+// networks is empty, all addresses that are not denied are allowed.
+func (pf *packetFilter) IsAllowed(addr net.Addr) bool {
+	if len(pf.allowed) == 0 && len(pf.denied) == 0 {
+		return true
+	}
+	ip := addrIP(addr)
+	if ip == nil {
+		return len(pf.allowed) == 0
+	}
+	for _, ipNet := range pf.denied {
+		if ipNet.Contains(ip) {
+			return false
+		}
+	}
+	if len(pf.allowed) == 0 {
+		return true
+	}
+	for _, ipNet := range pf.allowed {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+} //                                                                   IsAllowed
+
+// TakeRate returns true if a packet of 'size' bytes from 'addr', arriving
+// at time 'now', is within the packet and byte rate limits of its host.
+func (pf *packetFilter) TakeRate(addr net.Addr, size int, now time.Time) bool {
+	if pf.packetRate <= 0 && pf.byteRate <= 0 {
+		return true
+	}
+	host := addrHost(addr)
+	src := pf.sources[host]
+	if src == nil {
+		if len(pf.sources) >= maxRateSources {
+			return false
+		}
+		src = &sourceBuckets{}
+		pf.sources[host] = src
+	}
+	// check both limits before taking tokens, so a packet dropped
+	// by one limit doesn't use up the allowance of the other
+	if pf.packetRate > 0 &&
+		!src.packets.Has(1, pf.packetRate, pf.packetBurst, now) {
+		return false
+	}
+	if pf.byteRate > 0 &&
+		!src.bytes.Has(float64(size), pf.byteRate, pf.byteBurst, now) {
+		return false
+	}
+	if pf.packetRate > 0 {
+		src.packets.Take(1, pf.packetRate, pf.packetBurst, now)
+	}
+	if pf.byteRate > 0 {
+		src.bytes.Take(float64(size), pf.byteRate, pf.byteBurst, now)
+	}
+	return true
+} //                                                                    TakeRate
+
+// ForgetIdleSources discards the rate limiting state of hosts whose
+// buckets have refilled completely, since a full bucket is the same
+// as a new one. It only checks sources once per second.
+func (pf *packetFilter) ForgetIdleSources(now time.Time) {
+	if now.Sub(pf.lastSweep) < time.Second {
+		return
+	}
+	pf.lastSweep = now
+	for host, src := range pf.sources {
+		if (pf.packetRate <= 0 ||
+			src.packets.IsFull(pf.packetRate, pf.packetBurst, now)) &&
+			(pf.byteRate <= 0 ||
+				src.bytes.IsFull(pf.byteRate, pf.byteBurst, now)) {
+			delete(pf.sources, host)
+		}
+	}
+} //                                                           ForgetIdleSources
+
+// -----------------------------------------------------------------------------
+// # Helper Functions
+
+// addrIP returns the IP address of 'addr',
+// or nil if it doesn't contain an IP address.
+func addrIP(addr net.Addr) net.IP {
+	if udpAddr, ok := addr.(*net.UDPAddr); ok {
+		return udpAddr.IP
+	}
+	host := addrHost(addr)
+	if i := strings.LastIndex(host, "%"); i != -1 {
+		host = host[:i] // remove IPv6 zone
+	}
+	return net.ParseIP(host)
+} //                                                                      addrIP
+
+// parseNetworks parses a list of networks in CIDR notation, e.g.
+// "192.168.0.0/16" or "fd00::/8". Single IP addresses like "10.1.2.3"
+// or "::1" are also accepted and treated as networks of one address.
+func parseNetworks(list []string) ([]*net.IPNet, error) {
+	var ret []*net.IPNet
+	for _, s := range list {
+		s = strings.TrimSpace(s)
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, makeError(0xE73D20, "bad IP address:", s)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			ret = append(ret, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, makeError(0xE4902F, err)
+		}
+		ret = append(ret, ipNet)
+	}
+	return ret, nil
+} //                                                               parseNetworks
+
+// sameAddress returns true if 'a' and 'b' have the same IP address
+// and port, even when their IPs are written differently (for example,
+// an IPv4 address and the same address mapped to IPv6).
+func sameAddress(a, b net.Addr) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	ipA, ipB := addrIP(a), addrIP(b)
+	if ipA == nil || ipB == nil {
+		return a.String() == b.String()
+	}
+	return ipA.Equal(ipB) && addressPort(a.String()) == addressPort(b.String())
+} //                                                                 sameAddress
+
+// end
@@ -0,0 +1,239 @@
+// -----------------------------------------------------------------------------
+// github.com/balacode/udpt                             /[packet_filter_test.go]
+// (c) balarabe@protonmail.com                                      License: MIT
+// -----------------------------------------------------------------------------
+
+package udpt
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// to run all tests in this file:
+// go test -v -run Test_packetFilter_*
+
+// -----------------------------------------------------------------------------
+
+// newTestUDPAddr returns a *net.UDPAddr for IP address 's'
+func newTestUDPAddr(s string) *net.UDPAddr {
+	return &net.UDPAddr{IP: net.ParseIP(s), Port: 9000}
+}
+
+// (pf *packetFilter) Init(cf *Configuration) error
+//
+// go test -run Test_packetFilter_Init_
+//
+func Test_packetFilter_Init_(t *testing.T) {
+	var pf packetFilter
+	cf := NewDefaultConfig()
+	cf.AllowedNetworks = []string{"10.0.0.0/8", "192.168.1.7"}
+	cf.DeniedNetworks = []string{"::1"}
+	err := pf.Init(cf)
+	if err != nil {
+		t.Error("0xE46D08", err)
+	}
+	if len(pf.allowed) != 2 || len(pf.denied) != 1 {
+		t.Error("0xE050D3")
+	}
+	cf.AllowedNetworks = []string{"10.0.0.0/33"}
+	err = pf.Init(cf)
+	if !matchError(err, "invalid Configuration.AllowedNetworks") {
+		t.Error("0xE4F83D", "wrong error:", err)
+	}
+	cf.AllowedNetworks = nil
+	cf.DeniedNetworks = []string{"localhost"}
+	err = pf.Init(cf)
+	if !matchError(err, "invalid Configuration.DeniedNetworks") {
+		t.Error("0xE86405", "wrong error:", err)
+	}
+}
+
+// (pf *packetFilter) IsAllowed(addr net.Addr) bool
+//
+// go test -run Test_packetFilter_IsAllowed_
+//
+func Test_packetFilter_IsAllowed_(t *testing.T) {
+	var pf packetFilter
+	cf := NewDefaultConfig()
+	//
+	// without lists, everything is allowed
+	_ = pf.Init(cf)
+	if !pf.IsAllowed(newTestUDPAddr("203.0.113.5")) {
+		t.Error("0xE4B27D")
+	}
+	// denied networks take precedence over allowed networks
+	cf.AllowedNetworks = []string{"10.0.0.0/8", "fd00::/8"}
+	cf.DeniedNetworks = []string{"10.6.6.0/24", "10.1.2.3"}
+	_ = pf.Init(cf)
+	for _, tc := range []struct {
+		addr net.Addr
+		want bool
+	}{
+		{newTestUDPAddr("10.0.0.1"), true},
+		{newTestUDPAddr("10.6.6.6"), false},
+		{newTestUDPAddr("10.1.2.3"), false},
+		{newTestUDPAddr("10.1.2.4"), true},
+		{newTestUDPAddr("fd12::1"), true},
+		{newTestUDPAddr("fe80::1"), false},
+		{newTestUDPAddr("203.0.113.5"), false},
+		{&mockNetAddr{network: "udp", addr: "10.8.9.10:11"}, true},
+		{&mockNetAddr{network: "udp", addr: "no-ip"}, false},
+	} {
+		if got := pf.IsAllowed(tc.addr); got != tc.want {
+			t.Error("0xE4970B", tc.addr, "want:", tc.want, "got:", got)
+		}
+	}
+	// with only denied networks, other addresses are allowed
+	cf.AllowedNetworks = nil
+	_ = pf.Init(cf)
+	if !pf.IsAllowed(newTestUDPAddr("203.0.113.5")) ||
+		pf.IsAllowed(newTestUDPAddr("10.6.6.1")) {
+		t.Error("0xEE98F6")
+	}
+}
+
+// (pf *packetFilter) TakeRate(addr net.Addr, size int, now time.Time) bool
+//
+// go test -run Test_packetFilter_TakeRate_
+//
+func Test_packetFilter_TakeRate_(t *testing.T) {
+	var (
+		pf    packetFilter
+		cf    = NewDefaultConfig()
+		now   = time.Now()
+		addr1 = newTestUDPAddr("10.0.0.1")
+		addr2 = newTestUDPAddr("10.0.0.2")
+	)
+	_ = pf.Init(cf)
+	if !pf.TakeRate(addr1, 1000, now) || len(pf.sources) != 0 {
+		t.Error("0xEF218F")
+	}
+	// packet rate limit applies to each source separately
+	cf.SourcePacketRate = 1
+	cf.SourcePacketBurst = 2
+	_ = pf.Init(cf)
+	if !pf.TakeRate(addr1, 10, now) || !pf.TakeRate(addr1, 10, now) {
+		t.Error("0xE83218")
+	}
+	if pf.TakeRate(addr1, 10, now) {
+		t.Error("0xE9EE4C")
+	}
+	if !pf.TakeRate(addr2, 10, now) {
+		t.Error("0xE03D04")
+	}
+	// byte rate limit
+	cf.SourcePacketRate = 0
+	cf.SourceByteRate = 1000
+	cf.SourceByteBurst = 1500
+	_ = pf.Init(cf)
+	if !pf.TakeRate(addr1, 1000, now) || pf.TakeRate(addr1, 1000, now) {
+		t.Error("0xE65EEB")
+	}
+	if !pf.TakeRate(addr1, 1000, now.Add(time.Second)) {
+		t.Error("0xE5F7B7")
+	}
+	// a packet dropped by one limit must not take from the other
+	cf.SourcePacketRate = 1
+	cf.SourcePacketBurst = 2
+	_ = pf.Init(cf)
+	if !pf.TakeRate(addr1, 1000, now) || pf.TakeRate(addr1, 1000, now) {
+		t.Error("0xE33CD6")
+	}
+	if !pf.TakeRate(addr1, 500, now) || pf.TakeRate(addr1, 10, now) {
+		t.Error("0xE047FF", "wrong packet tokens")
+	}
+	_ = pf.Init(cf)
+	if !pf.TakeRate(addr2, 1000, now) || !pf.TakeRate(addr2, 10, now) ||
+		pf.TakeRate(addr2, 10, now) {
+		t.Error("0xEBDC09")
+	}
+	if !pf.TakeRate(addr2, 1000, now.Add(time.Second)) {
+		t.Error("0xEB5863", "wrong byte tokens")
+	}
+}
+
+// (pf *packetFilter) ForgetIdleSources(now time.Time)
+//
+// go test -run Test_packetFilter_ForgetIdleSources_
+//
+func Test_packetFilter_ForgetIdleSources_(t *testing.T) {
+	var (
+		pf  packetFilter
+		cf  = NewDefaultConfig()
+		now = time.Now()
+	)
+	cf.SourcePacketRate = 10
+	cf.SourcePacketBurst = 10
+	_ = pf.Init(cf)
+	pf.TakeRate(newTestUDPAddr("10.0.0.1"), 10, now)
+	pf.TakeRate(newTestUDPAddr("10.0.0.2"), 10, now.Add(time.Second))
+	//
+	pf.ForgetIdleSources(now.Add(time.Second))
+	if len(pf.sources) != 1 {
+		t.Error("0xE916F7", len(pf.sources))
+	}
+	// must not check again within a second
+	pf.ForgetIdleSources(now.Add(1500 * time.Millisecond))
+	if len(pf.sources) != 1 {
+		t.Error("0xEBC8AD")
+	}
+	pf.ForgetIdleSources(now.Add(2 * time.Second))
+	if len(pf.sources) != 0 {
+		t.Error("0xEE0883")
+	}
+}
+
+// -----------------------------------------------------------------------------
+// # Helper Functions
+
+// parseNetworks(list []string) ([]*net.IPNet, error)
+//
+// go test -run Test_parseNetworks_
+//
+func Test_parseNetworks_(t *testing.T) {
+	got, err := parseNetworks([]string{" 192.168.0.0/16 ", "10.1.2.3", "::1"})
+	if err != nil {
+		t.Error("0xEE34C9", err)
+	}
+	if len(got) != 3 ||
+		got[0].String() != "192.168.0.0/16" ||
+		got[1].String() != "10.1.2.3/32" ||
+		got[2].String() != "::1/128" {
+		t.Error("0xE7A570", got)
+	}
+	_, err = parseNetworks([]string{"10.1.2.300"})
+	if !matchError(err, "bad IP address") {
+		t.Error("0xE7B790", "wrong error:", err)
+	}
+}
+
+// sameAddress(a, b net.Addr) bool
+//
+// go test -run Test_sameAddress_
+//
+func Test_sameAddress_(t *testing.T) {
+	v4 := &net.UDPAddr{IP: net.IP{10, 0, 0, 1}, Port: 9876}
+	for i, tc := range []struct {
+		b    net.Addr
+		want bool
+	}{
+		{&net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 9876}, true},
+		{&mockNetAddr{"udp", "[::ffff:10.0.0.1]:9876"}, true},
+		{&mockNetAddr{"udp", "10.0.0.1:9876"}, true},
+		{&net.UDPAddr{IP: net.IP{10, 0, 0, 1}, Port: 9877}, false},
+		{&net.UDPAddr{IP: net.IP{10, 0, 0, 2}, Port: 9876}, false},
+		{&mockNetAddr{"udp", "host:9876"}, false},
+		{nil, false},
+	} {
+		if got := sameAddress(v4, tc.b); got != tc.want {
+			t.Error("0xE28394", i, "got", got)
+		}
+	}
+	if !sameAddress(nil, nil) {
+		t.Error("0xE904F9")
+	}
+}
+
+// end
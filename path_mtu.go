@@ -0,0 +1,176 @@
+// -----------------------------------------------------------------------------
+// github.com/balacode/udpt                                       /[path_mtu.go]
+// (c) balarabe@protonmail.com                                      License: MIT
+// -----------------------------------------------------------------------------
+
+package udpt
+
+// # pathMTUCache Type
+//   pathMTUCache struct
+//   ) get(address string, base, max int, ttl time.Duration, now time.Time,
+//   ) (int, bool)
+//   ) begin(address string, base, max int, ttl time.Duration, . . .
+//   ) end(address string, payload, base, max int, now time.Time)
+//   ) expect(hash []byte) <-chan struct{}
+//   ) acknowledge(hash []byte) bool
+//   ) forget(hash []byte)
+//
+// # Functions
+//   makeProbe(size int) []byte
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// maxPacketOverhead is the number of bytes by which a packet can exceed its
+// payload: the header, the address validation cookie and the encryption
+// overhead. Configuration.Validate() requires PacketSizeLimit to leave
+// this much room above PacketPayloadSize.
+const maxPacketOverhead = 200
+
+// pathMTUProbes is the number of times a probe of a given size is sent
+// without acknowledgement, before the size is considered too large
+// (MAX_PROBES in RFC 8899)
+const pathMTUProbes = 3
+
+// pathMTUStep is the precision of the search for the largest payload
+// size: it ends when the largest size acknowledged and the smallest
+// size lost are less than pathMTUStep bytes apart
+const pathMTUStep = 16
+
+// -----------------------------------------------------------------------------
+// # pathMTUCache Type
+
+// pathMTUCache holds the largest payload sizes that reached each of the
+// addresses to which a Sender sends, found by sending padded probe
+// packets of different sizes (see Sender.discoverPathMTU). It also
+// tracks the probes waiting for acknowledgement.
+//
+// The zero value is ready to use.
+//
+type pathMTUCache struct {
+	mutex   sync.Mutex
+	entries map[string]pathMTUEntry
+	acks    map[string]chan struct{}
+} //                                                                pathMTUCache
+
+// pathMTUEntry is the result of the search for the largest payload size
+// for one address, within the range of sizes from 'base' to 'max'
+type pathMTUEntry struct {
+	payload int       // the largest payload size acknowledged
+	base    int       // Config.PacketPayloadSize during the search
+	max     int       // the largest payload size that was allowed
+	time    time.Time // when the search ended
+	probing bool      // set while the search is in progress
+} //                                                                pathMTUEntry
+
+// get returns the payload size found for 'address' within the range of
+// sizes from 'base' to 'max', if it was found less than 'ttl' before
+// 'now' (or 'ttl' is zero). Otherwise returns false.
+func (pc *pathMTUCache) get(address string, base, max int,
+	ttl time.Duration, now time.Time,
+) (int, bool) {
+	pc.mutex.Lock()
+	defer pc.mutex.Unlock()
+	en, ok := pc.entries[address]
+	if !ok || en.probing || en.base != base || en.max != max ||
+		ttl > 0 && now.Sub(en.time) >= ttl {
+		return 0, false
+	}
+	return en.payload, true
+} //                                                                         get
+
+// begin returns true and marks a search as in progress for 'address',
+// unless a search is already in progress, or the payload size found
+// by the last search is still valid (see get).
+func (pc *pathMTUCache) begin(address string, base, max int,
+	ttl time.Duration, now time.Time,
+) bool {
+	if _, ok := pc.get(address, base, max, ttl, now); ok {
+		return false
+	}
+	pc.mutex.Lock()
+	defer pc.mutex.Unlock()
+	en := pc.entries[address]
+	if en.probing {
+		return false
+	}
+	if pc.entries == nil {
+		pc.entries = make(map[string]pathMTUEntry)
+	}
+	en.probing = true
+	pc.entries[address] = en
+	return true
+} //                                                                       begin
+
+// end ends the search for 'address', storing the 'payload' size found
+// within the range from 'base' to 'max' at time 'now'. If 'payload' is
+// zero (the search was aborted), removes the address instead.
+func (pc *pathMTUCache) end(address string, payload, base, max int,
+	now time.Time,
+) {
+	pc.mutex.Lock()
+	defer pc.mutex.Unlock()
+	if payload == 0 {
+		delete(pc.entries, address)
+		return
+	}
+	pc.entries[address] = pathMTUEntry{
+		payload: payload, base: base, max: max, time: now,
+	}
+} //                                                                         end
+
+// expect returns a channel which is closed when the probe with
+// the specified hash is acknowledged (see acknowledge).
+func (pc *pathMTUCache) expect(hash []byte) <-chan struct{} {
+	pc.mutex.Lock()
+	defer pc.mutex.Unlock()
+	if pc.acks == nil {
+		pc.acks = make(map[string]chan struct{})
+	}
+	ret := make(chan struct{})
+	pc.acks[string(hash)] = ret
+	return ret
+} //                                                                      expect
+
+// acknowledge closes the channel of the probe with the specified hash.
+// Returns false if the probe isn't expected, for example if it was
+// acknowledged after it was forgotten.
+func (pc *pathMTUCache) acknowledge(hash []byte) bool {
+	pc.mutex.Lock()
+	defer pc.mutex.Unlock()
+	ack, ok := pc.acks[string(hash)]
+	if !ok {
+		return false
+	}
+	close(ack)
+	delete(pc.acks, string(hash))
+	return true
+} //                                                                 acknowledge
+
+// forget stops expecting the probe with the specified hash
+func (pc *pathMTUCache) forget(hash []byte) {
+	pc.mutex.Lock()
+	defer pc.mutex.Unlock()
+	delete(pc.acks, string(hash))
+} //                                                                      forget
+
+// -----------------------------------------------------------------------------
+// # Functions
+
+// makeProbe returns a tagProbe packet of 'size' bytes (before encryption):
+// a header line "size:<size>\n" followed by padding. If 'size' is too
+// small for the header, the packet is just the header.
+func makeProbe(size int) []byte {
+	header := tagProbe + fmt.Sprintf("size:%d\n", size)
+	if size < len(header) {
+		size = len(header)
+	}
+	ret := make([]byte, size)
+	copy(ret, header)
+	return ret
+} //                                                                   makeProbe
+
+// end
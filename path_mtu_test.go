@@ -0,0 +1,106 @@
+// -----------------------------------------------------------------------------
+// github.com/balacode/udpt                                  /[path_mtu_test.go]
+// (c) balarabe@protonmail.com                                      License: MIT
+// -----------------------------------------------------------------------------
+
+package udpt
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+// to run all tests in this file:
+// go test -v -run Test_pathMTU_*
+
+// -----------------------------------------------------------------------------
+
+// (pc *pathMTUCache) get(address string, base, max int, . . .
+// (pc *pathMTUCache) begin(address string, base, max int, . . .
+// (pc *pathMTUCache) end(address string, payload, base, max int, . . .
+//
+// go test -run Test_pathMTU_pathMTUCache_
+//
+func Test_pathMTU_pathMTUCache_(t *testing.T) {
+	var pc pathMTUCache
+	t0 := time.Now()
+	if _, ok := pc.get("a:1", 1024, 8800, time.Minute, t0); ok {
+		t.Error("0xE51BFA", "unknown address found")
+	}
+	if !pc.begin("a:1", 1024, 8800, time.Minute, t0) {
+		t.Error("0xEDFB70", "search not started")
+	}
+	// only one search per address at a time
+	if pc.begin("a:1", 1024, 8800, time.Minute, t0) {
+		t.Error("0xEF3692", "search started twice")
+	}
+	pc.end("a:1", 1400, 1024, 8800, t0)
+	if n, ok := pc.get("a:1", 1024, 8800, time.Minute, t0); !ok || n != 1400 {
+		t.Error("0xE08950", "wrong payload size:", n, ok)
+	}
+	// the size expires after the TTL, or when the range changes
+	if _, ok := pc.get("a:1", 1024, 8800, time.Minute,
+		t0.Add(time.Minute)); ok {
+		t.Error("0xEB0A83", "expired size found")
+	}
+	if _, ok := pc.get("a:1", 1024, 9800, 0, t0.Add(time.Hour)); ok {
+		t.Error("0xE8100C", "size found for a different range")
+	}
+	if n, ok := pc.get("a:1", 1024, 8800, 0, t0.Add(time.Hour)); !ok ||
+		n != 1400 {
+		t.Error("0xE41C2A", "size must not expire with zero TTL")
+	}
+	// an abandoned search forgets the address
+	if !pc.begin("a:1", 1024, 8800, time.Minute, t0.Add(time.Minute)) {
+		t.Error("0xEBF216", "search not restarted")
+	}
+	pc.end("a:1", 0, 1024, 8800, t0)
+	if _, ok := pc.get("a:1", 1024, 8800, 0, t0); ok {
+		t.Error("0xE542D8", "abandoned search stored")
+	}
+}
+
+// (pc *pathMTUCache) expect(hash []byte) <-chan struct{}
+// (pc *pathMTUCache) acknowledge(hash []byte) bool
+// (pc *pathMTUCache) forget(hash []byte)
+//
+// go test -run Test_pathMTU_acknowledge_
+//
+func Test_pathMTU_acknowledge_(t *testing.T) {
+	var pc pathMTUCache
+	ack := pc.expect([]byte("h1"))
+	if pc.acknowledge([]byte("h2")) {
+		t.Error("0xE03572", "unexpected probe acknowledged")
+	}
+	if !pc.acknowledge([]byte("h1")) {
+		t.Error("0xE1D354", "probe not acknowledged")
+	}
+	select {
+	case <-ack:
+	default:
+		t.Error("0xED21CF", "channel not closed")
+	}
+	pc.expect([]byte("h3"))
+	pc.forget([]byte("h3"))
+	if pc.acknowledge([]byte("h3")) {
+		t.Error("0xE16316", "forgotten probe acknowledged")
+	}
+}
+
+// makeProbe(size int) []byte
+//
+// go test -run Test_pathMTU_makeProbe_
+//
+func Test_pathMTU_makeProbe_(t *testing.T) {
+	probe := makeProbe(100)
+	if len(probe) != 100 ||
+		!bytes.HasPrefix(probe, []byte(tagProbe+"size:100\n")) {
+		t.Error("0xEEBCF7", "wrong probe:", string(probe))
+	}
+	if probe := makeProbe(1); string(probe) != tagProbe+"size:1\n" {
+		t.Error("0xEDE9EA", "wrong probe:", string(probe))
+	}
+}
+
+// end
@@ -0,0 +1,182 @@
+// -----------------------------------------------------------------------------
+// github.com/balacode/udpt                                       /[priority.go]
+// (c) balarabe@protonmail.com                                      License: MIT
+// -----------------------------------------------------------------------------
+
+package udpt
+
+// # Priority Type
+//   Priority int
+//   ) String() string
+//   ) isValid() bool
+//   ) weight() int
+//
+// # packetScheduler Type
+//   packetScheduler struct
+//   ) acquire(ctx context.Context, p Priority, size int) error
+//   ) release()
+
+import (
+	"context"
+	"strconv"
+	"sync"
+)
+
+// -----------------------------------------------------------------------------
+// # Priority Type
+
+// Priority is the priority class of a data item sent with
+// Sender.SendWithPriority(). When a Sender sends several items at the
+// same time, their packets are interleaved so that each class gets a
+// share of the connection in proportion to its weight: PriorityHigh
+// items get 4 times the share of PriorityNormal items, which get
+// 4 times the share of PriorityLow items. A class only uses its
+// share while it has packets to send, so a small urgent item
+// completes quickly even while a bulk transfer is in progress.
+type Priority int
+
+const (
+	// PriorityLow is for bulk transfers, such as
+	// backups, which can wait for other items.
+	PriorityLow Priority = -1
+
+	// PriorityNormal is the priority of items
+	// sent with Send(), SendAsync(), etc.
+	PriorityNormal Priority = 0
+
+	// PriorityHigh is for small urgent items, such as control messages.
+	PriorityHigh Priority = 1
+)
+
+// String returns the name of the priority, for example "PriorityHigh".
+func (p Priority) String() string {
+	switch p {
+	case PriorityLow:
+		return "PriorityLow"
+	case PriorityNormal:
+		return "PriorityNormal"
+	case PriorityHigh:
+		return "PriorityHigh"
+	}
+	return "Priority(" + strconv.Itoa(int(p)) + ")"
+} //                                                                      String
+
+// isValid returns true if the priority is one of the Priority constants
+func (p Priority) isValid() bool {
+	return p >= PriorityLow && p <= PriorityHigh
+} //                                                                     isValid
+
+// weight returns the share of the connection given to the priority's class
+func (p Priority) weight() int {
+	switch p {
+	case PriorityLow:
+		return 1
+	case PriorityHigh:
+		return 16
+	}
+	return 4
+} //                                                                      weight
+
+// -----------------------------------------------------------------------------
+// # packetScheduler Type
+
+// packetScheduler decides the order in which the packets of the items
+// being sent by a Sender at the same time are sent. Before sending a
+// packet, sendUndeliveredPackets() calls acquire() to wait for its turn,
+// and after sending it and waiting for Config.SendPacketInterval,
+// calls release() to give the turn to the next packet.
+//
+// The next packet is chosen by self-clocked fair queueing: each waiting
+// packet is given a virtual finish time, which is the finish time of the
+// previous packet of the same Priority (or the current virtual time, if
+// later) plus the packet's size divided by the priority's weight. The
+// packet with the earliest finish time is sent next. Packets with the
+// same finish time are sent in the order they started waiting, so
+// items of the same priority take turns.
+//
+// The zero value is ready to use.
+//
+type packetScheduler struct {
+	mutex   sync.Mutex
+	busy    bool                 // a packet has the turn
+	vtime   float64              // finish time of the packet with the turn
+	finish  map[Priority]float64 // last finish time of each priority
+	waiting []*scheduledPacket   // packets waiting for their turn
+} //                                                             packetScheduler
+
+// scheduledPacket is a packet waiting for its turn in a packetScheduler
+type scheduledPacket struct {
+	finish float64       // virtual finish time
+	ready  chan struct{} // closed when the packet gets the turn
+} //                                                             scheduledPacket
+
+// acquire waits until a packet of 'size' bytes with priority 'p' gets
+// the turn to be sent, and returns nil. If 'ctx' is done first, the
+// packet stops waiting, and acquire returns ctx's error without the
+// turn, so release() must not be called.
+func (ps *packetScheduler) acquire(ctx context.Context, p Priority,
+	size int,
+) error {
+	ps.mutex.Lock()
+	if ps.finish == nil {
+		ps.finish = make(map[Priority]float64)
+	}
+	start := ps.vtime
+	if last := ps.finish[p]; last > start {
+		start = last
+	}
+	finish := start + float64(size)/float64(p.weight())
+	ps.finish[p] = finish
+	if !ps.busy {
+		ps.busy = true
+		ps.vtime = finish
+		ps.mutex.Unlock()
+		return nil
+	}
+	sp := &scheduledPacket{finish: finish, ready: make(chan struct{})}
+	ps.waiting = append(ps.waiting, sp)
+	ps.mutex.Unlock()
+	select {
+	case <-sp.ready:
+		return nil
+	case <-ctx.Done():
+	}
+	ps.mutex.Lock()
+	for i, w := range ps.waiting {
+		if w == sp {
+			ps.waiting = append(ps.waiting[:i], ps.waiting[i+1:]...)
+			ps.mutex.Unlock()
+			return ctx.Err()
+		}
+	}
+	ps.mutex.Unlock()
+	// the packet got the turn while 'ctx' was done: pass it on
+	ps.release()
+	return ctx.Err()
+} //                                                                     acquire
+
+// release gives the turn to the waiting packet with
+// the earliest finish time. It must follow acquire().
+func (ps *packetScheduler) release() {
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+	if len(ps.waiting) == 0 {
+		// idle: start counting virtual time from zero again
+		ps.busy = false
+		ps.vtime = 0
+		ps.finish = nil
+		return
+	}
+	next := 0
+	for i, sp := range ps.waiting {
+		if sp.finish < ps.waiting[next].finish {
+			next = i
+		}
+	}
+	sp := ps.waiting[next]
+	ps.waiting = append(ps.waiting[:next], ps.waiting[next+1:]...)
+	ps.vtime = sp.finish
+	close(sp.ready)
+} //                                                                     release
+
+// end
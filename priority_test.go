@@ -0,0 +1,114 @@
+// -----------------------------------------------------------------------------
+// github.com/balacode/udpt                                  /[priority_test.go]
+// (c) balarabe@protonmail.com                                      License: MIT
+// -----------------------------------------------------------------------------
+
+package udpt
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+// to run all tests in this file:
+// go test -v -run Test_packetScheduler_*
+
+// -----------------------------------------------------------------------------
+
+// (ps *packetScheduler) acquire(ctx context.Context, p Priority,
+//     size int) error
+// (ps *packetScheduler) release()
+//
+// go test -run Test_packetScheduler_*
+//
+// must give the turn to waiting packets in order of their
+// finish times, which depend on their priorities
+func Test_packetScheduler_1(t *testing.T) {
+	var ps packetScheduler
+	ctx := context.Background()
+	_ = ps.acquire(ctx, PriorityNormal, 100) // holds the turn
+	order := make(chan string)
+	for i, name := range []string{"L1", "L2", "N1", "H1", "H2", "N2"} {
+		p := map[byte]Priority{
+			'L': PriorityLow, 'N': PriorityNormal, 'H': PriorityHigh,
+		}[name[0]]
+		go func(name string) {
+			_ = ps.acquire(ctx, p, 100)
+			order <- name
+		}(name)
+		// wait until the packet is queued, to make the order predictable
+		for {
+			ps.mutex.Lock()
+			n := len(ps.waiting)
+			ps.mutex.Unlock()
+			if n == i+1 {
+				break
+			}
+		}
+	}
+	var got []string
+	for i := 0; i < 6; i++ {
+		ps.release()
+		got = append(got, <-order)
+	}
+	ps.release()
+	if s := strings.Join(got, " "); s != "H1 H2 N1 N2 L1 L2" {
+		t.Error("0xED7231", "wrong order:", s)
+	}
+	if ps.busy || ps.finish != nil {
+		t.Error("0xEDA505", "scheduler must be idle")
+	}
+}
+
+// a waiting packet must stop waiting when its context is done,
+// and give up its place to the packets waiting after it
+func Test_packetScheduler_2(t *testing.T) {
+	var ps packetScheduler
+	_ = ps.acquire(context.Background(), PriorityNormal, 100)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- ps.acquire(ctx, PriorityHigh, 100)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-done:
+		if err != context.Canceled {
+			t.Error("0xE4ED74", "wrong error:", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("0xE2369C", "acquire() ignored the context")
+	}
+	ps.mutex.Lock()
+	n := len(ps.waiting)
+	ps.mutex.Unlock()
+	if n != 0 {
+		t.Error("0xEAE19D", "the packet must not be waiting")
+	}
+	ps.release()
+	if ps.busy {
+		t.Error("0xE52EA9", "scheduler must be idle")
+	}
+}
+
+// (p Priority) String() string
+//
+// go test -run Test_Priority_String_
+//
+func Test_Priority_String_(t *testing.T) {
+	for p, want := range map[Priority]string{
+		PriorityLow:    "PriorityLow",
+		PriorityNormal: "PriorityNormal",
+		PriorityHigh:   "PriorityHigh",
+		Priority(7):    "Priority(7)",
+	} {
+		if got := p.String(); got != want {
+			t.Error("0xEB7056", "wrong String():", got)
+		}
+	}
+}
+
+// end
@@ -0,0 +1,85 @@
+// -----------------------------------------------------------------------------
+// github.com/balacode/udpt                                     /[range_list.go]
+// (c) balarabe@protonmail.com                                      License: MIT
+// -----------------------------------------------------------------------------
+
+package udpt
+
+// # Functions
+//   encodeRanges(indexes []int, maxLen int) string
+//   decodeRanges(s string, count int) ([]int, error)
+
+import (
+	"strconv"
+	"strings"
+)
+
+// encodeRanges returns a compact list of fragment numbers, for example
+// "1-4,9,12-13", from 'indexes', which must contain 0-based fragment
+// indexes in ascending order. Fragment numbers in the list are 1-based,
+// like 'sn' in the header of a tagFragment packet.
+//
+// The list is truncated to whole ranges that fit in 'maxLen' bytes, so a
+// reply listing many missing fragments always fits in a single packet.
+// The remaining ranges can be requested after the listed ones arrive.
+//
+func encodeRanges(indexes []int, maxLen int) string {
+	var sb strings.Builder
+	for i := 0; i < len(indexes); {
+		first, last := indexes[i], indexes[i]
+		i++
+		for i < len(indexes) && indexes[i] == last+1 {
+			last = indexes[i]
+			i++
+		}
+		s := strconv.Itoa(first + 1)
+		if last > first {
+			s += "-" + strconv.Itoa(last+1)
+		}
+		if sb.Len() > 0 {
+			s = "," + s
+		}
+		if sb.Len()+len(s) > maxLen {
+			break
+		}
+		sb.WriteString(s)
+	}
+	return sb.String()
+} //                                                                encodeRanges
+
+// decodeRanges reads a list of fragment numbers written by encodeRanges()
+// and returns the 0-based fragment indexes in ascending order.
+//
+// Every fragment number must be between 1 and 'count', and the ranges
+// must be in ascending order without overlapping, so the result never
+// has more than 'count' indexes, whatever the list contains.
+//
+func decodeRanges(s string, count int) ([]int, error) {
+	var ret []int
+	if s == "" {
+		return ret, nil
+	}
+	prev := 0
+	for _, part := range strings.Split(s, ",") {
+		a, b := part, part
+		if at := strings.Index(part, "-"); at != -1 {
+			a, b = part[:at], part[at+1:]
+		}
+		first, err1 := strconv.Atoi(a)
+		last, err2 := strconv.Atoi(b)
+		if err1 != nil || err2 != nil {
+			return nil, makeError(0xE738BD, "bad range:", part)
+		}
+		if first <= prev || last < first || last > count {
+			return nil, makeError(0xEECB93, "range out of order or bounds:",
+				part)
+		}
+		for sn := first; sn <= last; sn++ {
+			ret = append(ret, sn-1)
+		}
+		prev = last
+	}
+	return ret, nil
+} //                                                                decodeRanges
+
+// end
@@ -0,0 +1,101 @@
+// -----------------------------------------------------------------------------
+// github.com/balacode/udpt                                /[range_list_test.go]
+// (c) balarabe@protonmail.com                                      License: MIT
+// -----------------------------------------------------------------------------
+
+package udpt
+
+import (
+	"fmt"
+	"testing"
+)
+
+// to run all tests in this file:
+// go test -v -run Test_ranges_*
+
+// -----------------------------------------------------------------------------
+
+// encodeRanges(indexes []int, maxLen int) string
+//
+// go test -run Test_ranges_encodeRanges_
+//
+func Test_ranges_encodeRanges_(t *testing.T) {
+	for _, it := range []struct {
+		indexes []int
+		maxLen  int
+		//
+		want string
+	}{
+		{nil, 100, ""},
+		{[]int{0}, 100, "1"},
+		{[]int{0, 1, 2, 3, 8}, 100, "1-4,9"},
+		{[]int{0, 2, 4, 5}, 100, "1,3,5-6"},
+		{[]int{99, 100, 101, 102}, 100, "100-103"},
+		//
+		// must only include whole ranges that fit in maxLen
+		{[]int{0, 1, 2, 3, 8}, 4, "1-4"},
+		{[]int{0, 1, 2, 3, 8}, 5, "1-4,9"},
+		{[]int{0, 1, 2, 3, 8}, 2, ""},
+	} {
+		got := encodeRanges(it.indexes, it.maxLen)
+		if got != it.want {
+			t.Errorf("0xE5B86D"+" encodeRanges(%v, %d)"+
+				"\n want: %#v"+
+				"\n  got: %#v",
+				it.indexes, it.maxLen, it.want, got)
+		}
+	}
+}
+
+// decodeRanges(s string, count int) ([]int, error)
+//
+// go test -run Test_ranges_decodeRanges_
+//
+func Test_ranges_decodeRanges_(t *testing.T) {
+	for _, it := range []struct {
+		s     string
+		count int
+		//
+		want    string
+		wantErr string
+	}{
+		{"", 10, "[]", ""},
+		{"1", 10, "[0]", ""},
+		{"1-4,9", 10, "[0 1 2 3 8]", ""},
+		{"1,3,5-6", 6, "[0 2 4 5]", ""},
+		//
+		// malformed lists
+		{"x", 10, "[]", "bad range"},
+		{"1-", 10, "[]", "bad range"},
+		{"1,,2", 10, "[]", "bad range"},
+		//
+		// ranges must be ascending, not overlap, and not exceed 'count'
+		{"0", 10, "[]", "out of order"},
+		{"4-2", 10, "[]", "out of order"},
+		{"3,1", 10, "[]", "out of order"},
+		{"1-5,5", 10, "[]", "out of order"},
+		{"9-11", 10, "[]", "out of order"},
+		{"1-1000000000", 10, "[]", "out of order"},
+	} {
+		got, err := decodeRanges(it.s, it.count)
+		if fmt.Sprint(got) != it.want ||
+			(it.wantErr == "" && err != nil) ||
+			(it.wantErr != "" && !matchError(err, it.wantErr)) {
+			t.Errorf("0xE25C88"+" decodeRanges(%#v, %d)"+
+				"\n want: %s %v"+
+				"\n  got: %v %v",
+				it.s, it.count, it.want, it.wantErr, got, err)
+		}
+	}
+}
+
+// encodeRanges() and decodeRanges() must be reversible
+func Test_ranges_roundTrip_(t *testing.T) {
+	indexes := []int{0, 1, 5, 6, 7, 20, 22, 1000}
+	got, err := decodeRanges(encodeRanges(indexes, 1024), 1001)
+	if fmt.Sprint(got) != fmt.Sprint(indexes) || err != nil {
+		t.Error("0xEA49A8", got, err)
+	}
+}
+
+// end
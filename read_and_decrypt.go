@@ -49,15 +49,9 @@ func readAndDecrypt(
 	if tempBuf == nil {
 		return nil, nil, makeError(0xED80B0, "nil tempBuf")
 	}
-	dl := time.Now().Add(timeout)
-	err = conn.SetReadDeadline(dl)
+	nRead, addr, err := readPacket(conn, timeout, tempBuf)
 	if err != nil {
-		return nil, nil, netError(err, 0xE09B6A)
-	}
-	// contents of 'tempBuf' is overwritten after every ReadFrom
-	nRead, addr, err := conn.ReadFrom(tempBuf)
-	if err != nil {
-		return nil, nil, netError(err, 0xE0E0B1)
+		return nil, nil, err
 	}
 	data, err = decryptor.Decrypt(tempBuf[:nRead])
 	if err != nil {
@@ -66,6 +60,40 @@ func readAndDecrypt(
 	return data, addr, err
 } //                                                              readAndDecrypt
 
+// readPacket reads a single encrypted packet from the UDP connection
+// 'conn' into 'tempBuf', without decrypting it, so the packet's source
+// address can be checked before spending any effort on decryption.
+//
+// Returns the number of bytes read into 'tempBuf' and the source address.
+//
+func readPacket(
+	conn netUDPConn,
+	timeout time.Duration,
+	tempBuf []byte,
+) (
+	n int,
+	addr net.Addr,
+	err error,
+) {
+	if conn == nil {
+		return 0, nil, makeError(0xE151BA, "nil connection")
+	}
+	if tempBuf == nil {
+		return 0, nil, makeError(0xE49007, "nil tempBuf")
+	}
+	dl := time.Now().Add(timeout)
+	err = conn.SetReadDeadline(dl)
+	if err != nil {
+		return 0, nil, netError(err, 0xE09B6A)
+	}
+	// contents of 'tempBuf' is overwritten after every ReadFrom
+	n, addr, err = conn.ReadFrom(tempBuf)
+	if err != nil {
+		return 0, nil, netError(err, 0xE0E0B1)
+	}
+	return n, addr, nil
+} //                                                                  readPacket
+
 // netError filters out network errors for readAndDecrypt() and returns
 // them as distinct error instances like errClosed and errTimeout.
 //
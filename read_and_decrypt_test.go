@@ -150,6 +150,51 @@ func Test_readAndDecrypt_7(t *testing.T) {
 	}
 }
 
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+// readPacket(conn netUDPConn, timeout time.Duration, tempBuf []byte)
+//
+// go test -run Test_readPacket_*
+
+// must read the packet without decrypting it
+func Test_readPacket_1(t *testing.T) {
+	conn := &mockNetUDPConn{readFromData: []byte("encrypted")}
+	buf := make([]byte, 100)
+	n, addr, err := readPacket(conn, time.Second, buf)
+	if n != 9 || string(buf[:n]) != "encrypted" {
+		t.Error("0xE51B55")
+	}
+	if addr == nil || addr.String() != "127.8.9.10:11" {
+		t.Error("0xED7AB8")
+	}
+	if err != nil {
+		t.Error("0xE08420", err)
+	}
+}
+
+// must fail when the connection or tempBuf is nil, or reading fails
+func Test_readPacket_2(t *testing.T) {
+	for _, tc := range []struct {
+		conn    netUDPConn
+		tempBuf []byte
+		err     string
+	}{
+		{nil, make([]byte, 10), "nil connection"},
+		{&mockNetUDPConn{}, nil, "nil tempBuf"},
+		{&mockNetUDPConn{failSetReadDeadline: true}, make([]byte, 10),
+			"failed SetReadDeadline"},
+		{&mockNetUDPConn{failReadFrom: true}, make([]byte, 10),
+			"failed SetReadDeadline"},
+	} {
+		n, addr, err := readPacket(tc.conn, time.Second, tc.tempBuf)
+		if n != 0 || addr != nil {
+			t.Error("0xEDF700")
+		}
+		if !matchError(err, tc.err) {
+			t.Error("0xEF19D3", "wrong error:", err)
+		}
+	}
+}
+
 // -----------------------------------------------------------------------------
 
 // netError(err error, otherErrorID uint32) error
@@ -0,0 +1,199 @@
+// -----------------------------------------------------------------------------
+// github.com/balacode/udpt                                    /[receive_mux.go]
+// (c) balarabe@protonmail.com                                      License: MIT
+// -----------------------------------------------------------------------------
+
+package udpt
+
+// # ReceiveMux Type
+//   ReceiveMux struct
+//
+// # Methods (mx *ReceiveMux)
+//   ) Handle(pattern string, handler func(k string, v []byte) error) error
+//   ) HandleDefault(handler func(k string, v []byte) error)
+//   ) Match(k string) (handler func(k string, v []byte) error, pattern string)
+//   ) Receive(k string, v []byte) error
+//   ) HandleItem(req *Request) error
+//
+// # Helper Function
+//   isPrefixPattern(pattern string) bool
+
+import (
+	"path"
+	"strings"
+	"sync"
+)
+
+// -----------------------------------------------------------------------------
+// # ReceiveMux Type
+
+// ReceiveMux routes the data items received by a Receiver to handler
+// functions, depending on each item's key, like http.ServeMux does with
+// URL paths. To use it, assign its Receive method to Receiver.Receive:
+//
+//     var mux udpt.ReceiveMux
+//     mux.Handle("config", receiveConfig)
+//     mux.Handle("logs/*", receiveLogs)
+//     rc := udpt.Receiver{Port: 9876, CryptoKey: key, Receive: mux.Receive}
+//
+// A pattern is one of:
+//
+// An exact key, like "config", which only matches that key.
+//
+// A prefix ending with "*" (and no other wildcards), like "logs/*",
+// which matches any key that begins with the prefix.
+//
+// A glob pattern, like "logs/*.txt" or "node-??", which matches keys
+// as described in path.Match(). Note that "*" doesn't match "/".
+//
+// When several patterns match a key, an exact key takes precedence,
+// then the longest prefix, then the glob pattern registered first.
+//
+// Items that don't match any pattern are passed to the default handler.
+// If there's no default handler, they are rejected and the Sender's
+// Send() fails with "rejected by Receiver: key not found: <key>".
+//
+// When Config.ReceiveWorkers is more than zero, items are confirmed to
+// the Sender before the mux sees them, so rejections (including "key
+// not found") are only logged by the Receiver, and Send() succeeds.
+//
+// The zero value is ready to use. Handlers can be registered
+// while the Receiver is running.
+//
+type ReceiveMux struct {
+	mutex    sync.RWMutex
+	exact    map[string]func(k string, v []byte) error
+	prefixes []receiveMuxEntry // sorted from the longest prefix
+	globs    []receiveMuxEntry // in the order they were registered
+	fallback func(k string, v []byte) error
+} //                                                                  ReceiveMux
+
+// receiveMuxEntry is a prefix or glob pattern and its handler
+type receiveMuxEntry struct {
+	pattern string
+	handler func(k string, v []byte) error
+} //                                                             receiveMuxEntry
+
+// -----------------------------------------------------------------------------
+// # Methods (mx *ReceiveMux)
+
+// Handle registers 'handler' for data items whose keys match 'pattern'.
+// Returns an error if the pattern is malformed or already registered,
+// or if the handler is nil.
+func (mx *ReceiveMux) Handle(
+	pattern string,
+	handler func(k string, v []byte) error,
+) error {
+	if handler == nil {
+		return makeError(0xEF3AA5, "nil handler for pattern:", pattern)
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return makeError(0xE697C3, "bad pattern:", pattern, err)
+	}
+	mx.mutex.Lock()
+	defer mx.mutex.Unlock()
+	exists := false
+	switch {
+	case !strings.ContainsAny(pattern, `*?[\`):
+		if mx.exact == nil {
+			mx.exact = make(map[string]func(k string, v []byte) error)
+		}
+		_, exists = mx.exact[pattern]
+		if !exists {
+			mx.exact[pattern] = handler
+		}
+	case isPrefixPattern(pattern):
+		for _, ent := range mx.prefixes {
+			exists = exists || ent.pattern == pattern
+		}
+		if !exists {
+			// keep longer prefixes first, so they are matched first
+			i := 0
+			for i < len(mx.prefixes) &&
+				len(mx.prefixes[i].pattern) >= len(pattern) {
+				i++
+			}
+			mx.prefixes = append(mx.prefixes, receiveMuxEntry{})
+			copy(mx.prefixes[i+1:], mx.prefixes[i:])
+			mx.prefixes[i] = receiveMuxEntry{pattern, handler}
+		}
+	default:
+		for _, ent := range mx.globs {
+			exists = exists || ent.pattern == pattern
+		}
+		if !exists {
+			mx.globs = append(mx.globs, receiveMuxEntry{pattern, handler})
+		}
+	}
+	if exists {
+		return makeError(0xEB0A58, "pattern already registered:", pattern)
+	}
+	return nil
+} //                                                                      Handle
+
+// HandleDefault sets the handler for data items whose keys don't match
+// any registered pattern. If 'handler' is nil, such items are rejected.
+func (mx *ReceiveMux) HandleDefault(handler func(k string, v []byte) error) {
+	mx.mutex.Lock()
+	mx.fallback = handler
+	mx.mutex.Unlock()
+} //                                                               HandleDefault
+
+// Match returns the handler for key 'k' and the pattern it's registered
+// with. If no pattern matches, returns the default handler (if any)
+// and a blank pattern.
+func (mx *ReceiveMux) Match(k string) (
+	handler func(k string, v []byte) error,
+	pattern string,
+) {
+	mx.mutex.RLock()
+	defer mx.mutex.RUnlock()
+	if handler, found := mx.exact[k]; found {
+		return handler, k
+	}
+	for _, ent := range mx.prefixes {
+		if strings.HasPrefix(k, ent.pattern[:len(ent.pattern)-1]) {
+			return ent.handler, ent.pattern
+		}
+	}
+	for _, ent := range mx.globs {
+		if matched, _ := path.Match(ent.pattern, k); matched {
+			return ent.handler, ent.pattern
+		}
+	}
+	return mx.fallback, ""
+} //                                                                       Match
+
+// Receive passes the data item to the handler that matches key 'k', and
+// returns the handler's result. If no handler matches, returns a
+// RejectionError, which the Receiver sends back to the Sender.
+//
+// Its signature matches Receiver.Receive, to which it can be assigned.
+//
+func (mx *ReceiveMux) Receive(k string, v []byte) error {
+	handler, _ := mx.Match(k)
+	if handler == nil {
+		return Reject("key not found: " + k)
+	}
+	return handler(k, v)
+} //                                                                     Receive
+
+// HandleItem implements Handler, so a ReceiveMux can be assigned to
+// Receiver.Handler and wrapped with middleware. It routes the
+// request's Key and Value like Receive().
+func (mx *ReceiveMux) HandleItem(req *Request) error {
+	return mx.Receive(req.Key, req.Value)
+} //                                                                  HandleItem
+
+// -----------------------------------------------------------------------------
+// # Helper Function
+
+// isPrefixPattern returns true if 'pattern' ends with "*"
+// and contains no other wildcards or escapes
+func isPrefixPattern(pattern string) bool {
+	n := len(pattern)
+	return n > 0 && pattern[n-1] == '*' &&
+		!strings.ContainsAny(pattern[:n-1], `*?[\`)
+} //                                                             isPrefixPattern
+
+// end
@@ -0,0 +1,182 @@
+// -----------------------------------------------------------------------------
+// github.com/balacode/udpt                               /[receive_mux_test.go]
+// (c) balarabe@protonmail.com                                      License: MIT
+// -----------------------------------------------------------------------------
+
+package udpt
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/balacode/udpt/udptest"
+)
+
+// to run all tests in this file:
+// go test -v -run Test_ReceiveMux_*
+
+// -----------------------------------------------------------------------------
+
+// makeTestHandler returns a handler that appends its
+// name and the received key to 'calls'
+func makeTestHandler(name string, calls *[]string,
+) func(k string, v []byte) error {
+	return func(k string, v []byte) error {
+		*calls = append(*calls, name+" "+k)
+		return nil
+	}
+}
+
+// -----------------------------------------------------------------------------
+// (mx *ReceiveMux) Match(k string) (
+//     handler func(k string, v []byte) error, pattern string)
+//
+// go test -run Test_ReceiveMux_Match_
+
+// must route keys by exact key, then longest prefix, then glob pattern
+func Test_ReceiveMux_Match_(t *testing.T) {
+	var (
+		mx    ReceiveMux
+		calls []string
+	)
+	for _, pattern := range []string{
+		"config", "logs/*", "logs/app/*", "*.txt", "node-??",
+	} {
+		err := mx.Handle(pattern, makeTestHandler(pattern, &calls))
+		if err != nil {
+			t.Error("0xE97FEC", err)
+		}
+	}
+	for _, it := range []struct {
+		k    string
+		want string
+	}{
+		{"config", "config"},
+		{"config2", ""},
+		{"logs/", "logs/*"},
+		{"logs/app/x.txt", "logs/app/*"},
+		{"logs/web/x.txt", "logs/*"},
+		{"notes.txt", "*.txt"},
+		{"dir/notes.txt", ""},
+		{"node-01", "node-??"},
+		{"node-001", ""},
+		{"", ""},
+	} {
+		handler, pattern := mx.Match(it.k)
+		if pattern != it.want || (handler == nil) != (it.want == "") {
+			t.Error("0xE45107", it.k, "matched", pattern, "want", it.want)
+		}
+	}
+	// the handler must be the one registered with the pattern
+	calls = nil
+	handler, _ := mx.Match("logs/db")
+	_ = handler("logs/db", nil)
+	if len(calls) != 1 || calls[0] != "logs/* logs/db" {
+		t.Error("0xEA8DB2", calls)
+	}
+}
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+// (mx *ReceiveMux) Handle(
+//     pattern string, handler func(k string, v []byte) error) error
+//
+// go test -run Test_ReceiveMux_Handle_
+
+// must not register duplicate or malformed patterns, or nil handlers
+func Test_ReceiveMux_Handle_(t *testing.T) {
+	var (
+		mx    ReceiveMux
+		calls []string
+	)
+	handler := makeTestHandler("", &calls)
+	for _, it := range []struct {
+		pattern string
+		handler func(k string, v []byte) error
+		wantErr string
+	}{
+		{"k", handler, ""},
+		{"k", handler, "already registered"},
+		{"p*", handler, ""},
+		{"p*", handler, "already registered"},
+		{"g?", handler, ""},
+		{"g?", handler, "already registered"},
+		{"bad[", handler, "bad pattern"},
+		{"x", nil, "nil handler"},
+	} {
+		err := mx.Handle(it.pattern, it.handler)
+		if !matchError(err, it.wantErr) {
+			t.Error("0xE89E9E", it.pattern, "wrong error:", err)
+		}
+	}
+}
+
+// -----------------------------------------------------------------------------
+// (mx *ReceiveMux) Receive(k string, v []byte) error
+// (mx *ReceiveMux) HandleDefault(handler func(k string, v []byte) error)
+//
+// go test -run Test_ReceiveMux_Receive_
+
+// must call the matching handler, or the default
+// handler, or reject keys that match nothing
+func Test_ReceiveMux_Receive_(t *testing.T) {
+	var (
+		mx    ReceiveMux
+		calls []string
+	)
+	_ = mx.Handle("a", makeTestHandler("A", &calls))
+	_ = mx.Handle("b", func(k string, v []byte) error {
+		return errors.New("failed " + string(v))
+	})
+	err := mx.Receive("a", nil)
+	if err != nil {
+		t.Error("0xE6C3B0", err)
+	}
+	err = mx.Receive("b", []byte("b"))
+	if err == nil || err.Error() != "failed b" {
+		t.Error("0xE8B8F2", "wrong error:", err)
+	}
+	err = mx.Receive("c", nil)
+	var rejection *RejectionError
+	if !errors.As(err, &rejection) || rejection.Reason != "key not found: c" {
+		t.Error("0xE0EEAC", "wrong error:", err)
+	}
+	mx.HandleDefault(makeTestHandler("default", &calls))
+	err = mx.Receive("c", nil)
+	if err != nil || len(calls) != 2 || calls[1] != "default c" {
+		t.Error("0xE4EF7C", err, calls)
+	}
+	// must route requests passed to it as a Handler
+	err = mx.HandleItem(&Request{Key: "a"})
+	if err != nil || len(calls) != 3 || calls[2] != "A a" {
+		t.Error("0xE2D8C6", err, calls)
+	}
+}
+
+// the Sender must receive the rejection of an unknown key
+func Test_ReceiveMux_Receive_Sender_(t *testing.T) {
+	var mx ReceiveMux
+	_ = mx.Handle("known", func(k string, v []byte) error { return nil })
+	nw := udptest.NewNetwork()
+	addrs, _, stop := runTestReceivers(nw, []string{"10.0.0.11"},
+		func(rc *Receiver) { rc.Receive = mx.Receive })
+	defer stop()
+	cf := NewDefaultConfig()
+	cf.Transport = nw.Host("10.0.0.1")
+	cf.ReplyTimeout = 200 * time.Millisecond
+	sd := Sender{Address: addrs[0], CryptoKey: []byte(testAESKey), Config: cf}
+	err := sd.SendString("known", "v")
+	if err != nil {
+		t.Error("0xEA567E", err)
+	}
+	t0 := time.Now()
+	err = sd.SendString("unknown", "v")
+	if !matchError(err, "rejected by Receiver: key not found: unknown") {
+		t.Error("0xEA369B", "wrong error:", err)
+	}
+	if time.Since(t0) > cf.ReplyTimeout {
+		t.Error("0xE42BE6", "the Sender must not retry")
+	}
+}
+
+// end
@@ -0,0 +1,138 @@
+// -----------------------------------------------------------------------------
+// github.com/balacode/udpt                                  /[receive_queue.go]
+// (c) balarabe@protonmail.com                                      License: MIT
+// -----------------------------------------------------------------------------
+
+package udpt
+
+// # QueuePolicy Type
+//   QueuePolicy int
+//   ) String() string
+//
+// # receiveQueue Type
+//   receiveQueue struct
+//   ) Start(workers, size int, handle func(req *Request))
+//   ) Put(req *Request, wait bool) bool
+//   ) PutAll(reqs []*Request, wait bool) bool
+//   ) Full() bool
+//   ) Stop()
+
+import (
+	"strconv"
+	"sync"
+)
+
+// -----------------------------------------------------------------------------
+// # QueuePolicy Type
+
+// QueuePolicy specifies what a Receiver does with a data item that has
+// been fully received when its receive queue is full. See
+// Configuration.ReceiveQueuePolicy.
+type QueuePolicy int
+
+const (
+	// QueueBlock makes the Receiver wait until the queue has room for
+	// the item. No other packets are processed while it waits, so it
+	// slows down all Senders until the workers catch up.
+	QueueBlock QueuePolicy = iota
+
+	// QueueReject discards the item and sends a rejection to the Sender,
+	// whose Send() then fails with "receive queue full". Items that are
+	// queued are still delivered at most once: if the handler fails,
+	// the item is lost (see Configuration.ReceiveQueueSize).
+	QueueReject
+
+	// QueueDrop ignores the item's last fragment without confirming it,
+	// but keeps the rest of the item. The Sender resends the fragment
+	// after Config.ReplyTimeout, when the queue may have room.
+	QueueDrop
+)
+
+// String returns the name of the policy, for example "QueueBlock".
+func (qp QueuePolicy) String() string {
+	switch qp {
+	case QueueBlock:
+		return "QueueBlock"
+	case QueueReject:
+		return "QueueReject"
+	case QueueDrop:
+		return "QueueDrop"
+	}
+	return "QueuePolicy(" + strconv.Itoa(int(qp)) + ")"
+} //                                                                      String
+
+// -----------------------------------------------------------------------------
+// # receiveQueue Type
+
+// receiveQueue holds fully received data items until
+// one of a fixed number of workers can handle them
+type receiveQueue struct {
+	requests chan *Request
+	wg       sync.WaitGroup
+} //                                                                receiveQueue
+
+// Start creates a queue for 'size' requests and starts 'workers'
+// goroutines, which pass each queued request to 'handle'.
+func (qu *receiveQueue) Start(workers, size int, handle func(req *Request)) {
+	qu.requests = make(chan *Request, size)
+	qu.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer qu.wg.Done()
+			for req := range qu.requests {
+				handle(req)
+			}
+		}()
+	}
+} //                                                                       Start
+
+// Put adds 'req' to the queue and returns true. If the queue is full,
+// waits for room if 'wait' is true, otherwise returns false at once.
+func (qu *receiveQueue) Put(req *Request, wait bool) bool {
+	if wait {
+		qu.requests <- req
+		return true
+	}
+	select {
+	case qu.requests <- req:
+		return true
+	default:
+		return false
+	}
+} //                                                                         Put
+
+// PutAll adds all of 'reqs' to the queue, or none of them, and returns
+// true. If the queue doesn't have room for all, waits for room if 'wait'
+// is true, otherwise returns false at once. Without 'wait', requests that
+// could never fit in the queue together are never added, since adding
+// them would block. With 'wait', they are handed to the workers.
+//
+// Since workers only take requests from the queue, the room checked here
+// can only grow until the next Put(), so they must not be called by
+// several goroutines at once.
+//
+func (qu *receiveQueue) PutAll(reqs []*Request, wait bool) bool {
+	size := cap(qu.requests)
+	if !wait && size-len(qu.requests) < len(reqs) {
+		return false
+	}
+	for _, req := range reqs {
+		qu.requests <- req
+	}
+	return true
+} //                                                                      PutAll
+
+// Full returns true if the queue has no room for another
+// request. A queue with no room at all is never full.
+func (qu *receiveQueue) Full() bool {
+	return cap(qu.requests) > 0 && len(qu.requests) == cap(qu.requests)
+} //                                                                        Full
+
+// Stop waits for the workers to handle all the queued requests, then
+// stops them. Put() must not be called after Stop().
+func (qu *receiveQueue) Stop() {
+	close(qu.requests)
+	qu.wg.Wait()
+} //                                                                        Stop
+
+// end
@@ -0,0 +1,347 @@
+// -----------------------------------------------------------------------------
+// github.com/balacode/udpt                             /[receive_queue_test.go]
+// (c) balarabe@protonmail.com                                      License: MIT
+// -----------------------------------------------------------------------------
+
+package udpt
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/balacode/udpt/udptest"
+)
+
+// to run all tests in this file:
+// go test -v -run Test_ReceiveQueue_*
+
+// -----------------------------------------------------------------------------
+
+// newQueuedReceiver returns a Receiver with one worker and room for one
+// queued item, whose Handler reports each key to 'started', then waits
+// until 'release' is closed
+func newQueuedReceiver(policy QueuePolicy, started chan string,
+	release chan struct{},
+) *Receiver {
+	rc := &Receiver{Config: NewDefaultConfig(),
+		Handler: HandlerFunc(func(req *Request) error {
+			started <- req.Key
+			<-release
+			return nil
+		}),
+	}
+	rc.Config.LogWriter = &strings.Builder{}
+	rc.Config.ReceiveWorkers = 1
+	rc.Config.ReceiveQueueSize = 1
+	rc.Config.ReceiveQueuePolicy = policy
+	rc.startQueue()
+	return rc
+}
+
+// fillReceiveQueue sends items "a" and "b" to 'rc', so that "a" is being
+// handled and "b" is waiting in the queue, and checks they are confirmed
+func fillReceiveQueue(t *testing.T, rc *Receiver, started chan string) {
+	for _, k := range []string{"a", "b"} {
+		frags, _, _ := makeMulticastPackets(t, k, []byte(k))
+		reply, err := rc.receiveFragment(frags[0], nil)
+		if !bytes.HasPrefix(reply, []byte(tagConfirmation)) || err != nil {
+			t.Fatal("0xE20868", k, "wrong reply:", string(reply), err)
+		}
+		if k == "a" && <-started != "a" {
+			t.Fatal("0xEF58A0", "wrong item handled")
+		}
+	}
+}
+
+// -----------------------------------------------------------------------------
+// (qp QueuePolicy) String() string
+//
+// go test -run Test_ReceiveQueue_String_
+//
+func Test_ReceiveQueue_String_(t *testing.T) {
+	for _, test := range []struct {
+		qp   QueuePolicy
+		want string
+	}{
+		{QueueBlock, "QueueBlock"},
+		{QueueReject, "QueueReject"},
+		{QueueDrop, "QueueDrop"},
+		{QueuePolicy(7), "QueuePolicy(7)"},
+	} {
+		if got := test.qp.String(); got != test.want {
+			t.Error("0xE78C02", "want:", test.want, "got:", got)
+		}
+	}
+}
+
+// -----------------------------------------------------------------------------
+// (qu *receiveQueue) Start(workers, size int, handle func(req *Request))
+// (qu *receiveQueue) Put(req *Request, wait bool) bool
+// (qu *receiveQueue) Stop()
+//
+// go test -run Test_ReceiveQueue_receiveQueue_
+//
+func Test_ReceiveQueue_receiveQueue_(t *testing.T) {
+	var handled int32
+	release := make(chan struct{})
+	var qu receiveQueue
+	qu.Start(2, 3, func(req *Request) {
+		<-release
+		atomic.AddInt32(&handled, 1)
+	})
+	// 2 requests are taken by the workers, 3 wait in the queue
+	n := 0
+	for i := 0; i < 10 && qu.Put(&Request{}, false); i++ {
+		n++
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n != 5 {
+		t.Error("0xE612DF", "queued", n, "requests")
+	}
+	close(release)
+	if !qu.Put(&Request{}, true) {
+		t.Error("0xE67E99", "Put() failed")
+	}
+	// must handle all queued requests before returning
+	qu.Stop()
+	if got := atomic.LoadInt32(&handled); got != 6 {
+		t.Error("0xEC97A9", "handled", got, "requests")
+	}
+}
+
+// -----------------------------------------------------------------------------
+// (rc *Receiver) receiveFragment(recv []byte, addr net.Addr) ([]byte, error)
+//
+// go test -run Test_ReceiveQueue_Receiver_*
+
+// QueueBlock must confirm the last fragment when there's room in the queue
+func Test_ReceiveQueue_Receiver_1(t *testing.T) {
+	started, release := make(chan string, 3), make(chan struct{})
+	rc := newQueuedReceiver(QueueBlock, started, release)
+	fillReceiveQueue(t, rc, started)
+	frags, _, _ := makeMulticastPackets(t, "c", []byte("c"))
+	replied := make(chan []byte)
+	go func() {
+		reply, _ := rc.receiveFragment(frags[0], nil)
+		replied <- reply
+	}()
+	select {
+	case reply := <-replied:
+		t.Error("0xE58C1A", "replied without room:", string(reply))
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	if reply := <-replied; !bytes.HasPrefix(reply, []byte(tagConfirmation)) {
+		t.Error("0xEB7BEA", "wrong reply:", string(reply))
+	}
+	rc.stopQueue()
+	if len(started) != 2 || rc.Stats().QueueFullItems != 0 {
+		t.Error("0xEC6AEA", len(started), rc.Stats().QueueFullItems)
+	}
+}
+
+// QueueReject must discard the item and send a rejection
+func Test_ReceiveQueue_Receiver_2(t *testing.T) {
+	started, release := make(chan string, 3), make(chan struct{})
+	rc := newQueuedReceiver(QueueReject, started, release)
+	fillReceiveQueue(t, rc, started)
+	frags, _, _ := makeMulticastPackets(t, "c", []byte("c"))
+	reply, _ := rc.receiveFragment(frags[0], nil)
+	if !strings.HasPrefix(string(reply), tagRejection) ||
+		!strings.HasSuffix(string(reply), "receive queue full") {
+		t.Error("0xEEBB6A", "wrong reply:", string(reply))
+	}
+	close(release)
+	rc.stopQueue()
+	if len(rc.dataItems) != 0 || rc.Stats().QueueFullItems != 1 {
+		t.Error("0xE73336", len(rc.dataItems), rc.Stats().QueueFullItems)
+	}
+}
+
+// QueueDrop must not reply, but keep the item until it's resent
+// (when the Receiver doesn't advertise a window)
+func Test_ReceiveQueue_Receiver_3(t *testing.T) {
+	started, release := make(chan string, 3), make(chan struct{})
+	rc := newQueuedReceiver(QueueDrop, started, release)
+	rc.Config.ReceiveWindow = 0
+	fillReceiveQueue(t, rc, started)
+	frags, _, _ := makeMulticastPackets(t, "c", []byte("c"))
+	reply, err := rc.receiveFragment(frags[0], nil)
+	if reply != nil || err != nil || len(rc.dataItems) != 1 {
+		t.Error("0xE1D4E4", "wrong reply:", string(reply), err)
+	}
+	close(release)
+	time.Sleep(50 * time.Millisecond)
+	reply, _ = rc.receiveFragment(frags[0], nil)
+	if !bytes.HasPrefix(reply, []byte(tagConfirmation)) {
+		t.Error("0xE484E6", "wrong reply:", string(reply))
+	}
+	rc.stopQueue()
+	if len(started) != 2 || rc.Stats().QueueFullItems != 1 {
+		t.Error("0xEDCABC", len(started), rc.Stats().QueueFullItems)
+	}
+}
+
+// a slow handler must not delay confirmations to the Sender
+func Test_ReceiveQueue_Receiver_4(t *testing.T) {
+	nw := udptest.NewNetwork()
+	var handled int32
+	addrs, _, stop := runTestReceivers(nw, []string{"10.0.0.11"},
+		func(rc *Receiver) {
+			rc.Config.ReceiveWorkers = 2
+			rc.Config.ReceiveQueueSize = 10
+			rc.Receive = func(k string, v []byte) error {
+				time.Sleep(300 * time.Millisecond)
+				atomic.AddInt32(&handled, 1)
+				return nil
+			}
+		})
+	cf := NewDefaultConfig()
+	cf.Transport = nw.Host("10.0.0.1")
+	cf.ReplyTimeout = 200 * time.Millisecond
+	sd := Sender{Address: addrs[0], CryptoKey: []byte(testAESKey), Config: cf}
+	t0 := time.Now()
+	for _, k := range []string{"a", "b", "c"} {
+		if err := sd.SendString(k, k); err != nil {
+			t.Error("0xECD9FB", k, err)
+		}
+	}
+	if elapsed := time.Since(t0); elapsed > 300*time.Millisecond {
+		t.Error("0xEC1638", "sending took", elapsed)
+	}
+	stop()
+	for i := 0; i < 100 && atomic.LoadInt32(&handled) < 3; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := atomic.LoadInt32(&handled); got != 3 {
+		t.Error("0xEB92FB", "handled", got, "items")
+	}
+}
+
+// QueueDrop must reply with a zero window to a Sender that uses
+// flow control, and keep the item until it's resent
+func Test_ReceiveQueue_Receiver_5(t *testing.T) {
+	started, release := make(chan string, 3), make(chan struct{})
+	rc := newQueuedReceiver(QueueDrop, started, release)
+	fillReceiveQueue(t, rc, started)
+	frags, _, _ := makeMulticastPackets(t, "c", []byte("c"))
+	reply, err := rc.receiveFragment(frags[0], nil)
+	want := tagWindow + string(getHash(frags[0])) + "win:0"
+	if string(reply) != want || err != nil || len(rc.dataItems) != 1 {
+		t.Error("0xE7AE2D", "wrong reply:", string(reply), err)
+	}
+	close(release)
+	time.Sleep(50 * time.Millisecond)
+	reply, _ = rc.receiveFragment(frags[0], nil)
+	want = tagConfirmation + string(getHash(frags[0])) + "win:256"
+	if string(reply) != want {
+		t.Error("0xEE88BE", "wrong reply:", string(reply))
+	}
+	rc.stopQueue()
+}
+
+// QueueReject must reject a batch without queueing any of its items
+// when the queue doesn't have room for all, or never could have, and
+// must not block the Receiver while doing so
+func Test_ReceiveQueue_Receiver_6(t *testing.T) {
+	started, release := make(chan string, 8), make(chan struct{})
+	rc := newQueuedReceiver(QueueReject, started, release)
+	rc.Deliveries = NewMemoryDeliveryStore(10)
+	rc.stopQueue()
+	rc.Config.ReceiveQueueSize = 2
+	rc.startQueue()
+	fillReceiveQueue(t, rc, started) // leaves room for one item
+	sd := makeTestSender()
+	makeBatch := func(keys ...string) []byte {
+		var data []byte
+		for _, k := range keys {
+			data = append(data, makeBatchRecord(k, []byte(k))...)
+		}
+		it, err := sd.beginItem(&senderItem{key: batchKey, batch: true},
+			data)
+		if err != nil || len(it.packets) != 1 {
+			t.Fatal("0xE1525B", err)
+		}
+		return it.packets[0].data
+	}
+	reply, _ := rc.receiveFragment(makeBatch("x", "y"), nil)
+	if !strings.HasSuffix(string(reply), "receive queue full") {
+		t.Error("0xE24EE6", "wrong reply:", string(reply))
+	}
+	if rc.Stats().QueueFullItems != 1 || len(rc.dataItems) != 0 {
+		t.Error("0xE73A3A", rc.Stats().QueueFullItems, len(rc.dataItems))
+	}
+	close(release)
+	time.Sleep(50 * time.Millisecond) // the queue is empty again
+	//
+	// a batch larger than the queue is rejected at once, while empty
+	replied := make(chan []byte, 1)
+	go func() {
+		reply, _ := rc.receiveFragment(makeBatch("p", "q", "r"), nil)
+		replied <- reply
+	}()
+	select {
+	case reply = <-replied:
+		if !strings.HasSuffix(string(reply), "receive queue full") {
+			t.Error("0xE8F02A", "wrong reply:", string(reply))
+		}
+	case <-time.After(time.Second):
+		t.Fatal("0xEBF5AE", "blocked by a batch larger than the queue")
+	}
+	reply, _ = rc.receiveFragment(makeBatch("x", "y"), nil)
+	if !bytes.HasPrefix(reply, []byte(tagConfirmation)) {
+		t.Error("0xE6833F", "wrong reply:", string(reply))
+	}
+	rc.stopQueue()
+	close(started)
+	var keys []string
+	for k := range started {
+		keys = append(keys, k)
+	}
+	if fmt.Sprint(keys) != "[b x y]" {
+		t.Error("0xE7415B", "wrong items handled:", keys)
+	}
+}
+
+// a queued item whose handler fails must be counted, and stay delivered
+// (it's confirmed when queued, so it's delivered at most once)
+func Test_ReceiveQueue_Receiver_7(t *testing.T) {
+	rc := &Receiver{Config: NewDefaultConfig(),
+		Deliveries: NewMemoryDeliveryStore(10),
+		Handler: HandlerFunc(func(req *Request) error {
+			if req.Key == "panic" {
+				panic("failed")
+			}
+			return Reject("failed")
+		}),
+	}
+	rc.Config.LogWriter = &strings.Builder{}
+	rc.Config.ReceiveWorkers = 1
+	rc.Config.ReceiveQueueSize = 2
+	rc.startQueue()
+	var frags [][]byte
+	for _, k := range []string{"err", "panic"} {
+		pks, _, _ := makeMulticastPackets(t, k, []byte(k))
+		frags = append(frags, pks[0])
+		reply, _ := rc.receiveFragment(pks[0], nil)
+		if !bytes.HasPrefix(reply, []byte(tagConfirmation)) {
+			t.Error("0xE999AB", k, "wrong reply:", string(reply))
+		}
+	}
+	rc.stopQueue()
+	if got := rc.Stats().FailedQueuedItems; got != 2 {
+		t.Error("0xED84A9", "FailedQueuedItems:", got)
+	}
+	// a resent fragment is confirmed without handling the item again
+	reply, _ := rc.receiveFragment(frags[0], nil)
+	if !bytes.HasPrefix(reply, []byte(tagConfirmation)) ||
+		rc.Stats().FailedQueuedItems != 2 {
+		t.Error("0xEB8BC2", "wrong reply:", string(reply))
+	}
+}
+
+// end
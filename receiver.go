@@ -12,21 +12,73 @@ package udpt
 // ) error
 //
 // type Receiver struct
+// type ReceiverStats struct
 //
 // # Public Methods
 //   ) Run() error
+//   ) Stats() ReceiverStats
 //   ) Stop()
 //
 // # Run() Internals
 //   ) initRun() error
 //   ) initRunDI(
-//   ) buildReply(recv []byte) (reply []byte, err error)
+//   ) listenAddress() (string, error)
+//   ) listenTransport(address string) error
+//   ) setConnection(conn netUDPConn)
+//   ) connections() (conn, mconn netUDPConn)
+//   ) joinMulticastGroup() error
+//   ) receiveMulticast(mconn, conn netUDPConn)
+//   ) processPacket(conn netUDPConn, encReq []byte, addr net.Addr, . . .
+//   ) acceptPacket(addr net.Addr, size int, now time.Time) bool
+//   ) decryptPacket(encReq []byte) ([]byte, error)
+//   ) buildReply(recv []byte, addr net.Addr) (reply []byte, err error)
+//   ) buildReplyToAddress(recv []byte, addr net.Addr) (reply []byte, . . .
 //   ) sendReply(conn netUDPConn, addr net.Addr, reply []byte)
+//   ) replyCipher() SymmetricCipher
 //
 // # Packet Handlers
 //   type fragmentHeader struct
+//   ) itemID() string
+//   ) readItemHeader(recv []byte, tag string) (*fragmentHeader, error)
 //   ) readFragmentHeader(recv []byte) (*fragmentHeader, error)
-//   ) receiveFragment(recv []byte) ([]byte, error)
+//   ) receiveFragment(recv []byte, addr net.Addr) ([]byte, error)
+//   ) deliverBatch(recv []byte, id string, req *Request) ( . . .
+//   ) confirmFragment(h *fragmentHeader, hash []byte, size int) []byte
+//   ) refuseFragment(hash []byte, size int) []byte
+//   ) receiveWindow(size int) int
+//   ) handleItem(req *Request) (err error)
+//   ) receiveMulticastFragment(recv []byte, addr net.Addr) error
+//   ) receiveStatus(recv []byte) ([]byte, error)
+//   ) receiveQuery(recv []byte) ([]byte, error)
+//   ) receiveProbe(recv []byte) ([]byte, error)
+//   ) receivePing(recv []byte) ([]byte, error)
+//
+// # Receive Queue
+//   ) startQueue()
+//   ) stopQueue()
+//   ) queueItem(req *Request) bool
+//   ) queueItems(reqs []*Request) bool
+//   ) replyToFullQueue(recv []byte, id, k string) []byte
+//   ) handleQueuedItem(req *Request)
+//
+// # Data Item Management
+//   ) retainDataItem(h *fragmentHeader) (id string, it *dataItem, . . .
+//   ) checkMemoryLimits(it *dataItem, size int) string
+//   ) checkIndexMemory(h *fragmentHeader) string
+//   ) canWaitForMemory(h *fragmentHeader, it *dataItem, size int) bool
+//   ) discardDataItem(id string)
+//   ) releaseDataItem(id string, it *dataItem)
+//   ) discardStaleItems(now time.Time)
+//   ) rememberMulticastItem(id string, now time.Time)
+//   ) isDelivered(id string) bool
+//   ) markDelivered(id string)
+//   ) rejectItem(recv []byte, k, reason string) []byte
+//
+// # Checkpoints
+//   ) isResumable(count int) bool
+//   ) loadCheckpoint(id string, it *dataItem)
+//   ) saveCheckpoint(id string, it *dataItem, index int)
+//   ) closeCheckpoints()
 //
 // # Logging Methods
 //   ) logError(id uint32, a ...interface{}) error
@@ -36,13 +88,19 @@ import (
 	"bytes"
 	"context"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"net"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
+// maxMulticastDone is the maximum number of completed multicast
+// data items a Receiver remembers (see Receiver.multicastDone).
+const maxMulticastDone = 1024
+
 // Receive sets up and runs a Receiver.
 //
 // Once it starts running, this function will only
@@ -58,9 +116,10 @@ func Receive(
 	receive func(k string, v []byte) error,
 ) error {
 	ch := make(chan error, 1)
-	var rc Receiver
+	// set up the Receiver before Run() starts, since Stop() reads it
+	rc := &Receiver{Port: port, CryptoKey: cryptoKey, Receive: receive,
+		Config: NewDefaultConfig()}
 	go func() {
-		rc = Receiver{Port: port, CryptoKey: cryptoKey, Receive: receive}
 		err := rc.Run()
 		ch <- err
 	}()
@@ -80,8 +139,43 @@ type Receiver struct {
 
 	// Port is the port number of the listening server.
 	// This number must be between 1 and 65535.
+	//
+	// When only Port is specified, the Receiver listens
+	// on all local addresses, like Address ":<Port>".
+	//
 	Port int
 
+	// Address is the optional local address on which the Receiver
+	// listens, in "host:port" form. When specified, it overrides Port.
+	//
+	// Use a specific address to listen on a single interface, for
+	// example "127.0.0.1:9876" for loopback only, "[::1]:9876" for IPv6
+	// loopback, or "[fe80::1%eth0]:9876" for an IPv6 link-local address
+	// with a zone ID.
+	//
+	// A blank or unspecified host, like ":9876", "0.0.0.0:9876" or
+	// "[::]:9876", listens on all IPv4 and IPv6 addresses (dual-stack)
+	// if the operating system supports it.
+	//
+	Address string
+
+	// MulticastGroup is an optional multicast group address ("ip:port")
+	// which the Receiver joins, to receive data items that a
+	// MulticastSender sends to the group, in addition to the packets
+	// sent to Address or Port. For example "239.1.2.3:9877".
+	//
+	// Use a different port from the Receiver's own port, since the
+	// group's socket also receives unicast packets sent to its port.
+	// Replies to the MulticastSender are sent from Address or Port.
+	//
+	MulticastGroup string
+
+	// MulticastInterface is the name of the network interface on which
+	// to join MulticastGroup, for example "eth0". If you leave it blank,
+	// the system's default multicast interface is used. It is ignored
+	// when Config.Transport is specified.
+	MulticastInterface string
+
 	// CryptoKey is the secret symmetric encryption key that
 	// must be shared by the Sender and the Receiver.
 	//
@@ -90,6 +184,12 @@ type Receiver struct {
 	//
 	CryptoKey []byte
 
+	// ReplyCryptoKey is an optional secret key used to encrypt replies
+	// sent back to the Sender. If you leave it nil, replies are
+	// encrypted using CryptoKey. If you specify it, it must match
+	// the Sender's ReplyCryptoKey. See KeyParams.DeriveKeys().
+	ReplyCryptoKey []byte
+
 	// Config contains UDP and other configuration settings.
 	// These settings normally don't need to be changed.
 	Config *Configuration
@@ -103,19 +203,123 @@ type Receiver struct {
 	// The reason there are two parameters is to separate metadata like
 	// timestamps or filenames from the content of the transferred resource.
 	//
+	// Return Reject(reason) to refuse the item and send the reason back
+	// to the Sender. To route items to different functions by their
+	// key, assign the Receive method of a ReceiveMux.
+	//
 	Receive func(k string, v []byte) error
 
+	// Handler is an alternative to Receive, which also receives details
+	// such as the Sender's address, and can be wrapped with middleware
+	// (see Chain). When Handler is specified, Receive is not called.
+	//
+	// A panic in Receive or Handler is recovered and treated like
+	// a returned error, so it doesn't stop Run() or crash a worker.
+	//
+	Handler Handler
+
+	// Deliveries remembers the message IDs of delivered data items, so
+	// when a Sender resends an item that was already delivered (because
+	// the confirmation of its last fragment was lost) it's confirmed
+	// without calling Receive again. If you leave it nil, Run() assigns
+	// a MemoryDeliveryStore that remembers the last 10,000 IDs. Use a
+	// FileDeliveryStore to remember them after a restart.
+	Deliveries DeliveryStore
+
+	// Abandoned is an optional callback function. This Receiver will call
+	// it when it discards a partially received data item, because no more
+	// fragments of the item arrived within Config.PartialItemTimeout.
+	//
+	// 'k' and 'hash' identify the abandoned item. 'received' is
+	// the number of fragments that arrived, out of 'count'.
+	//
+	Abandoned func(k string, hash []byte, received, count int)
+
 	// -------------------------------------------------------------------------
 
 	// conn is the UDP connection on which Receiver listens;
 	// setting this to nil allows Run() to stop listening
 	conn netUDPConn
 
-	// receivingDataItem contains the data item
-	// currently being received from the Sender.
-	receivingDataItem dataItem
+	// mconn is the connection on which the Receiver receives packets
+	// sent to MulticastGroup, or nil if no group is specified
+	mconn netUDPConn
+
+	// connMutex guards conn and mconn, since Stop() can be
+	// called from another goroutine while Run() is using them
+	connMutex sync.Mutex
+
+	// processMutex serializes the processing of packets, since packets
+	// sent to MulticastGroup are received in a separate goroutine
+	processMutex sync.Mutex
+
+	// dataItems contains the data items currently being
+	// received from Senders, mapped by their item ID.
+	dataItems map[string]*dataItem
+
+	// reassemblyMemory is the total memory, in bytes, held by all
+	// the data items in dataItems (see dataItem.MemorySize).
+	reassemblyMemory int
+
+	// lastSweep is the last time discardStaleItems()
+	// checked for abandoned partial data items.
+	lastSweep time.Time
+
+	// multicastDone contains the IDs of data items completely received
+	// from a MulticastSender, mapped to the time they were completed,
+	// so repeated status requests are answered without receiving
+	// the item again. Items are forgotten after PartialItemTimeout.
+	multicastDone map[string]time.Time
+
+	// queue holds fully received items for Config.ReceiveWorkers
+	// to handle, or is nil if ReceiveWorkers is zero
+	queue *receiveQueue
+
+	// checkpoints saves the fragments of resumable items to files
+	// in Config.CheckpointDir, if the directory is specified
+	checkpoints checkpointStore
+
+	// validator issues and checks cookies used to validate
+	// Sender addresses when Config.ValidateAddresses is true
+	validator addressValidator
+
+	// filter drops packets from denied networks and sources that exceed
+	// their rate limits, as specified in Config's Source Filtering section
+	filter packetFilter
+
+	// stats counts the packets dropped by this Receiver;
+	// statsMutex guards it, since Stats() can be called any time
+	stats      ReceiverStats
+	statsMutex sync.Mutex
 } //                                                                    Receiver
 
+// ReceiverStats contains counters of the packets dropped by a Receiver,
+// which help to notice abuse. The counters are cumulative from the time
+// the Receiver was created. Use Receiver.Stats() to read them.
+type ReceiverStats struct {
+
+	// DeniedPackets is the number of packets dropped because their
+	// source is not in Config.AllowedNetworks, or is in DeniedNetworks.
+	DeniedPackets int64
+
+	// RateLimitedPackets is the number of packets dropped because their
+	// source exceeded Config.SourcePacketRate or Config.SourceByteRate.
+	RateLimitedPackets int64
+
+	// UndecryptablePackets is the number of packets
+	// dropped because they could not be decrypted.
+	UndecryptablePackets int64
+
+	// QueueFullItems is the number of times a fully received item was
+	// rejected or dropped because the receive queue was full.
+	QueueFullItems int64
+
+	// FailedQueuedItems is the number of queued items whose handler
+	// returned an error or panicked. These items are lost, since they
+	// were confirmed to the Sender when they were queued.
+	FailedQueuedItems int64
+} //                                                               ReceiverStats
+
 // -----------------------------------------------------------------------------
 // # Public Methods
 
@@ -133,49 +337,82 @@ func (rc *Receiver) Run() error {
 	if err != nil {
 		return err
 	}
+	err = rc.joinMulticastGroup()
+	if err != nil {
+		return err
+	}
+	if rc.Config.ReceiveWorkers > 0 {
+		rc.startQueue()
+		defer rc.stopQueue()
+	}
+	if rc.checkpoints.IsEnabled() {
+		defer rc.closeCheckpoints()
+	}
+	if conn, mconn := rc.connections(); mconn != nil {
+		go rc.receiveMulticast(mconn, conn)
+	}
 	// receive transmissions
 	encReq := make([]byte, rc.Config.PacketSizeLimit)
-	for rc.conn != nil {
-		// 'encReq' is overwritten after every readAndDecrypt
-		recv, addr, err := readAndDecrypt(rc.conn, rc.Config.ReplyTimeout,
-			rc.Config.Cipher, encReq)
-		if err == errClosed {
+	for {
+		conn, _ := rc.connections()
+		if conn == nil {
 			break
 		}
-		if err != nil {
-			_ = rc.logError(0xEA288A, err)
-			continue
+		rc.processMutex.Lock()
+		rc.discardStaleItems(time.Now())
+		rc.filter.ForgetIdleSources(time.Now())
+		rc.checkpoints.RemoveExpired(time.Now())
+		if rc.Config.ValidateAddresses {
+			rc.validator.ForgetExpired(time.Now())
 		}
-		if rc.Config.VerboseReceiver {
-			rc.logInfo()
-			rc.logInfo(strings.Repeat("-", 80))
-			rc.logInfo("Receiver read", len(recv), "bytes from", addr)
-		}
-		reply, err := rc.buildReply(recv)
-		if len(reply) == 0 || err != nil {
-			continue
+		rc.processMutex.Unlock()
+		//
+		// 'encReq' is overwritten after every readPacket
+		n, addr, err := readPacket(conn, rc.Config.ReplyTimeout, encReq)
+		if err == errClosed {
+			break
 		}
-		encReply, err := rc.Config.Cipher.Encrypt(reply)
 		if err != nil {
-			_ = rc.logError(0xE5C3E8, err)
+			_ = rc.logError(0xEA288A, err)
 			continue
 		}
-		rc.sendReply(rc.conn, addr, encReply)
+		rc.processPacket(conn, encReq[:n], addr, false)
 	}
 	return nil
 } //                                                                         Run
 
+// Stats returns a copy of the counters of packets dropped by this
+// Receiver. It is safe to call Stats() while the Receiver is running.
+func (rc *Receiver) Stats() ReceiverStats {
+	rc.statsMutex.Lock()
+	defer rc.statsMutex.Unlock()
+	return rc.stats
+} //                                                                       Stats
+
 // Stop stops the Receiver from listening and
 // receiving data by closing its connection.
+//
+// It is safe to call Stop() from another goroutine while Run() is running.
+//
 func (rc *Receiver) Stop() {
-	if rc.conn == nil {
+	rc.connMutex.Lock()
+	conn, mconn := rc.conn, rc.mconn
+	rc.conn, rc.mconn = nil, nil
+	rc.connMutex.Unlock()
+	//
+	if mconn != nil {
+		err := mconn.Close()
+		if err != nil {
+			_ = rc.logError(0xE0E064, err)
+		}
+	}
+	if conn == nil {
 		return
 	}
-	err := rc.conn.Close()
+	err := conn.Close()
 	if err != nil {
 		_ = rc.logError(0xE9C2D1, err)
 	}
-	rc.conn = nil
 } //                                                                        Stop
 
 // -----------------------------------------------------------------------------
@@ -197,50 +434,321 @@ func (rc *Receiver) initRunDI(
 	if err != nil {
 		return rc.logError(0xE14BC8, err)
 	}
-	if rc.Port < 1 || rc.Port > 65535 {
-		return rc.logError(0xE58B2F, "invalid Receiver.Port:", rc.Port)
+	address, err := rc.listenAddress()
+	if err != nil {
+		return rc.logError(0xE58B2F, err)
 	}
 	err = rc.Config.Cipher.SetKey(rc.CryptoKey)
 	if err != nil {
 		return rc.logError(0xE8A5C6, "invalid Receiver.CryptoKey:", err)
 	}
-	if rc.Receive == nil {
-		return rc.logError(0xE82C9E, "nil Receiver.Receive")
+	if len(rc.ReplyCryptoKey) > 0 {
+		if rc.Config.ReplyCipher == nil {
+			return rc.logError(0xEA7225, "nil Receiver.Config.ReplyCipher")
+		}
+		err = rc.Config.ReplyCipher.SetKey(rc.ReplyCryptoKey)
+		if err != nil {
+			return rc.logError(0xE0B3F2,
+				"invalid Receiver.ReplyCryptoKey:", err)
+		}
 	}
-	udpAddr, err := netResolveUDPAddr("udp",
-		fmt.Sprintf("0.0.0.0:%d", rc.Port))
+	if rc.Receive == nil && rc.Handler == nil {
+		return rc.logError(0xE82C9E, "nil Receiver.Receive and Handler")
+	}
+	if rc.Deliveries == nil {
+		rc.Deliveries = NewMemoryDeliveryStore(defaultDeliveryHistory)
+	}
+	if rc.Config.CheckpointDir != "" {
+		err = rc.checkpoints.Init(rc.Config.CheckpointDir,
+			rc.Config.CheckpointLifetime)
+		if err != nil {
+			return rc.logError(0xE7F7E5, err)
+		}
+	}
+	err = rc.filter.Init(rc.Config)
 	if err != nil {
-		return rc.logError(0xE1D68C, err)
+		return rc.logError(0xE9ACB7, err)
+	}
+	if rc.Config.ValidateAddresses {
+		err = rc.validator.Init(rc.Config.CookieLifetime)
+		if err != nil {
+			return rc.logError(0xE1F103, err)
+		}
+	}
+	var udpAddr *net.UDPAddr
+	if rc.Config.Transport == nil {
+		udpAddr, err = netResolveUDPAddr("udp", address)
+		if err != nil {
+			return rc.logError(0xE1D68C, err)
+		}
 	}
 	if rc.Config.VerboseReceiver {
 		rc.logInfo(strings.Repeat("-", 80))
 		rc.logInfo("Receiver listening...")
 	}
-	rc.conn, err = netListenUDP("udp", udpAddr)
+	if rc.Config.Transport != nil {
+		return rc.listenTransport(address)
+	}
+	conn, err := netListenUDP("udp", udpAddr)
 	if err != nil {
-		rc.conn = nil // avoid non-nil interface with nil concrete value
 		return rc.logError(0xEBF95F, err)
 	}
+	rc.setConnection(conn)
 	return nil
 } //                                                                   initRunDI
 
-// buildReply builds a reply to the received data. Presently, the only packet
-// type received is a fragment (FRAG), replied with confirmation (CONF) packet.
-func (rc *Receiver) buildReply(recv []byte) (reply []byte, err error) {
+// listenAddress returns the local address on which the Receiver listens:
+// Address if specified, or all IPv4 and IPv6 addresses on Port otherwise.
+func (rc *Receiver) listenAddress() (string, error) {
+	if rc.Address != "" {
+		if addressPort(rc.Address) == 0 {
+			return "", makeError(0xECA2C0,
+				"invalid Receiver.Address:", rc.Address)
+		}
+		return rc.Address, nil
+	}
+	if rc.Port < 1 || rc.Port > 65535 {
+		return "", makeError(0xE46D7E, "invalid Receiver.Port:", rc.Port)
+	}
+	return fmt.Sprintf("0.0.0.0:%d", rc.Port), nil
+} //                                                               listenAddress
+
+// listenTransport starts listening on the local 'address' using
+// the connection returned by Config.Transport.ListenPacket().
+func (rc *Receiver) listenTransport(address string) error {
+	conn, err := rc.Config.Transport.ListenPacket(address)
+	if err != nil {
+		return rc.logError(0xE5B4AD, err)
+	}
+	if conn == nil {
+		return rc.logError(0xE860E0, "nil connection from Transport")
+	}
+	rc.setConnection(newPacketConnAdapter(conn, nil))
+	return nil
+} //                                                             listenTransport
+
+// setConnection sets the connection on which the Receiver listens.
+func (rc *Receiver) setConnection(conn netUDPConn) {
+	rc.connMutex.Lock()
+	rc.conn = conn
+	rc.connMutex.Unlock()
+} //                                                               setConnection
+
+// connections returns the connection on which the Receiver listens and
+// the multicast connection. Both are nil after Stop() has been called.
+func (rc *Receiver) connections() (conn, mconn netUDPConn) {
+	rc.connMutex.Lock()
+	defer rc.connMutex.Unlock()
+	return rc.conn, rc.mconn
+} //                                                                 connections
+
+// joinMulticastGroup starts listening for packets sent to MulticastGroup,
+// if it's specified. When Config.Transport is specified, it must
+// implement MulticastTransport.
+func (rc *Receiver) joinMulticastGroup() error {
+	if rc.MulticastGroup == "" {
+		return nil
+	}
+	var (
+		conn net.PacketConn
+		err  error
+	)
+	if rc.Config.Transport == nil {
+		conn, err = listenMulticastUDP(rc.MulticastGroup, rc.MulticastInterface)
+	} else if tr, ok := rc.Config.Transport.(MulticastTransport); ok {
+		conn, err = tr.ListenMulticast(rc.MulticastGroup)
+	} else {
+		return rc.logError(0xE8D2A1, "Config.Transport doesn't support",
+			"multicast (see MulticastTransport)")
+	}
+	if err != nil {
+		return rc.logError(0xEFDB10, "invalid Receiver.MulticastGroup:", err)
+	}
+	if conn == nil {
+		return rc.logError(0xEB29C3, "nil connection from Transport")
+	}
+	rc.connMutex.Lock()
+	rc.mconn = newPacketConnAdapter(conn, nil)
+	rc.connMutex.Unlock()
+	if rc.Config.VerboseReceiver {
+		rc.logInfo("Receiver joined", rc.MulticastGroup)
+	}
+	return nil
+} //                                                          joinMulticastGroup
+
+// receiveMulticast processes packets sent to MulticastGroup until
+// connection 'mconn' is closed. Run() starts it in a goroutine.
+// Replies are sent through the Receiver's own connection 'conn'.
+func (rc *Receiver) receiveMulticast(mconn, conn netUDPConn) {
+	encReq := make([]byte, rc.Config.PacketSizeLimit)
+	for {
+		// 'encReq' is overwritten after every readPacket
+		n, addr, err := readPacket(mconn, rc.Config.ReplyTimeout, encReq)
+		if err == errClosed {
+			break
+		}
+		if err == errTimeout {
+			continue
+		}
+		if err != nil {
+			_ = rc.logError(0xEA3FA8, err)
+			continue
+		}
+		rc.processPacket(conn, encReq[:n], addr, true)
+	}
+} //                                                            receiveMulticast
+
+// processPacket filters, decrypts and handles packet 'encReq' received
+// from 'addr', then sends the reply (if any) through connection 'conn'.
+//
+// 'multicast' is true if the packet was sent to MulticastGroup.
+// Fragments sent to the group are not confirmed individually.
+//
+func (rc *Receiver) processPacket(
+	conn netUDPConn,
+	encReq []byte,
+	addr net.Addr,
+	multicast bool,
+) {
+	rc.processMutex.Lock()
+	defer rc.processMutex.Unlock()
+	if !rc.acceptPacket(addr, len(encReq), time.Now()) {
+		return
+	}
+	recv, err := rc.decryptPacket(encReq)
+	if err != nil {
+		return
+	}
+	if rc.Config.VerboseReceiver {
+		rc.logInfo()
+		rc.logInfo(strings.Repeat("-", 80))
+		rc.logInfo("Receiver read", len(recv), "bytes from", addr)
+	}
+	if multicast && bytes.HasPrefix(recv, []byte(tagFragment)) {
+		_ = rc.receiveMulticastFragment(recv, addr)
+		return
+	}
+	reply, err := rc.buildReplyToAddress(recv, addr)
+	if len(reply) == 0 || err != nil {
+		return
+	}
+	encReply, err := rc.replyCipher().Encrypt(reply)
+	if err != nil {
+		_ = rc.logError(0xE5C3E8, err)
+		return
+	}
+	rc.sendReply(conn, addr, encReply)
+} //                                                               processPacket
+
+// acceptPacket returns true if a packet of 'size' bytes received from
+// 'addr' at time 'now' passes the source filter, so it can be decrypted.
+// Otherwise counts the dropped packet in the Receiver's stats.
+func (rc *Receiver) acceptPacket(addr net.Addr, size int, now time.Time) bool {
+	var counter *int64
+	switch {
+	case !rc.filter.IsAllowed(addr):
+		counter = &rc.stats.DeniedPackets
+	case !rc.filter.TakeRate(addr, size, now):
+		counter = &rc.stats.RateLimitedPackets
+	default:
+		return true
+	}
+	rc.statsMutex.Lock()
+	*counter++
+	rc.statsMutex.Unlock()
+	if rc.Config.VerboseReceiver {
+		rc.logInfo("Receiver dropped", size, "bytes from", addr)
+	}
+	return false
+} //                                                                acceptPacket
+
+// decryptPacket decrypts the packet 'encReq' using Config.Cipher.
+// If the packet can't be decrypted, counts it in the Receiver's stats.
+func (rc *Receiver) decryptPacket(encReq []byte) ([]byte, error) {
+	recv, err := rc.Config.Cipher.Decrypt(encReq)
+	if err != nil {
+		rc.statsMutex.Lock()
+		rc.stats.UndecryptablePackets++
+		rc.statsMutex.Unlock()
+		return nil, rc.logError(0xEAD9A0, err)
+	}
+	return recv, nil
+} //                                                               decryptPacket
+
+// buildReply builds a reply to data received from 'addr'. A fragment (FRAG) is
+// replied with a confirmation (CONF) packet, a status request (STAT)
+// from a MulticastSender with a DONE or NACK packet, and a query (QURY)
+// with a HAVE packet. Packets with an unknown tag are only logged.
+func (rc *Receiver) buildReply(recv []byte, addr net.Addr) (
+	reply []byte, err error,
+) {
 	switch {
 	case len(recv) == 0:
 		_ = rc.logError(0xE6B3BA, "received no data")
 		//
 	case bytes.HasPrefix(recv, []byte(tagFragment)):
-		reply, err = rc.receiveFragment(recv)
+		reply, err = rc.receiveFragment(recv, addr)
+		//
+	case bytes.HasPrefix(recv, []byte(tagStatus)):
+		reply, err = rc.receiveStatus(recv)
+		//
+	case bytes.HasPrefix(recv, []byte(tagQuery)):
+		reply, err = rc.receiveQuery(recv)
+		//
+	case bytes.HasPrefix(recv, []byte(tagProbe)):
+		reply, err = rc.receiveProbe(recv)
+		//
+	case bytes.HasPrefix(recv, []byte(tagPing)):
+		reply, err = rc.receivePing(recv)
 		//
 	default:
-		reply = []byte("invalid_packet_header")
+		// don't reply to unknown packets, since their
+		// source address may be spoofed: only log them
 		err = rc.logError(0xE985CC, "invalid packet header")
 	}
 	return reply, err
 } //                                                                  buildReply
 
+// buildReplyToAddress builds a reply to data received from 'addr'.
+//
+// When Config.ValidateAddresses is true, it only builds the reply with
+// buildReply() if the Sender's address has been validated. Otherwise, it
+// replies to fragments, status requests, queries, probes and pings with
+// a cookie, provided the cookie isn't larger than the received data, and
+// doesn't reply to anything else.
+//
+func (rc *Receiver) buildReplyToAddress(recv []byte, addr net.Addr) (
+	reply []byte, err error,
+) {
+	if !rc.Config.ValidateAddresses {
+		if bytes.HasPrefix(recv, []byte(tagCookie)) &&
+			len(recv) >= len(tagCookie)+cookieSize {
+			recv = recv[len(tagCookie)+cookieSize:]
+		}
+		return rc.buildReply(recv, addr)
+	}
+	size := len(recv)
+	recv, isValid := rc.validator.Validate(recv, addr, time.Now())
+	if isValid {
+		return rc.buildReply(recv, addr)
+	}
+	if !bytes.HasPrefix(recv, []byte(tagFragment)) &&
+		!bytes.HasPrefix(recv, []byte(tagStatus)) &&
+		!bytes.HasPrefix(recv, []byte(tagQuery)) &&
+		!bytes.HasPrefix(recv, []byte(tagProbe)) &&
+		!bytes.HasPrefix(recv, []byte(tagPing)) {
+		return nil, nil
+	}
+	reply = rc.validator.MakeCookie(addr, time.Now())
+	if len(reply) > size {
+		return nil, nil
+	}
+	if rc.Config.VerboseReceiver {
+		rc.logInfo("Receiver sent cookie to unvalidated", addr)
+	}
+	return reply, nil
+} //                                                         buildReplyToAddress
+
 // sendReply sends 'reply' to the specified connection
 func (rc *Receiver) sendReply(conn netUDPConn, addr net.Addr, reply []byte) {
 	deadline := time.Now().Add(rc.Config.WriteTimeout)
@@ -259,6 +767,15 @@ func (rc *Receiver) sendReply(conn netUDPConn, addr net.Addr, reply []byte) {
 	}
 } //                                                                   sendReply
 
+// replyCipher returns the cipher used to encrypt replies to the Sender:
+// Config.ReplyCipher if ReplyCryptoKey is specified, or Config.Cipher.
+func (rc *Receiver) replyCipher() SymmetricCipher {
+	if len(rc.ReplyCryptoKey) > 0 {
+		return rc.Config.ReplyCipher
+	}
+	return rc.Config.Cipher
+} //                                                                 replyCipher
+
 // -----------------------------------------------------------------------------
 // # Packet Handlers
 
@@ -266,14 +783,26 @@ func (rc *Receiver) sendReply(conn netUDPConn, addr net.Addr, reply []byte) {
 type fragmentHeader struct {
 	dataOffset  int    // position of compressed data (part of the value)
 	key         string // key 'k' of the key-value message
+	messageID   string // unique ID of the message, if the Sender sent it
+	batch       bool   // set if the item is a batch of small items
+	flow        bool   // set if the Sender uses the Receiver's window
 	hash        []byte // hash of entire key-value message
 	index       int    // 0-based index of this fragment
 	packetCount int    // total number of fragments (i.e. packets) in message
 }
 
-// readFragmentHeader reads the header from a received fragment packet
-func (rc *Receiver) readFragmentHeader(recv []byte) (*fragmentHeader, error) {
-	if !bytes.HasPrefix(recv, []byte(tagFragment)) {
+// itemID returns the ID of the data item to which the header belongs
+func (h *fragmentHeader) itemID() string {
+	return fmt.Sprintf("%X %s", h.hash, h.key)
+} //                                                                      itemID
+
+// readItemHeader reads the key, message ID, hash, batch and flow flags and
+// fragment count from the header of a received packet which starts with
+// 'tag' (tagFragment, tagStatus or tagQuery)
+func (rc *Receiver) readItemHeader(recv []byte, tag string) (
+	*fragmentHeader, error,
+) {
+	if !bytes.HasPrefix(recv, []byte(tag)) {
 		return nil, rc.logError(0xE4F3C5, "missing header")
 	}
 	var h fragmentHeader
@@ -283,8 +812,11 @@ func (rc *Receiver) readFragmentHeader(recv []byte) (*fragmentHeader, error) {
 	}
 	h.dataOffset++ // skip newline
 	//
-	s := string(recv[len(tagFragment):h.dataOffset])
+	s := string(recv[len(tag):h.dataOffset])
 	h.key = getPart(s, "key:", " ")
+	h.messageID = getPart(s, " id:", " ")
+	h.batch = getPart(s, " batch:", " ") == "1"
+	h.flow = getPart(s, " flow:", " ") == "1"
 	//
 	var err error
 	h.hash, err = hex.DecodeString(getPart(s, "hash:", " "))
@@ -295,58 +827,765 @@ func (rc *Receiver) readFragmentHeader(recv []byte) (*fragmentHeader, error) {
 	if h.packetCount < 1 {
 		return nil, rc.logError(0xE18A95, "bad 'count'")
 	}
+	return &h, nil
+} //                                                              readItemHeader
+
+// readFragmentHeader reads the header from a received fragment packet
+func (rc *Receiver) readFragmentHeader(recv []byte) (*fragmentHeader, error) {
+	h, err := rc.readItemHeader(recv, tagFragment)
+	if err != nil {
+		return nil, err
+	}
+	s := string(recv[len(tagFragment):h.dataOffset])
 	h.index, _ = strconv.Atoi(getPart(s, "sn:", " "))
 	if h.index < 1 || h.index > h.packetCount {
 		return nil, rc.logError(0xEF27F8, "bad 'sn'")
 	}
 	h.index--
-	return &h, nil
+	return h, nil
 } //                                                          readFragmentHeader
 
 // receiveFragment handles a tagFragment packet sent by a Sender, and
 // sends back a confirmation packet (tagConfirmation) to the Sender.
-func (rc *Receiver) receiveFragment(recv []byte) ([]byte, error) {
+//
+// If the data item exceeds one of the limits in Config, the item is
+// discarded and a rejection packet (tagRejection) is sent back instead.
+// But if the Sender uses flow control and the item only has to wait for
+// other items to free reassembly memory, the fragment is not stored, and
+// a tagWindow packet is sent back, so the Sender waits for room.
+//
+func (rc *Receiver) receiveFragment(recv []byte, addr net.Addr) (
+	[]byte, error,
+) {
 	h, err := rc.readFragmentHeader(recv)
 	if err != nil {
 		return nil, err
 	}
-	it := &rc.receivingDataItem
-	it.Retain(h.key, h.hash, h.packetCount)
 	compressedData := recv[h.dataOffset:]
 	if len(compressedData) < 1 {
 		return nil, rc.logError(0xE92B0F, "received no data")
 	}
+	confirmedHash := getHash(recv)
+	if rc.isDelivered(h.messageID) {
+		// a resent fragment of a delivered item: confirm it again
+		return rc.confirmFragment(h, confirmedHash, len(compressedData)), nil
+	}
+	if _, done := rc.multicastDone[h.itemID()]; done {
+		// a late repair of a multicast item: confirm without storing
+		return rc.confirmFragment(h, confirmedHash, len(compressedData)), nil
+	}
+	if rc.checkIndexMemory(h) != "" &&
+		rc.canWaitForMemory(h, nil, len(compressedData)) {
+		return rc.refuseFragment(confirmedHash, len(compressedData)), nil
+	}
+	id, it, reason := rc.retainDataItem(h)
+	if reason != "" {
+		return rc.rejectItem(recv, h.key, reason), nil
+	}
+	it.MessageID = h.messageID
+	it.Batch = h.batch
+	it.Flow = h.flow
+	it.LastActivity = time.Now()
+	if it.FirstActivity.IsZero() {
+		it.FirstActivity = it.LastActivity
+	}
+	//
 	// store the current piece
 	if len(it.CompressedPieces[h.index]) == 0 {
+		reason = rc.checkMemoryLimits(it, len(compressedData))
+		if reason != "" && rc.canWaitForMemory(h, it, len(compressedData)) {
+			return rc.refuseFragment(confirmedHash, len(compressedData)), nil
+		}
+		if reason != "" {
+			rc.discardDataItem(id)
+			return rc.rejectItem(recv, h.key, reason), nil
+		}
 		it.CompressedPieces[h.index] = compressedData
-	} else if !bytes.Equal(compressedData, it.CompressedPieces[h.index]) {
+		it.ReceivedSize += len(compressedData)
+		rc.reassemblyMemory += len(compressedData)
+		rc.saveCheckpoint(id, it, h.index)
+	} else if bytes.Equal(compressedData, it.CompressedPieces[h.index]) {
+		it.DuplicateCount++
+	} else {
 		return nil, rc.logError(0xE1A99A, "unknown packet alteration")
 	}
 	if it.IsLoaded() {
-		if rc.Receive == nil {
+		if rc.Receive == nil && rc.Handler == nil {
 			return nil, rc.logError(0xE49E2A, "nil Receiver.Receive")
 		}
-		data, err := it.UnpackBytes(rc.Config.Compressor)
+		limit := rc.Config.MaxUncompressedSize
+		data, err := it.UnpackBytes(rc.Config.Compressor, limit)
+		if err == errUncompressedSize {
+			rc.discardDataItem(id)
+			return rc.rejectItem(recv, it.Key, fmt.Sprintf(
+				"uncompressed size exceeds limit %d", limit)), nil
+		}
 		if err != nil {
 			return nil, rc.logError(0xE3DB1D, err)
 		}
-		err = rc.Receive(it.Key, data)
-		if err != nil {
-			return nil, rc.logError(0xE77B4D, err)
+		req := &Request{
+			Key:                it.Key,
+			Value:              data,
+			MessageID:          it.MessageID,
+			Addr:               addr,
+			Hash:               it.Hash,
+			CompressedSize:     it.CompressedSizeInfo,
+			UncompressedSize:   it.UncompressedSizeInfo,
+			FragmentCount:      len(it.CompressedPieces),
+			DuplicateFragments: it.DuplicateCount,
+			FirstFragmentTime:  it.FirstActivity,
+			ReceivedTime:       it.LastActivity,
 		}
+		if it.Batch {
+			delivered, reply, err := rc.deliverBatch(recv, id, req)
+			if !delivered {
+				return reply, err
+			}
+		} else if rc.queue != nil {
+			if !rc.queueItem(req) {
+				return rc.replyToFullQueue(recv, id, req.Key), nil
+			}
+		} else {
+			err = rc.handleItem(req)
+			var rejection *RejectionError
+			if errors.As(err, &rejection) {
+				rc.discardDataItem(id)
+				return rc.rejectItem(recv, it.Key, rejection.Reason), nil
+			}
+			if err != nil {
+				return nil, rc.logError(0xE77B4D, err)
+			}
+		}
+		rc.markDelivered(it.MessageID)
 		rc.logInfo("received:", it.Key)
 		if rc.Config.VerboseReceiver {
 			var sb strings.Builder
 			it.LogStats("receiveFragment", &sb)
 			rc.logInfo(sb.String())
 		}
-		it.Reset()
+		if it.Multicast {
+			rc.rememberMulticastItem(id, time.Now())
+		}
+		rc.discardDataItem(id)
 	}
-	confirmedHash := getHash(recv)
-	reply := append([]byte(tagConfirmation), confirmedHash...)
-	return reply, nil
+	return rc.confirmFragment(h, confirmedHash, len(compressedData)), nil
 } //                                                             receiveFragment
 
+// deliverBatch passes each item in batch 'req', which completed data item
+// 'id' with fragment 'recv', to the Handler (or the receive queue) as a
+// separate Request. The batch's message ID and number of each item make
+// its message ID, so items already delivered are skipped when the
+// Sender resends the batch.
+//
+// If an item can't be delivered, returns false with the reply to 'recv',
+// so the Sender resends the batch. A rejection of an item by the Handler
+// is only logged, since the other items in the batch are delivered.
+// With a receive queue, the items are queued together, only when there
+// is room for all of them (see queueItems).
+//
+func (rc *Receiver) deliverBatch(recv []byte, id string, req *Request) (
+	delivered bool, reply []byte, err error,
+) {
+	messages, err := decodeBatch(req.Value)
+	if err != nil {
+		rc.discardDataItem(id)
+		return false, rc.rejectItem(recv, req.Key, err.Error()), nil
+	}
+	var queued []*Request
+	for i, msg := range messages {
+		item := *req
+		item.Key = msg.key
+		item.Value = msg.value
+		item.MessageID = batchMessageID(req.MessageID, i+1)
+		item.Hash = getHash(msg.value)
+		item.UncompressedSize = len(msg.value)
+		if rc.isDelivered(item.MessageID) {
+			continue
+		}
+		if rc.queue != nil {
+			queued = append(queued, &item)
+			continue
+		}
+		err = rc.handleItem(&item)
+		var rejection *RejectionError
+		if errors.As(err, &rejection) {
+			_ = rc.logError(0xE66B0F, "rejected batched item:",
+				item.Key, rejection.Reason)
+		} else if err != nil {
+			return false, nil, rc.logError(0xE80014, err)
+		}
+		rc.markDelivered(item.MessageID)
+	}
+	if len(queued) > 0 {
+		if !rc.queueItems(queued) {
+			return false, rc.replyToFullQueue(recv, id, req.Key), nil
+		}
+		for _, item := range queued {
+			rc.markDelivered(item.MessageID)
+		}
+	}
+	return true, nil, nil
+} //                                                                deliverBatch
+
+// confirmFragment returns the confirmation of the fragment with header
+// 'h', hash 'hash' and 'size' bytes of data. If the Sender uses flow
+// control, the hash is followed by the Receiver's window.
+func (rc *Receiver) confirmFragment(h *fragmentHeader, hash []byte, size int,
+) []byte {
+	reply := append([]byte(tagConfirmation), hash...)
+	if h.flow && rc.Config.ReceiveWindow > 0 {
+		reply = append(reply, "win:"+strconv.Itoa(rc.receiveWindow(size))...)
+	}
+	return reply
+} //                                                             confirmFragment
+
+// refuseFragment returns the tagWindow reply to the fragment with hash
+// 'hash' and 'size' bytes of data, which was not stored for lack of room.
+func (rc *Receiver) refuseFragment(hash []byte, size int) []byte {
+	reply := append([]byte(tagWindow), hash...)
+	return append(reply, "win:"+strconv.Itoa(rc.receiveWindow(size))...)
+} //                                                              refuseFragment
+
+// receiveWindow returns the number of fragments of 'size' bytes which
+// the Receiver lets a Sender have in flight for each data item: zero
+// if the receive queue is full, otherwise Config.ReceiveWindow, or
+// fewer if the rest of Config.MaxReassemblyMemory can't hold them.
+func (rc *Receiver) receiveWindow(size int) int {
+	if rc.queue != nil && rc.queue.Full() {
+		return 0
+	}
+	cf := rc.Config
+	ret := cf.ReceiveWindow
+	if cf.MaxReassemblyMemory > 0 && size > 0 {
+		room := (cf.MaxReassemblyMemory - rc.reassemblyMemory) / size
+		if room < ret {
+			ret = room
+		}
+	}
+	if ret < 0 {
+		ret = 0
+	}
+	return ret
+} //                                                               receiveWindow
+
+// handleItem passes a fully received data item to Handler,
+// or if Handler is not specified, to the Receive callback.
+// A panic in either is recovered and returned as an error.
+func (rc *Receiver) handleItem(req *Request) (err error) {
+	defer func() {
+		r := recover()
+		if r != nil {
+			err = makeError(0xE4EEB5, "panic handling item:", req.Key, r)
+		}
+	}()
+	if rc.Handler != nil {
+		return rc.Handler.HandleItem(req)
+	}
+	return rc.Receive(req.Key, req.Value)
+} //                                                                  handleItem
+
+// -----------------------------------------------------------------------------
+// # Receive Queue
+
+// startQueue starts Config.ReceiveWorkers goroutines that
+// handle fully received items placed in the receive queue.
+func (rc *Receiver) startQueue() {
+	rc.processMutex.Lock()
+	defer rc.processMutex.Unlock()
+	rc.queue = &receiveQueue{}
+	rc.queue.Start(rc.Config.ReceiveWorkers, rc.Config.ReceiveQueueSize,
+		rc.handleQueuedItem)
+} //                                                                  startQueue
+
+// stopQueue waits until all queued items are handled and stops the
+// workers. Items completed after this are handled without a queue.
+func (rc *Receiver) stopQueue() {
+	rc.processMutex.Lock()
+	defer rc.processMutex.Unlock()
+	rc.queue.Stop()
+	rc.queue = nil
+} //                                                                   stopQueue
+
+// queueItem places 'req' in the receive queue and returns true. If the
+// queue is full, waits for room when Config.ReceiveQueuePolicy is
+// QueueBlock, otherwise returns false.
+func (rc *Receiver) queueItem(req *Request) bool {
+	return rc.queue.Put(req, rc.Config.ReceiveQueuePolicy == QueueBlock)
+} //                                                                   queueItem
+
+// queueItems places all the items of a batch in the receive queue, or
+// none of them, like queueItem() does with a single item. This way an
+// item is never queued when the batch that holds it fails, which would
+// make the Sender send it again in a new batch.
+func (rc *Receiver) queueItems(reqs []*Request) bool {
+	return rc.queue.PutAll(reqs, rc.Config.ReceiveQueuePolicy == QueueBlock)
+} //                                                                  queueItems
+
+// replyToFullQueue returns the reply to fragment 'recv', which completed
+// data item 'id' when the receive queue was full: a rejection if
+// Config.ReceiveQueuePolicy is QueueReject, or nil to drop the fragment
+// without a reply, keeping the item until the Sender resends it. If the
+// Sender uses flow control, the dropped fragment gets a tagWindow reply
+// with a zero window, so the Sender waits until the queue has room.
+func (rc *Receiver) replyToFullQueue(recv []byte, id, k string) []byte {
+	rc.statsMutex.Lock()
+	rc.stats.QueueFullItems++
+	rc.statsMutex.Unlock()
+	if rc.Config.ReceiveQueuePolicy == QueueReject {
+		rc.discardDataItem(id)
+		return rc.rejectItem(recv, k, "receive queue full")
+	}
+	if rc.Config.VerboseReceiver {
+		rc.logInfo("receive queue full, dropped last fragment of:", k)
+	}
+	if it := rc.dataItems[id]; it != nil && it.Flow &&
+		rc.Config.ReceiveWindow > 0 {
+		return rc.refuseFragment(getHash(recv), 0)
+	}
+	return nil
+} //                                                            replyToFullQueue
+
+// handleQueuedItem is called by the workers to handle each queued item.
+// Since the item has already been confirmed to the Sender and marked
+// delivered, errors (including rejections) are only logged and counted
+// in ReceiverStats.FailedQueuedItems.
+func (rc *Receiver) handleQueuedItem(req *Request) {
+	err := rc.handleItem(req)
+	if err != nil {
+		rc.statsMutex.Lock()
+		rc.stats.FailedQueuedItems++
+		rc.statsMutex.Unlock()
+		_ = rc.logError(0xEF7463, "failed handling item:", req.Key, err)
+	}
+} //                                                            handleQueuedItem
+
+// receiveMulticastFragment handles a tagFragment packet sent to
+// MulticastGroup. Unlike receiveFragment(), it doesn't reply: the
+// MulticastSender asks for missing fragments with tagStatus packets.
+func (rc *Receiver) receiveMulticastFragment(recv []byte, addr net.Addr,
+) error {
+	h, err := rc.readFragmentHeader(recv)
+	if err != nil {
+		return err
+	}
+	if _, done := rc.multicastDone[h.itemID()]; done ||
+		rc.isDelivered(h.messageID) {
+		return nil
+	}
+	_, it, reason := rc.retainDataItem(h)
+	if reason != "" {
+		return rc.logError(0xE0C412, "rejected item:", h.key, reason)
+	}
+	it.Multicast = true
+	_, err = rc.receiveFragment(recv, addr)
+	return err
+} //                                                    receiveMulticastFragment
+
+// receiveStatus handles a tagStatus packet sent by a MulticastSender.
+// Replies with tagDone if the data item has been received, or with
+// tagNack and the ranges of fragments that are still missing.
+//
+// The list of ranges is limited to Config.PacketPayloadSize bytes.
+// Any other missing fragments are listed after those arrive.
+//
+func (rc *Receiver) receiveStatus(recv []byte) ([]byte, error) {
+	h, err := rc.readItemHeader(recv, tagStatus)
+	if err != nil {
+		return nil, err
+	}
+	if _, done := rc.multicastDone[h.itemID()]; done ||
+		rc.isDelivered(h.messageID) {
+		return append([]byte(tagDone), h.hash...), nil
+	}
+	_, it, reason := rc.retainDataItem(h)
+	if reason != "" {
+		return rc.rejectItem(recv, h.key, reason), nil
+	}
+	it.Multicast = true
+	it.LastActivity = time.Now()
+	var missing []int
+	for i, piece := range it.CompressedPieces {
+		if len(piece) == 0 {
+			missing = append(missing, i)
+		}
+	}
+	reply := append([]byte(tagNack), h.hash...)
+	reply = append(reply, encodeRanges(missing, rc.Config.PacketPayloadSize)...)
+	return reply, nil
+} //                                                               receiveStatus
+
+// receiveQuery handles a tagQuery packet sent by a Sender before it sends
+// a resumable data item. Replies with tagHave, the hash of the query and
+// the ranges of fragments the Receiver already has, in memory or in a
+// checkpoint file, so the Sender only sends the other fragments.
+//
+// The list of ranges is limited to Config.PacketPayloadSize bytes, and the
+// Sender sends any fragments that aren't listed. The last fragment of an
+// item that is complete but not delivered (because Receive failed, or the
+// receive queue was full) is never listed, so that the Sender resends it
+// and the Receiver tries to deliver the item again.
+//
+func (rc *Receiver) receiveQuery(recv []byte) ([]byte, error) {
+	h, err := rc.readItemHeader(recv, tagQuery)
+	if err != nil {
+		return nil, err
+	}
+	reply := append([]byte(tagHave), getHash(recv)...)
+	if _, done := rc.multicastDone[h.itemID()]; done ||
+		rc.isDelivered(h.messageID) {
+		return append(reply, fmt.Sprintf("1-%d", h.packetCount)...), nil
+	}
+	it := rc.dataItems[h.itemID()]
+	if it == nil && rc.isResumable(h.packetCount) {
+		// load the item's checkpoint, if it has one. If the item is
+		// refused, its first fragment will be rejected, so just
+		// reply that the Receiver has nothing
+		var reason string
+		_, it, reason = rc.retainDataItem(h)
+		if reason != "" {
+			return reply, nil
+		}
+	}
+	var have []int
+	if it != nil {
+		it.LastActivity = time.Now()
+		for i, piece := range it.CompressedPieces {
+			if len(piece) > 0 {
+				have = append(have, i)
+			}
+		}
+		if it.IsLoaded() {
+			have = have[:len(have)-1]
+		}
+	}
+	reply = append(reply, encodeRanges(have, rc.Config.PacketPayloadSize)...)
+	return reply, nil
+} //                                                                receiveQuery
+
+// receiveProbe handles a tagProbe packet sent by a Sender to find the
+// largest packet size that reaches the Receiver (see discoverPathMTU).
+// Replies with tagProbeAck and the hash of the probe, if the probe has
+// the size stated in its header, so the reply itself stays small.
+func (rc *Receiver) receiveProbe(recv []byte) ([]byte, error) {
+	var size int
+	_, err := fmt.Sscanf(string(recv[len(tagProbe):]), "size:%d\n", &size)
+	if err != nil || size != len(recv) {
+		return nil, rc.logError(0xEE56E1, "bad probe")
+	}
+	if rc.Config.VerboseReceiver {
+		rc.logInfo("Receiver acknowledged probe of", size, "bytes")
+	}
+	return append([]byte(tagProbeAck), getHash(recv)...), nil
+} //                                                                receiveProbe
+
+// receivePing handles a tagPing packet sent by a Sender to check if the
+// Receiver is up (see Sender.Ping). Replies with tagPong and the hash
+// of the ping packet, without doing anything else.
+func (rc *Receiver) receivePing(recv []byte) ([]byte, error) {
+	if !bytes.HasPrefix(recv[len(tagPing):], []byte("id:")) {
+		return nil, rc.logError(0xE3A4FC, "bad ping")
+	}
+	if rc.Config.VerboseReceiver {
+		rc.logInfo("Receiver answered ping")
+	}
+	return append([]byte(tagPong), getHash(recv)...), nil
+} //                                                                 receivePing
+
+// -----------------------------------------------------------------------------
+// # Data Item Management
+
+// retainDataItem returns the ID and the partially-received data item to
+// which fragment header 'h' belongs, creating the item if it's new. A
+// new item gets the pieces saved in its checkpoint file, if any.
+//
+// If the item would exceed Config.MaxFragmentCount, MaxItemSize or
+// MaxPartialItems, or if the list of its pieces, which is allocated for
+// all the fragments at once, doesn't fit in Config.MaxReassemblyMemory,
+// returns a nil item and the reason for rejecting it.
+//
+func (rc *Receiver) retainDataItem(h *fragmentHeader) (
+	id string, it *dataItem, reason string,
+) {
+	cf := rc.Config
+	if cf.MaxFragmentCount > 0 && h.packetCount > cf.MaxFragmentCount {
+		return "", nil, fmt.Sprintf("fragment count %d exceeds limit %d",
+			h.packetCount, cf.MaxFragmentCount)
+	}
+	// every fragment carries at least one byte of data
+	if cf.MaxItemSize > 0 && h.packetCount > cf.MaxItemSize {
+		return "", nil, fmt.Sprintf("item size exceeds limit %d",
+			cf.MaxItemSize)
+	}
+	if rc.dataItems == nil {
+		rc.dataItems = make(map[string]*dataItem)
+	}
+	id = h.itemID()
+	it = rc.dataItems[id]
+	isNew := it == nil
+	if isNew &&
+		cf.MaxPartialItems > 0 && len(rc.dataItems) >= cf.MaxPartialItems {
+		return "", nil, fmt.Sprintf("partial items exceed limit %d",
+			cf.MaxPartialItems)
+	}
+	if reason = rc.checkIndexMemory(h); reason != "" {
+		return "", nil, reason
+	}
+	if isNew {
+		it = &dataItem{}
+		rc.dataItems[id] = it
+	}
+	// Retain() discards the item's pieces if the fragment count changed
+	rc.reassemblyMemory -= it.MemorySize()
+	it.Retain(h.key, h.hash, h.packetCount)
+	rc.reassemblyMemory += it.MemorySize()
+	if isNew {
+		rc.loadCheckpoint(id, it)
+	}
+	return id, it, ""
+} //                                                              retainDataItem
+
+// checkMemoryLimits returns a blank string if a piece of 'size' bytes can
+// be added to data item 'it' without exceeding Config.MaxItemSize or
+// Config.MaxReassemblyMemory. Otherwise returns the reason for rejection.
+func (rc *Receiver) checkMemoryLimits(it *dataItem, size int) string {
+	cf := rc.Config
+	if cf.MaxItemSize > 0 && it.ReceivedSize+size > cf.MaxItemSize {
+		return fmt.Sprintf("item size exceeds limit %d", cf.MaxItemSize)
+	}
+	if cf.MaxReassemblyMemory > 0 &&
+		rc.reassemblyMemory+size > cf.MaxReassemblyMemory {
+		return fmt.Sprintf("reassembly memory exceeds limit %d",
+			cf.MaxReassemblyMemory)
+	}
+	return ""
+} //                                                           checkMemoryLimits
+
+// checkIndexMemory returns a blank string if the list of pieces of the
+// data item with header 'h', which is allocated for all its fragments
+// at once, fits in Config.MaxReassemblyMemory with the memory held by
+// the other items, or if the item already has it. Otherwise returns
+// the reason for rejection.
+func (rc *Receiver) checkIndexMemory(h *fragmentHeader) string {
+	cf := rc.Config
+	if cf.MaxReassemblyMemory == 0 {
+		return ""
+	}
+	held := 0
+	if it := rc.dataItems[h.itemID()]; it != nil {
+		if len(it.CompressedPieces) == h.packetCount {
+			return ""
+		}
+		held = it.MemorySize() // discarded when the count changes
+	}
+	need := h.packetCount * pieceIndexSize
+	if rc.reassemblyMemory-held+need > cf.MaxReassemblyMemory {
+		return fmt.Sprintf("reassembly memory exceeds limit %d",
+			cf.MaxReassemblyMemory)
+	}
+	return ""
+} //                                                            checkIndexMemory
+
+// canWaitForMemory returns true if a fragment of 'size' bytes of data item
+// 'it', with header 'h', which exceeds Config.MaxReassemblyMemory, can
+// wait for other items to free memory: if the Sender uses flow control,
+// and the item doesn't exceed the limits on its own. If 'it' is nil,
+// the item has not been retained yet, for lack of memory.
+func (rc *Receiver) canWaitForMemory(h *fragmentHeader, it *dataItem,
+	size int,
+) bool {
+	cf := rc.Config
+	held, received := h.packetCount*pieceIndexSize, 0
+	if it != nil {
+		held, received = it.MemorySize(), it.ReceivedSize
+	}
+	return h.flow && cf.ReceiveWindow > 0 &&
+		(cf.MaxFragmentCount == 0 || h.packetCount <= cf.MaxFragmentCount) &&
+		(cf.MaxItemSize == 0 || received+size <= cf.MaxItemSize) &&
+		held+size <= cf.MaxReassemblyMemory
+} //                                                            canWaitForMemory
+
+// discardDataItem removes the data item with the specified ID, releases
+// the memory held by its pieces, and deletes its checkpoint file.
+func (rc *Receiver) discardDataItem(id string) {
+	it := rc.dataItems[id]
+	if it == nil {
+		return
+	}
+	if rc.isResumable(len(it.CompressedPieces)) {
+		err := rc.checkpoints.Remove(id)
+		if err != nil {
+			_ = rc.logError(0xED288B, err)
+		}
+	}
+	rc.releaseDataItem(id, it)
+} //                                                             discardDataItem
+
+// releaseDataItem removes data item 'it' with the specified ID
+// and releases the memory held by its pieces.
+func (rc *Receiver) releaseDataItem(id string, it *dataItem) {
+	rc.reassemblyMemory -= it.MemorySize()
+	it.Reset()
+	delete(rc.dataItems, id)
+} //                                                             releaseDataItem
+
+// discardStaleItems discards partially received data items that haven't
+// received any fragments for longer than Config.PartialItemTimeout,
+// as of time 'now', and reports each one to the Abandoned callback.
+// The checkpoint files of discarded items are kept, so the Sender
+// can still resume them.
+//
+// To avoid scanning all items after every packet, it only
+// checks items every quarter of PartialItemTimeout.
+//
+func (rc *Receiver) discardStaleItems(now time.Time) {
+	timeout := rc.Config.PartialItemTimeout
+	if timeout <= 0 || now.Sub(rc.lastSweep) < timeout/4 {
+		return
+	}
+	rc.lastSweep = now
+	for id, doneTime := range rc.multicastDone {
+		if now.Sub(doneTime) >= timeout {
+			delete(rc.multicastDone, id)
+		}
+	}
+	for id, it := range rc.dataItems {
+		if now.Sub(it.LastActivity) < timeout {
+			continue
+		}
+		var (
+			k        = it.Key
+			hash     = it.Hash
+			received = it.ReceivedCount()
+			count    = len(it.CompressedPieces)
+		)
+		err := rc.checkpoints.Release(id)
+		if err != nil {
+			_ = rc.logError(0xE41D96, err)
+		}
+		rc.releaseDataItem(id, it)
+		rc.logInfo("abandoned:", k, fmt.Sprintf("hash: %X fragments: %d/%d",
+			hash, received, count))
+		if rc.Abandoned != nil {
+			rc.Abandoned(k, hash, received, count)
+		}
+	}
+} //                                                           discardStaleItems
+
+// rememberMulticastItem records that the multicast data item 'id' was
+// completely received at time 'now'. To limit memory use, it forgets
+// the oldest item when there are already maxMulticastDone items.
+func (rc *Receiver) rememberMulticastItem(id string, now time.Time) {
+	if rc.multicastDone == nil {
+		rc.multicastDone = make(map[string]time.Time)
+	}
+	if len(rc.multicastDone) >= maxMulticastDone {
+		oldestID, oldest := "", now
+		for id, doneTime := range rc.multicastDone {
+			if !doneTime.After(oldest) {
+				oldestID, oldest = id, doneTime
+			}
+		}
+		delete(rc.multicastDone, oldestID)
+	}
+	rc.multicastDone[id] = now
+} //                                                       rememberMulticastItem
+
+// isDelivered returns true if Deliveries has the message ID 'id'. Returns
+// false if 'id' is blank, since older Senders don't send message IDs.
+func (rc *Receiver) isDelivered(id string) bool {
+	if id == "" || rc.Deliveries == nil {
+		return false
+	}
+	delivered, err := rc.Deliveries.Delivered(id)
+	if err != nil {
+		_ = rc.logError(0xEACC95, err)
+		return false
+	}
+	return delivered
+} //                                                                 isDelivered
+
+// markDelivered records message ID 'id' in Deliveries, if it's not blank.
+// The item's last fragment is confirmed even if this fails, since it's
+// already been handled, so the error is only logged.
+func (rc *Receiver) markDelivered(id string) {
+	if id == "" || rc.Deliveries == nil {
+		return
+	}
+	err := rc.Deliveries.MarkDelivered(id)
+	if err != nil {
+		_ = rc.logError(0xED61F5, err)
+	}
+} //                                                               markDelivered
+
+// rejectItem logs the rejection of a data item and returns a tagRejection
+// reply for the Sender, containing the hash of the received fragment
+// packet 'recv' and the reason for rejection.
+func (rc *Receiver) rejectItem(recv []byte, k, reason string) []byte {
+	_ = rc.logError(0xEC39DA, "rejected item:", k, reason)
+	reply := append([]byte(tagRejection), getHash(recv)...)
+	reply = append(reply, reason...)
+	return reply
+} //                                                                  rejectItem
+
+// -----------------------------------------------------------------------------
+// # Checkpoints
+
+// isResumable returns true if the fragments of a data item with 'count'
+// fragments are saved to a checkpoint file. See Config.CheckpointDir.
+func (rc *Receiver) isResumable(count int) bool {
+	min := rc.Config.ResumeMinFragments
+	return rc.checkpoints.IsEnabled() && min > 0 && count >= min
+} //                                                                 isResumable
+
+// loadCheckpoint adds the pieces saved in the checkpoint file of new
+// data item 'it' to the item, as long as they fit in the memory limits.
+func (rc *Receiver) loadCheckpoint(id string, it *dataItem) {
+	count := len(it.CompressedPieces)
+	if !rc.isResumable(count) {
+		return
+	}
+	pieces, err := rc.checkpoints.Load(id, count)
+	if err != nil {
+		_ = rc.logError(0xEF1F5E, err)
+		return
+	}
+	loaded := 0
+	for i, piece := range pieces {
+		if len(piece) == 0 || rc.checkMemoryLimits(it, len(piece)) != "" {
+			continue
+		}
+		it.CompressedPieces[i] = piece
+		it.ReceivedSize += len(piece)
+		rc.reassemblyMemory += len(piece)
+		loaded++
+	}
+	if loaded > 0 && rc.Config.VerboseReceiver {
+		rc.logInfo("resuming:", it.Key,
+			fmt.Sprintf("fragments: %d/%d", loaded, count))
+	}
+} //                                                              loadCheckpoint
+
+// saveCheckpoint saves piece 'index' of data item 'id' to the item's
+// checkpoint file, unless the item is complete. Since the piece is
+// still held in memory, a failure to save it is only logged.
+func (rc *Receiver) saveCheckpoint(id string, it *dataItem, index int) {
+	count := len(it.CompressedPieces)
+	if !rc.isResumable(count) || it.IsLoaded() {
+		return
+	}
+	err := rc.checkpoints.Save(id, count, index, it.CompressedPieces[index])
+	if err != nil {
+		_ = rc.logError(0xE5F666, err)
+	}
+} //                                                              saveCheckpoint
+
+// closeCheckpoints closes the open checkpoint files when Run() exits.
+func (rc *Receiver) closeCheckpoints() {
+	rc.processMutex.Lock()
+	defer rc.processMutex.Unlock()
+	rc.checkpoints.Close()
+} //                                                            closeCheckpoints
+
 // -----------------------------------------------------------------------------
 // # Logging Methods
 
@@ -7,11 +7,20 @@ package udpt
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
 	"net"
+	"os"
+	"path/filepath"
 	"reflect"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
+
+	"github.com/balacode/udpt/udptest"
 )
 
 // to run all tests in this file:
@@ -20,8 +29,8 @@ import (
 // -----------------------------------------------------------------------------
 
 // newRunnableReceiver() creates a Receiver with all required fields set
-func newRunnableReceiver() Receiver {
-	ret := Receiver{
+func newRunnableReceiver() *Receiver {
+	ret := &Receiver{
 		Port:      9876,
 		CryptoKey: []byte("0123456789abcdefghijklmnopqrst12"),
 		Config:    NewDefaultConfig(),
@@ -32,6 +41,32 @@ func newRunnableReceiver() Receiver {
 	return ret
 }
 
+// -----------------------------------------------------------------------------
+// Receive(ctx, port, cryptoKey, receive) error
+//
+// go test -run Test_Receive_
+
+// must return nil once the context is cancelled, and an
+// error when the Receiver fails to start (run with -race)
+func Test_Receive_(t *testing.T) {
+	key := []byte("0123456789abcdefghijklmnopqrst12")
+	receive := func(k string, v []byte) error { return nil }
+	//
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		cancel()
+	}()
+	err := Receive(ctx, 9876, key, receive)
+	if err != nil {
+		t.Error("0xEA1484", err)
+	}
+	err = Receive(context.Background(), 0, key, receive)
+	if err == nil {
+		t.Error("0xE5E9D2")
+	}
+} //                                                               Test_Receive_
+
 // -----------------------------------------------------------------------------
 // (rc *Receiver) Run() error
 //
@@ -423,8 +458,163 @@ func Test_Receiver_initRun_9(t *testing.T) {
 	}
 }
 
+// must resolve Address instead of Port when Address is specified
+func Test_Receiver_initRun_10(t *testing.T) {
+	var resolved string
+	netResolveUDPAddr :=
+		func(network string, addr string) (*net.UDPAddr, error) {
+			resolved = addr
+			return nil, makeError(0xE72C44, "failed netResolveUDPAddr")
+		}
+	rc := newRunnableReceiver()
+	rc.Address = "[fe80::1%eth0]:9877"
+	err := rc.initRunDI(netResolveUDPAddr, net.ListenUDP)
+	if resolved != "[fe80::1%eth0]:9877" {
+		t.Error("0xE280AB", "wrong address:", resolved)
+	}
+	if !matchError(err, "failed netResolveUDPAddr") {
+		t.Error("0xE4D6C6", "wrong error:", err)
+	}
+}
+
+// must fail when Address has no valid port, even if Port is valid
+func Test_Receiver_initRun_11(t *testing.T) {
+	for _, addr := range []string{"127.0.0.1", "::1", "[::1]:0", ":70000"} {
+		rc := newRunnableReceiver()
+		rc.Address = addr
+		err := rc.initRunDI(net.ResolveUDPAddr, net.ListenUDP)
+		if !matchError(err, "invalid Receiver.Address") {
+			t.Error("0xEC4A31", addr, "wrong error:", err)
+		}
+		if rc.conn != nil {
+			t.Error("0xE09656")
+			rc.Stop()
+		}
+	}
+}
+
+// must transfer data over IPv6 loopback when Address is "[::1]:port"
+func Test_Receiver_initRun_12(t *testing.T) {
+	conn, err := net.ListenUDP("udp6", &net.UDPAddr{IP: net.IPv6loopback})
+	if err != nil {
+		t.Skip("IPv6 loopback is not available:", err)
+	}
+	conn.Close()
+	cryptoKey := []byte(testAESKey)
+	cf, rc, td := makeConfigAndReceiver(cryptoKey)
+	rc.Address = "[::1]:9876"
+	go func() { _ = rc.Run() }()
+	defer func() { rc.Stop() }()
+	time.Sleep(200 * time.Millisecond)
+	//
+	sd := Sender{Address: "[::1]:9876", CryptoKey: cryptoKey, Config: cf}
+	err = sd.SendString("k6", "over IPv6")
+	if err != nil {
+		t.Error("0xEEDEF0", err)
+	}
+	if got := td.get("", "k6"); len(got) != 1 || string(got[0]) != "over IPv6" {
+		t.Error("0xEFDBCB")
+	}
+}
+
 // - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
-// (rc *Receiver) buildReply(recv []byte) (reply []byte, err error)
+// (rc *Receiver) joinMulticastGroup() error
+//
+// go test -run Test_Receiver_joinMulticastGroup_
+
+// must join MulticastGroup using Config.Transport, if it's specified
+func Test_Receiver_joinMulticastGroup_(t *testing.T) {
+	nw := udptest.NewNetwork()
+	rc := newRunnableReceiver()
+	rc.Config.Transport = nw.Host("10.0.0.2")
+	//
+	// must do nothing without a MulticastGroup
+	if rc.joinMulticastGroup() != nil || rc.mconn != nil {
+		t.Error("0xE4C1B9")
+	}
+	rc.MulticastGroup = "239.1.2.3:9877"
+	if rc.joinMulticastGroup() != nil || rc.mconn == nil {
+		t.Error("0xE0D8F3")
+	}
+	_ = rc.mconn.Close()
+	//
+	// the group must be a multicast address
+	rc.mconn = nil
+	rc.MulticastGroup = "10.0.0.3:9877"
+	err := rc.joinMulticastGroup()
+	if rc.mconn != nil || !matchError(err, "not a multicast address") {
+		t.Error("0xE2F6C4", "wrong error:", err)
+	}
+	// the Transport must support multicast
+	rc.Config.Transport = &mockTransport{}
+	err = rc.joinMulticastGroup()
+	if !matchError(err, "doesn't support multicast") {
+		t.Error("0xE7A3D0", "wrong error:", err)
+	}
+}
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+// (rc *Receiver) acceptPacket(addr net.Addr, size int, now time.Time) bool
+// (rc *Receiver) Stats() ReceiverStats
+//
+// go test -run Test_Receiver_acceptPacket_*
+
+// must drop and count packets from denied networks and rate-limited sources
+func Test_Receiver_acceptPacket_1(t *testing.T) {
+	var tlog strings.Builder
+	rc := Receiver{Config: NewDebugConfig(&tlog)}
+	rc.Config.DeniedNetworks = []string{"10.6.6.0/24"}
+	rc.Config.SourcePacketRate = 1
+	rc.Config.SourcePacketBurst = 1
+	err := rc.filter.Init(rc.Config)
+	if err != nil {
+		t.Error("0xEC8321", err)
+	}
+	now := time.Now()
+	if rc.acceptPacket(newTestUDPAddr("10.6.6.6"), 100, now) {
+		t.Error("0xE16539")
+	}
+	if !rc.acceptPacket(newTestUDPAddr("10.0.0.1"), 100, now) {
+		t.Error("0xEFEA96")
+	}
+	if rc.acceptPacket(newTestUDPAddr("10.0.0.1"), 100, now) {
+		t.Error("0xE519AE")
+	}
+	got := rc.Stats()
+	if got.DeniedPackets != 1 || got.RateLimitedPackets != 1 ||
+		got.UndecryptablePackets != 0 {
+		t.Error("0xE69002", got)
+	}
+	if !strings.Contains(tlog.String(), "Receiver dropped 100 bytes from") {
+		t.Error("0xE9BC19", "wrong log:", tlog.String())
+	}
+}
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+// (rc *Receiver) decryptPacket(encReq []byte) ([]byte, error)
+//
+// go test -run Test_Receiver_decryptPacket_*
+
+// must decrypt valid packets and count undecryptable ones
+func Test_Receiver_decryptPacket_1(t *testing.T) {
+	rc := Receiver{Config: NewDefaultConfig()}
+	rc.Config.Cipher = newTestAESCipher(t)
+	recv, err := rc.decryptPacket(newTestAESCiphertext())
+	if string(recv) != "abc" || err != nil {
+		t.Error("0xEC323E", err)
+	}
+	recv, err = rc.decryptPacket([]byte{0xA8, 0xE1, 0x7D, 0xD6})
+	if recv != nil || !matchError(err, "invalid ciphertext") {
+		t.Error("0xEE7703", "wrong error:", err)
+	}
+	if rc.Stats().UndecryptablePackets != 1 {
+		t.Error("0xED060C")
+	}
+}
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+// (rc *Receiver) buildReply(recv []byte, addr net.Addr) (
+//     reply []byte, err error)
 
 // must succeed
 func Test_Receiver_buildReply_1(t *testing.T) {
@@ -443,11 +633,11 @@ func Test_Receiver_buildReply_1(t *testing.T) {
 		return nil
 	}
 	reply, err := rc.buildReply([]byte(
-		tagFragment + "key:test1 " +
-			"hash:BA7816BF8F01CFEA414140DE5DAE2223" +
-			"B00361A396177A9CB410FF61F20015AD sn:1 count:1\n" +
+		tagFragment+"key:test1 "+
+			"hash:BA7816BF8F01CFEA414140DE5DAE2223"+
+			"B00361A396177A9CB410FF61F20015AD sn:1 count:1\n"+
 			string(comp),
-	))
+	), nil)
 	if recKey != "test1" {
 		t.Error("0xE89AA5")
 	}
@@ -471,7 +661,7 @@ func Test_Receiver_buildReply_2(t *testing.T) {
 	var tlog strings.Builder
 	rc := Receiver{Config: NewDefaultConfig()}
 	rc.Config.LogWriter = &tlog
-	reply, err := rc.buildReply(nil)
+	reply, err := rc.buildReply(nil, nil)
 	if reply != nil {
 		t.Error("0xE18DB7")
 	}
@@ -490,9 +680,9 @@ func Test_Receiver_buildReply_3(t *testing.T) {
 	rc := Receiver{Config: NewDefaultConfig()}
 	rc.Config.Cipher.SetKey([]byte(testAESKey))
 	rc.Config.LogWriter = &tlog
-	reply, err := rc.buildReply([]byte("XYZ: ..."))
-	if string(reply) != "invalid_packet_header" {
-		t.Error("0xE2CA90")
+	reply, err := rc.buildReply([]byte("XYZ: ..."), nil)
+	if reply != nil {
+		t.Error("0xE2CA90", "must not reply:", string(reply))
 	}
 	if !matchError(err, "invalid packet header") {
 		t.Error("0xEC3D21", "wrong error:", err)
@@ -503,6 +693,84 @@ func Test_Receiver_buildReply_3(t *testing.T) {
 	}
 }
 
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+// (rc *Receiver) buildReplyToAddress(recv []byte, addr net.Addr) (
+//     reply []byte, err error)
+//
+// go test -run Test_Receiver_buildReplyToAddress_*
+
+// must reply with a cookie until the address is validated
+func Test_Receiver_buildReplyToAddress_1(t *testing.T) {
+	zc := &zlibCompressor{}
+	comp, _ := zc.Compress([]byte("abc"))
+	rc := Receiver{Config: NewDefaultConfig()}
+	rc.Config.ValidateAddresses = true
+	rc.Config.Cipher.SetKey([]byte(testAESKey))
+	rc.validator.Init(rc.Config.CookieLifetime)
+	received := 0
+	rc.Receive = func(k string, v []byte) error {
+		received++
+		return nil
+	}
+	addr := &net.UDPAddr{IP: []byte{127, 0, 0, 1}, Port: 9876}
+	frag := []byte(tagFragment + "key:test1 " +
+		"hash:BA7816BF8F01CFEA414140DE5DAE2223" +
+		"B00361A396177A9CB410FF61F20015AD sn:1 count:1\n" + string(comp))
+	//
+	// no reply to invalid packets from an unvalidated address
+	reply, err := rc.buildReplyToAddress([]byte("XYZ: ..."), addr)
+	if reply != nil || err != nil {
+		t.Error("0xE8D9FF")
+	}
+	// fragment from an unvalidated address is not processed
+	reply, err = rc.buildReplyToAddress(frag, addr)
+	if !bytes.HasPrefix(reply, []byte(tagCookie)) || err != nil {
+		t.Error("0xE7633E", "wrong reply:", string(reply))
+	}
+	if len(reply) > len(frag) {
+		t.Error("0xEAD396", "reply must not be larger than request")
+	}
+	if received != 0 {
+		t.Error("0xEC0220")
+	}
+	// the same fragment is processed when prefixed with the cookie
+	reply, err = rc.buildReplyToAddress(append(reply, frag...), addr)
+	if !bytes.Equal(reply,
+		append([]byte(tagConfirmation), getHash(frag)...)) || err != nil {
+		t.Error("0xE4E465", "wrong reply:", string(reply))
+	}
+	if received != 1 {
+		t.Error("0xE87B25")
+	}
+}
+
+// must not send a cookie larger than the request
+func Test_Receiver_buildReplyToAddress_2(t *testing.T) {
+	rc := Receiver{Config: NewDefaultConfig()}
+	rc.Config.ValidateAddresses = true
+	rc.validator.Init(rc.Config.CookieLifetime)
+	addr := &net.UDPAddr{IP: []byte{127, 0, 0, 1}, Port: 9876}
+	reply, err := rc.buildReplyToAddress([]byte(tagFragment), addr)
+	if reply != nil || err != nil {
+		t.Error("0xE20167")
+	}
+}
+
+// must ignore an echoed cookie when Config.ValidateAddresses is false
+func Test_Receiver_buildReplyToAddress_3(t *testing.T) {
+	rc := Receiver{Config: NewDefaultConfig()}
+	rc.Config.ValidateAddresses = false
+	rc.Config.Cipher.SetKey([]byte(testAESKey))
+	cookie := tagCookie + strings.Repeat("#", cookieSize)
+	reply, err := rc.buildReplyToAddress([]byte(cookie+"XYZ: ..."), nil)
+	if reply != nil {
+		t.Error("0xEABDC9", "must not reply:", string(reply))
+	}
+	if !matchError(err, "invalid packet header") {
+		t.Error("0xECD757", "wrong error:", err)
+	}
+}
+
 // - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
 // (rc *Receiver) sendReply(conn netUDPConn, addr net.Addr, reply []byte)
 
@@ -587,14 +855,14 @@ func Test_Receiver_sendReply_3(t *testing.T) {
 }
 
 // - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
-// (rc *Receiver) receiveFragment(recv []byte) ([]byte, error)
+// (rc *Receiver) receiveFragment(recv []byte, addr net.Addr) ([]byte, error)
 //
 // go test -run Test_Receiver_receiveFragment_*
 
 // must fail because received data is zero-length, therefore has no header
 func Test_Receiver_receiveFragment_1(t *testing.T) {
 	var rc Receiver
-	data, err := rc.receiveFragment([]byte{})
+	data, err := rc.receiveFragment([]byte{}, nil)
 	if data != nil {
 		t.Error("0xE36A92")
 	}
@@ -606,7 +874,7 @@ func Test_Receiver_receiveFragment_1(t *testing.T) {
 // must fail because there is no newline found (it must terminate the header)
 func Test_Receiver_receiveFragment_2(t *testing.T) {
 	rc := Receiver{Config: NewDefaultConfig()}
-	data, err := rc.receiveFragment([]byte(tagFragment))
+	data, err := rc.receiveFragment([]byte(tagFragment), nil)
 	if data != nil {
 		t.Error("0xE9F5CF")
 	}
@@ -621,8 +889,8 @@ const testHash = "B4E7119D881C4877" + "C9E2BC95B182C542" +
 // must fail because serial number 'sn' in the header is not numeric
 func Test_Receiver_receiveFragment_3(t *testing.T) {
 	rc := Receiver{Config: NewDefaultConfig()}
-	data, err := rc.receiveFragment([]byte(tagFragment +
-		"key:abc hash:" + testHash + " sn:bad count:1\n"))
+	data, err := rc.receiveFragment([]byte(tagFragment+
+		"key:abc hash:"+testHash+" sn:bad count:1\n"), nil)
 	if data != nil {
 		t.Error("0xEA0B81")
 	}
@@ -634,8 +902,8 @@ func Test_Receiver_receiveFragment_3(t *testing.T) {
 // must fail because fragment 'count' in the header is not numeric
 func Test_Receiver_receiveFragment_4(t *testing.T) {
 	rc := Receiver{Config: NewDefaultConfig()}
-	data, err := rc.receiveFragment([]byte(tagFragment +
-		"key:abc hash:" + testHash + " sn:1 count:bad\n"))
+	data, err := rc.receiveFragment([]byte(tagFragment+
+		"key:abc hash:"+testHash+" sn:1 count:bad\n"), nil)
 	if data != nil {
 		t.Error("0xEA9D01")
 	}
@@ -647,8 +915,8 @@ func Test_Receiver_receiveFragment_4(t *testing.T) {
 // must fail because serial number 'sn' in the header exceeds the fragment count
 func Test_Receiver_receiveFragment_5(t *testing.T) {
 	rc := Receiver{Config: NewDefaultConfig()}
-	data, err := rc.receiveFragment([]byte(tagFragment +
-		"key:abc hash:" + testHash + " sn:2 count:1\n"))
+	data, err := rc.receiveFragment([]byte(tagFragment+
+		"key:abc hash:"+testHash+" sn:2 count:1\n"), nil)
 	if data != nil {
 		t.Error("0xEB21B0")
 	}
@@ -660,8 +928,8 @@ func Test_Receiver_receiveFragment_5(t *testing.T) {
 // must fail because hash in header contains odd number of hex digits
 func Test_Receiver_receiveFragment_6(t *testing.T) {
 	rc := Receiver{Config: NewDefaultConfig()}
-	data, err := rc.receiveFragment([]byte(tagFragment +
-		"key:abc hash:321 sn:1 count:1\n"))
+	data, err := rc.receiveFragment([]byte(tagFragment+
+		"key:abc hash:321 sn:1 count:1\n"), nil)
 	if data != nil {
 		t.Error("0xE11DF3")
 	}
@@ -673,8 +941,8 @@ func Test_Receiver_receiveFragment_6(t *testing.T) {
 // must fail because hash in header contains non-hex characters
 func Test_Receiver_receiveFragment_7(t *testing.T) {
 	rc := Receiver{Config: NewDefaultConfig()}
-	data, err := rc.receiveFragment([]byte(tagFragment +
-		"key:abc hash:GG sn:1 count:1\n"))
+	data, err := rc.receiveFragment([]byte(tagFragment+
+		"key:abc hash:GG sn:1 count:1\n"), nil)
 	if data != nil {
 		t.Error("0xEF09EC")
 	}
@@ -686,8 +954,8 @@ func Test_Receiver_receiveFragment_7(t *testing.T) {
 // must fail because hash in header is too short
 func Test_Receiver_receiveFragment_8(t *testing.T) {
 	rc := Receiver{Config: NewDefaultConfig()}
-	data, err := rc.receiveFragment([]byte(tagFragment +
-		"key:abc hash:FF sn:1 count:1\n"))
+	data, err := rc.receiveFragment([]byte(tagFragment+
+		"key:abc hash:FF sn:1 count:1\n"), nil)
 	if data != nil {
 		t.Error("0xE24F86")
 	}
@@ -699,8 +967,8 @@ func Test_Receiver_receiveFragment_8(t *testing.T) {
 // must fail because there is no data to uncompress after the header
 func Test_Receiver_receiveFragment_9(t *testing.T) {
 	rc := Receiver{Config: NewDefaultConfig()}
-	data, err := rc.receiveFragment([]byte(tagFragment +
-		"key:abc hash:" + testHash + " sn:1 count:1\n"))
+	data, err := rc.receiveFragment([]byte(tagFragment+
+		"key:abc hash:"+testHash+" sn:1 count:1\n"), nil)
 	if data != nil {
 		t.Error("0xE85E88")
 	}
@@ -709,6 +977,738 @@ func Test_Receiver_receiveFragment_9(t *testing.T) {
 	}
 }
 
+// must reject an item with more fragments than Config.MaxFragmentCount
+func Test_Receiver_receiveFragment_10(t *testing.T) {
+	var tlog strings.Builder
+	rc := Receiver{Config: NewDefaultConfig()}
+	rc.Config.LogWriter = &tlog
+	rc.Config.MaxFragmentCount = 100
+	recv := []byte(tagFragment +
+		"key:abc hash:" + testHash + " sn:1 count:101\nxyz")
+	reply, err := rc.receiveFragment(recv, nil)
+	if err != nil {
+		t.Error("0xE1E2A5", err)
+	}
+	want := tagRejection + string(getHash(recv)) +
+		"fragment count 101 exceeds limit 100"
+	if string(reply) != want {
+		t.Error("0xEB241F", "wrong reply:", string(reply))
+	}
+	if len(rc.dataItems) != 0 {
+		t.Error("0xEFFC2C", "no item must be allocated")
+	}
+	if !strings.Contains(tlog.String(), "rejected item: abc") {
+		t.Error("0xEAE280", "wrong log:", tlog.String())
+	}
+}
+
+// must reject an item larger than Config.MaxItemSize
+// and release the memory held by its pieces
+func Test_Receiver_receiveFragment_11(t *testing.T) {
+	rc := Receiver{Config: NewDefaultConfig()}
+	rc.Config.MaxItemSize = 5
+	frag := func(sn int, data string) []byte {
+		return []byte(tagFragment + "key:abc hash:" + testHash +
+			" sn:" + strconv.Itoa(sn) + " count:3\n" + data)
+	}
+	reply, _ := rc.receiveFragment(frag(1, "abc"), nil)
+	if !bytes.HasPrefix(reply, []byte(tagConfirmation)) {
+		t.Error("0xEDD6DB")
+	}
+	if rc.reassemblyMemory != 3+3*pieceIndexSize {
+		t.Error("0xEC4A1D", rc.reassemblyMemory)
+	}
+	reply, _ = rc.receiveFragment(frag(2, "def"), nil)
+	if !bytes.HasPrefix(reply, []byte(tagRejection)) ||
+		!bytes.HasSuffix(reply, []byte("item size exceeds limit 5")) {
+		t.Error("0xE10611", "wrong reply:", string(reply))
+	}
+	if len(rc.dataItems) != 0 || rc.reassemblyMemory != 0 {
+		t.Error("0xE2401B", "item must be discarded")
+	}
+	// must reject right away if each fragment can't contain at least 1 byte
+	rc.Config.MaxItemSize = 2
+	reply, _ = rc.receiveFragment(frag(1, "a"), nil)
+	if !bytes.HasPrefix(reply, []byte(tagRejection)) {
+		t.Error("0xECE1FD", "wrong reply:", string(reply))
+	}
+}
+
+// must reject new items beyond Config.MaxPartialItems,
+// but continue accepting fragments of existing items
+func Test_Receiver_receiveFragment_12(t *testing.T) {
+	rc := Receiver{Config: NewDefaultConfig()}
+	rc.Config.MaxPartialItems = 2
+	frag := func(k string, sn int) []byte {
+		return []byte(tagFragment + "key:" + k + " hash:" + testHash +
+			" sn:" + strconv.Itoa(sn) + " count:3\nxyz")
+	}
+	for i, tc := range []struct {
+		recv []byte
+		tag  string
+	}{
+		{frag("k1", 1), tagConfirmation},
+		{frag("k2", 1), tagConfirmation},
+		{frag("k3", 1), tagRejection},
+		{frag("k1", 2), tagConfirmation},
+	} {
+		reply, _ := rc.receiveFragment(tc.recv, nil)
+		if !bytes.HasPrefix(reply, []byte(tc.tag)) {
+			t.Error("0xEE4036", i, "wrong reply:", string(reply))
+		}
+	}
+	if len(rc.dataItems) != 2 || rc.reassemblyMemory != 9+6*pieceIndexSize {
+		t.Error("0xE551FD", len(rc.dataItems), rc.reassemblyMemory)
+	}
+}
+
+// must reject fragments that would exceed Config.MaxReassemblyMemory
+func Test_Receiver_receiveFragment_13(t *testing.T) {
+	rc := Receiver{Config: NewDefaultConfig()}
+	rc.Config.MaxReassemblyMemory = 10 + 2*pieceIndexSize
+	frag := func(k string) []byte {
+		return []byte(tagFragment + "key:" + k + " hash:" + testHash +
+			" sn:1 count:2\n" + "0123456")
+	}
+	reply, _ := rc.receiveFragment(frag("k1"), nil)
+	if !bytes.HasPrefix(reply, []byte(tagConfirmation)) {
+		t.Error("0xEFDA44")
+	}
+	reply, _ = rc.receiveFragment(frag("k2"), nil)
+	if !bytes.HasSuffix(reply, []byte(fmt.Sprint(
+		"reassembly memory exceeds limit ", rc.Config.MaxReassemblyMemory))) {
+		t.Error("0xE4BB9F", "wrong reply:", string(reply))
+	}
+	if len(rc.dataItems) != 1 || rc.reassemblyMemory != 7+2*pieceIndexSize {
+		t.Error("0xEA378A", len(rc.dataItems), rc.reassemblyMemory)
+	}
+}
+
+// must send back the reason when Receive returns a RejectionError
+func Test_Receiver_receiveFragment_14(t *testing.T) {
+	rc := Receiver{Config: NewDefaultConfig(),
+		Receive: func(k string, v []byte) error {
+			return fmt.Errorf("wrapped: %w", Reject("not wanted"))
+		},
+	}
+	frags, _, _ := makeMulticastPackets(t, "k", []byte("abc"))
+	reply, err := rc.receiveFragment(frags[0], nil)
+	want := tagRejection + string(getHash(frags[0])) + "not wanted"
+	if string(reply) != want || err != nil {
+		t.Error("0xE0F3C1", "wrong reply:", string(reply), err)
+	}
+	if len(rc.dataItems) != 0 || rc.reassemblyMemory != 0 {
+		t.Error("0xE5B7A9", "item must be discarded")
+	}
+}
+
+// must pass the details of the transfer to Handler
+func Test_Receiver_receiveFragment_15(t *testing.T) {
+	var got *Request
+	rc := Receiver{Config: NewDefaultConfig(),
+		Handler: HandlerFunc(func(req *Request) error {
+			got = req
+			return nil
+		}),
+	}
+	v := randomBytes(1, 2000)
+	frags, _, hash := makeMulticastPackets(t, "k", v)
+	addr := &mockNetAddr{network: "udp", addr: "10.0.0.1:5000"}
+	t0 := time.Now()
+	for i, frag := range frags {
+		_, _ = rc.receiveFragment(frag, addr)
+		if i == 0 {
+			_, _ = rc.receiveFragment(frag, addr) // a duplicate
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	if got == nil {
+		t.Fatal("0xEE989E", "Handler not called")
+	}
+	compressed := 0
+	for _, frag := range frags {
+		compressed += len(frag) - bytes.IndexByte(frag, '\n') - 1
+	}
+	if got.Key != "k" || !bytes.Equal(got.Value, v) || got.Addr != addr ||
+		!bytes.Equal(got.Hash, hash) || got.CompressedSize != compressed ||
+		got.UncompressedSize != len(v) || got.FragmentCount != len(frags) ||
+		got.DuplicateFragments != 1 {
+		t.Error("0xE1C322", "wrong request:", got.Key, got.Addr,
+			got.CompressedSize, got.UncompressedSize, got.FragmentCount,
+			got.DuplicateFragments)
+	}
+	if got.FirstFragmentTime.Before(t0) ||
+		got.Duration() < 10*time.Millisecond ||
+		got.ReceivedTime.After(time.Now()) {
+		t.Error("0xEE6997", "wrong times:", got.FirstFragmentTime,
+			got.ReceivedTime)
+	}
+}
+
+// must confirm resent fragments of a delivered item without delivering it
+func Test_Receiver_receiveFragment_16(t *testing.T) {
+	var ids []string
+	fail := true
+	rc := Receiver{Config: NewDefaultConfig(),
+		Deliveries: NewMemoryDeliveryStore(10),
+		Handler: HandlerFunc(func(req *Request) error {
+			if fail {
+				fail = false
+				return makeError(0xE5DA7A, "failed")
+			}
+			ids = append(ids, req.MessageID)
+			return nil
+		}),
+	}
+	rc.Config.LogWriter = &strings.Builder{}
+	sd := makeTestSender()
+	it, err := sd.beginSend("msg-1", "k", randomBytes(1, 2000))
+	if err != nil {
+		t.Fatal("0xEC6B65", err)
+	}
+	// the item must not be marked as delivered if the Handler fails,
+	// so it can be delivered when the last fragment is resent
+	for round := 0; round < 3; round++ {
+		for i, pk := range it.packets {
+			reply, _ := rc.receiveFragment(pk.data, nil)
+			last := i == len(it.packets)-1
+			if !bytes.HasPrefix(reply, []byte(tagConfirmation)) &&
+				!(round == 0 && last) {
+				t.Error("0xE283CB", round, i, "wrong reply:", string(reply))
+			}
+		}
+	}
+	if fmt.Sprint(ids) != "[msg-1]" || len(rc.dataItems) != 0 {
+		t.Error("0xE2621B", ids, len(rc.dataItems))
+	}
+	status := []byte(tagStatus + fmt.Sprintf(
+		"key:k id:msg-1 hash:%X count:%d\n", it.dataHash, len(it.packets)))
+	reply, _ := rc.receiveStatus(status)
+	if string(reply) != tagDone+string(it.dataHash) {
+		t.Error("0xE3C43F", "wrong reply:", string(reply))
+	}
+}
+
+// must advertise its window to a Sender that uses flow control, and make
+// it wait instead of rejecting items when reassembly memory is full
+func Test_Receiver_receiveFragment_17(t *testing.T) {
+	rc := Receiver{Config: NewDefaultConfig()}
+	// room for the pieces lists of 2 items and 2 fragments of 7 bytes
+	rc.Config.MaxReassemblyMemory = 2*3*pieceIndexSize + 14
+	frag := func(k, data string) []byte {
+		return []byte(tagFragment + "key:" + k + " hash:" + testHash +
+			" flow:1 sn:1 count:3\n" + data)
+	}
+	for i, tc := range []struct {
+		recv []byte
+		want string
+	}{
+		{frag("k1", "0123456"), tagConfirmation + "win:11"},
+		{frag("k2", "0123456"), tagConfirmation + "win:0"},
+		{frag("k3", "0123456"), tagWindow + "win:0"},
+		{frag("k4", strings.Repeat("x", 2*3*pieceIndexSize)), tagRejection},
+	} {
+		reply, _ := rc.receiveFragment(tc.recv, nil)
+		hash := string(getHash(tc.recv))
+		if tc.want != tagRejection {
+			tc.want = tc.want[:5] + hash + tc.want[5:]
+		}
+		if !strings.HasPrefix(string(reply), tc.want) {
+			t.Error("0xE037FB", i, "wrong reply:", string(reply))
+		}
+	}
+	// the waiting item must be stored once memory is released
+	for id, it := range rc.dataItems {
+		if it.Key == "k1" {
+			rc.discardDataItem(id)
+		}
+	}
+	recv := frag("k3", "0123456")
+	reply, _ := rc.receiveFragment(recv, nil)
+	if string(reply) != tagConfirmation+string(getHash(recv))+"win:0" {
+		t.Error("0xE0AD5F", "wrong reply:", string(reply))
+	}
+	if rc.reassemblyMemory != rc.Config.MaxReassemblyMemory {
+		t.Error("0xE11BF2", rc.reassemblyMemory)
+	}
+}
+
+// must count the list of an item's pieces, which is allocated for all
+// its fragments at once, against Config.MaxReassemblyMemory
+func Test_Receiver_receiveFragment_18(t *testing.T) {
+	rc := Receiver{Config: NewDefaultConfig()}
+	rc.Config.MaxReassemblyMemory = 1000 * pieceIndexSize
+	frag := func(k string, count int) []byte {
+		return []byte(tagFragment + "key:" + k + " hash:" + testHash +
+			" sn:1 count:" + strconv.Itoa(count) + "\nx")
+	}
+	reply, _ := rc.receiveFragment(frag("k1", 600), nil)
+	if !bytes.HasPrefix(reply, []byte(tagConfirmation)) {
+		t.Error("0xEE5DAA", "wrong reply:", string(reply))
+	}
+	reply, _ = rc.receiveFragment(frag("k2", 600), nil)
+	if !bytes.HasPrefix(reply, []byte(tagRejection)) ||
+		!bytes.Contains(reply, []byte("reassembly memory exceeds limit")) {
+		t.Error("0xE8009A", "wrong reply:", string(reply))
+	}
+	if len(rc.dataItems) != 1 || rc.reassemblyMemory != 1+600*pieceIndexSize {
+		t.Error("0xE074B8", len(rc.dataItems), rc.reassemblyMemory)
+	}
+	// a query must not allocate pieces beyond the limit either
+	rc.Config.ResumeMinFragments = 2
+	query := []byte(tagQuery + "key:k3 hash:" + testHash + " count:600\n")
+	reply, _ = rc.receiveQuery(query)
+	if string(reply) != tagHave+string(getHash(query)) ||
+		len(rc.dataItems) != 1 {
+		t.Error("0xEC725D", "wrong reply:", string(reply), len(rc.dataItems))
+	}
+}
+
+// must reject an item whose uncompressed size exceeds
+// Config.MaxUncompressedSize, without allocating it
+func Test_Receiver_receiveFragment_19(t *testing.T) {
+	received := 0
+	rc := Receiver{Config: NewDefaultConfig(),
+		Receive: func(k string, v []byte) error {
+			received++
+			return nil
+		},
+	}
+	rc.Config.MaxUncompressedSize = 64 * 1024
+	frag := func(k string, comp []byte) []byte {
+		return append([]byte(tagFragment+"key:"+k+" hash:"+testHash+
+			" sn:1 count:1\n"), comp...)
+	}
+	zc := &zlibCompressor{}
+	bomb, _ := zc.Compress(make([]byte, 1024*1024)) // 1 MiB of zeros
+	forged, _ := zc.Compress([]byte("abc"))
+	forged = append(forged[:len(forged)-4], 0xFF, 0xFF, 0xFF, 0xFF)
+	for i, comp := range [][]byte{bomb, forged} {
+		reply, _ := rc.receiveFragment(frag(fmt.Sprint("k", i), comp), nil)
+		if !bytes.HasPrefix(reply, []byte(tagRejection)) ||
+			!bytes.HasSuffix(reply, []byte("uncompressed size exceeds limit "+
+				strconv.Itoa(rc.Config.MaxUncompressedSize))) {
+			t.Error("0xEC77E3", i, "wrong reply:", string(reply))
+		}
+	}
+	if received != 0 || len(rc.dataItems) != 0 || rc.reassemblyMemory != 0 {
+		t.Error("0xE5C002", received, len(rc.dataItems), rc.reassemblyMemory)
+	}
+}
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+// (rc *Receiver) deliverBatch(recv []byte, id string, req *Request) ( . . .
+//
+// go test -run Test_Receiver_deliverBatch_
+
+// must deliver each item in a batch once, resending the batch
+// until all are delivered, and only log rejected items
+func Test_Receiver_deliverBatch_(t *testing.T) {
+	var delivered []string
+	failB := true
+	rc := Receiver{Config: NewDefaultConfig(),
+		Deliveries: NewMemoryDeliveryStore(10),
+		Handler: HandlerFunc(func(req *Request) error {
+			switch {
+			case req.Key == "b" && failB:
+				failB = false
+				return makeError(0xEC2389, "failed")
+			case req.Key == "c":
+				return Reject("no c")
+			}
+			delivered = append(delivered, req.Key+"="+string(req.Value))
+			if !bytes.Equal(req.Hash, getHash(req.Value)) ||
+				!strings.HasSuffix(req.MessageID, ".1") && req.Key == "a" {
+				t.Error("0xEEE0FC", "wrong request:", req.MessageID)
+			}
+			return nil
+		}),
+	}
+	var tlog strings.Builder
+	rc.Config.LogWriter = &tlog
+	var data []byte
+	for _, k := range []string{"a", "b", "c"} {
+		data = append(data, makeBatchRecord(k, []byte(k+k))...)
+	}
+	sd := makeTestSender()
+	it, err := sd.beginItem(&senderItem{key: batchKey, batch: true}, data)
+	if err != nil || len(it.packets) != 1 {
+		t.Fatal("0xEC3190", err)
+	}
+	frag := it.packets[0].data
+	reply, _ := rc.receiveFragment(frag, nil)
+	if reply != nil || fmt.Sprint(delivered) != "[a=aa]" {
+		t.Error("0xE08A0D", "wrong result:", string(reply), delivered)
+	}
+	reply, _ = rc.receiveFragment(frag, nil)
+	if !bytes.HasPrefix(reply, []byte(tagConfirmation)) ||
+		fmt.Sprint(delivered) != "[a=aa b=bb]" {
+		t.Error("0xEF08A7", "wrong result:", string(reply), delivered)
+	}
+	if !strings.Contains(tlog.String(), "rejected batched item: c no c") {
+		t.Error("0xE666F8", "rejection not logged:", tlog.String())
+	}
+	// a resent batch must only be confirmed
+	reply, _ = rc.receiveFragment(frag, nil)
+	if !bytes.HasPrefix(reply, []byte(tagConfirmation)) ||
+		len(delivered) != 2 {
+		t.Error("0xE7286E", "wrong result:", string(reply), delivered)
+	}
+	// a malformed batch must be rejected
+	it, _ = sd.beginItem(&senderItem{key: batchKey, batch: true},
+		[]byte("bad"))
+	reply, _ = rc.receiveFragment(it.packets[0].data, nil)
+	if !bytes.HasPrefix(reply, []byte(tagRejection)) {
+		t.Error("0xE38018", "wrong reply:", string(reply))
+	}
+}
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+// (rc *Receiver) receiveMulticastFragment(recv []byte, addr net.Addr) error
+// (rc *Receiver) receiveStatus(recv []byte) ([]byte, error)
+//
+// go test -run Test_Receiver_receiveMulticast_*
+
+// makeMulticastPackets returns the fragment packets and the
+// status request a MulticastSender sends for item 'k', 'v'
+func makeMulticastPackets(t *testing.T, k string, v []byte) (
+	fragments [][]byte, status []byte, hash []byte,
+) {
+	sd := makeTestSender()
+	it, err := sd.beginSend("", k, v)
+	if err != nil {
+		t.Fatal("0xE9D6B2", err)
+	}
+	for _, pk := range it.packets {
+		fragments = append(fragments, pk.data)
+	}
+	status = []byte(tagStatus + fmt.Sprintf(
+		"key:%s id:%s hash:%X count:%d\n",
+		k, it.messageID, it.dataHash, len(it.packets)))
+	return fragments, status, it.dataHash
+}
+
+// must reply with the missing fragments, then deliver the item once
+func Test_Receiver_receiveMulticast_1(t *testing.T) {
+	var delivered []string
+	rc := Receiver{Config: NewDefaultConfig(),
+		Receive: func(k string, v []byte) error {
+			delivered = append(delivered, k)
+			return nil
+		},
+	}
+	frags, status, hash := makeMulticastPackets(t, "k", randomBytes(1, 2000))
+	if len(frags) < 4 {
+		t.Fatal("0xE5A8B3", "too few fragments:", len(frags))
+	}
+	nack := func(ranges string) string {
+		return tagNack + string(hash) + ranges
+	}
+	reply, err := rc.receiveStatus(status)
+	want := nack(fmt.Sprintf("1-%d", len(frags)))
+	if string(reply) != want || err != nil {
+		t.Error("0xE6B1C2", "wrong reply:", string(reply), err)
+	}
+	// fragments sent to the group are stored without a reply
+	err = rc.receiveMulticastFragment(frags[1], nil)
+	if err != nil {
+		t.Error("0xE8F25D", err)
+	}
+	reply, _ = rc.receiveStatus(status)
+	want = nack(fmt.Sprintf("1,3-%d", len(frags)))
+	if string(reply) != want {
+		t.Error("0xE2C7A6", "wrong reply:", string(reply))
+	}
+	// repaired fragments are confirmed
+	for i, frag := range frags {
+		if i == 1 {
+			continue
+		}
+		reply, _ = rc.receiveFragment(frag, nil)
+		if !bytes.HasPrefix(reply, []byte(tagConfirmation)) {
+			t.Error("0xE3D9A1", i, "wrong reply:", string(reply))
+		}
+	}
+	reply, _ = rc.receiveStatus(status)
+	if string(reply) != tagDone+string(hash) {
+		t.Error("0xEB5E47", "wrong reply:", string(reply))
+	}
+	// late fragments must not deliver the item again
+	_ = rc.receiveMulticastFragment(frags[0], nil)
+	reply, _ = rc.receiveFragment(frags[0], nil)
+	if !bytes.HasPrefix(reply, []byte(tagConfirmation)) {
+		t.Error("0xE1E9C5", "wrong reply:", string(reply))
+	}
+	if fmt.Sprint(delivered) != "[k]" || len(rc.dataItems) != 0 {
+		t.Error("0xE9B0D4", delivered, len(rc.dataItems))
+	}
+}
+
+// must reject items that exceed the Receiver's limits
+func Test_Receiver_receiveMulticast_2(t *testing.T) {
+	var tlog strings.Builder
+	rc := Receiver{Config: NewDefaultConfig()}
+	rc.Config.LogWriter = &tlog
+	rc.Config.MaxFragmentCount = 1
+	frags, status, _ := makeMulticastPackets(t, "k", randomBytes(2, 2000))
+	if len(frags) < 2 {
+		t.Fatal("0xE7E2F9", "too few fragments:", len(frags))
+	}
+	reply, err := rc.receiveStatus(status)
+	want := tagRejection + string(getHash(status)) +
+		fmt.Sprintf("fragment count %d exceeds limit 1", len(frags))
+	if string(reply) != want || err != nil {
+		t.Error("0xE4A7C8", "wrong reply:", string(reply), err)
+	}
+	err = rc.receiveMulticastFragment(frags[0], nil)
+	if !matchError(err, "rejected item: k") || len(rc.dataItems) != 0 {
+		t.Error("0xE0C5D2", "wrong error:", err)
+	}
+	// a malformed status request must fail
+	_, err = rc.receiveStatus([]byte(tagStatus + "key:k hash:FF count:1\n"))
+	if !matchError(err, "bad hash") {
+		t.Error("0xE6F3A8", "wrong error:", err)
+	}
+}
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+// (rc *Receiver) receiveQuery(recv []byte) ([]byte, error)
+//
+// go test -run Test_Receiver_receiveQuery_*
+
+// makeQueryPacket returns the query packet a Sender
+// sends for the item with status request 'status'
+func makeQueryPacket(status []byte) []byte {
+	return append([]byte(tagQuery), status[len(tagStatus):]...)
+}
+
+// must reply with the fragments the Receiver has in memory
+func Test_Receiver_receiveQuery_1(t *testing.T) {
+	failed := true
+	rc := Receiver{Config: NewDefaultConfig(),
+		Deliveries: NewMemoryDeliveryStore(10),
+		Receive: func(k string, v []byte) error {
+			if failed {
+				return errors.New("failed")
+			}
+			return nil
+		},
+	}
+	frags, status, _ := makeMulticastPackets(t, "k", randomBytes(3, 4000))
+	if len(frags) < 4 {
+		t.Fatal("0xE7B388", "too few fragments:", len(frags))
+	}
+	query := makeQueryPacket(status)
+	have := func(ranges string) string {
+		return tagHave + string(getHash(query)) + ranges
+	}
+	reply, err := rc.receiveQuery(query)
+	if string(reply) != have("") || err != nil || len(rc.dataItems) != 0 {
+		t.Error("0xE16973", "wrong reply:", string(reply), err)
+	}
+	_, _ = rc.receiveFragment(frags[0], nil)
+	_, _ = rc.receiveFragment(frags[2], nil)
+	reply, _ = rc.receiveQuery(query)
+	if string(reply) != have("1,3") {
+		t.Error("0xE8971D", "wrong reply:", string(reply))
+	}
+	// a complete item that failed to be delivered must be resent
+	for i := 1; i < len(frags); i++ {
+		_, _ = rc.receiveFragment(frags[i], nil)
+	}
+	reply, _ = rc.receiveQuery(query)
+	if string(reply) != have(fmt.Sprintf("1-%d", len(frags)-1)) {
+		t.Error("0xE97051", "wrong reply:", string(reply))
+	}
+	failed = false
+	_, _ = rc.receiveFragment(frags[len(frags)-1], nil)
+	reply, _ = rc.receiveQuery(query)
+	if string(reply) != have(fmt.Sprintf("1-%d", len(frags))) {
+		t.Error("0xE230AE", "wrong reply:", string(reply))
+	}
+	// a malformed query must fail
+	_, err = rc.receiveQuery([]byte(tagQuery + "key:k hash:FF count:1\n"))
+	if !matchError(err, "bad hash") {
+		t.Error("0xE36BB8", "wrong error:", err)
+	}
+}
+
+// must save fragments to checkpoint files, and
+// reply with them after the Receiver restarts
+func Test_Receiver_receiveQuery_2(t *testing.T) {
+	dir, err := ioutil.TempDir("", "udpt")
+	if err != nil {
+		t.Fatal("0xEAB49F", err)
+	}
+	defer os.RemoveAll(dir)
+	newReceiver := func() *Receiver {
+		rc := &Receiver{Config: NewDefaultConfig(),
+			Receive: func(k string, v []byte) error { return nil }}
+		rc.Config.ResumeMinFragments = 2
+		_ = rc.checkpoints.Init(dir, time.Hour)
+		return rc
+	}
+	frags, status, _ := makeMulticastPackets(t, "k", randomBytes(4, 4000))
+	rc := newReceiver()
+	_, _ = rc.receiveFragment(frags[1], nil)
+	_, _ = rc.receiveFragment(frags[2], nil)
+	rc.checkpoints.Close()
+	//
+	rc = newReceiver()
+	query := makeQueryPacket(status)
+	reply, err := rc.receiveQuery(query)
+	if string(reply) != tagHave+string(getHash(query))+"2-3" || err != nil {
+		t.Error("0xE5F319", "wrong reply:", string(reply), err)
+	}
+	for i, frag := range frags {
+		if i != 1 && i != 2 {
+			_, _ = rc.receiveFragment(frag, nil)
+		}
+	}
+	if len(rc.dataItems) != 0 || rc.reassemblyMemory != 0 {
+		t.Error("0xEEAA37", "item not completed")
+	}
+	paths, _ := filepath.Glob(filepath.Join(dir, "*"+checkpointExt))
+	if len(paths) != 0 {
+		t.Error("0xED07CD", "checkpoint file not deleted:", paths)
+	}
+}
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+// (rc *Receiver) receiveProbe(recv []byte) ([]byte, error)
+//
+// go test -run Test_Receiver_receiveProbe_
+//
+// must acknowledge probes of the size stated in their header
+func Test_Receiver_receiveProbe_(t *testing.T) {
+	rc := Receiver{Config: NewDefaultConfig()}
+	probe := makeProbe(1400)
+	reply, err := rc.buildReply(probe, nil)
+	if string(reply) != tagProbeAck+string(getHash(probe)) || err != nil {
+		t.Error("0xED989E", "wrong reply:", string(reply), err)
+	}
+	// a truncated probe must not be acknowledged
+	reply, err = rc.receiveProbe(probe[:1000])
+	if reply != nil || !matchError(err, "bad probe") {
+		t.Error("0xEF98F6", "wrong reply:", string(reply), err)
+	}
+}
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+// (rc *Receiver) receivePing(recv []byte) ([]byte, error)
+//
+// go test -run Test_Receiver_receivePing_
+//
+// must answer pings with the hash of the ping packet
+func Test_Receiver_receivePing_(t *testing.T) {
+	rc := Receiver{Config: NewDefaultConfig()}
+	ping := makePing("0123")
+	reply, err := rc.buildReply(ping, nil)
+	if string(reply) != tagPong+string(getHash(ping)) || err != nil {
+		t.Error("0xE5F04E", "wrong reply:", string(reply), err)
+	}
+	reply, err = rc.receivePing([]byte(tagPing + "bad"))
+	if reply != nil || !matchError(err, "bad ping") {
+		t.Error("0xED0FAC", "wrong reply:", string(reply), err)
+	}
+}
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+// (rc *Receiver) discardStaleItems(now time.Time)
+//
+// go test -run Test_Receiver_discardStaleItems_*
+
+// must discard items idle for longer than Config.PartialItemTimeout
+// and report them to the Abandoned callback
+func Test_Receiver_discardStaleItems_1(t *testing.T) {
+	var tlog strings.Builder
+	rc := Receiver{Config: NewDefaultConfig()}
+	rc.Config.LogWriter = &tlog
+	rc.Config.PartialItemTimeout = time.Minute
+	type abandoned struct {
+		k               string
+		hash            []byte
+		received, count int
+	}
+	var got []abandoned
+	rc.Abandoned = func(k string, hash []byte, received, count int) {
+		got = append(got, abandoned{k, hash, received, count})
+	}
+	frag := func(k string, sn int) []byte {
+		return []byte(tagFragment + "key:" + k + " hash:" + testHash +
+			" sn:" + strconv.Itoa(sn) + " count:3\nxyz")
+	}
+	rc.receiveFragment(frag("old", 1), nil)
+	rc.receiveFragment(frag("old", 3), nil)
+	rc.receiveFragment(frag("new", 1), nil)
+	rc.dataItems[fmt.Sprintf("%s old", testHash)].LastActivity =
+		time.Now().Add(-2 * time.Minute)
+	//
+	rc.discardStaleItems(time.Now())
+	//
+	if len(got) != 1 || got[0].k != "old" || got[0].received != 2 ||
+		got[0].count != 3 || fmt.Sprintf("%X", got[0].hash) != testHash {
+		t.Error("0xE97E56", "wrong callback:", got)
+	}
+	if len(rc.dataItems) != 1 || rc.reassemblyMemory != 3+3*pieceIndexSize {
+		t.Error("0xEBB14E", len(rc.dataItems), rc.reassemblyMemory)
+	}
+	if !strings.Contains(tlog.String(), "abandoned: old hash: "+testHash+
+		" fragments: 2/3") {
+		t.Error("0xE6D9BE", "wrong log:", tlog.String())
+	}
+	// must not check again until a quarter of the timeout has passed
+	rc.dataItems[fmt.Sprintf("%s new", testHash)].LastActivity =
+		time.Now().Add(-2 * time.Minute)
+	rc.discardStaleItems(rc.lastSweep.Add(time.Second))
+	if len(got) != 1 {
+		t.Error("0xED54F0")
+	}
+	rc.discardStaleItems(rc.lastSweep.Add(15 * time.Second))
+	if len(got) != 2 || got[1].k != "new" || len(rc.dataItems) != 0 {
+		t.Error("0xE39213", "wrong callback:", got)
+	}
+}
+
+// must keep partial items if Config.PartialItemTimeout is zero
+func Test_Receiver_discardStaleItems_2(t *testing.T) {
+	rc := Receiver{Config: NewDefaultConfig()}
+	rc.Config.PartialItemTimeout = 0
+	rc.receiveFragment([]byte(tagFragment+"key:abc hash:"+testHash+
+		" sn:1 count:2\nxyz"), nil)
+	rc.discardStaleItems(time.Now().Add(24 * time.Hour))
+	if len(rc.dataItems) != 1 {
+		t.Error("0xE1AC32")
+	}
+}
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+// (rc *Receiver) rememberMulticastItem(id string, now time.Time)
+//
+// go test -run Test_Receiver_rememberMulticastItem_
+
+// must forget the oldest items when full, or when they expire
+func Test_Receiver_rememberMulticastItem_(t *testing.T) {
+	rc := Receiver{Config: NewDefaultConfig()}
+	rc.Config.PartialItemTimeout = time.Minute
+	t0 := time.Now().Add(-time.Hour)
+	for i := 0; i < maxMulticastDone; i++ {
+		rc.rememberMulticastItem(strconv.Itoa(i), t0.Add(
+			time.Duration(i)*time.Millisecond))
+	}
+	rc.rememberMulticastItem("new", time.Now())
+	_, has0 := rc.multicastDone["0"]
+	_, has1 := rc.multicastDone["1"]
+	if len(rc.multicastDone) != maxMulticastDone || has0 || !has1 {
+		t.Error("0xE8A4F1", len(rc.multicastDone), has0, has1)
+	}
+	rc.discardStaleItems(time.Now())
+	if _, hasNew := rc.multicastDone["new"]; len(rc.multicastDone) != 1 ||
+		!hasNew {
+		t.Error("0xE3C6B7", len(rc.multicastDone))
+	}
+}
+
 // -----------------------------------------------------------------------------
 // # Logging Methods
 
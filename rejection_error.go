@@ -0,0 +1,54 @@
+// -----------------------------------------------------------------------------
+// github.com/balacode/udpt                                /[rejection_error.go]
+// (c) balarabe@protonmail.com                                      License: MIT
+// -----------------------------------------------------------------------------
+
+package udpt
+
+// RejectionError is an error which Receiver.Receive can return to reject
+// a data item. The Receiver discards the item and sends Reason back to
+// the Sender, so Sender.Send() fails right away with "rejected by
+// Receiver:" and the reason, instead of resending the item.
+//
+// Any other error returned by Receive is only logged by the Receiver,
+// and the Sender keeps resending until it runs out of retries.
+//
+// Neither reaches the Sender when Config.ReceiveWorkers is more than
+// zero, since queued items are confirmed before they are handled.
+//
+type RejectionError struct {
+	Reason string
+} //                                                              RejectionError
+
+// Reject returns a *RejectionError with the given reason.
+func Reject(reason string) error {
+	return &RejectionError{Reason: reason}
+} //                                                                      Reject
+
+// Error implements the error interface.
+func (re *RejectionError) Error() string {
+	return "rejected: " + re.Reason
+} //                                                                       Error
+
+// -----------------------------------------------------------------------------
+
+// rejectedError is returned by Sender.Send() when the Receiver rejects
+// a data item. Its message is the Sender's usual error message, and it
+// wraps a *RejectionError with the Receiver's reason, so callers can
+// get the reason with errors.As().
+type rejectedError struct {
+	err       error
+	rejection *RejectionError
+} //                                                               rejectedError
+
+// Error implements the error interface.
+func (re *rejectedError) Error() string {
+	return re.err.Error()
+} //                                                                       Error
+
+// Unwrap returns the *RejectionError with the Receiver's reason.
+func (re *rejectedError) Unwrap() error {
+	return re.rejection
+} //                                                                      Unwrap
+
+// end
@@ -0,0 +1,36 @@
+// -----------------------------------------------------------------------------
+// github.com/balacode/udpt                           /[rejection_error_test.go]
+// (c) balarabe@protonmail.com                                      License: MIT
+// -----------------------------------------------------------------------------
+
+package udpt
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// to run all tests in this file:
+// go test -v -run Test_RejectionError_*
+
+// -----------------------------------------------------------------------------
+
+// Reject(reason string) error
+//
+// go test -run Test_RejectionError_
+//
+func Test_RejectionError_(t *testing.T) {
+	err := Reject("too big")
+	if err.Error() != "rejected: too big" {
+		t.Error("0xEF7CFF", err)
+	}
+	// must be found when wrapped
+	var rejection *RejectionError
+	wrapped := fmt.Errorf("handler: %w", err)
+	if !errors.As(wrapped, &rejection) || rejection.Reason != "too big" {
+		t.Error("0xEA9AD1", wrapped)
+	}
+}
+
+// end
@@ -17,7 +17,12 @@ package udpt
 //
 // # Main Methods (sd *Sender)
 //   ) Send(k string, v []byte) error
+//   ) SendAsync(k string, v []byte) <-chan error
 //   ) SendString(k, v string) error
+//   ) SendWithID(id, k string, v []byte) error
+//   ) SendWithPriority(p Priority, k string, v []byte) error
+//   ) Ping(ctx context.Context) (time.Duration, error)
+//   ) Close() error
 //
 // # Informatory Properties (sd *Sender)
 //   ) AverageResponseMs() float64
@@ -28,24 +33,66 @@ package udpt
 //   ) LogStats(w ...io.Writer)
 //
 // # Internal Lifecycle Methods (sd *Sender)
-//   ) beginSend(k string, v []byte) error
-//   ) makePackets(k string, comp []byte) error
+//   ) initConfig()
+//   ) beginSend(id, k string, v []byte) (*senderItem, error)
+//   ) beginItem(it *senderItem, v []byte) (*senderItem, error)
+//   ) checkSettings() error
+//   ) setKeys() error
+//   ) makePackets(it *senderItem, comp []byte) error
+//   ) deliverPackets( . . .
+//   ) startItem(it *senderItem, connect func() (netUDPConn, error), . . .
+//   ) useConnection(connect func() (netUDPConn, error)) (netUDPConn, . . .
+//   ) finishItem(it *senderItem)
+//   ) queryReceiver(it *senderItem)
 //   ) connect() (netUDPConn, error)
 //   ) connectDI( . . .
-//   ) sendUndeliveredPackets() error
-//   ) collectConfirmations()
-//   ) waitForAllConfirmations()
-//   ) close()
-//   ) endSend() error
+//   ) dialTransport() (netUDPConn, error)
+//   ) sendUndeliveredPackets(it *senderItem) error
+//   ) waitForWindow(it *senderItem) bool
+//   ) collectConfirmations(conn netUDPConn)
+//   ) activeItems() []*senderItem
+//   ) receiveCookie(recv []byte)
+//   ) receiveRejection(recv []byte)
+//   ) receiveRefusal(recv []byte)
+//   ) receiveHave(recv []byte)
+//   ) waitForAllConfirmations(it *senderItem)
+//   ) close() error
+//   ) endSend(it *senderItem) error
+//
+// # Batching (sd *Sender)
+//   ) sendBatched(k string, v []byte, . . .
+//   ) sealBatch(b *senderBatch)
+//   ) sendBatch(b *senderBatch, . . .
+//
+// # Path MTU Discovery (sd *Sender)
+//   ) payloadSize() int
+//   ) fragmentPayloadSize(header int) int
+//   ) startPathMTUDiscovery()
+//   ) discoverPathMTU(conn netUDPConn, address string, base, max int)
+//   ) probePath(conn netUDPConn, payload int) bool
+//   ) receiveProbeAck(recv []byte)
+//   ) setDontFragment(conn interface{})
+//
+// # Keepalive (sd *Sender)
+//   ) ping(ctx context.Context, conn netUDPConn) (time.Duration, error)
+//   ) receivePong(recv []byte)
+//   ) keepAlive(conn netUDPConn, stop chan struct{})
 //
 // # Internal Helper Methods (sd *Sender)
 //   ) logError(id uint32, a ...interface{}) error
 //   ) logInfo(a ...interface{})
 //   ) makePacket(data []byte) (*senderPacket, error)
+//   ) packetOverhead() int
+//   ) packetPrefix() []byte
+//   ) replyCipher() SymmetricCipher
 //   ) validateAddress() error
+//
+// # Internal Functions
+//   splitWindow(reply []byte) (hash []byte, window int)
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -90,6 +137,7 @@ func Send(addr, k string, v, cryptoKey []byte, config ...*Configuration) error {
 		cf = NewDefaultConfig()
 	}
 	sender := Sender{Address: addr, CryptoKey: cryptoKey, Config: cf}
+	defer func() { _ = sender.Close() }()
 	err := sender.Send(k, v)
 	return err
 } //                                                                        Send
@@ -137,10 +185,22 @@ type udpStats struct {
 // to create a single-use Sender to send a message, but it's
 // more efficient to construct a reusable Sender.
 //
+// A Sender is safe for concurrent use: several goroutines can call Send()
+// at the same time, or use SendAsync(), and the items are sent together
+// over a single connection. Don't change the Sender's public fields
+// while items are being sent.
+//
+// The connection stays open between sends, so sending many small items
+// doesn't resolve Address and open a new socket for each item. After
+// a failed send, after Config.ConnectionTTL, or when Address or Config
+// change, the next send opens a new connection, resolving Address
+// again. Call Close() when you no longer need the Sender.
+//
 type Sender struct {
 
 	// Address is the domain name or IP address of the listening
 	// receiver with the port number. For example: "127.0.0.1:9876"
+	// IPv6 addresses must be enclosed in brackets: "[::1]:9876"
 	//
 	// The port number must be between 1 and 65535.
 	//
@@ -154,25 +214,84 @@ type Sender struct {
 	//
 	CryptoKey []byte
 
+	// ReplyCryptoKey is an optional secret key used to decrypt replies
+	// sent back by the Receiver. If you leave it nil, replies are
+	// decrypted using CryptoKey. If you specify it, it must match
+	// the Receiver's ReplyCryptoKey. See KeyParams.DeriveKeys().
+	ReplyCryptoKey []byte
+
 	// Config contains UDP and other configuration settings.
 	// These settings normally don't need to be changed.
 	Config *Configuration
 
+	// Unreachable is an optional callback, called with the Receiver's
+	// address when it stops answering the keepalive pings sent while
+	// the connection is idle (see Config.KeepaliveInterval).
+	Unreachable func(address string)
+
 	// -------------------------------------------------------------------------
 
-	// conn holds the UDP connection to a Receiver
+	// mutex guards the fields below, which are shared
+	// by all the data items being sent at the same time
+	mutex sync.Mutex
+
+	// conn holds the UDP connection to a Receiver. It's opened by
+	// the first send, and stays open until Close() is called, or
+	// until it is replaced by a new connection (see startItem).
 	conn netUDPConn
 
-	// dataHash contains the hash of all bytes of the data item being sent
-	dataHash []byte
+	// connAddress and connConfig are the values
+	// of Address and Config when conn was opened
+	connAddress string
+	connConfig  *Configuration
+
+	// connTime is the time conn was opened
+	connTime time.Time
+
+	// connFailed is set when an item sent over conn was not delivered,
+	// or a keepalive ping was not answered, to make the next send open
+	// a new connection
+	connFailed bool
+
+	// replyTime is the time the last reply was received over conn,
+	// or the time conn was opened, if no reply was received
+	replyTime time.Time
 
-	// packets contains all the packets of the currently transferred data item;
-	// some of them may have been delivered, while others may need (re)sending
-	packets []senderPacket
+	// keepaliveStop is closed to stop the keepalives sent over conn
+	// (see keepAlive), or is nil if keepalives are not enabled
+	keepaliveStop chan struct{}
 
-	// startTime is the time the first packet was sent, after
-	// the bytes of the data item have been compressed
-	startTime time.Time
+	// pings contains the ping packets waiting for the Receiver's
+	// reply, by the hash of each packet (see Ping)
+	pings map[string]*senderPing
+
+	// items contains the data items currently being sent over conn
+	items []*senderItem
+
+	// scheduler interleaves the packets of the items being
+	// sent, according to their priority (see SendWithPriority)
+	scheduler packetScheduler
+
+	// batch collects small items to send together, or is nil if
+	// no batch is open. See sendBatched() and Config.BatchLinger.
+	batch *senderBatch
+
+	// pathMTU holds the largest payload sizes found for each address
+	// by path MTU discovery (see Config.PathMTUDiscovery)
+	pathMTU pathMTUCache
+
+	// lastItem is the data item that was sent last, whose
+	// details are reported by DeliveredAllParts() and LogStats()
+	lastItem *senderItem
+
+	// cookie is the address validation token last received from the
+	// Receiver (see addressValidator). When set, every packet sent
+	// to the Receiver is prefixed with tagCookie and the cookie.
+	cookie []byte
+
+	// cipherOverhead is the number of bytes which Config.Cipher adds
+	// to each packet it encrypts, measured when the keys are set
+	cipherOverhead int
 
 	// stats contains UDP transfer statistics, such as the transfer
 	// speed and the number of packets delivered and lost
@@ -191,45 +310,52 @@ type Sender struct {
 // 'v' is the value being sent as a sequence of bytes. It can be as large
 // as the free memory available on the Sender's and Receiver's machine.
 //
+// If the Receiver rejects the item, the returned error wraps
+// a *RejectionError with the Receiver's reason (see errors.As).
+//
+// When Config.BatchLinger is set, small items are sent in batches with
+// other items sent at about the same time (see sendBatched).
+//
 func (sd *Sender) Send(k string, v []byte) error {
-	return sd.sendDI(k, v, sd.connect, sd.sendUndeliveredPackets)
+	return sd.sendDI("", k, v, PriorityNormal,
+		sd.connect, sd.sendUndeliveredPackets)
 } //                                                                        Send
 
 // sendDI is only used by Send() and provides parameters for
 // dependency injection, to enable mocking during testing.
-func (sd *Sender) sendDI(k string, v []byte,
+func (sd *Sender) sendDI(id, k string, v []byte, p Priority,
 	connect func() (netUDPConn, error),
-	sendUndeliveredPackets func() error,
+	sendUndeliveredPackets func(it *senderItem) error,
 ) error {
-	if sd.Config == nil {
-		sd.Config = NewDefaultConfig()
+	sd.initConfig()
+	if id == "" && p == PriorityNormal && sd.Config.BatchLinger > 0 &&
+		len(makeBatchRecord(k, v)) <= sd.payloadSize() {
+		return sd.sendBatched(k, v, connect, sendUndeliveredPackets)
 	}
-	err := sd.beginSend(k, v)
+	it, err := sd.beginSend(id, k, v)
 	if err != nil {
 		return err
 	}
-	newConn, err := connect()
-	if err != nil {
-		return sd.logError(0xE8B8D0, err)
-	}
-	sd.conn = newConn
-	go sd.collectConfirmations() // exits when conn becomes nil
-	for retries := 0; retries < sd.Config.SendRetries; retries++ {
-		err = sendUndeliveredPackets()
-		if err != nil {
-			defer func() { sd.close() }()
-			return sd.logError(0xE23CE0, err)
-		}
-		sd.waitForAllConfirmations()
-		if sd.DeliveredAllParts() {
-			break
-		}
-		time.Sleep(sd.Config.SendRetryInterval)
-	}
-	sd.close()
-	return sd.endSend()
+	it.priority = p
+	return sd.deliverPackets(it, connect, sendUndeliveredPackets)
 } //                                                                      sendDI
 
+// SendAsync starts transferring a key-value to the Receiver like Send(),
+// but returns without waiting for the transfer to finish. The result of
+// the transfer (nil if the item was delivered) is sent to the returned
+// channel, which has room for it, so you don't have to read it.
+//
+// Don't change the bytes of 'v' until the result arrives. The items
+// sent at the same time are multiplexed over one connection.
+//
+func (sd *Sender) SendAsync(k string, v []byte) <-chan error {
+	ret := make(chan error, 1)
+	go func() {
+		ret <- sd.Send(k, v)
+	}()
+	return ret
+} //                                                                   SendAsync
+
 // SendString transfers a key and value string
 // to the Receiver specified by Sender.Address.
 //
@@ -244,12 +370,85 @@ func (sd *Sender) SendString(k string, v string) error {
 	return sd.Send(k, []byte(v))
 } //                                                                  SendString
 
+// SendWithID transfers a key-value to the Receiver like Send(), but with
+// message ID 'id' instead of a new random ID. The Receiver delivers only
+// one item with a given ID, as long as its Deliveries store remembers
+// the ID, so an item stored with its ID can safely be sent again, for
+// example after the Sender restarts without knowing if it was delivered.
+//
+// 'id' can have up to 64 printable ASCII characters, without spaces.
+// See NewMessageID().
+//
+func (sd *Sender) SendWithID(id, k string, v []byte) error {
+	err := validateMessageID(id)
+	if err != nil {
+		return err
+	}
+	return sd.sendDI(id, k, v, PriorityNormal,
+		sd.connect, sd.sendUndeliveredPackets)
+} //                                                                  SendWithID
+
+// SendWithPriority transfers a key-value to the Receiver like Send(), but
+// with priority 'p'. While other items are being sent at the same time,
+// the packets of a PriorityHigh item are sent more often than theirs, so
+// the item is delivered sooner, and the packets of a PriorityLow item
+// are sent less often. See Priority.
+//
+// Only PriorityNormal items are sent in batches (see Config.BatchLinger),
+// so PriorityHigh items are never held back waiting for other items.
+//
+func (sd *Sender) SendWithPriority(p Priority, k string, v []byte) error {
+	if !p.isValid() {
+		return sd.logError(0xECC582, "invalid priority:", p)
+	}
+	return sd.sendDI("", k, v, p, sd.connect, sd.sendUndeliveredPackets)
+} //                                                            SendWithPriority
+
+// Ping checks if the Receiver at Address is up, by sending it a small
+// ping packet and waiting for the reply, and returns the round-trip time.
+// Use it to find out at once if the Receiver is down, before sending
+// a large item, instead of after Config.SendRetries failed rounds.
+//
+// The ping is resent every Config.SendRetryInterval, in case it's lost.
+// Ping() fails if no reply arrives within Config.ReplyTimeout, or when
+// 'ctx' is done, whichever happens first. It uses the same connection
+// as the items being sent, opening it if needed.
+//
+func (sd *Sender) Ping(ctx context.Context) (time.Duration, error) {
+	sd.initConfig()
+	err := sd.checkSettings()
+	if err != nil {
+		return 0, err
+	}
+	sd.mutex.Lock()
+	conn, err := sd.useConnection(sd.connect)
+	sd.mutex.Unlock()
+	if err != nil {
+		return 0, sd.logError(0xE658BF, err)
+	}
+	return sd.ping(ctx, conn)
+} //                                                                        Ping
+
+// Close closes the Sender's connection to the Receiver and stops
+// receiving replies. Items being sent over the connection fail.
+//
+// The Sender can still be used after Close(): the next
+// send opens a new connection, which must be closed again.
+//
+func (sd *Sender) Close() error {
+	sd.mutex.Lock()
+	defer sd.mutex.Unlock()
+	return sd.close()
+} //                                                                       Close
+
 // -----------------------------------------------------------------------------
 // # Informatory Properties (sd *Sender)
 
 // AverageResponseMs is the average response time, in milliseconds, between
 // a packet being sent and its delivery confirmation being received.
 func (sd *Sender) AverageResponseMs() float64 {
+	sd.mutex.Lock()
+	defer sd.mutex.Unlock()
 	if sd.stats.packetsDelivered == 0 {
 		return 0.0
 	}
@@ -261,28 +460,21 @@ func (sd *Sender) AverageResponseMs() float64 {
 	return ret
 } //                                                           AverageResponseMs
 
-// DeliveredAllParts returns true if all parts of the
-// sent data item have been delivered. I.e. all packets
-// have been sent, resent if needed, and confirmed.
+// DeliveredAllParts returns true if all parts of the data item
+// sent last have been delivered. I.e. all packets have
+// been sent, resent if needed, and confirmed.
 func (sd *Sender) DeliveredAllParts() bool {
-	defer func() {
-		if r := recover(); r != nil {
-			_ = sd.logError(0xEC7A22, "Sender.DeliveredAllParts panic:", r)
-		}
-	}()
-	ret := len(sd.packets) > 0
-	for _, pk := range sd.packets {
-		if !bytes.Equal(pk.sentHash, pk.confirmedHash) {
-			ret = false
-			break
-		}
-	}
-	return ret
+	sd.mutex.Lock()
+	it := sd.lastItem
+	sd.mutex.Unlock()
+	return it != nil && it.DeliveredAllParts()
 } //                                                           DeliveredAllParts
 
 // TransferSpeedKBpS returns the transfer speed of the current Send
 // operation, in Kilobytes (more accurately, Kibibytes) per second.
 func (sd *Sender) TransferSpeedKBpS() float64 {
+	sd.mutex.Lock()
+	defer sd.mutex.Unlock()
 	if sd.stats.transferTime < 1 {
 		return 0.0
 	}
@@ -296,14 +488,24 @@ func (sd *Sender) TransferSpeedKBpS() float64 {
 
 // LogStats prints UDP transfer statistics to the specified writer 'wr',
 // or to Sender.LogWriter. If both are not specified, does nothing.
+// The packets listed are those of the data item sent last.
 func (sd *Sender) LogStats(w ...io.Writer) {
 	//
 	log := sd.logInfo
 	if len(w) > 0 {
 		log = func(a ...interface{}) { fmt.Fprintln(w[0], a...) }
 	}
+	var packets []senderPacket
+	sd.mutex.Lock()
+	it, stats := sd.lastItem, sd.stats
+	sd.mutex.Unlock()
+	if it != nil {
+		it.mutex.Lock()
+		packets = append(packets, it.packets...)
+		it.mutex.Unlock()
+	}
 	tItem := time.Duration(0)
-	for i, pk := range sd.packets {
+	for i, pk := range packets {
 		tPacket, status := time.Duration(0), "✔"
 		if pk.IsDelivered() {
 			if !pk.confirmedTime.IsZero() {
@@ -326,17 +528,17 @@ func (sd *Sender) LogStats(w ...io.Writer) {
 		tItem += tPacket
 	}
 	var (
-		sec   = sd.stats.transferTime.Seconds()
+		sec   = stats.transferTime.Seconds()
 		avg   = sd.AverageResponseMs()
 		speed = sd.TransferSpeedKBpS()
 		prt   = func(tag, format string, v interface{}) {
 			log(tag, fmt.Sprintf(format, v))
 		}
 	)
-	prt("B. delivered:", "%d", sd.stats.bytesDelivered)
-	prt("Bytes lost  :", "%d", sd.stats.bytesLost)
-	prt("P. delivered:", "%d", sd.stats.packetsDelivered)
-	prt("Packets lost:", "%d", sd.stats.packetsLost)
+	prt("B. delivered:", "%d", stats.bytesDelivered)
+	prt("Bytes lost  :", "%d", stats.bytesLost)
+	prt("P. delivered:", "%d", stats.packetsDelivered)
+	prt("Packets lost:", "%d", stats.packetsLost)
 	prt("Time in item:", "%0.1f s", sec)
 	prt("Avg./ Packet:", "%0.1f ms", avg)
 	prt("Trans. speed:", "%0.1f KiB/s", speed)
@@ -345,18 +547,62 @@ func (sd *Sender) LogStats(w ...io.Writer) {
 // -----------------------------------------------------------------------------
 // # Internal Lifecycle Methods (sd *Sender)
 
-// beginSend checks if the sender is properly configured before sending
-func (sd *Sender) beginSend(k string, v []byte) error {
-	//
-	// setup cipher
-	if sd.Config.Cipher == nil {
-		return sd.logError(0xE83D07, "nil Sender.Config.Cipher")
+// initConfig assigns the default configuration if Config is nil. It's
+// guarded by the mutex, since Send() can be called from many goroutines.
+func (sd *Sender) initConfig() {
+	sd.mutex.Lock()
+	defer sd.mutex.Unlock()
+	if sd.Config == nil {
+		sd.Config = NewDefaultConfig()
 	}
-	err := sd.Config.Cipher.SetKey(sd.CryptoKey)
+} //                                                                  initConfig
+
+// beginSend checks if the sender is properly configured before sending,
+// then makes the packets of the data item, with message ID 'id', or
+// a new message ID if 'id' is blank.
+func (sd *Sender) beginSend(id, k string, v []byte) (*senderItem, error) {
+	return sd.beginItem(&senderItem{key: k, messageID: id}, v)
+} //                                                                   beginSend
+
+// beginItem is used by beginSend() and sendBatch() to make the packets of
+// data item 'it' with value 'v'. The item's key, message ID (which is
+// generated if blank) and batch flag must be set.
+func (sd *Sender) beginItem(it *senderItem, v []byte) (*senderItem, error) {
+	err := sd.checkSettings()
 	if err != nil {
-		return sd.logError(0xE02D7B, "invalid Sender.CryptoKey:", err)
+		return nil, err
+	}
+	if it.messageID == "" {
+		it.messageID, err = NewMessageID()
+		if err != nil {
+			return nil, sd.logError(0xE30289, err)
+		}
+	}
+	it.dataHash = getHash(v)
+	if sd.Config.VerboseSender {
+		sd.logInfo("\n" + strings.Repeat("-", 80) + "\n" +
+			fmt.Sprintf("Send key: %s size: %d hash: %X",
+				it.key, len(v), it.dataHash))
+	}
+	comp, err := sd.Config.Compressor.Compress(v)
+	if err != nil {
+		return nil, sd.logError(0xE2EB59, err)
+	}
+	it.startTime = time.Now()
+	err = sd.makePackets(it, comp)
+	if err != nil {
+		return nil, err
+	}
+	return it, nil
+} //                                                                   beginItem
+
+// checkSettings sets the cipher keys, then checks if
+// Config and Address are valid before sending anything
+func (sd *Sender) checkSettings() error {
+	err := sd.setKeys()
+	if err != nil {
+		return err
 	}
-	// check settings
 	err = sd.Config.Validate()
 	if err != nil {
 		return sd.logError(0xE5D92D, "invalid Sender.Config:", err)
@@ -365,58 +611,257 @@ func (sd *Sender) beginSend(k string, v []byte) error {
 	if err != nil {
 		return sd.logError(0xE5A04A, err)
 	}
-	sd.dataHash = getHash(v)
-	if sd.Config.VerboseSender {
-		sd.logInfo("\n" + strings.Repeat("-", 80) + "\n" +
-			fmt.Sprintf("Send key: %s size: %d hash: %X",
-				k, len(v), sd.dataHash))
+	return nil
+} //                                                               checkSettings
+
+// setKeys sets the keys of the ciphers used to encrypt packets and
+// decrypt replies. The ciphers are shared by the items being sent,
+// so their keys are only set while holding the mutex.
+func (sd *Sender) setKeys() error {
+	sd.mutex.Lock()
+	defer sd.mutex.Unlock()
+	if sd.Config.Cipher == nil {
+		return sd.logError(0xE83D07, "nil Sender.Config.Cipher")
 	}
-	comp, err := sd.Config.Compressor.Compress(v)
+	err := sd.Config.Cipher.SetKey(sd.CryptoKey)
 	if err != nil {
-		return sd.logError(0xE2EB59, err)
+		return sd.logError(0xE02D7B, "invalid Sender.CryptoKey:", err)
+	}
+	if len(sd.ReplyCryptoKey) > 0 {
+		if sd.Config.ReplyCipher == nil {
+			return sd.logError(0xEDFE43, "nil Sender.Config.ReplyCipher")
+		}
+		err = sd.Config.ReplyCipher.SetKey(sd.ReplyCryptoKey)
+		if err != nil {
+			return sd.logError(0xE706D9, "invalid Sender.ReplyCryptoKey:", err)
+		}
 	}
-	sd.startTime = time.Now()
-	err = sd.makePackets(k, comp)
+	enc, err := sd.Config.Cipher.Encrypt(nil)
 	if err != nil {
-		return err
+		return sd.logError(0xEDBCFD, err)
 	}
+	sd.cipherOverhead = len(enc)
 	return nil
-} //                                                                   beginSend
+} //                                                                     setKeys
 
-// makePackets creates the packets for sending over UDP,
-// by partitioning compressed message 'comp'
-func (sd *Sender) makePackets(k string, comp []byte) error {
+// makePackets creates the packets of data item 'it' for
+// sending over UDP, by partitioning compressed message 'comp'
+func (sd *Sender) makePackets(it *senderItem, comp []byte) error {
 	length := len(comp)
 	if length == 0 {
-		sd.packets = nil
+		it.packets = nil
 		return nil
 	}
-	max := sd.Config.PacketPayloadSize
+	flags := ""
+	if it.batch {
+		flags = " batch:1"
+	}
+	flags += " flow:1" // the Sender respects the Receiver's window
+	makeHeader := func(sn, count int) string {
+		return tagFragment + fmt.Sprintf(
+			"key:%s id:%s hash:%X%s sn:%d count:%d\n",
+			it.key, it.messageID, it.dataHash, flags, sn, count,
+		)
+	}
+	// there can't be more packets than bytes,
+	// so 'length' bounds the size of the header
+	max := sd.fragmentPayloadSize(len(makeHeader(length, length)))
+	if max < 1 {
+		return sd.logError(0xE2A1F4,
+			"key and message ID too long for Config.PacketSizeLimit")
+	}
 	n := length / max
 	if (n * max) < length {
 		n++
 	}
 	packets := make([]senderPacket, n)
+	indexes := make(map[string]int, n)
 	for i := range packets {
 		a := i * max
 		b := a + max
 		if b > len(comp) {
 			b = len(comp)
 		}
-		header := tagFragment + fmt.Sprintf(
-			"key:%s hash:%X sn:%d count:%d\n",
-			k, sd.dataHash, i+1, n,
-		)
+		header := makeHeader(i+1, n)
 		pk, err := sd.makePacket(append([]byte(header), comp[a:b]...))
 		if err != nil {
 			return sd.logError(0xE567A4, err)
 		}
 		packets[i] = *pk
+		indexes[string(pk.sentHash)] = i
+	}
+	it.packets = packets
+	it.indexes = indexes
+	if min := sd.Config.ResumeMinFragments; sd.Config.ResumeTransfers &&
+		min > 0 && n >= min {
+		it.query = []byte(tagQuery + fmt.Sprintf(
+			"key:%s id:%s hash:%X count:%d\n",
+			it.key, it.messageID, it.dataHash, n,
+		))
+		it.queryHash = getHash(it.query)
 	}
-	sd.packets = packets
 	return nil
 } //                                                                 makePackets
 
+// deliverPackets sends the packets of data item 'it' to the Receiver,
+// resending undelivered packets until all are confirmed, the item is
+// rejected, or Config.SendRetries is exhausted.
+//
+// Before sending a resumable item, it asks the Receiver which packets it
+// already has (see queryReceiver), and only sends the other packets.
+//
+func (sd *Sender) deliverPackets(
+	it *senderItem,
+	connect func() (netUDPConn, error),
+	sendUndeliveredPackets func(it *senderItem) error,
+) error {
+	err := sd.startItem(it, connect)
+	if err != nil {
+		return sd.logError(0xE8B8D0, err)
+	}
+	defer sd.finishItem(it)
+	cookieRetried := false
+	for retries := 0; retries < sd.Config.SendRetries; retries++ {
+		if it.query != nil && !it.isQueryAnswered() {
+			sd.queryReceiver(it)
+		}
+		err = sendUndeliveredPackets(it)
+		if err != nil {
+			return sd.logError(0xE23CE0, err)
+		}
+		sd.waitForAllConfirmations(it)
+		if it.DeliveredAllParts() || it.rejectionReason() != "" ||
+			it.isClosed() {
+			break
+		}
+		// resend right away with a new cookie; the first
+		// time this happens, it doesn't count as a retry
+		if it.takeNewCookie() {
+			if !cookieRetried {
+				cookieRetried = true
+				retries--
+			}
+			continue
+		}
+		time.Sleep(sd.Config.SendRetryInterval)
+	}
+	return sd.endSend(it)
+} //                                                              deliverPackets
+
+// startItem adds data item 'it' to the items being sent over the
+// Sender's connection, which it opens with 'connect' if needed
+// (see useConnection).
+func (sd *Sender) startItem(it *senderItem, connect func() (netUDPConn, error),
+) error {
+	sd.mutex.Lock()
+	defer sd.mutex.Unlock()
+	conn, err := sd.useConnection(connect)
+	if err != nil {
+		return err
+	}
+	it.conn = conn
+	it.ctx, it.cancel = context.WithCancel(context.Background())
+	sd.items = append(sd.items, it)
+	return nil
+} //                                                                   startItem
+
+// useConnection returns the Sender's connection. If there's no connection,
+// it first opens one with 'connect', starts receiving replies from the
+// Receiver and, if Config.KeepaliveInterval is set, starts keepalives.
+//
+// If no items are being sent, and the last item was not delivered,
+// the connection is older than Config.ConnectionTTL, or Address or Config
+// have changed, it closes the connection and opens a new one, so the
+// address is resolved again.
+//
+// It also starts path MTU discovery, if it's enabled and the payload
+// size for Address is not known (see startPathMTUDiscovery).
+//
+// The caller must hold the mutex.
+//
+func (sd *Sender) useConnection(connect func() (netUDPConn, error)) (
+	netUDPConn, error,
+) {
+	ttl := sd.Config.ConnectionTTL
+	if sd.conn != nil && len(sd.items) == 0 &&
+		(sd.connFailed || sd.connAddress != sd.Address ||
+			sd.connConfig != sd.Config ||
+			ttl > 0 && time.Since(sd.connTime) >= ttl) {
+		_ = sd.close()
+	}
+	if sd.conn == nil {
+		conn, err := connect()
+		if err != nil {
+			return nil, err
+		}
+		sd.conn = conn
+		sd.connAddress = sd.Address
+		sd.connConfig = sd.Config
+		sd.connTime = time.Now()
+		sd.connFailed = false
+		sd.replyTime = sd.connTime
+		go sd.collectConfirmations(conn) // exits when conn is closed
+		if sd.Config.KeepaliveInterval > 0 {
+			sd.keepaliveStop = make(chan struct{})
+			go sd.keepAlive(conn, sd.keepaliveStop)
+		}
+	}
+	sd.startPathMTUDiscovery()
+	return sd.conn, nil
+} //                                                               useConnection
+
+// finishItem removes data item 'it' from the items being sent. If the
+// item was not delivered (nor rejected), it marks the connection as
+// failed, so it will be replaced.
+func (sd *Sender) finishItem(it *senderItem) {
+	failed := !it.DeliveredAllParts() && it.rejectionReason() == ""
+	sd.mutex.Lock()
+	defer sd.mutex.Unlock()
+	for i, item := range sd.items {
+		if item == it {
+			sd.items = append(sd.items[:i], sd.items[i+1:]...)
+			break
+		}
+	}
+	sd.lastItem = it
+	if failed && it.conn == sd.conn {
+		sd.connFailed = true
+	}
+} //                                                                  finishItem
+
+// queryReceiver sends the query packet to ask the Receiver which packets
+// of data item 'it' it already has, for example from a transfer that
+// was interrupted, then waits up to Config.SendRetryInterval for the
+// reply, which receiveHave() handles. If the Receiver replies with
+// a new cookie, sends the query again with the cookie.
+//
+// Receivers that don't support queries reply with an error, which is
+// ignored, so all the packets are sent as usual.
+//
+func (sd *Sender) queryReceiver(it *senderItem) {
+	for attempt := 0; attempt < 2; attempt++ {
+		it.takeNewCookie()
+		pk, err := sd.makePacket(it.query)
+		if err == nil {
+			err = pk.SendWithPrefix(it.conn, sd.Config.Cipher,
+				sd.packetPrefix())
+		}
+		if err != nil {
+			_ = sd.logError(0xE97CD1, err)
+			return
+		}
+		t0 := time.Now()
+		for !it.isQueryAnswered() && !it.hasNewCookie() &&
+			it.rejectionReason() == "" &&
+			time.Since(t0) < sd.Config.SendRetryInterval {
+			time.Sleep(sd.Config.SendWaitInterval)
+		}
+		if !it.hasNewCookie() {
+			return
+		}
+	}
+} //                                                               queryReceiver
+
 // connect connects to the Receiver at Sender.Address and
 // returns a new UDP connection or nil and an error instance.
 //
@@ -431,6 +876,9 @@ func (sd *Sender) connect() (netUDPConn, error) {
 func (sd *Sender) connectDI(
 	netDialUDP func(_ string, _, _ *net.UDPAddr) (netUDPConn, error),
 ) (netUDPConn, error) {
+	if sd.Config.Transport != nil {
+		return sd.dialTransport()
+	}
 	udpAddr, err := net.ResolveUDPAddr("udp", sd.Address)
 	if err != nil {
 		return nil, sd.logError(0xEC7C6B, "ResolveUDPAddr:", err)
@@ -444,48 +892,156 @@ func (sd *Sender) connectDI(
 	if err != nil {
 		return nil, sd.logError(0xE5F9C7, err)
 	}
+	sd.setDontFragment(conn)
 	return conn, nil
 } //                                                                   connectDI
 
+// dialTransport returns a connection to the Receiver at
+// Sender.Address, created by Config.Transport.DialPacket().
+func (sd *Sender) dialTransport() (netUDPConn, error) {
+	pc, raddr, err := sd.Config.Transport.DialPacket(sd.Address)
+	if err != nil {
+		return nil, sd.logError(0xE8F33B, err)
+	}
+	if pc == nil || raddr == nil {
+		if pc != nil {
+			_ = pc.Close()
+		}
+		return nil, sd.logError(0xEB0441,
+			"nil connection or address from Transport")
+	}
+	conn := newPacketConnAdapter(pc, raddr)
+	err = conn.SetWriteBuffer(sd.Config.SendBufferSize)
+	if err != nil {
+		_ = conn.Close()
+		return nil, sd.logError(0xEACA72, err)
+	}
+	sd.setDontFragment(pc)
+	return conn, nil
+} //                                                               dialTransport
+
 // sendUndeliveredPackets sends all undelivered
-// packets to the destination Receiver.
-func (sd *Sender) sendUndeliveredPackets() error {
-	var wg sync.WaitGroup
-	n := len(sd.packets)
-	for i := 0; i < n; i++ {
-		pk := &sd.packets[i]
-		if pk.IsDelivered() {
+// packets of data item 'it' to the destination Receiver.
+//
+// Each packet waits for room in the Receiver's window (see waitForWindow),
+// then for its turn in the Sender's scheduler, which interleaves the
+// packets of the items being sent by priority. The turn is held for
+// Config.SendPacketInterval after sending.
+//
+func (sd *Sender) sendUndeliveredPackets(it *senderItem) error {
+	prefix := sd.packetPrefix()
+	it.startRound(time.Now())
+	for i := range it.packets {
+		it.mutex.Lock()
+		pk := &it.packets[i]
+		delivered := pk.IsDelivered()
+		it.mutex.Unlock()
+		if delivered {
 			continue
 		}
-		time.Sleep(sd.Config.SendPacketInterval)
-		wg.Add(1)
-		go func() {
-			err := pk.Send(sd.conn, sd.Config.Cipher)
-			if err != nil {
-				_ = sd.logError(0xE67BA4, err)
-			}
-			wg.Done()
-		}()
+		if !sd.waitForWindow(it) {
+			break // the rest are sent in the next round
+		}
+		if sd.scheduler.acquire(it.ctx, it.priority, len(pk.data)) != nil {
+			break // the connection was closed
+		}
+		// the packet may have been confirmed while waiting
+		it.mutex.Lock()
+		delivered = pk.IsDelivered()
+		var err error
+		if !delivered {
+			err = pk.SendWithPrefix(it.conn, sd.Config.Cipher, prefix)
+			it.markSent(i)
+		}
+		it.mutex.Unlock()
+		if err != nil {
+			_ = sd.logError(0xE67BA4, err)
+		}
+		if !delivered {
+			time.Sleep(sd.Config.SendPacketInterval)
+		}
+		sd.scheduler.release()
 	}
-	wg.Wait()
 	return nil
 } //                                                      sendUndeliveredPackets
 
-// collectConfirmations enters a loop that receives confirmation packets
-// from the sender, and marks all confirmed packets as delivered.
-func (sd *Sender) collectConfirmations() {
+// waitForWindow waits until another packet of data item 'it' can be sent
+// without exceeding the window advertised by the Receiver, and returns
+// true. Returns false if the window stays full for Config.ReplyTimeout.
+//
+// While the window is zero and no packets are in flight, no confirmation
+// will arrive to open it, so every Config.SendRetryInterval it returns
+// true to send one packet as a probe. The Receiver replies to the probe
+// with its current window.
+//
+func (sd *Sender) waitForWindow(it *senderItem) bool {
+	for {
+		open, inFlight, since := it.windowState(time.Now())
+		if open {
+			return true
+		}
+		if since >= sd.Config.ReplyTimeout {
+			return false
+		}
+		if inFlight == 0 && since >= sd.Config.SendRetryInterval {
+			if sd.Config.VerboseSender {
+				sd.logInfo("Probing zero window of", it.key)
+			}
+			return true
+		}
+		if it.rejectionReason() != "" || it.hasNewCookie() || it.isClosed() {
+			return false
+		}
+		time.Sleep(sd.Config.SendWaitInterval)
+	}
+} //                                                               waitForWindow
+
+// collectConfirmations enters a loop that receives replies from the
+// Receiver through connection 'conn', and passes each reply to the
+// data item to which it belongs, until the connection is closed.
+func (sd *Sender) collectConfirmations(conn netUDPConn) {
 	encReply := make([]byte, sd.Config.PacketSizeLimit)
-	for sd.conn != nil {
+	for {
 		// 'encReply' is overwritten after every readAndDecrypt
-		recv, addr, err := readAndDecrypt(sd.conn, sd.Config.ReplyTimeout,
-			sd.Config.Cipher, encReply)
+		recv, addr, err := readAndDecrypt(conn, sd.Config.ReplyTimeout,
+			sd.replyCipher(), encReply)
 		if err == errClosed {
 			break
 		}
+		if err == errTimeout {
+			continue
+		}
 		if err != nil {
 			_ = sd.logError(0xE9D1CC, err)
 			continue
 		}
+		sd.mutex.Lock()
+		sd.replyTime = time.Now()
+		sd.mutex.Unlock()
+		if bytes.HasPrefix(recv, []byte(tagRejection)) {
+			sd.receiveRejection(recv)
+			continue
+		}
+		if bytes.HasPrefix(recv, []byte(tagCookie)) {
+			sd.receiveCookie(recv)
+			continue
+		}
+		if bytes.HasPrefix(recv, []byte(tagHave)) {
+			sd.receiveHave(recv)
+			continue
+		}
+		if bytes.HasPrefix(recv, []byte(tagWindow)) {
+			sd.receiveRefusal(recv)
+			continue
+		}
+		if bytes.HasPrefix(recv, []byte(tagProbeAck)) {
+			sd.receiveProbeAck(recv)
+			continue
+		}
+		if bytes.HasPrefix(recv, []byte(tagPong)) {
+			sd.receivePong(recv)
+			continue
+		}
 		if !bytes.HasPrefix(recv, []byte(tagConfirmation)) {
 			_ = sd.logError(0xE96D3B, "bad reply header")
 			if sd.Config.VerboseSender {
@@ -493,34 +1049,132 @@ func (sd *Sender) collectConfirmations() {
 			}
 			continue
 		}
-		confirmedHash := recv[len(tagConfirmation):]
 		if sd.Config.VerboseSender {
 			sd.logInfo("Sender received", len(recv), "bytes from", addr)
 		}
-		go func(confirmedHash []byte) {
-			for i, pk := range sd.packets {
-				if bytes.Equal(pk.sentHash, confirmedHash) {
-					sd.packets[i].confirmedTime = time.Now()
-					sd.packets[i].confirmedHash = confirmedHash
-					break
-				}
+		confirmedHash, window := splitWindow(recv[len(tagConfirmation):])
+		now := time.Now()
+		for _, it := range sd.activeItems() {
+			if it.confirm(confirmedHash, window, now) {
+				break
 			}
-		}(confirmedHash)
+		}
 	}
 } //                                                        collectConfirmations
 
-// waitForAllConfirmations waits for all confirmation packets to
-// be received from the receiver. Since UDP packet delivery is not
-// guaranteed, some confirmations may not be received. This method
+// activeItems returns a copy of the list of data items being sent
+func (sd *Sender) activeItems() []*senderItem {
+	sd.mutex.Lock()
+	defer sd.mutex.Unlock()
+	return append([]*senderItem{}, sd.items...)
+} //                                                                 activeItems
+
+// receiveCookie handles a tagCookie packet from the Receiver, sent when it
+// has not yet validated this Sender's address. Stores the cookie, which
+// will be echoed in all packets sent to the Receiver from now on, and
+// makes all the items being sent resend their packets with it.
+func (sd *Sender) receiveCookie(recv []byte) {
+	cookie := recv[len(tagCookie):]
+	if len(cookie) != cookieSize {
+		_ = sd.logError(0xEA6DE8, "bad cookie reply")
+		return
+	}
+	sd.mutex.Lock()
+	defer sd.mutex.Unlock()
+	if bytes.Equal(cookie, sd.cookie) {
+		return
+	}
+	sd.cookie = append([]byte{}, cookie...)
+	for _, it := range sd.items {
+		it.setNewCookie()
+	}
+	if sd.Config.VerboseSender {
+		sd.logInfo("Sender received address validation cookie")
+	}
+} //                                                               receiveCookie
+
+// receiveRejection handles a tagRejection packet from the Receiver. If the
+// rejected packet belongs to an item being sent, sets the item's rejection
+// to make Send() stop retrying and return the Receiver's reason.
+func (sd *Sender) receiveRejection(recv []byte) {
+	recv = recv[len(tagRejection):]
+	if len(recv) < 32 {
+		_ = sd.logError(0xE69ABD, "bad rejection reply")
+		return
+	}
+	rejectedHash, reason := recv[:32], string(recv[32:])
+	if reason == "" {
+		reason = "no reason given"
+	}
+	for _, it := range sd.activeItems() {
+		if it.reject(rejectedHash, reason) {
+			break
+		}
+	}
+	if sd.Config.VerboseSender {
+		sd.logInfo("Receiver rejected item:", reason)
+	}
+} //                                                            receiveRejection
+
+// receiveRefusal handles a tagWindow packet from the Receiver, sent when
+// it had no room to store a packet. Sets the Receiver's window for the
+// item to which the packet belongs, so sendUndeliveredPackets() waits.
+func (sd *Sender) receiveRefusal(recv []byte) {
+	refusedHash, window := splitWindow(recv[len(tagWindow):])
+	now := time.Now()
+	for _, it := range sd.activeItems() {
+		if it.refuse(refusedHash, window, now) {
+			break
+		}
+	}
+	if sd.Config.VerboseSender {
+		sd.logInfo("Receiver has no room, window:", window)
+	}
+} //                                                              receiveRefusal
+
+// receiveHave handles a tagHave packet from the Receiver, sent in reply
+// to the query packet of an item (see queryReceiver). Marks the packets
+// which the Receiver already has as delivered, so they won't be sent.
+func (sd *Sender) receiveHave(recv []byte) {
+	recv = recv[len(tagHave):]
+	if len(recv) < 32 {
+		_ = sd.logError(0xE9C74B, "bad have reply")
+		return
+	}
+	for _, it := range sd.activeItems() {
+		if !bytes.Equal(recv[:32], it.queryHash) {
+			continue
+		}
+		indexes, err := decodeRanges(string(recv[32:]), len(it.packets))
+		if err != nil {
+			_ = sd.logError(0xE530ED, err)
+			return
+		}
+		it.markHave(indexes, time.Now())
+		if sd.Config.VerboseSender {
+			sd.logInfo("Receiver already has", len(indexes), "of",
+				len(it.packets), "packets of", it.key)
+		}
+		return
+	}
+	// otherwise, it's a late reply about an item that's no longer sent
+} //                                                                 receiveHave
+
+// waitForAllConfirmations waits for all confirmation packets of data
+// item 'it' to be received from the receiver. Since UDP packet delivery
+// is not guaranteed, some confirmations may not be received. This method
 // will only wait for the duration specified in Config.ReplyTimeout.
-func (sd *Sender) waitForAllConfirmations() {
+func (sd *Sender) waitForAllConfirmations(it *senderItem) {
 	if sd.Config.VerboseSender {
 		sd.logInfo("Waiting . . .")
 	}
 	t0 := time.Now()
 	for {
 		time.Sleep(sd.Config.SendWaitInterval)
-		if sd.DeliveredAllParts() {
+		if it.rejectionReason() != "" || it.hasNewCookie() || it.isClosed() {
+			break
+		}
+		if it.DeliveredAllParts() {
 			if sd.Config.VerboseSender {
 				sd.logInfo("Delivered all packets")
 			}
@@ -533,35 +1187,64 @@ func (sd *Sender) waitForAllConfirmations() {
 			break
 		}
 	}
-	for _, pk := range sd.packets {
+	var stats udpStats
+	it.mutex.Lock()
+	for _, pk := range it.packets {
 		if pk.IsDelivered() {
-			sd.stats.bytesDelivered += int64(len(pk.data))
-			sd.stats.packetsDelivered++
+			stats.bytesDelivered += int64(len(pk.data))
+			stats.packetsDelivered++
 		} else {
-			sd.stats.bytesLost += int64(len(pk.data))
-			sd.stats.packetsLost++
+			stats.bytesLost += int64(len(pk.data))
+			stats.packetsLost++
 		}
 	}
+	it.mutex.Unlock()
+	sd.mutex.Lock()
+	sd.stats.bytesDelivered += stats.bytesDelivered
+	sd.stats.packetsDelivered += stats.packetsDelivered
+	sd.stats.bytesLost += stats.bytesLost
+	sd.stats.packetsLost += stats.packetsLost
+	sd.mutex.Unlock()
 	if sd.Config.VerboseSender {
 		sd.logInfo("Waited:", time.Since(t0))
 	}
 } //                                                     waitForAllConfirmations
 
-// close closes the UDP connection.
-func (sd *Sender) close() {
+// close closes the UDP connection and stops its keepalives. The items
+// being sent over it stop without retrying, and fail.
+// The caller must hold the mutex.
+func (sd *Sender) close() error {
 	if sd.conn == nil {
-		return
+		return nil
+	}
+	if sd.keepaliveStop != nil {
+		close(sd.keepaliveStop)
+		sd.keepaliveStop = nil
+	}
+	// stop sending the items at once, instead of retrying
+	for _, it := range sd.items {
+		if it.conn == sd.conn {
+			it.markClosed()
+		}
 	}
 	err := sd.conn.Close()
 	sd.conn = nil
 	if err != nil {
-		_ = sd.logError(0xEA7D7E, err)
+		return sd.logError(0xEA7D7E, err)
 	}
+	return nil
 } //                                                                       close
 
-// endSend finializes Send() by checking if the message was delivered
-func (sd *Sender) endSend() error {
-	if !sd.DeliveredAllParts() {
+// endSend finializes Send() by checking if data item 'it' was delivered
+func (sd *Sender) endSend(it *senderItem) error {
+	if reason := it.rejectionReason(); reason != "" {
+		err := sd.logError(0xEC9C46, "rejected by Receiver:", reason)
+		return &rejectedError{err: err, rejection: &RejectionError{reason}}
+	}
+	if !it.DeliveredAllParts() {
+		if it.isClosed() {
+			return sd.logError(0xE96C13, "connection closed by Close()")
+		}
 		return sd.logError(0xE1C3A7, "undelivered packets")
 	}
 	if sd.Config.VerboseSender {
@@ -570,6 +1253,390 @@ func (sd *Sender) endSend() error {
 	return nil
 } //                                                                     endSend
 
+// -----------------------------------------------------------------------------
+// # Batching (sd *Sender)
+
+// sendBatched adds key 'k' and value 'v' to the open batch, or opens a new
+// batch, and returns the result of sending the batch. The batch is sent
+// when the next item doesn't fit in the payload size (see payloadSize),
+// or Config.BatchLinger after it was opened, by the call that opened it.
+//
+// Each item in the batch is delivered to the Receiver's Handler (or
+// Receive callback) separately. See Receiver.deliverBatch().
+//
+func (sd *Sender) sendBatched(k string, v []byte,
+	connect func() (netUDPConn, error),
+	sendUndeliveredPackets func(it *senderItem) error,
+) error {
+	rec := makeBatchRecord(k, v)
+	max := sd.payloadSize()
+	sd.mutex.Lock()
+	b := sd.batch
+	if b != nil && len(b.data)+len(rec) > max {
+		sd.sealBatch(b)
+		b = nil
+	}
+	opened := b == nil
+	if opened {
+		b = &senderBatch{
+			sealed: make(chan struct{}),
+			done:   make(chan struct{}),
+		}
+		sd.batch = b
+		b.timer = time.AfterFunc(sd.Config.BatchLinger, func() {
+			sd.mutex.Lock()
+			defer sd.mutex.Unlock()
+			sd.sealBatch(b)
+		})
+	}
+	b.data = append(b.data, rec...)
+	b.count++
+	if len(b.data) == max {
+		sd.sealBatch(b)
+	}
+	sd.mutex.Unlock()
+	if !opened {
+		<-b.done
+		return b.err
+	}
+	<-b.sealed
+	b.err = sd.sendBatch(b, connect, sendUndeliveredPackets)
+	close(b.done)
+	return b.err
+} //                                                                 sendBatched
+
+// sealBatch closes batch 'b' to new items, if it's the open batch.
+// The caller must hold the mutex.
+func (sd *Sender) sealBatch(b *senderBatch) {
+	if sd.batch != b {
+		return // already sealed
+	}
+	sd.batch = nil
+	b.timer.Stop()
+	close(b.sealed)
+} //                                                                   sealBatch
+
+// sendBatch sends the items in sealed batch 'b' to the
+// Receiver, as a single data item with key batchKey.
+func (sd *Sender) sendBatch(b *senderBatch,
+	connect func() (netUDPConn, error),
+	sendUndeliveredPackets func(it *senderItem) error,
+) error {
+	if sd.Config.VerboseSender {
+		sd.logInfo("Sending batch of", b.count, "items")
+	}
+	it, err := sd.beginItem(&senderItem{key: batchKey, batch: true}, b.data)
+	if err != nil {
+		return err
+	}
+	return sd.deliverPackets(it, connect, sendUndeliveredPackets)
+} //                                                                   sendBatch
+
+// -----------------------------------------------------------------------------
+// # Path MTU Discovery (sd *Sender)
+
+// payloadSize returns the size of the payload of each packet: the size
+// found by path MTU discovery for Address, if it's enabled and the size
+// is known, or Config.PacketPayloadSize otherwise.
+func (sd *Sender) payloadSize() int {
+	base := sd.Config.PacketPayloadSize
+	if !sd.Config.PathMTUDiscovery {
+		return base
+	}
+	max := sd.Config.PacketSizeLimit - maxPacketOverhead
+	n, ok := sd.pathMTU.get(sd.Address, base, max,
+		sd.Config.PathMTUTTL, time.Now())
+	if !ok {
+		return base
+	}
+	return n
+} //                                                                 payloadSize
+
+// fragmentPayloadSize returns the size of the payload of each fragment
+// whose header has 'header' bytes: payloadSize(), reduced if needed so
+// that the whole packet, with the header, the cookie prefix and the
+// encryption overhead, fits in Config.PacketSizeLimit, and in the path
+// MTU if path MTU discovery found it. Returns less than 1 if there is
+// no room for a payload.
+func (sd *Sender) fragmentPayloadSize(header int) int {
+	overhead := sd.packetOverhead()
+	ret := sd.payloadSize()
+	if ret > sd.Config.PacketPayloadSize {
+		// found by path MTU discovery, with probes that were
+		// ret+maxPacketOverhead bytes long on the wire
+		ret += maxPacketOverhead - overhead - header
+	}
+	if room := sd.Config.PacketSizeLimit - overhead - header; ret > room {
+		ret = room
+	}
+	return ret
+} //                                                         fragmentPayloadSize
+
+// startPathMTUDiscovery starts searching for the largest payload size
+// that reaches Address over the Sender's connection, in the background,
+// unless the search is disabled, is already in progress, or the size is
+// known. The caller must hold the mutex.
+func (sd *Sender) startPathMTUDiscovery() {
+	if !sd.Config.PathMTUDiscovery || sd.conn == nil {
+		return
+	}
+	base := sd.Config.PacketPayloadSize
+	max := sd.Config.PacketSizeLimit - maxPacketOverhead
+	if max <= base || !sd.pathMTU.begin(sd.Address, base, max,
+		sd.Config.PathMTUTTL, time.Now()) {
+		return
+	}
+	go sd.discoverPathMTU(sd.conn, sd.Address, base, max)
+} //                                                       startPathMTUDiscovery
+
+// discoverPathMTU searches for the largest payload size from 'base' to
+// 'max' that reaches 'address' through connection 'conn', and stores
+// it in the Sender's pathMTU cache, so that items sent after the search
+// use it (see payloadSize).
+//
+// It first probes 'max', which usually succeeds on a local network, then
+// does a binary search, until the largest size acknowledged and the
+// smallest size lost are less than pathMTUStep bytes apart. 'base' is
+// not probed: if nothing larger is acknowledged, the search ends with
+// Config.PacketPayloadSize. If the connection is replaced or closed, the
+// search is abandoned, and starts again with the next connection.
+//
+func (sd *Sender) discoverPathMTU(conn netUDPConn, address string,
+	base, max int,
+) {
+	lo, hi := base, max // the largest sizes acknowledged and not yet lost
+	for n := max; ; n = (lo + hi + 1) / 2 {
+		acked := sd.probePath(conn, n)
+		sd.mutex.Lock()
+		replaced := sd.conn != conn
+		sd.mutex.Unlock()
+		if replaced {
+			sd.pathMTU.end(address, 0, base, max, time.Now())
+			return
+		}
+		if acked {
+			lo = n
+		} else {
+			hi = n - 1
+		}
+		if hi-lo < pathMTUStep {
+			break
+		}
+	}
+	sd.pathMTU.end(address, lo, base, max, time.Now())
+	if sd.Config.VerboseSender {
+		sd.logInfo("Path MTU discovery for", address, "found payload size",
+			lo)
+	}
+} //                                                             discoverPathMTU
+
+// probePath sends a padded tagProbe packet through 'conn', which is just
+// as large as a packet with a payload of 'payload' bytes can be, and
+// returns true if the Receiver acknowledges it. The probe is sent up to
+// pathMTUProbes times, waiting Config.SendRetryInterval for each reply.
+//
+// Returns false at once if the probe can't be sent: with the "don't
+// fragment" flag set, the operating system fails to send packets that
+// are larger than the path MTU it knows about.
+//
+func (sd *Sender) probePath(conn netUDPConn, payload int) bool {
+	for attempt := 0; attempt < pathMTUProbes; attempt++ {
+		// the probe's size on the wire must be payload+maxPacketOverhead,
+		// after the cookie is prepended and the packet is encrypted
+		prefix := sd.packetPrefix()
+		enc, err := sd.Config.Cipher.Encrypt(prefix)
+		if err != nil {
+			_ = sd.logError(0xEDE57C, err)
+			return false
+		}
+		// the probe is only sent with 'prefix', so unlike makePacket(),
+		// there's no need to leave room for a cookie it may get later
+		probe := makeProbe(payload + maxPacketOverhead - len(enc))
+		pk := &senderPacket{data: probe, sentHash: getHash(probe),
+			sentTime: time.Now()}
+		ack := sd.pathMTU.expect(pk.sentHash)
+		err = pk.SendWithPrefix(conn, sd.Config.Cipher, prefix)
+		if err != nil {
+			sd.pathMTU.forget(pk.sentHash)
+			if sd.Config.VerboseSender {
+				sd.logInfo("Probe of", payload, "bytes failed:", err)
+			}
+			return false
+		}
+		select {
+		case <-ack:
+			return true
+		case <-time.After(sd.Config.SendRetryInterval):
+			sd.pathMTU.forget(pk.sentHash)
+		}
+	}
+	return false
+} //                                                                   probePath
+
+// receiveProbeAck handles a tagProbeAck packet from the Receiver, sent
+// in reply to a probe packet (see probePath). Late acknowledgements of
+// probes that are no longer expected are ignored.
+func (sd *Sender) receiveProbeAck(recv []byte) {
+	recv = recv[len(tagProbeAck):]
+	if len(recv) != 32 {
+		_ = sd.logError(0xE715CA, "bad probe reply")
+		return
+	}
+	sd.pathMTU.acknowledge(recv)
+} //                                                             receiveProbeAck
+
+// setDontFragment sets the "don't fragment" flag on connection 'conn',
+// when Config.PathMTUDiscovery is enabled. A failure is only logged,
+// since probes still work, though less precisely, without the flag.
+func (sd *Sender) setDontFragment(conn interface{}) {
+	if !sd.Config.PathMTUDiscovery {
+		return
+	}
+	err := setDontFragment(conn)
+	if err != nil {
+		_ = sd.logError(0xE4C764, err)
+	}
+} //                                                             setDontFragment
+
+// -----------------------------------------------------------------------------
+// # Keepalive (sd *Sender)
+
+// ping sends ping packets to the Receiver through connection 'conn', each
+// with a new ID, every Config.SendRetryInterval until one is answered,
+// and returns the round-trip time of the answered ping (see Ping).
+func (sd *Sender) ping(ctx context.Context, conn netUDPConn) (
+	time.Duration, error,
+) {
+	pong := make(chan time.Duration, 1)
+	var sent []string
+	defer func() {
+		sd.mutex.Lock()
+		for _, hash := range sent {
+			delete(sd.pings, hash)
+		}
+		sd.mutex.Unlock()
+	}()
+	timeout := time.NewTimer(sd.Config.ReplyTimeout)
+	defer timeout.Stop()
+	interval := sd.Config.SendRetryInterval
+	if interval <= 0 {
+		interval = time.Millisecond // NewTicker() needs a positive interval
+	}
+	resend := time.NewTicker(interval)
+	defer resend.Stop()
+	for {
+		id, err := NewMessageID()
+		if err != nil {
+			return 0, sd.logError(0xE0A91F, err)
+		}
+		pk, err := sd.makePacket(makePing(id))
+		if err != nil {
+			return 0, err
+		}
+		sd.mutex.Lock()
+		if sd.pings == nil {
+			sd.pings = make(map[string]*senderPing)
+		}
+		sd.pings[string(pk.sentHash)] = &senderPing{
+			sentTime: time.Now(), pong: pong,
+		}
+		sd.mutex.Unlock()
+		sent = append(sent, string(pk.sentHash))
+		err = pk.SendWithPrefix(conn, sd.Config.Cipher, sd.packetPrefix())
+		if err != nil {
+			return 0, sd.logError(0xE14ED9, err)
+		}
+		select {
+		case rtt := <-pong:
+			return rtt, nil
+		case <-ctx.Done():
+			return 0, sd.logError(0xEE6DD1, ctx.Err())
+		case <-timeout.C:
+			return 0, sd.logError(0xE2B663, "no reply to ping")
+		case <-resend.C:
+		}
+	}
+} //                                                                        ping
+
+// receivePong handles a tagPong packet from the Receiver, sent in reply
+// to a ping packet (see ping). Sends the round-trip time to the waiting
+// ping, if any: late replies to pings that are no longer awaited
+// are ignored.
+func (sd *Sender) receivePong(recv []byte) {
+	recv = recv[len(tagPong):]
+	if len(recv) != 32 {
+		_ = sd.logError(0xE0DF8F, "bad pong reply")
+		return
+	}
+	sd.mutex.Lock()
+	pg := sd.pings[string(recv)]
+	delete(sd.pings, string(recv))
+	sd.mutex.Unlock()
+	if pg == nil {
+		return
+	}
+	select {
+	case pg.pong <- time.Since(pg.sentTime):
+	default: // another ping of the same call was answered first
+	}
+} //                                                                 receivePong
+
+// keepAlive pings the Receiver through connection 'conn' whenever no
+// reply has arrived over it for Config.KeepaliveInterval, until 'stop'
+// is closed (see close).
+//
+// If a ping is not answered, the Receiver is considered unreachable:
+// keepAlive marks the connection as failed, so the next send opens a
+// new one (which starts its own keepalives), reports the Receiver's
+// address to the Unreachable callback, and returns.
+//
+func (sd *Sender) keepAlive(conn netUDPConn, stop chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	interval := sd.Config.KeepaliveInterval
+	for {
+		sd.mutex.Lock()
+		wait := interval - time.Since(sd.replyTime)
+		address := sd.connAddress
+		sd.mutex.Unlock()
+		if wait > 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(wait):
+			}
+			continue
+		}
+		_, err := sd.ping(ctx, conn)
+		if ctx.Err() != nil {
+			return // the connection was closed
+		}
+		if err == nil {
+			continue
+		}
+		sd.mutex.Lock()
+		if sd.conn == conn {
+			sd.connFailed = true
+		}
+		unreachable := sd.Unreachable
+		sd.mutex.Unlock()
+		if sd.Config.VerboseSender {
+			sd.logInfo("Receiver unreachable:", address)
+		}
+		if unreachable != nil {
+			unreachable(address)
+		}
+		return
+	}
+} //                                                                   keepAlive
+
 // -----------------------------------------------------------------------------
 // # Internal Helper Methods (sd *Sender)
 
@@ -591,14 +1658,26 @@ func (sd *Sender) logInfo(a ...interface{}) {
 	}
 } //                                                                     logInfo
 
+// replyCipher returns the cipher used to decrypt replies from the Receiver:
+// Config.ReplyCipher if ReplyCryptoKey is specified, or Config.Cipher.
+func (sd *Sender) replyCipher() SymmetricCipher {
+	if len(sd.ReplyCryptoKey) > 0 {
+		return sd.Config.ReplyCipher
+	}
+	return sd.Config.Cipher
+} //                                                                 replyCipher
+
 // makePacket prepares a packet for immediate sending: it stores,
 // hashes data and sets the packet's sentTime to current time.
 //
-// The size of the packet must not exceed Config.PacketSizeLimit
+// The size of the packet, including the cookie prefix it may be sent
+// with and the encryption overhead, must not exceed Config.PacketSizeLimit
 //
 func (sd *Sender) makePacket(data []byte) (*senderPacket, error) {
-	if len(data) > sd.Config.PacketSizeLimit {
-		return nil, sd.logError(0xE71F9B, "len(data) > Config.PacketSizeLimit")
+	if size := len(data) + sd.packetOverhead(); size >
+		sd.Config.PacketSizeLimit {
+		return nil, sd.logError(0xE71F9B, "packet size", size,
+			"exceeds Config.PacketSizeLimit")
 	}
 	sentHash := getHash(data)
 	pk := senderPacket{
@@ -610,6 +1689,26 @@ func (sd *Sender) makePacket(data []byte) (*senderPacket, error) {
 	return &pk, nil
 } //                                                                  makePacket
 
+// packetOverhead returns the number of bytes by which a packet sent to
+// the Receiver can exceed its data: the cookie prefix, which may be
+// added when the packet is resent, and the encryption overhead.
+func (sd *Sender) packetOverhead() int {
+	sd.mutex.Lock()
+	defer sd.mutex.Unlock()
+	return cookiePrefixSize + sd.cipherOverhead
+} //                                                              packetOverhead
+
+// packetPrefix returns the prefix of every packet sent to the Receiver:
+// tagCookie and the last cookie received, or nil if there's no cookie.
+func (sd *Sender) packetPrefix() []byte {
+	sd.mutex.Lock()
+	defer sd.mutex.Unlock()
+	if len(sd.cookie) == 0 {
+		return nil
+	}
+	return append([]byte(tagCookie), sd.cookie...)
+} //                                                                packetPrefix
+
 // validateAddress returns nil if Address is valid, or an error otherwise.
 // Presently it only checks if the address contains a valid port number.
 func (sd *Sender) validateAddress() error {
@@ -617,14 +1716,31 @@ func (sd *Sender) validateAddress() error {
 	if strings.TrimSpace(ad) == "" {
 		return errors.New("missing Sender.Address")
 	}
-	var port int
-	if i := strings.Index(ad, ":"); i != -1 {
-		port, _ = strconv.Atoi(ad[i+1:])
-	}
-	if port < 1 || port > 65535 {
+	if addressPort(ad) == 0 {
 		return errors.New("invalid port in Sender.Address")
 	}
 	return nil
 } //                                                             validateAddress
 
+// -----------------------------------------------------------------------------
+// # Internal Functions
+
+// splitWindow splits a tagConfirmation or tagWindow 'reply', without its
+// tag, into the hash of the packet and the Receiver's window, which is
+// -1 if the Receiver didn't send it.
+func splitWindow(reply []byte) (hash []byte, window int) {
+	if len(reply) < 32 {
+		return reply, -1
+	}
+	hash, rest := reply[:32], string(reply[32:])
+	if !strings.HasPrefix(rest, "win:") {
+		return reply, -1
+	}
+	window, err := strconv.Atoi(rest[len("win:"):])
+	if err != nil || window < 0 {
+		return hash, -1
+	}
+	return hash, window
+} //                                                                 splitWindow
+
 // end
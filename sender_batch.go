@@ -0,0 +1,101 @@
+// -----------------------------------------------------------------------------
+// github.com/balacode/udpt                                   /[sender_batch.go]
+// (c) balarabe@protonmail.com                                      License: MIT
+// -----------------------------------------------------------------------------
+
+package udpt
+
+// # senderBatch Type
+//   senderBatch struct
+//
+// # Functions
+//   makeBatchRecord(k string, v []byte) []byte
+//   decodeBatch(data []byte) ([]batchMessage, error)
+//   batchMessageID(batchID string, n int) string
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// batchKey is the key of the data items that contain batches of messages
+const batchKey = "batch"
+
+// -----------------------------------------------------------------------------
+// # senderBatch Type
+
+// senderBatch collects small messages sent at about the same time by one
+// Sender, to send them to the Receiver as a single data item. The first
+// message's Send() call sends the batch, once it's sealed: when the next
+// message doesn't fit, or after Config.BatchLinger. The other calls wait
+// until it's sent, and return the same result.
+//
+// All fields except 'err' are guarded by the Sender's mutex. 'err' is
+// set before 'done' is closed, and only read after that.
+//
+type senderBatch struct {
+	data   []byte        // records of the messages (see makeBatchRecord)
+	count  int           // number of messages
+	timer  *time.Timer   // seals the batch after Config.BatchLinger
+	sealed chan struct{} // closed when no more messages can be added
+	done   chan struct{} // closed when the batch has been sent
+	err    error         // the result of sending the batch
+} //                                                                 senderBatch
+
+// batchMessage is a message unpacked from a batch by decodeBatch()
+type batchMessage struct {
+	key   string
+	value []byte
+} //                                                                batchMessage
+
+// -----------------------------------------------------------------------------
+// # Functions
+
+// makeBatchRecord returns the record of key 'k' and value 'v' in a batch:
+// a header line "key:<size> value:<size>\n" followed by the key and value.
+func makeBatchRecord(k string, v []byte) []byte {
+	header := fmt.Sprintf("key:%d value:%d\n", len(k), len(v))
+	ret := make([]byte, 0, len(header)+len(k)+len(v))
+	ret = append(ret, header...)
+	ret = append(ret, k...)
+	return append(ret, v...)
+} //                                                             makeBatchRecord
+
+// decodeBatch returns the messages in the records of batch 'data'
+func decodeBatch(data []byte) ([]batchMessage, error) {
+	var ret []batchMessage
+	for len(data) > 0 {
+		at := bytes.IndexByte(data, '\n')
+		if at == -1 {
+			return nil, makeError(0xEDEF3F, "bad batch record header")
+		}
+		header := string(data[:at+1])
+		ksize, err1 := strconv.Atoi(getPart(header, "key:", " "))
+		vsize, err2 := strconv.Atoi(getPart(header, "value:", "\n"))
+		data = data[at+1:]
+		if err1 != nil || err2 != nil || ksize < 0 || vsize < 0 ||
+			ksize+vsize > len(data) {
+			return nil, makeError(0xE18A09, "bad batch record size")
+		}
+		ret = append(ret, batchMessage{
+			key:   string(data[:ksize]),
+			value: data[ksize : ksize+vsize],
+		})
+		data = data[ksize+vsize:]
+	}
+	if len(ret) == 0 {
+		return nil, makeError(0xEA3A29, "empty batch")
+	}
+	return ret, nil
+} //                                                                 decodeBatch
+
+// batchMessageID returns the message ID of message number 'n' (1-based)
+// in the batch with message ID 'batchID'. The Receiver uses it to deliver
+// each message only once, even if the batch is resent.
+func batchMessageID(batchID string, n int) string {
+	return batchID + "." + strconv.Itoa(n)
+} //                                                              batchMessageID
+
+// end
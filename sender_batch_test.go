@@ -0,0 +1,63 @@
+// -----------------------------------------------------------------------------
+// github.com/balacode/udpt                              /[sender_batch_test.go]
+// (c) balarabe@protonmail.com                                      License: MIT
+// -----------------------------------------------------------------------------
+
+package udpt
+
+import (
+	"fmt"
+	"testing"
+)
+
+// to run all tests in this file:
+// go test -v -run Test_decodeBatch_*
+
+// -----------------------------------------------------------------------------
+
+// makeBatchRecord(k string, v []byte) []byte
+// decodeBatch(data []byte) ([]batchMessage, error)
+//
+// go test -run Test_decodeBatch_*
+
+// must decode the records made by makeBatchRecord
+func Test_decodeBatch_1(t *testing.T) {
+	var data []byte
+	data = append(data, makeBatchRecord("cpu", []byte("42"))...)
+	data = append(data, makeBatchRecord("", []byte("line\nbreak"))...)
+	data = append(data, makeBatchRecord("empty", nil)...)
+	messages, err := decodeBatch(data)
+	if err != nil {
+		t.Fatal("0xE14646", err)
+	}
+	var got string
+	for _, msg := range messages {
+		got += fmt.Sprintf("%q=%q ", msg.key, msg.value)
+	}
+	want := `"cpu"="42" ""="line\nbreak" "empty"="" `
+	if got != want {
+		t.Error("0xEE2A95", "wrong messages:", got)
+	}
+}
+
+// must fail when the records are malformed
+func Test_decodeBatch_2(t *testing.T) {
+	rec := makeBatchRecord("key", []byte("value"))
+	for i, tc := range []struct {
+		data []byte
+		want string
+	}{
+		{nil, "empty batch"},
+		{[]byte("key:3 value:5"), "bad batch record header"},
+		{rec[:len(rec)-1], "bad batch record size"},
+		{[]byte("key:-1 value:0\n"), "bad batch record size"},
+		{[]byte("key:x value:0\n"), "bad batch record size"},
+	} {
+		_, err := decodeBatch(tc.data)
+		if !matchError(err, tc.want) {
+			t.Error("0xEB5828", i, "wrong error:", err)
+		}
+	}
+}
+
+// end
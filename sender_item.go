@@ -0,0 +1,324 @@
+// -----------------------------------------------------------------------------
+// github.com/balacode/udpt                                    /[sender_item.go]
+// (c) balarabe@protonmail.com                                      License: MIT
+// -----------------------------------------------------------------------------
+
+package udpt
+
+// # senderItem Type
+//   senderItem struct
+//
+// # Methods (it *senderItem)
+//   ) DeliveredAllParts() bool
+//   ) clone() *senderItem
+//   ) confirm(hash []byte, window int, now time.Time) bool
+//   ) refuse(hash []byte, window int, now time.Time) bool
+//   ) reject(hash []byte, reason string) bool
+//   ) markHave(indexes []int, now time.Time)
+//   ) rejectionReason() string
+//   ) setNewCookie()
+//   ) hasNewCookie() bool
+//   ) takeNewCookie() bool
+//   ) markClosed()
+//   ) isClosed() bool
+//   ) isQueryAnswered() bool
+//   ) startRound(now time.Time)
+//   ) markSent(index int)
+//   ) windowState(now time.Time) (open bool, inFlight int, . . .
+//   ) setWindow(window int, now time.Time)
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// -----------------------------------------------------------------------------
+// # senderItem Type
+
+// senderItem contains the packets of a data item being sent by a Sender,
+// and the progress of their delivery. A Sender can send several items at
+// the same time over one connection, each with its own senderItem, so
+// replies from the Receiver are matched to items by their hashes.
+//
+// The fields above 'mutex' don't change after the packets are made. The
+// fields below it, and the packets' times and confirmed hashes, change
+// while the item is being sent, so they are guarded by the mutex.
+//
+type senderItem struct {
+
+	// key is the key 'k' of the data item
+	key string
+
+	// messageID is the unique ID of the data item, which the
+	// Receiver uses to recognize an item it has already delivered
+	messageID string
+
+	// batch is set if the data item is a batch of
+	// small items (see Sender.sendBatched)
+	batch bool
+
+	// priority is the priority class of the data item, which
+	// the Sender's scheduler uses to interleave its packets
+	priority Priority
+
+	// dataHash contains the hash of all bytes of the data item
+	dataHash []byte
+
+	// packets contains all the packets of the data item; some of
+	// them may have been delivered, while others may need (re)sending
+	packets []senderPacket
+
+	// indexes maps the sentHash of each packet to its index in packets
+	indexes map[string]int
+
+	// query is the tagQuery packet that asks the Receiver which packets
+	// of the data item it already has, or nil if the item has fewer
+	// than Config.ResumeMinFragments packets
+	query []byte
+
+	// queryHash is the hash of query, which the Receiver's reply contains
+	queryHash []byte
+
+	// startTime is the time the first packet was sent, after
+	// the bytes of the data item have been compressed
+	startTime time.Time
+
+	// conn is the Sender's connection, while the item is being sent
+	conn netUDPConn
+
+	// ctx is cancelled by cancel when the Sender closes conn,
+	// to stop sending the item instead of retrying
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// -------------------------------------------------------------------------
+
+	// mutex guards the fields below and the packets
+	mutex sync.Mutex
+
+	// queryAnswered is set when the Receiver replies to the query
+	queryAnswered bool
+
+	// rejection contains the reason given by the Receiver for rejecting
+	// the data item, or a blank string if it was not rejected
+	rejection string
+
+	// newCookie is set when a new cookie arrives from the Receiver, to
+	// resend undelivered packets right away, prefixed with the cookie
+	newCookie bool
+
+	// hasWindow is set when the Receiver has advertised its window for
+	// the item. Until then, the item's packets are sent without waiting.
+	hasWindow bool
+
+	// window is the number of packets the Receiver last allowed
+	// to be in flight (sent, but not confirmed) for the item
+	window int
+
+	// windowTime is the time the window was last advertised,
+	// or the time the current round of sending started
+	windowTime time.Time
+
+	// inFlight contains the indexes of the packets sent
+	// in the current round, which are not yet confirmed
+	inFlight map[int]bool
+} //                                                                  senderItem
+
+// -----------------------------------------------------------------------------
+// # Methods (it *senderItem)
+
+// DeliveredAllParts returns true if all packets of the data
+// item have been sent, resent if needed, and confirmed.
+func (it *senderItem) DeliveredAllParts() bool {
+	it.mutex.Lock()
+	defer it.mutex.Unlock()
+	for i := range it.packets {
+		if !it.packets[i].IsDelivered() {
+			return false
+		}
+	}
+	return len(it.packets) > 0
+} //                                                           DeliveredAllParts
+
+// clone returns a copy of the item with undelivered copies of its
+// packets, to send the same item to another Receiver.
+func (it *senderItem) clone() *senderItem {
+	it.mutex.Lock()
+	defer it.mutex.Unlock()
+	ret := &senderItem{
+		key:       it.key,
+		messageID: it.messageID,
+		batch:     it.batch,
+		priority:  it.priority,
+		dataHash:  it.dataHash,
+		packets:   make([]senderPacket, len(it.packets)),
+		indexes:   it.indexes,
+		query:     it.query,
+		queryHash: it.queryHash,
+		startTime: it.startTime,
+	}
+	for i, pk := range it.packets {
+		ret.packets[i] = senderPacket{data: pk.data, sentHash: pk.sentHash}
+	}
+	return ret
+} //                                                                       clone
+
+// confirm marks the packet whose sentHash is 'hash' as delivered at time
+// 'now', and sets the Receiver's window, unless 'window' is -1. Returns
+// false if the packet doesn't belong to this item.
+func (it *senderItem) confirm(hash []byte, window int, now time.Time) bool {
+	i, ok := it.indexes[string(hash)]
+	if !ok {
+		return false
+	}
+	it.mutex.Lock()
+	defer it.mutex.Unlock()
+	pk := &it.packets[i]
+	pk.confirmedHash = pk.sentHash
+	pk.confirmedTime = now
+	delete(it.inFlight, i)
+	it.setWindow(window, now)
+	return true
+} //                                                                     confirm
+
+// refuse handles the Receiver's refusal to store the packet whose sentHash
+// is 'hash' for lack of room: the packet is no longer in flight, and the
+// Receiver's window is set. Returns false if the packet doesn't belong
+// to this item.
+func (it *senderItem) refuse(hash []byte, window int, now time.Time) bool {
+	i, ok := it.indexes[string(hash)]
+	if !ok {
+		return false
+	}
+	it.mutex.Lock()
+	defer it.mutex.Unlock()
+	delete(it.inFlight, i)
+	it.setWindow(window, now)
+	return true
+} //                                                                      refuse
+
+// reject sets the item's rejection 'reason', if the rejected packet
+// with hash 'hash' belongs to this item. Otherwise returns false.
+func (it *senderItem) reject(hash []byte, reason string) bool {
+	if _, ok := it.indexes[string(hash)]; !ok {
+		return false
+	}
+	it.mutex.Lock()
+	defer it.mutex.Unlock()
+	it.rejection = reason
+	return true
+} //                                                                      reject
+
+// markHave marks the packets at 'indexes', which the Receiver already
+// has, as delivered at time 'now', and records that the query is answered.
+func (it *senderItem) markHave(indexes []int, now time.Time) {
+	it.mutex.Lock()
+	defer it.mutex.Unlock()
+	for _, i := range indexes {
+		pk := &it.packets[i]
+		pk.confirmedHash = pk.sentHash
+		pk.confirmedTime = now
+	}
+	it.queryAnswered = true
+} //                                                                    markHave
+
+// rejectionReason returns the reason for which the Receiver
+// rejected the item, or a blank string if it wasn't rejected.
+func (it *senderItem) rejectionReason() string {
+	it.mutex.Lock()
+	defer it.mutex.Unlock()
+	return it.rejection
+} //                                                             rejectionReason
+
+// setNewCookie records that a new cookie has arrived from the Receiver.
+func (it *senderItem) setNewCookie() {
+	it.mutex.Lock()
+	defer it.mutex.Unlock()
+	it.newCookie = true
+} //                                                                setNewCookie
+
+// hasNewCookie returns true if a new cookie has arrived from the
+// Receiver, which hasn't been cleared with takeNewCookie().
+func (it *senderItem) hasNewCookie() bool {
+	it.mutex.Lock()
+	defer it.mutex.Unlock()
+	return it.newCookie
+} //                                                                hasNewCookie
+
+// takeNewCookie returns true if a new cookie has arrived
+// since the last call, and clears the indication.
+func (it *senderItem) takeNewCookie() bool {
+	it.mutex.Lock()
+	defer it.mutex.Unlock()
+	ret := it.newCookie
+	it.newCookie = false
+	return ret
+} //                                                               takeNewCookie
+
+// markClosed records that the Sender closed the item's
+// connection, by cancelling the item's context.
+func (it *senderItem) markClosed() {
+	if it.cancel != nil {
+		it.cancel()
+	}
+} //                                                                  markClosed
+
+// isClosed returns true if the Sender closed the item's connection.
+func (it *senderItem) isClosed() bool {
+	return it.ctx != nil && it.ctx.Err() != nil
+} //                                                                    isClosed
+
+// isQueryAnswered returns true if the Receiver replied to the query.
+func (it *senderItem) isQueryAnswered() bool {
+	it.mutex.Lock()
+	defer it.mutex.Unlock()
+	return it.queryAnswered
+} //                                                             isQueryAnswered
+
+// startRound is called before (re)sending the item's undelivered packets
+// at time 'now'. Packets sent in earlier rounds are no longer counted
+// as in flight, since their confirmations are no longer awaited.
+func (it *senderItem) startRound(now time.Time) {
+	it.mutex.Lock()
+	defer it.mutex.Unlock()
+	it.inFlight = make(map[int]bool)
+	it.windowTime = now
+} //                                                                  startRound
+
+// markSent records that the packet at 'index' is in flight.
+// The caller must hold the mutex.
+func (it *senderItem) markSent(index int) {
+	if it.inFlight == nil {
+		it.inFlight = make(map[int]bool)
+	}
+	it.inFlight[index] = true
+} //                                                                    markSent
+
+// windowState returns true if another packet can be sent without
+// exceeding the Receiver's window, the number of packets in flight,
+// and the time elapsed since the window was advertised (or since
+// the round started), at time 'now'.
+func (it *senderItem) windowState(now time.Time) (
+	open bool, inFlight int, since time.Duration,
+) {
+	it.mutex.Lock()
+	defer it.mutex.Unlock()
+	inFlight = len(it.inFlight)
+	open = !it.hasWindow || inFlight < it.window
+	return open, inFlight, now.Sub(it.windowTime)
+} //                                                                 windowState
+
+// setWindow sets the Receiver's window to 'window' packets, advertised
+// at time 'now'. Does nothing if 'window' is -1 (not advertised). The
+// caller must hold the mutex.
+func (it *senderItem) setWindow(window int, now time.Time) {
+	if window < 0 {
+		return
+	}
+	it.hasWindow = true
+	it.window = window
+	it.windowTime = now
+} //                                                                   setWindow
+
+// end
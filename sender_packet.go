@@ -31,13 +31,29 @@ func (pk *senderPacket) IsDelivered() bool {
 
 // Send encrypts and sends this packet through connection 'conn'.
 func (pk *senderPacket) Send(conn netUDPConn, cipher SymmetricCipher) error {
+	return pk.SendWithPrefix(conn, cipher, nil)
+} //                                                                        Send
+
+// SendWithPrefix encrypts and sends this packet through connection 'conn',
+// with 'prefix' inserted before the packet's data. The prefix (e.g. an
+// address validation cookie) is not part of the packet's hash.
+func (pk *senderPacket) SendWithPrefix(
+	conn netUDPConn,
+	cipher SymmetricCipher,
+	prefix []byte,
+) error {
 	if conn == nil {
 		return makeError(0xE4B1BA, "nil connection")
 	}
 	if cipher == nil {
 		return makeError(0xE44F2A, "nil cipher")
 	}
-	ciphertext, err := cipher.Encrypt(pk.data)
+	data := pk.data
+	if len(prefix) > 0 {
+		data = append(append(make([]byte, 0, len(prefix)+len(data)),
+			prefix...), data...)
+	}
+	ciphertext, err := cipher.Encrypt(data)
 	if err != nil {
 		return makeError(0xEB39C3, err)
 	}
@@ -47,6 +63,6 @@ func (pk *senderPacket) Send(conn netUDPConn, cipher SymmetricCipher) error {
 		return makeError(0xE93D1F, err)
 	}
 	return nil
-} //                                                                        Send
+} //                                                              SendWithPrefix
 
 // end
@@ -105,4 +105,28 @@ func Test_senderPacket_Send_6(t *testing.T) {
 	}
 }
 
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+// (pk *senderPacket) SendWithPrefix(
+//     conn netUDPConn, cipher SymmetricCipher, prefix []byte) error
+//
+// go test -run Test_senderPacket_SendWithPrefix_
+//
+func Test_senderPacket_SendWithPrefix_(t *testing.T) {
+	pk := senderPacket{data: []byte("data")}
+	conn := &mockNetUDPConn{}
+	cipher := &aesCipher{}
+	cipher.SetKey([]byte("12345678901234567890123456789012"))
+	err := pk.SendWithPrefix(conn, cipher, []byte("prefix:"))
+	if err != nil {
+		t.Error("0xEFA64C", err)
+	}
+	plaintext, err := cipher.Decrypt(conn.written)
+	if string(plaintext) != "prefix:data" || err != nil {
+		t.Error("0xE9BBF8", "wrong data:", string(plaintext))
+	}
+	if string(pk.data) != "data" {
+		t.Error("0xEE898A", "packet data must not change")
+	}
+}
+
 // end
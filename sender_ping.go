@@ -0,0 +1,38 @@
+// -----------------------------------------------------------------------------
+// github.com/balacode/udpt                                    /[sender_ping.go]
+// (c) balarabe@protonmail.com                                      License: MIT
+// -----------------------------------------------------------------------------
+
+package udpt
+
+// # senderPing Type
+//   senderPing struct
+//
+// # Functions
+//   makePing(id string) []byte
+
+import (
+	"time"
+)
+
+// -----------------------------------------------------------------------------
+// # senderPing Type
+
+// senderPing is a ping packet sent by Sender.Ping() and waiting for the
+// Receiver's tagPong reply. Ping() resends the ping with a new ID until
+// one is answered, and all the pings sent by the same call share the
+// same 'pong' channel, which receives the round-trip time.
+type senderPing struct {
+	sentTime time.Time          // when the ping was sent
+	pong     chan time.Duration // buffered; receives the round-trip time
+} //                                                                  senderPing
+
+// -----------------------------------------------------------------------------
+// # Functions
+
+// makePing returns a tagPing packet with a header line "id:<id>\n"
+func makePing(id string) []byte {
+	return []byte(tagPing + "id:" + id + "\n")
+} //                                                                    makePing
+
+// end
@@ -7,10 +7,19 @@ package udpt
 
 import (
 	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io/ioutil"
 	"net"
+	"os"
 	"strings"
+	"sync"
 	"testing"
 	"time"
+
+	"github.com/balacode/udpt/udptest"
 )
 
 // to run all tests in this file:
@@ -46,8 +55,7 @@ func Test_SendString_(t *testing.T) {
 	cryptoKey := []byte("3z5EdC485Ex9Wy0AsY4Apu6930Bx57Z0")
 	//
 	// set-up and run the receiver
-	received := map[string][]byte{} // collects received keys and values
-	_, rc := makeConfigAndReceiver(cryptoKey, &received)
+	_, rc, td := makeConfigAndReceiver(cryptoKey)
 	go func() { _ = rc.Run() }()
 	defer func() { rc.Stop() }()
 	time.Sleep(200 * time.Millisecond)
@@ -56,13 +64,13 @@ func Test_SendString_(t *testing.T) {
 	if err != nil {
 		t.Error("0xE4A1ED", err)
 	}
-	if len(received) != 1 {
+	td.mu.Lock()
+	if len(td.items) != 1 {
 		t.Error("0xED5B82", err)
 	}
-	for k, v := range received {
-		if k != "_k_" || string(v) != "_v_" {
-			t.Error("0xEE56FE", err)
-		}
+	td.mu.Unlock()
+	if got := td.get("", "_k_"); len(got) != 1 || string(got[0]) != "_v_" {
+		t.Error("0xEE56FE", err)
 	}
 }
 
@@ -131,7 +139,7 @@ func Test_Sender_Send_2(t *testing.T) {
 		return nil, makeError(0xEF2DC4, "failed connect")
 	}
 	sd := makeTestSender()
-	err := sd.sendDI("greeting", []byte("Hello!"),
+	err := sd.sendDI("", "greeting", []byte("Hello!"), PriorityNormal,
 		connect, sd.sendUndeliveredPackets)
 	if !matchError(err, "failed connect") {
 		t.Error("0xEA93AF")
@@ -140,17 +148,48 @@ func Test_Sender_Send_2(t *testing.T) {
 
 // must fail because sendUndeliveredPackets() errored
 func Test_Sender_Send_3(t *testing.T) {
-	sendUndeliveredPackets := func() error {
+	sendUndeliveredPackets := func(it *senderItem) error {
 		return makeError(0xE9AF68, "failed sendUndeliveredPackets")
 	}
 	sd := makeTestSender()
-	err := sd.sendDI("greeting", []byte("Hello!"),
+	err := sd.sendDI("", "greeting", []byte("Hello!"), PriorityNormal,
 		sd.connect, sendUndeliveredPackets)
 	if !matchError(err, "failed sendUndeliveredPackets") {
 		t.Error("0xED9E31")
 	}
 }
 
+// must stop retrying and fail when the Receiver rejects the item
+func Test_Sender_Send_4(t *testing.T) {
+	cryptoKey := []byte("3z5EdC485Ex9Wy0AsY4Apu6930Bx57Z0")
+	cf, rc, td := makeConfigAndReceiver(cryptoKey)
+	rc.Config.MaxFragmentCount = 2
+	cf.PacketPayloadSize = 100
+	go func() { _ = rc.Run() }()
+	defer func() { rc.Stop() }()
+	time.Sleep(200 * time.Millisecond)
+	//
+	sd := Sender{Address: "127.0.0.1:9876", CryptoKey: cryptoKey, Config: cf}
+	v := make([]byte, 1000)
+	_, _ = rand.Read(v) // random bytes don't compress
+	t0 := time.Now()
+	err := sd.Send("big", v)
+	if !matchError(err, "rejected by Receiver: fragment count") {
+		t.Error("0xE194F1", "wrong error:", err)
+	}
+	var rejection *RejectionError
+	if !errors.As(err, &rejection) ||
+		!strings.HasPrefix(rejection.Reason, "fragment count") {
+		t.Error("0xED21AB", "no *RejectionError in:", err)
+	}
+	if time.Since(t0) > cf.ReplyTimeout {
+		t.Error("0xE5CD22", "Send must not wait for all retries")
+	}
+	if len(td.get("", "big")) != 0 {
+		t.Error("0xEAD613")
+	}
+}
+
 // -----------------------------------------------------------------------------
 
 // (sd *Sender) SendString(k, v string) error
@@ -165,6 +204,452 @@ func Test_Sender_SendString_(t *testing.T) {
 	}
 }
 
+// -----------------------------------------------------------------------------
+
+// (sd *Sender) SendWithID(id, k string, v []byte) error
+//
+// go test -run Test_Sender_SendWithID_
+//
+func Test_Sender_SendWithID_(t *testing.T) {
+	nw := udptest.NewNetwork()
+	addrs, td, stop := runTestReceivers(nw, []string{"10.0.0.11"}, nil)
+	defer stop()
+	cf := NewDefaultConfig()
+	cf.Transport = nw.Host("10.0.0.1")
+	sd := Sender{Address: addrs[0], CryptoKey: []byte(testAESKey), Config: cf}
+	//
+	// an item sent again with the same ID must be confirmed, not delivered
+	for i, id := range []string{"id-1", "id-1", "id-2"} {
+		err := sd.SendWithID(id, "k", []byte("v"))
+		if err != nil {
+			t.Error("0xE51316", i, err)
+		}
+	}
+	if got := td.get(addrs[0], "k"); len(got) != 2 {
+		t.Error("0xEF9CF0", "delivered", len(got), "times")
+	}
+	err := sd.SendWithID("", "k", []byte("v"))
+	if !matchError(err, "blank message ID") {
+		t.Error("0xECC3F3", "wrong error:", err)
+	}
+}
+
+// (sd *Sender) SendWithPriority(p Priority, k string, v []byte) error
+//
+// go test -run Test_Sender_SendWithPriority_
+//
+// must deliver a high-priority item while a low-priority item is being sent
+func Test_Sender_SendWithPriority_(t *testing.T) {
+	nw := udptest.NewNetwork()
+	addrs, td, stop := runTestReceivers(nw, []string{"10.0.0.11"}, nil)
+	defer stop()
+	cf := NewDefaultConfig()
+	cf.Transport = nw.Host("10.0.0.1")
+	sd := Sender{Address: addrs[0], CryptoKey: []byte(testAESKey), Config: cf}
+	defer func() { _ = sd.Close() }()
+	//
+	bulk := randomBytes(1, 400*1024) // about 400 packets
+	bulkResult := make(chan error, 1)
+	go func() {
+		bulkResult <- sd.SendWithPriority(PriorityLow, "bulk", bulk)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	urgent := randomBytes(2, 20*1024)
+	err := sd.SendWithPriority(PriorityHigh, "urgent", urgent)
+	if err != nil {
+		t.Error("0xEDD69D", err)
+	}
+	select {
+	case <-bulkResult:
+		t.Error("0xE892CB", "bulk item finished before the urgent item")
+	default:
+	}
+	if err := <-bulkResult; err != nil {
+		t.Error("0xEE6ED5", err)
+	}
+	for k, v := range map[string][]byte{"bulk": bulk, "urgent": urgent} {
+		got := td.get(addrs[0], k)
+		if len(got) != 1 || !bytes.Equal(got[0], v) {
+			t.Error("0xE3B53D", k, "not delivered")
+		}
+	}
+	err = sd.SendWithPriority(Priority(5), "k", []byte("v"))
+	if !matchError(err, "invalid priority: Priority(5)") {
+		t.Error("0xE57416", "wrong error:", err)
+	}
+}
+
+// (sd *Sender) SendAsync(k string, v []byte) <-chan error
+//
+// go test -run Test_Sender_SendAsync_
+//
+// must deliver items sent at the same time over one connection
+func Test_Sender_SendAsync_(t *testing.T) {
+	nw := udptest.NewNetwork()
+	addrs, td, stop := runTestReceivers(nw, []string{"10.0.0.11"}, nil)
+	defer stop()
+	tr := &dialCountingTransport{Transport: nw.Host("10.0.0.1")}
+	cf := NewDefaultConfig()
+	cf.Transport = tr
+	sd := Sender{Address: addrs[0], CryptoKey: []byte(testAESKey), Config: cf}
+	defer func() { _ = sd.Close() }()
+	//
+	const n = 8
+	var (
+		values  [n][]byte
+		results [n]<-chan error
+	)
+	for i := range values {
+		values[i] = randomBytes(int64(10+i), 20*1024)
+		results[i] = sd.SendAsync(fmt.Sprint("k", i), values[i])
+	}
+	for i := range results {
+		if err := <-results[i]; err != nil {
+			t.Error("0xE8F9BD", i, err)
+		}
+		got := td.get(addrs[0], fmt.Sprint("k", i))
+		if len(got) != 1 || !bytes.Equal(got[0], values[i]) {
+			t.Error("0xE8B5B5", i, "item not delivered")
+		}
+	}
+	// the connection must be kept for the next item
+	if err := <-sd.SendAsync("last", []byte("v")); err != nil {
+		t.Error("0xEEBF7D", err)
+	}
+	if dials := tr.count(); dials != 1 {
+		t.Error("0xEB8B6F", "connected", dials, "times")
+	}
+}
+
+// (sd *Sender) Send(k string, v []byte) error
+//
+// go test -run Test_Sender_Send_Batch_
+//
+// must send small items in batches when Config.BatchLinger is set,
+// and deliver each item separately
+func Test_Sender_Send_Batch_(t *testing.T) {
+	nw := udptest.NewNetwork()
+	addrs, td, stop := runTestReceivers(nw, []string{"10.0.0.11"}, nil)
+	defer stop()
+	tr := udptest.ImpairTransport(nw.Host("10.0.0.1"), udptest.Impairment{})
+	cf := NewDefaultConfig()
+	cf.Transport = tr
+	cf.BatchLinger = 100 * time.Millisecond
+	sd := Sender{Address: addrs[0], CryptoKey: []byte(testAESKey), Config: cf}
+	defer func() { _ = sd.Close() }()
+	//
+	const n = 20
+	var results [n]<-chan error
+	for i := range results {
+		results[i] = sd.SendAsync(fmt.Sprint("k", i), []byte(fmt.Sprint(i)))
+	}
+	big := randomBytes(7, 2000) // doesn't fit in a batch
+	if err := sd.Send("big", big); err != nil {
+		t.Error("0xE77E45", err)
+	}
+	for i := range results {
+		if err := <-results[i]; err != nil {
+			t.Error("0xE2CC01", i, err)
+		}
+		got := td.get(addrs[0], fmt.Sprint("k", i))
+		if len(got) != 1 || string(got[0]) != fmt.Sprint(i) {
+			t.Error("0xE33543", i, "wrong delivery:", got)
+		}
+	}
+	if got := td.get(addrs[0], "big"); len(got) != 1 {
+		t.Error("0xEB89BB", "big item not delivered")
+	}
+	// the batch and the big item's 2 fragments,
+	// and perhaps a few more resends
+	if got := tr.Stats().Packets; got > 10 {
+		t.Error("0xE22D7E", "sent", got, "packets")
+	}
+}
+
+// (sd *Sender) Close() error
+//
+// go test -run Test_Sender_Close_
+//
+// must open a new connection after Close(), after Config.ConnectionTTL,
+// after Address changes and after a failed send, but not otherwise
+func Test_Sender_Close_(t *testing.T) {
+	nw := udptest.NewNetwork()
+	ips := []string{"10.0.0.11", "10.0.0.12"}
+	addrs, _, stop := runTestReceivers(nw, ips, nil)
+	defer func() { stop() }()
+	tr := &dialCountingTransport{Transport: nw.Host("10.0.0.1")}
+	cf := NewDefaultConfig()
+	cf.Transport = tr
+	cf.ConnectionTTL = 300 * time.Millisecond
+	cf.ReplyTimeout = 100 * time.Millisecond
+	cf.SendRetries = 1
+	sd := Sender{Address: addrs[0], CryptoKey: []byte(testAESKey), Config: cf}
+	send := func(dials int) {
+		t.Helper()
+		if err := sd.SendString("k", "v"); err != nil {
+			t.Error("0xEDF67E", err)
+		}
+		if got := tr.count(); got != dials {
+			t.Error("0xE1F59C", "connected", got, "times, want", dials)
+		}
+	}
+	send(1)
+	send(1)
+	if err := sd.Close(); err != nil || sd.conn != nil {
+		t.Error("0xEC2808", "not closed:", err)
+	}
+	send(2)
+	time.Sleep(cf.ConnectionTTL)
+	send(3)
+	sd.Address = addrs[1]
+	send(4)
+	//
+	// a failed send must replace the connection, even if it's not expired
+	stop()
+	if err := sd.SendString("k", "v"); err == nil {
+		t.Error("0xE202AB", "sent to a stopped Receiver")
+	}
+	_, _, stop = runTestReceivers(nw, ips, nil)
+	send(5)
+	send(5)
+	if err := sd.Close(); err != nil {
+		t.Error("0xE993E9", err)
+	}
+}
+
+// dialCountingTransport is a Transport that counts calls to DialPacket()
+type dialCountingTransport struct {
+	Transport
+	mu    sync.Mutex
+	dials int
+}
+
+// DialPacket counts the call and dials using the wrapped Transport
+func (tr *dialCountingTransport) DialPacket(address string) (
+	net.PacketConn, net.Addr, error,
+) {
+	tr.mu.Lock()
+	tr.dials++
+	tr.mu.Unlock()
+	return tr.Transport.DialPacket(address)
+}
+
+// count returns the number of calls to DialPacket()
+func (tr *dialCountingTransport) count() int {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	return tr.dials
+}
+
+// (sd *Sender) Send(k string, v []byte) error
+//
+// go test -run Test_Sender_Send_Resume_
+//
+// must only send the fragments which a restarted Receiver doesn't have
+func Test_Sender_Send_Resume_(t *testing.T) {
+	dir, err := ioutil.TempDir("", "udpt")
+	if err != nil {
+		t.Fatal("0xEA8EC3", err)
+	}
+	defer os.RemoveAll(dir)
+	setup := func(rc *Receiver) {
+		rc.Config.CheckpointDir = dir
+		rc.Config.ResumeMinFragments = 4
+		rc.Config.ValidateAddresses = true
+	}
+	// send the item, while dropping all packets after the query (which
+	// is sent twice, the second time with a cookie) and the first 9
+	// fragments, then restart the Receiver
+	nw := udptest.NewNetwork()
+	_, _, stop := runTestReceivers(nw, []string{"10.0.0.11"}, setup)
+	newSender := func(im udptest.Impairment) (
+		*Sender, *udptest.ImpairedTransport,
+	) {
+		tr := udptest.ImpairTransport(nw.Host("10.0.0.1"), im)
+		cf := NewDefaultConfig()
+		cf.Transport = tr
+		cf.ReplyTimeout = 100 * time.Millisecond
+		cf.SendRetryInterval = 50 * time.Millisecond
+		cf.SendRetries = 1
+		cf.ResumeTransfers = true
+		cf.ResumeMinFragments = 4
+		sd := &Sender{Address: "10.0.0.11:9876",
+			CryptoKey: []byte(testAESKey), Config: cf}
+		return sd, tr
+	}
+	v := randomBytes(5, 40*1024)
+	sd, _ := newSender(udptest.Impairment{
+		DropFunc: func(seq int, b []byte) bool { return seq > 10 },
+	})
+	err = sd.Send("k", v)
+	if !matchError(err, "undelivered packets") {
+		t.Fatal("0xE68F51", "wrong error:", err)
+	}
+	count := len(sd.lastItem.packets)
+	stop()
+	addrs, td, stop := runTestReceivers(nw, []string{"10.0.0.11"}, setup)
+	defer stop()
+	//
+	sd, tr := newSender(udptest.Impairment{})
+	err = sd.Send("k", v)
+	if err != nil {
+		t.Fatal("0xE6B549", err)
+	}
+	if got := tr.Stats().Packets; got != 2+count-9 {
+		t.Error("0xE0F49A", "sent", got, "packets for", count, "fragments")
+	}
+	got := td.get(addrs[0], "k")
+	if len(got) != 1 || !bytes.Equal(got[0], v) {
+		t.Error("0xE7508C", "item not delivered")
+	}
+	// without Config.ResumeTransfers, the Sender doesn't query
+	sd, tr = newSender(udptest.Impairment{})
+	sd.Config.ResumeTransfers = false
+	err = sd.Send("k2", v)
+	if err != nil || sd.lastItem.query != nil {
+		t.Error("0xE691FF", "must not query the Receiver:", err)
+	}
+	if got := tr.Stats().Packets; got != count {
+		t.Error("0xEF38B8", "sent", got, "packets for", count, "fragments")
+	}
+}
+
+// (sd *Sender) Send(k string, v []byte) error
+//
+// go test -run Test_Sender_Send_Window_
+//
+// must not have more packets in flight than the Receiver's window
+func Test_Sender_Send_Window_(t *testing.T) {
+	nw := udptest.NewNetwork()
+	addrs, td, stop := runTestReceivers(nw, []string{"10.0.0.11"},
+		func(rc *Receiver) { rc.Config.ReceiveWindow = 2 })
+	defer stop()
+	tr := udptest.ImpairTransport(nw.Host("10.0.0.1"),
+		udptest.Impairment{Delay: 20 * time.Millisecond})
+	cf := NewDefaultConfig()
+	cf.Transport = tr
+	sd := Sender{Address: addrs[0], CryptoKey: []byte(testAESKey), Config: cf}
+	defer func() { _ = sd.Close() }()
+	//
+	// the first item opens the connection
+	if err := sd.Send("warm-up", []byte("v")); err != nil {
+		t.Fatal("0xE775CF", err)
+	}
+	sent := tr.Stats().Packets
+	v := randomBytes(3, 60*1024) // 61 packets, after compression
+	t0 := time.Now()
+	if err := sd.Send("k", v); err != nil {
+		t.Error("0xE03E5E", err)
+	}
+	// 2 packets per round trip of at least 20 ms
+	if elapsed := time.Since(t0); elapsed < 500*time.Millisecond {
+		t.Error("0xEA85E3", "window not respected:", elapsed)
+	}
+	if got := td.get(addrs[0], "k"); len(got) != 1 || !bytes.Equal(got[0], v) {
+		t.Error("0xEEB38D", "item not delivered")
+	}
+	// no packets must be lost and resent
+	if n := tr.Stats().Packets - sent; n > 61 {
+		t.Error("0xE83F5D", "sent", n, "packets")
+	}
+}
+
+// (sd *Sender) Send(k string, v []byte) error
+//
+// go test -run Test_Sender_Send_PathMTU_
+//
+// must find the largest payload size that reaches the Receiver
+// and use it to send the items after the search
+func Test_Sender_Send_PathMTU_(t *testing.T) {
+	nw := udptest.NewNetwork()
+	addrs, td, stop := runTestReceivers(nw, []string{"10.0.0.11"},
+		func(rc *Receiver) { rc.Config.PacketSizeLimit = 9000 })
+	defer stop()
+	// the path drops packets larger than 1300 bytes
+	tr := udptest.ImpairTransport(nw.Host("10.0.0.1"), udptest.Impairment{
+		DropFunc: func(seq int, b []byte) bool { return len(b) > 1300 },
+	})
+	cf := NewDefaultConfig()
+	cf.Transport = tr
+	cf.PacketSizeLimit = 9000
+	cf.PathMTUDiscovery = true
+	cf.SendRetryInterval = 20 * time.Millisecond
+	sd := Sender{Address: addrs[0], CryptoKey: []byte(testAESKey), Config: cf}
+	defer func() { _ = sd.Close() }()
+	if err := sd.Send("warm-up", []byte("v")); err != nil {
+		t.Fatal("0xE83481", err)
+	}
+	for i := 0; i < 300 && sd.payloadSize() == cf.PacketPayloadSize; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := sd.payloadSize(); n < 1100-pathMTUStep || n > 1100 {
+		t.Fatal("0xE67072", "wrong payload size:", n)
+	}
+	sent, dropped := tr.Stats().Packets, tr.Stats().Dropped
+	v := randomBytes(5, 20*1024) // 21 packets of PacketPayloadSize
+	if err := sd.Send("k", v); err != nil {
+		t.Error("0xEC91F0", err)
+	}
+	if got := td.get(addrs[0], "k"); len(got) != 1 || !bytes.Equal(got[0], v) {
+		t.Error("0xE5447C", "item not delivered")
+	}
+	// the item's packets must be larger, but fit the path
+	if n := tr.Stats().Packets - sent; n > 20 {
+		t.Error("0xEDA34E", "sent", n, "packets")
+	}
+	if n := tr.Stats().Dropped - dropped; n != 0 {
+		t.Error("0xE2263D", "dropped", n, "packets")
+	}
+	// a long key makes the header larger, so the payload must be smaller
+	dropped = tr.Stats().Dropped
+	k := strings.Repeat("k", 300)
+	if err := sd.Send(k, v); err != nil {
+		t.Error("0xE8051B", err)
+	}
+	if got := td.get(addrs[0], k); len(got) != 1 || !bytes.Equal(got[0], v) {
+		t.Error("0xEB36A5", "item not delivered")
+	}
+	if n := tr.Stats().Dropped - dropped; n != 0 {
+		t.Error("0xE84336", "dropped", n, "packets")
+	}
+}
+
+// (sd *Sender) Ping(ctx context.Context) (time.Duration, error)
+//
+// go test -run Test_Sender_Ping_
+//
+func Test_Sender_Ping_(t *testing.T) {
+	nw := udptest.NewNetwork()
+	addrs, _, stop := runTestReceivers(nw, []string{"10.0.0.11"}, nil)
+	defer stop()
+	tr := udptest.ImpairTransport(nw.Host("10.0.0.1"),
+		udptest.Impairment{Delay: 20 * time.Millisecond})
+	cf := NewDefaultConfig()
+	cf.Transport = tr
+	cf.SendRetryInterval = 50 * time.Millisecond
+	sd := Sender{Address: addrs[0], CryptoKey: []byte(testAESKey), Config: cf}
+	defer func() { _ = sd.Close() }()
+	//
+	// must return the round-trip time of a live Receiver
+	rtt, err := sd.Ping(context.Background())
+	if err != nil || rtt < 20*time.Millisecond || rtt > time.Second {
+		t.Error("0xE5D994", "wrong round-trip time:", rtt, err)
+	}
+	if len(sd.pings) != 0 {
+		t.Error("0xE94F12", "pings not forgotten:", len(sd.pings))
+	}
+	// must fail when the context is done, if the Receiver doesn't reply
+	sd.Address = "10.0.0.99:9876"
+	ctx, cancel := context.WithTimeout(context.Background(),
+		200*time.Millisecond)
+	defer cancel()
+	t0 := time.Now()
+	_, err = sd.Ping(ctx)
+	if err == nil || time.Since(t0) > time.Second {
+		t.Error("0xEAA254", "must fail:", time.Since(t0), err)
+	}
+}
+
 // -----------------------------------------------------------------------------
 // # Informatory Properties (sd *Sender)
 
@@ -212,10 +697,10 @@ func Test_Sender_LogStats_(t *testing.T) {
 	//
 	sd := Sender{Config: NewDefaultConfig()}
 	sd.Config.LogWriter = &tlog
-	sd.packets = []senderPacket{
+	sd.lastItem = &senderItem{packets: []senderPacket{
 		{sentHash: []byte{0x0}, confirmedHash: []byte{0x0}},
 		{sentHash: []byte{0x1}, confirmedHash: []byte{0x0}},
-	}
+	}}
 	sd.stats.bytesDelivered = 123000
 	sd.stats.bytesLost = 456
 	sd.stats.packetsDelivered = 10
@@ -321,6 +806,130 @@ func Test_Sender_connect_5(t *testing.T) {
 	}
 }
 
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+// (sd *Sender) waitForWindow(it *senderItem) bool
+// (sd *Sender) receiveRefusal(recv []byte)
+//
+// go test -run Test_Sender_waitForWindow_
+//
+func Test_Sender_waitForWindow_(t *testing.T) {
+	sd := makeTestSender()
+	sd.Config.VerboseSender = false
+	sd.Config.SendRetryInterval = 50 * time.Millisecond
+	sd.Config.SendWaitInterval = 5 * time.Millisecond
+	sd.Config.ReplyTimeout = 200 * time.Millisecond
+	it := &senderItem{
+		packets: []senderPacket{{sentHash: bytes.Repeat([]byte{1}, 32)}},
+		indexes: map[string]int{string(bytes.Repeat([]byte{1}, 32)): 0},
+	}
+	sd.items = []*senderItem{it}
+	it.startRound(time.Now())
+	// with no window advertised, packets are sent without waiting
+	if !sd.waitForWindow(it) {
+		t.Error("0xE9ADD4")
+	}
+	// a zero window with the packet in flight must wait until ReplyTimeout
+	it.markSent(0)
+	sd.receiveRefusal([]byte(tagWindow + string(it.packets[0].sentHash) +
+		"win:0"))
+	it.markSent(0)
+	t0 := time.Now()
+	if sd.waitForWindow(it) || time.Since(t0) < 150*time.Millisecond {
+		t.Error("0xE9D48B", "must wait for the window")
+	}
+	// a zero window with nothing in flight must be probed after
+	// SendRetryInterval; the refusal removes the packet from flight
+	sd.receiveRefusal([]byte(tagWindow + string(it.packets[0].sentHash) +
+		"win:0"))
+	t0 = time.Now()
+	if !sd.waitForWindow(it) || time.Since(t0) < 40*time.Millisecond ||
+		time.Since(t0) > 150*time.Millisecond {
+		t.Error("0xE1D44A", "must probe the window", time.Since(t0))
+	}
+}
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+// (sd *Sender) keepAlive(conn netUDPConn, stop chan struct{})
+//
+// go test -run Test_Sender_keepAlive_
+//
+// must ping an idle Receiver, and report it when it stops replying
+func Test_Sender_keepAlive_(t *testing.T) {
+	nw := udptest.NewNetwork()
+	addrs, _, stop := runTestReceivers(nw, []string{"10.0.0.11"}, nil)
+	defer stop()
+	tr := udptest.ImpairTransport(nw.Host("10.0.0.1"), udptest.Impairment{})
+	cf := NewDefaultConfig()
+	cf.Transport = tr
+	cf.KeepaliveInterval = 50 * time.Millisecond
+	cf.ReplyTimeout = 200 * time.Millisecond
+	cf.SendRetryInterval = 20 * time.Millisecond
+	unreachable := make(chan string, 1)
+	sd := Sender{Address: addrs[0], CryptoKey: []byte(testAESKey), Config: cf,
+		Unreachable: func(address string) { unreachable <- address },
+	}
+	defer func() { _ = sd.Close() }()
+	if err := sd.Send("k", []byte("v")); err != nil {
+		t.Fatal("0xEB27B7", err)
+	}
+	sent := tr.Stats().Packets
+	time.Sleep(300 * time.Millisecond)
+	select {
+	case address := <-unreachable:
+		t.Error("0xE1F5EA", "live Receiver reported:", address)
+	default:
+	}
+	if n := tr.Stats().Packets - sent; n < 3 {
+		t.Error("0xEA6CD5", "sent", n, "keepalives")
+	}
+	stop()
+	select {
+	case address := <-unreachable:
+		if address != addrs[0] {
+			t.Error("0xE40C40", "wrong address:", address)
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("0xE9F2AA", "unreachable Receiver not reported")
+	}
+	sd.mutex.Lock()
+	failed := sd.connFailed
+	sd.mutex.Unlock()
+	if !failed {
+		t.Error("0xE7A38F", "connection not marked as failed")
+	}
+}
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+// (sd *Sender) receiveCookie(recv []byte)
+//
+// go test -run Test_Sender_receiveCookie_
+//
+func Test_Sender_receiveCookie_(t *testing.T) {
+	var tlog strings.Builder
+	sd := makeTestSender()
+	sd.Config.LogWriter = &tlog
+	it := &senderItem{}
+	sd.items = []*senderItem{it}
+	cookie := bytes.Repeat([]byte{7}, cookieSize)
+	sd.receiveCookie(append([]byte(tagCookie), cookie...))
+	if !bytes.Equal(sd.cookie, cookie) || !it.takeNewCookie() {
+		t.Error("0xEE3659")
+	}
+	// the same cookie again is not new
+	sd.receiveCookie(append([]byte(tagCookie), cookie...))
+	if it.hasNewCookie() {
+		t.Error("0xE52869")
+	}
+	// a cookie of the wrong size is ignored
+	sd.receiveCookie([]byte(tagCookie + "bad"))
+	if !bytes.Equal(sd.cookie, cookie) || it.hasNewCookie() {
+		t.Error("0xE9F9BB")
+	}
+	if !strings.Contains(tlog.String(), "bad cookie reply") {
+		t.Error("0xE15226", "wrong log:", tlog.String())
+	}
+}
+
 // - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
 // (sd *Sender) close() error
 //
@@ -426,6 +1035,57 @@ func Test_Sender_makePacket_2(t *testing.T) {
 	}
 }
 
+// must leave room for the cookie prefix and the encryption overhead
+func Test_Sender_makePacket_3(t *testing.T) {
+	sd := makeTestSender()
+	if err := sd.setKeys(); err != nil {
+		t.Fatal("0xEC1B0A", err)
+	}
+	max := sd.Config.PacketSizeLimit - cookiePrefixSize - 28 // AES-GCM
+	if _, err := sd.makePacket(make([]byte, max)); err != nil {
+		t.Error("0xE992C8", err)
+	}
+	_, err := sd.makePacket(make([]byte, max+1))
+	if !matchError(err, "PacketSizeLimit") {
+		t.Error("0xEF4CB2", "wrong error:", err)
+	}
+}
+
+// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
+// (sd *Sender) makePackets(it *senderItem, comp []byte) error
+//
+// go test -run Test_Sender_makePackets_
+
+// the header, which grows with the key, must fit in every packet
+// along with the payload, or fail if there's no room for a payload
+func Test_Sender_makePackets_(t *testing.T) {
+	sd := makeTestSender()
+	sd.Config.LogWriter = nil
+	if err := sd.setKeys(); err != nil {
+		t.Fatal("0xEB9C94", err)
+	}
+	limit := sd.Config.PacketSizeLimit
+	for _, keySize := range []int{limit - 300, limit - 250} {
+		it := &senderItem{key: strings.Repeat("k", keySize),
+			messageID: strings.Repeat("i", maxMessageIDSize)}
+		err := sd.makePackets(it, randomBytes(6, 2000))
+		if err != nil || len(it.packets) < 2 {
+			t.Error("0xEC986E", keySize, err)
+			continue
+		}
+		for _, pk := range it.packets {
+			if len(pk.data)+cookiePrefixSize+28 > limit {
+				t.Error("0xEE2E73", keySize, "packet too large:", len(pk.data))
+			}
+		}
+	}
+	it := &senderItem{key: strings.Repeat("k", limit)}
+	err := sd.makePackets(it, []byte("v"))
+	if !matchError(err, "too long") {
+		t.Error("0xE6FE04", "wrong error:", err)
+	}
+}
+
 // - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
 // (sd *Sender) validateAddress() error
 //
@@ -471,23 +1131,85 @@ func Test_Sender_validateAddress_4(t *testing.T) {
 	}
 }
 
+// must accept IPv6 addresses, including ones with a zone ID
+func Test_Sender_validateAddress_5(t *testing.T) {
+	sd := makeTestSender()
+	for _, addr := range []string{
+		"[::1]:9876", "[fe80::1%eth0]:9876", "[2001:db8::7]:65535",
+	} {
+		sd.Address = addr
+		err := sd.validateAddress()
+		if err != nil {
+			t.Error("0xEB5AFF", addr, err)
+		}
+	}
+}
+
+// must reject IPv6 addresses without brackets or a valid port
+func Test_Sender_validateAddress_6(t *testing.T) {
+	sd := makeTestSender()
+	for _, addr := range []string{"::1", "::1:9876", "[::1]", "[::1]:0"} {
+		sd.Address = addr
+		err := sd.validateAddress()
+		if !matchError(err, "invalid port in Sender.Address") {
+			t.Error("0xED6FF7", addr, "wrong error:", err)
+		}
+	}
+}
+
 // -----------------------------------------------------------------------------
+// # Internal Functions
 
-// makeConfigAndReceiver creates and returns a
-// Configuration and Receiver for testing Sender.
-func makeConfigAndReceiver(cryptoKey []byte, received *map[string][]byte,
-) (*Configuration, *Receiver) {
-	cf := NewDefaultConfig()
-	cf.ReplyTimeout = 250 * time.Millisecond
-	cf.WriteTimeout = 250 * time.Millisecond
-	//
-	rc := Receiver{Port: 9876, CryptoKey: cryptoKey, Config: cf,
+// splitWindow(reply []byte) (hash []byte, window int)
+//
+// go test -run Test_splitWindow_
+//
+func Test_splitWindow_(t *testing.T) {
+	hash := string(bytes.Repeat([]byte{9}, 32))
+	for i, tc := range []struct {
+		reply  string
+		hash   string
+		window int
+	}{
+		{hash, hash, -1},
+		{hash + "win:0", hash, 0},
+		{hash + "win:64", hash, 64},
+		{hash + "win:-1", hash, -1},
+		{hash + "win:x", hash, -1},
+		{"short", "short", -1},
+	} {
+		gotHash, window := splitWindow([]byte(tc.reply))
+		if string(gotHash) != tc.hash || window != tc.window {
+			t.Error("0xEC30F8", i, "wrong result:", window)
+		}
+	}
+}
+
+// -----------------------------------------------------------------------------
+
+// makeConfigAndReceiver creates and returns a Configuration and Receiver
+// for testing Sender, and the items the Receiver receives, which are
+// recorded under a blank address. The Receiver has its own Configuration,
+// since a Configuration's ciphers can't be shared by a running Receiver.
+func makeConfigAndReceiver(cryptoKey []byte) (
+	*Configuration, *Receiver, *testDeliveries,
+) {
+	newConfig := func() *Configuration {
+		cf := NewDefaultConfig()
+		cf.ReplyTimeout = 250 * time.Millisecond
+		cf.WriteTimeout = 250 * time.Millisecond
+		return cf
+	}
+	td := &testDeliveries{items: make(map[string][][]byte)}
+	rc := Receiver{Port: 9876, CryptoKey: cryptoKey, Config: newConfig(),
 		Receive: func(k string, v []byte) error {
-			(*received)[k] = []byte(v)
+			td.mu.Lock()
+			td.items[" "+k] = append(td.items[" "+k], v)
+			td.mu.Unlock()
 			return nil
 		},
 	}
-	return cf, &rc
+	return newConfig(), &rc, td
 }
 
 // makeTestSender creates a properly-configured Sender for testing.
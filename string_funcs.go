@@ -7,9 +7,30 @@ package udpt
 
 import (
 	"fmt"
+	"net"
+	"strconv"
 	"strings"
 )
 
+// addressPort returns the port number in 'address', which must be in
+// "host:port" form, for example "127.0.0.1:9876", "[::1]:9876",
+// "[fe80::1%eth0]:9876" or ":9876".
+//
+// Returns zero if 'address' can't be split into a host and port,
+// or if the port is not a number between 1 and 65535.
+//
+func addressPort(address string) int {
+	_, s, err := net.SplitHostPort(address)
+	if err != nil {
+		return 0
+	}
+	port, err := strconv.Atoi(s)
+	if err != nil || port < 1 || port > 65535 {
+		return 0
+	}
+	return port
+} //                                                                 addressPort
+
 // getPart returns the substring between 'prefix' and 'suffix'.
 //
 // When the prefix is blank, returns the part from the beginning of 's'.
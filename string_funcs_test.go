@@ -114,4 +114,37 @@ func Test_string_padf_(t *testing.T) {
 	}
 }
 
+// addressPort(address string) int
+//
+// go test -run Test_string_addressPort_
+//
+func Test_string_addressPort_(t *testing.T) {
+	for _, it := range []struct {
+		address string
+		want    int
+	}{
+		{"", 0},
+		{"127.0.0.1", 0},
+		{"127.0.0.1:9876", 9876},
+		{"localhost:1", 1},
+		{":9876", 9876},
+		{"[::]:9876", 9876},
+		{"[::1]:9876", 9876},
+		{"[fe80::1%eth0]:9876", 9876},
+		{"::1", 0},
+		{"::1:9876", 0},
+		{"127.0.0.1:0", 0},
+		{"127.0.0.1:65536", 0},
+		{"127.0.0.1:http", 0},
+	} {
+		got := addressPort(it.address)
+		if got != it.want {
+			t.Errorf("0xE78439"+" addressPort(%#v)"+
+				"\n want: %#v"+
+				"\n  got: %#v",
+				it.address, it.want, got)
+		}
+	}
+}
+
 // end
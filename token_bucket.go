@@ -0,0 +1,67 @@
+// -----------------------------------------------------------------------------
+// github.com/balacode/udpt                                   /[token_bucket.go]
+// (c) balarabe@protonmail.com                                      License: MIT
+// -----------------------------------------------------------------------------
+
+package udpt
+
+import (
+	"time"
+)
+
+// tokenBucket implements the token bucket algorithm used to limit the
+// rate of packets or bytes. The bucket holds up to 'burst' tokens and
+// is refilled at 'rate' tokens per second. Each packet (or byte) takes
+// one token, and is dropped if the bucket doesn't have enough tokens.
+//
+// A new (zero value) bucket starts full.
+//
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+} //                                                                 tokenBucket
+
+// Has returns true if the bucket has at least 'n' tokens at time 'now',
+// without removing them. Use it to check several buckets before taking
+// tokens from any of them.
+func (tb *tokenBucket) Has(n, rate, burst float64, now time.Time) bool {
+	tb.refill(rate, burst, now)
+	return tb.tokens >= n
+} //                                                                         Has
+
+// IsFull returns true if the bucket will be full at time 'now'. A full
+// bucket behaves just like a new bucket, so it doesn't need to be kept.
+func (tb *tokenBucket) IsFull(rate, burst float64, now time.Time) bool {
+	tb.refill(rate, burst, now)
+	return tb.tokens >= burst
+} //                                                                      IsFull
+
+// Take removes 'n' tokens from the bucket and returns true if the
+// bucket has enough tokens at time 'now'. Otherwise returns false
+// and leaves the tokens in the bucket.
+func (tb *tokenBucket) Take(n, rate, burst float64, now time.Time) bool {
+	tb.refill(rate, burst, now)
+	if tb.tokens < n {
+		return false
+	}
+	tb.tokens -= n
+	return true
+} //                                                                        Take
+
+// refill adds the tokens accumulated since the last refill,
+// up to 'burst' tokens. A new bucket is filled completely.
+func (tb *tokenBucket) refill(rate, burst float64, now time.Time) {
+	if tb.last.IsZero() {
+		tb.tokens = burst
+	} else if elapsed := now.Sub(tb.last); elapsed > 0 {
+		tb.tokens += elapsed.Seconds() * rate
+		if tb.tokens > burst {
+			tb.tokens = burst
+		}
+	}
+	if now.After(tb.last) {
+		tb.last = now
+	}
+} //                                                                      refill
+
+// end
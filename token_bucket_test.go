@@ -0,0 +1,93 @@
+// -----------------------------------------------------------------------------
+// github.com/balacode/udpt                              /[token_bucket_test.go]
+// (c) balarabe@protonmail.com                                      License: MIT
+// -----------------------------------------------------------------------------
+
+package udpt
+
+import (
+	"testing"
+	"time"
+)
+
+// to run all tests in this file:
+// go test -v -run Test_tokenBucket_*
+
+// -----------------------------------------------------------------------------
+
+// (tb *tokenBucket) Take(n, rate, burst float64, now time.Time) bool
+//
+// go test -run Test_tokenBucket_Take_
+//
+func Test_tokenBucket_Take_(t *testing.T) {
+	var (
+		tb  tokenBucket
+		now = time.Now()
+	)
+	// a new bucket starts full, allowing a burst
+	for i := 0; i < 3; i++ {
+		if !tb.Take(1, 10, 3, now) {
+			t.Error("0xEDC163", i)
+		}
+	}
+	if tb.Take(1, 10, 3, now) {
+		t.Error("0xEE327E")
+	}
+	// after 100 ms at 10 tokens per second, one token is available
+	now = now.Add(100 * time.Millisecond)
+	if !tb.Take(1, 10, 3, now) {
+		t.Error("0xE6A594")
+	}
+	if tb.Take(1, 10, 3, now) {
+		t.Error("0xEB1074")
+	}
+	// taking more tokens than available must not remove any
+	now = now.Add(time.Second)
+	if tb.Take(4, 10, 3, now) {
+		t.Error("0xEB7BD2")
+	}
+	if !tb.Take(3, 10, 3, now) {
+		t.Error("0xE54483")
+	}
+	// a clock going backwards must not add tokens
+	if tb.Take(1, 10, 3, now.Add(-time.Minute)) {
+		t.Error("0xEB1993")
+	}
+}
+
+// (tb *tokenBucket) Has(n, rate, burst float64, now time.Time) bool
+//
+// go test -run Test_tokenBucket_Has_
+//
+func Test_tokenBucket_Has_(t *testing.T) {
+	var (
+		tb  tokenBucket
+		now = time.Now()
+	)
+	if !tb.Has(3, 10, 3, now) || !tb.Has(3, 10, 3, now) || tb.Has(4, 10, 3, now) {
+		t.Error("0xE97E8A", "Has() must not remove tokens")
+	}
+}
+
+// (tb *tokenBucket) IsFull(rate, burst float64, now time.Time) bool
+//
+// go test -run Test_tokenBucket_IsFull_
+//
+func Test_tokenBucket_IsFull_(t *testing.T) {
+	var (
+		tb  tokenBucket
+		now = time.Now()
+	)
+	if !tb.IsFull(10, 5, now) {
+		t.Error("0xEFC720")
+	}
+	tb.Take(5, 10, 5, now)
+	if tb.IsFull(10, 5, now.Add(100*time.Millisecond)) {
+		t.Error("0xE465AB")
+	}
+	if !tb.IsFull(10, 5, now.Add(500*time.Millisecond)) {
+		t.Error("0xE85DA8")
+	}
+}
+
+// end
@@ -9,6 +9,7 @@ import (
 	"bytes"
 	"fmt"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 )
@@ -58,19 +59,25 @@ func testTransfer(itemCount, itemSize int, t *testing.T) {
 	//
 	// this map collects received keys and values
 	received := make(map[string][]byte, N)
+	var mu sync.Mutex
 	//
-	// enable verbose logging but don't print the output
-	cf := NewDefaultConfig()
-	cf.LogWriter = nil
-	cf.VerboseSender = true
-	cf.VerboseReceiver = true
-	//
+	// enable verbose logging but don't print the output; Sender
+	// and Receiver each need their own Configuration and ciphers
+	newConfig := func() *Configuration {
+		cf := NewDefaultConfig()
+		cf.LogWriter = nil
+		cf.VerboseSender = true
+		cf.VerboseReceiver = true
+		return cf
+	}
 	// set-up and run the receiver
 	rc := Receiver{
-		Port: 9876, CryptoKey: cryptoKey, Config: cf,
+		Port: 9876, CryptoKey: cryptoKey, Config: newConfig(),
 		//
 		Receive: func(k string, v []byte) error {
+			mu.Lock()
 			received[k] = []byte(v)
+			mu.Unlock()
 			return nil
 		},
 	}
@@ -78,15 +85,17 @@ func testTransfer(itemCount, itemSize int, t *testing.T) {
 	defer func() { rc.Stop() }()
 	//
 	// make a map of N messages
+	mu.Lock()
 	for i := 0; i < N; i++ {
 		k := fmt.Sprint("P", i)
 		v := fmt.Sprintf("%04d", i)
 		received[k] = []byte(v)
 	}
+	mu.Unlock()
 	// send the messages to the receiver
 	time.Sleep(time.Second)
 	sd := Sender{
-		Address: "127.0.0.1:9876", CryptoKey: cryptoKey, Config: cf,
+		Address: "127.0.0.1:9876", CryptoKey: cryptoKey, Config: newConfig(),
 	}
 	makeKV := func(i int) (string, []byte) {
 		sn := fmt.Sprintf("%04d", i)
@@ -106,7 +115,9 @@ func testTransfer(itemCount, itemSize int, t *testing.T) {
 	// compare received to expected values
 	for i := 0; i < N; i++ {
 		k, vS := makeKV(i)
+		mu.Lock()
 		vR := received[k]
+		mu.Unlock()
 		if !bytes.Equal(vS, vR) {
 			t.Error("0xE67F41", "mismatch for key:", k,
 				"len(vS):", len(vS),
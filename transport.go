@@ -0,0 +1,326 @@
+// -----------------------------------------------------------------------------
+// github.com/balacode/udpt                                      /[transport.go]
+// (c) balarabe@protonmail.com                                      License: MIT
+// -----------------------------------------------------------------------------
+
+package udpt
+
+// # Transport Interfaces
+//   Transport interface
+//   MulticastTransport interface
+//
+// # UDPTransport Type
+//   UDPTransport struct
+//   ) ListenPacket(address string) (net.PacketConn, error)
+//   ) ListenMulticast(group string) (net.PacketConn, error)
+//   ) DialPacket(address string) (net.PacketConn, net.Addr, error)
+//
+// # Existing Connection Transport
+//   NewConnTransport(conn net.PacketConn) Transport
+//   connTransport struct
+//   ) ListenPacket(address string) (net.PacketConn, error)
+//   ) DialPacket(address string) (net.PacketConn, net.Addr, error)
+//   sharedConn struct
+//   newSharedConn(conn net.PacketConn) *sharedConn
+//   ) ReadFrom(p []byte) (int, net.Addr, error)
+//   ) SetDeadline(t time.Time) error
+//   ) SetReadDeadline(t time.Time) error
+//   ) Close() error
+//   ) isClosed() bool
+//   ) use() bool
+//
+// # Internal Adapter
+//   packetConnAdapter struct
+//   newPacketConnAdapter(conn net.PacketConn, raddr net.Addr) netUDPConn
+//   ) Write(p []byte) (int, error)
+//   ) SetWriteBuffer(bytes int) error
+//
+// # Helper Function
+//   listenMulticastUDP(group, ifname string) (net.PacketConn, error)
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// -----------------------------------------------------------------------------
+// # Transport Interfaces
+
+// Transport creates the packet connections used by Sender and Receiver.
+//
+// By default (when Configuration.Transport is nil) Sender and Receiver
+// create their own UDP sockets. Specify a Transport in Configuration when
+// you need to control how sockets are created, for example to use an
+// already-bound socket, a socket passed from systemd, a tunnel, or an
+// in-memory network for testing.
+//
+type Transport interface {
+
+	// ListenPacket returns a connection that receives packets sent to the
+	// local 'address' ("host:port"). It is called by Receiver.Run().
+	// The Receiver closes the connection when it stops.
+	ListenPacket(address string) (net.PacketConn, error)
+
+	// DialPacket returns a connection for exchanging packets with the
+	// remote 'address' ("host:port"), and the resolved remote address
+	// to which packets must be written. It is called by Sender when it
+	// first sends an item, and again when it replaces the connection
+	// (see Configuration.ConnectionTTL). The Sender closes the
+	// connection when it replaces it, and in Sender.Close().
+	DialPacket(address string) (net.PacketConn, net.Addr, error)
+} //                                                                   Transport
+
+// MulticastTransport is a Transport that can also join multicast groups.
+// When Configuration.Transport is specified, a Receiver with a
+// MulticastGroup requires it to implement this interface.
+type MulticastTransport interface {
+	Transport
+
+	// ListenMulticast returns a connection that receives packets sent to
+	// the multicast 'group' ("ip:port"). It is called by Receiver.Run()
+	// when Receiver.MulticastGroup is specified. The Receiver closes the
+	// connection when it stops.
+	ListenMulticast(group string) (net.PacketConn, error)
+} //                                                          MulticastTransport
+
+// -----------------------------------------------------------------------------
+// # UDPTransport Type
+
+// UDPTransport is a Transport that creates ordinary UDP sockets, like
+// Sender and Receiver do when Configuration.Transport is nil. It is
+// useful for wrapping with another Transport that adds some behavior.
+type UDPTransport struct{}
+
+// ListenPacket listens for UDP packets on the local 'address'.
+func (UDPTransport) ListenPacket(address string) (net.PacketConn, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return nil, makeError(0xE00A24, err)
+	}
+	conn, err := net.ListenUDP("udp", udpAddr)
+	if err != nil {
+		return nil, makeError(0xE6B573, err)
+	}
+	return conn, nil
+} //                                                                ListenPacket
+
+// ListenMulticast joins the multicast 'group' on the
+// system's default multicast interface.
+func (UDPTransport) ListenMulticast(group string) (net.PacketConn, error) {
+	return listenMulticastUDP(group, "")
+} //                                                             ListenMulticast
+
+// DialPacket resolves the remote 'address' and opens
+// a UDP socket on any available local port.
+func (UDPTransport) DialPacket(address string) (
+	net.PacketConn, net.Addr, error,
+) {
+	raddr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return nil, nil, makeError(0xE9F14E, err)
+	}
+	conn, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		return nil, nil, makeError(0xE3CE09, err)
+	}
+	return conn, raddr, nil
+} //                                                                  DialPacket
+
+// -----------------------------------------------------------------------------
+// # Existing Connection Transport
+
+// NewConnTransport returns a Transport that uses the existing connection
+// 'conn' instead of creating new sockets, for example a socket created
+// by your own code or inherited from systemd.
+//
+// Sender and Receiver don't close 'conn' when they finish using it:
+// you remain responsible for closing it. This also means a Sender can
+// reuse 'conn' for many transfers.
+//
+func NewConnTransport(conn net.PacketConn) Transport {
+	return &connTransport{conn: conn}
+} //                                                            NewConnTransport
+
+// connTransport is the Transport returned by NewConnTransport()
+type connTransport struct {
+	conn net.PacketConn
+} //                                                               connTransport
+
+// ListenPacket returns the existing connection. The local 'address'
+// is ignored, since the connection is already bound to an address.
+func (ct *connTransport) ListenPacket(address string) (net.PacketConn, error) {
+	if ct.conn == nil {
+		return nil, makeError(0xE6ACB6, "nil connection")
+	}
+	return newSharedConn(ct.conn), nil
+} //                                                                ListenPacket
+
+// DialPacket resolves the remote 'address' and returns the existing
+// connection, through which packets will be written to that address.
+func (ct *connTransport) DialPacket(address string) (
+	net.PacketConn, net.Addr, error,
+) {
+	if ct.conn == nil {
+		return nil, nil, makeError(0xE94AF3, "nil connection")
+	}
+	raddr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return nil, nil, makeError(0xE1164E, err)
+	}
+	return newSharedConn(ct.conn), raddr, nil
+} //                                                                  DialPacket
+
+// sharedConn wraps a connection supplied to NewConnTransport(),
+// to prevent Sender and Receiver from closing it.
+type sharedConn struct {
+	net.PacketConn
+	mu     sync.Mutex
+	closed bool
+	calls  sync.WaitGroup // pending calls to the shared connection
+} //                                                                  sharedConn
+
+// newSharedConn wraps 'conn' in a sharedConn.
+func newSharedConn(conn net.PacketConn) *sharedConn {
+	return &sharedConn{PacketConn: conn}
+} //                                                               newSharedConn
+
+// ReadFrom reads a packet from the shared connection, or
+// returns errClosed if Close() has already been called.
+func (sc *sharedConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	if !sc.use() {
+		return 0, nil, errClosed
+	}
+	defer sc.calls.Done()
+	n, addr, err := sc.PacketConn.ReadFrom(p)
+	if err != nil && sc.isClosed() {
+		return 0, nil, errClosed
+	}
+	return n, addr, err
+} //                                                                    ReadFrom
+
+// SetDeadline sets the shared connection's read and write deadlines,
+// or returns errClosed if Close() has already been called.
+func (sc *sharedConn) SetDeadline(t time.Time) error {
+	if !sc.use() {
+		return errClosed
+	}
+	defer sc.calls.Done()
+	return sc.PacketConn.SetDeadline(t)
+} //                                                                 SetDeadline
+
+// SetReadDeadline sets the shared connection's read deadline,
+// or returns errClosed if Close() has already been called.
+func (sc *sharedConn) SetReadDeadline(t time.Time) error {
+	if !sc.use() {
+		return errClosed
+	}
+	defer sc.calls.Done()
+	return sc.PacketConn.SetReadDeadline(t)
+} //                                                             SetReadDeadline
+
+// Close doesn't close the shared connection. It interrupts any pending
+// ReadFrom(), so that the Sender or Receiver can stop reading, waits for
+// it to return, then clears the connection's read deadline, leaving the
+// connection ready for its owner to read from it again.
+//
+func (sc *sharedConn) Close() error {
+	sc.mu.Lock()
+	if sc.closed {
+		sc.mu.Unlock()
+		return nil
+	}
+	sc.closed = true
+	sc.mu.Unlock()
+	err := sc.PacketConn.SetReadDeadline(time.Unix(1, 0))
+	sc.calls.Wait()
+	if err2 := sc.PacketConn.SetReadDeadline(time.Time{}); err == nil {
+		err = err2
+	}
+	return err
+} //                                                                       Close
+
+// isClosed returns true if Close() has been called.
+func (sc *sharedConn) isClosed() bool {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return sc.closed
+} //                                                                    isClosed
+
+// use registers a pending call to the shared connection, which Close()
+// waits for. Returns false (without registering) if already closed.
+func (sc *sharedConn) use() bool {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if sc.closed {
+		return false
+	}
+	sc.calls.Add(1)
+	return true
+} //                                                                         use
+
+// -----------------------------------------------------------------------------
+// # Internal Adapter
+
+// packetConnAdapter makes a net.PacketConn returned by
+// a Transport usable wherever this package uses netUDPConn.
+type packetConnAdapter struct {
+	net.PacketConn
+	raddr net.Addr
+} //                                                           packetConnAdapter
+
+// newPacketConnAdapter wraps 'conn' in a netUDPConn. 'raddr' is the
+// remote address to which Write() sends packets; it can be nil if
+// the connection is only used for WriteTo(), like in a Receiver.
+func newPacketConnAdapter(conn net.PacketConn, raddr net.Addr) netUDPConn {
+	return &packetConnAdapter{PacketConn: conn, raddr: raddr}
+} //                                                        newPacketConnAdapter
+
+// Write writes a packet to the remote address given to the adapter.
+func (pa *packetConnAdapter) Write(p []byte) (int, error) {
+	if pa.raddr == nil {
+		return 0, makeError(0xE44D6B, "no remote address")
+	}
+	return pa.PacketConn.WriteTo(p, pa.raddr)
+} //                                                                       Write
+
+// SetWriteBuffer sets the size of the connection's transmit buffer
+// if the connection supports it (like *net.UDPConn), otherwise it
+// does nothing.
+func (pa *packetConnAdapter) SetWriteBuffer(bytes int) error {
+	conn, ok := pa.PacketConn.(interface{ SetWriteBuffer(int) error })
+	if !ok {
+		return nil
+	}
+	return conn.SetWriteBuffer(bytes)
+} //                                                              SetWriteBuffer
+
+// -----------------------------------------------------------------------------
+// # Helper Function
+
+// listenMulticastUDP joins the multicast 'group' ("ip:port") on the network
+// interface named 'ifname', or on the system's default multicast interface
+// if 'ifname' is blank.
+func listenMulticastUDP(group, ifname string) (net.PacketConn, error) {
+	groupAddr, err := net.ResolveUDPAddr("udp", group)
+	if err != nil {
+		return nil, makeError(0xE36AB2, err)
+	}
+	if !groupAddr.IP.IsMulticast() {
+		return nil, makeError(0xED3956, "not a multicast address:", group)
+	}
+	var ifi *net.Interface
+	if ifname != "" {
+		ifi, err = net.InterfaceByName(ifname)
+		if err != nil {
+			return nil, makeError(0xE7D63E, err)
+		}
+	}
+	conn, err := net.ListenMulticastUDP("udp", ifi, groupAddr)
+	if err != nil {
+		return nil, makeError(0xEAE896, err)
+	}
+	return conn, nil
+} //                                                          listenMulticastUDP
+
+// end
@@ -0,0 +1,247 @@
+// -----------------------------------------------------------------------------
+// github.com/balacode/udpt                                 /[transport_test.go]
+// (c) balarabe@protonmail.com                                      License: MIT
+// -----------------------------------------------------------------------------
+
+package udpt
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// to run all tests in this file:
+// go test -v -run Test_Transport_*
+
+// -----------------------------------------------------------------------------
+
+// mockTransport is a Transport which returns the
+// connection, address and error you specify.
+type mockTransport struct {
+	conn  net.PacketConn
+	raddr net.Addr
+	err   error
+	//
+	listenAddress string
+	dialAddress   string
+}
+
+// ListenPacket implements Transport.ListenPacket().
+func (mk *mockTransport) ListenPacket(address string) (net.PacketConn, error) {
+	mk.listenAddress = address
+	return mk.conn, mk.err
+}
+
+// DialPacket implements Transport.DialPacket().
+func (mk *mockTransport) DialPacket(address string) (
+	net.PacketConn, net.Addr, error,
+) {
+	mk.dialAddress = address
+	return mk.conn, mk.raddr, mk.err
+}
+
+// -----------------------------------------------------------------------------
+// # UDPTransport Type
+
+// must transfer data when Sender and Receiver use UDPTransport
+func Test_Transport_UDPTransport_1(t *testing.T) {
+	cf, rc, td := makeConfigAndReceiver([]byte(testAESKey))
+	cf.Transport = UDPTransport{}
+	rc.Config.Transport = UDPTransport{}
+	go func() { _ = rc.Run() }()
+	defer func() { rc.Stop() }()
+	time.Sleep(200 * time.Millisecond)
+	//
+	sd := Sender{Address: "127.0.0.1:9876", CryptoKey: []byte(testAESKey),
+		Config: cf}
+	err := sd.SendString("k", "via UDPTransport")
+	if err != nil {
+		t.Error("0xE19830", err)
+	}
+	got := td.get("", "k")
+	if len(got) != 1 || string(got[0]) != "via UDPTransport" {
+		t.Error("0xEEC3B1")
+	}
+}
+
+// must fail with invalid addresses
+func Test_Transport_UDPTransport_2(t *testing.T) {
+	conn, err := UDPTransport{}.ListenPacket("127.0.0.1:bad")
+	if conn != nil || err == nil {
+		t.Error("0xEDF208")
+	}
+	conn, raddr, err := UDPTransport{}.DialPacket("127.0.0.1:bad")
+	if conn != nil || raddr != nil || !matchError(err, "unknown port") {
+		t.Error("0xE48B79", "wrong error:", err)
+	}
+}
+
+// -----------------------------------------------------------------------------
+// # Existing Connection Transport
+
+// must receive on an existing socket and leave it open when stopped
+func Test_Transport_NewConnTransport_1(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("0xE22EBE", err)
+	}
+	defer conn.Close()
+	_, rc, td := makeConfigAndReceiver([]byte(testAESKey))
+	rc.Config.Transport = NewConnTransport(conn)
+	rc.Port = 0 // ignored
+	rc.Address = conn.LocalAddr().String()
+	go func() { _ = rc.Run() }()
+	time.Sleep(200 * time.Millisecond)
+	//
+	sd := Sender{Address: conn.LocalAddr().String(),
+		CryptoKey: []byte(testAESKey), Config: NewDefaultConfig()}
+	err = sd.SendString("k", "via existing socket")
+	if err != nil {
+		t.Error("0xE586A6", err)
+	}
+	got := td.get("", "k")
+	if len(got) != 1 || string(got[0]) != "via existing socket" {
+		t.Error("0xE4C382")
+	}
+	rc.Stop()
+	// the socket must still be usable after the Receiver stops
+	_, err = conn.WriteTo([]byte("ping"), conn.LocalAddr())
+	if err != nil {
+		t.Error("0xEAEA04", err)
+	}
+}
+
+// must fail when the connection is nil
+func Test_Transport_NewConnTransport_2(t *testing.T) {
+	tr := NewConnTransport(nil)
+	conn, err := tr.ListenPacket(":9876")
+	if conn != nil || !matchError(err, "nil connection") {
+		t.Error("0xE021A9", "wrong error:", err)
+	}
+	conn, raddr, err := tr.DialPacket("127.0.0.1:9876")
+	if conn != nil || raddr != nil || !matchError(err, "nil connection") {
+		t.Error("0xED4189", "wrong error:", err)
+	}
+}
+
+// (sc *sharedConn) Close() error
+//
+// go test -run Test_Transport_sharedConn_
+//
+// must interrupt a pending read, then leave the connection usable
+func Test_Transport_sharedConn_(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("0xE8F1F1", err)
+	}
+	defer pc.Close()
+	sc := newSharedConn(pc)
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := sc.ReadFrom(make([]byte, 10))
+		done <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	if err := sc.Close(); err != nil {
+		t.Error("0xEE756D", err)
+	}
+	if err := <-done; err != errClosed {
+		t.Error("0xE03AD8", "wrong error:", err)
+	}
+	if _, _, err := sc.ReadFrom(make([]byte, 10)); err != errClosed {
+		t.Error("0xE580CC", "wrong error:", err)
+	}
+	// the owner's reads must not time out after Close()
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		_, _ = pc.WriteTo([]byte("abc"), pc.LocalAddr())
+	}()
+	buf := make([]byte, 10)
+	n, _, err := pc.ReadFrom(buf)
+	if string(buf[:n]) != "abc" || err != nil {
+		t.Error("0xE02CA5", err)
+	}
+}
+
+// -----------------------------------------------------------------------------
+// # Internal Adapter
+
+// (pa *packetConnAdapter) Write(p []byte) (int, error)
+// (pa *packetConnAdapter) SetWriteBuffer(bytes int) error
+//
+// go test -run Test_Transport_packetConnAdapter_
+//
+func Test_Transport_packetConnAdapter_(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("0xEAB19F", err)
+	}
+	defer pc.Close()
+	// Write() must write to the remote address
+	conn := newPacketConnAdapter(pc, pc.LocalAddr())
+	n, err := conn.Write([]byte("abc"))
+	if n != 3 || err != nil {
+		t.Error("0xE52D88", err)
+	}
+	buf := make([]byte, 10)
+	_ = pc.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err = pc.ReadFrom(buf)
+	if string(buf[:n]) != "abc" || err != nil {
+		t.Error("0xEE244F", err)
+	}
+	if conn.SetWriteBuffer(64*1024) != nil {
+		t.Error("0xE57D37")
+	}
+	// Write() must fail without a remote address
+	conn = newPacketConnAdapter(pc, nil)
+	_, err = conn.Write([]byte("abc"))
+	if !matchError(err, "no remote address") {
+		t.Error("0xEFFDCF", "wrong error:", err)
+	}
+	// SetWriteBuffer() must do nothing if the connection doesn't support it
+	conn = newPacketConnAdapter(newSharedConn(pc), nil)
+	if conn.SetWriteBuffer(64*1024) != nil {
+		t.Error("0xECFF35")
+	}
+}
+
+// -----------------------------------------------------------------------------
+// # Sender and Receiver
+
+// must report errors returned by Config.Transport
+func Test_Transport_errors_(t *testing.T) {
+	tr := &mockTransport{err: makeError(0xEBAA27, "failed Transport")}
+	//
+	rc := newRunnableReceiver()
+	rc.Address = "[::1]:9877"
+	rc.Config.Transport = tr
+	err := rc.Run()
+	if tr.listenAddress != "[::1]:9877" {
+		t.Error("0xEB61E0", "wrong address:", tr.listenAddress)
+	}
+	if !matchError(err, "failed Transport") {
+		t.Error("0xE1F09D", "wrong error:", err)
+	}
+	sd := makeTestSender()
+	sd.Config.Transport = tr
+	conn, err := sd.connect()
+	if tr.dialAddress != sd.Address {
+		t.Error("0xE6E1B5", "wrong address:", tr.dialAddress)
+	}
+	if conn != nil || !matchError(err, "failed Transport") {
+		t.Error("0xEEA1D8", "wrong error:", err)
+	}
+	// must fail when the Transport returns no connection
+	tr.err = nil
+	err = rc.Run()
+	if !matchError(err, "nil connection from Transport") {
+		t.Error("0xE4E3C8", "wrong error:", err)
+	}
+	conn, err = sd.connect()
+	if conn != nil || !matchError(err, "nil connection or address") {
+		t.Error("0xE8E3E8", "wrong error:", err)
+	}
+}
+
+// end
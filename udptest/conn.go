@@ -0,0 +1,229 @@
+// -----------------------------------------------------------------------------
+// github.com/balacode/udpt                                   /udptest/[conn.go]
+// (c) balarabe@protonmail.com                                      License: MIT
+// -----------------------------------------------------------------------------
+
+package udptest
+
+// # Conn Type
+//   Conn struct
+//   newConn(nw *Network, laddr *net.UDPAddr) *Conn
+//
+// # Methods (c *Conn) implementing net.PacketConn
+//   ) ReadFrom(b []byte) (n int, addr net.Addr, err error)
+//   ) WriteTo(b []byte, addr net.Addr) (n int, err error)
+//   ) Close() error
+//   ) LocalAddr() net.Addr
+//   ) SetDeadline(t time.Time) error
+//   ) SetReadDeadline(t time.Time) error
+//   ) SetWriteDeadline(t time.Time) error
+//
+// # Internal Methods (c *Conn)
+//   ) enqueue(from *net.UDPAddr, b []byte)
+//   ) opError(op string, err error) error
+//
+// # Errors
+//   timeoutError struct
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// packet is a datagram waiting to be read from a Conn
+type packet struct {
+	from *net.UDPAddr
+	data []byte
+} //                                                                      packet
+
+// -----------------------------------------------------------------------------
+// # Conn Type
+
+// Conn is a connection on a virtual Network. It implements net.PacketConn.
+type Conn struct {
+	network *Network
+	laddr   *net.UDPAddr
+	queue   chan packet
+	//
+	mu           sync.Mutex
+	readDeadline time.Time
+	deadlineSet  chan struct{} // closed when the read deadline changes
+	closed       chan struct{} // closed by Close()
+	isClosed     bool
+} //                                                                        Conn
+
+// newConn creates a connection on network 'nw' bound to 'laddr'
+func newConn(nw *Network, laddr *net.UDPAddr) *Conn {
+	return &Conn{
+		network:     nw,
+		laddr:       laddr,
+		queue:       make(chan packet, QueueSize),
+		deadlineSet: make(chan struct{}),
+		closed:      make(chan struct{}),
+	}
+} //                                                                     newConn
+
+// -----------------------------------------------------------------------------
+// # Methods (c *Conn) implementing net.PacketConn
+
+// ReadFrom waits for the next packet, copies it into 'b' and returns
+// the number of bytes copied and the packet's source address.
+//
+// It fails with a timeout error when the read deadline passes,
+// or with net.ErrClosed after the connection is closed.
+//
+func (c *Conn) ReadFrom(b []byte) (n int, addr net.Addr, err error) {
+	for {
+		c.mu.Lock()
+		var (
+			deadline    = c.readDeadline
+			deadlineSet = c.deadlineSet
+			isClosed    = c.isClosed
+		)
+		c.mu.Unlock()
+		if isClosed {
+			return 0, nil, c.opError("read", net.ErrClosed)
+		}
+		var (
+			timer   *time.Timer
+			expired <-chan time.Time
+		)
+		if !deadline.IsZero() {
+			wait := time.Until(deadline)
+			if wait <= 0 {
+				return 0, nil, c.opError("read", &timeoutError{})
+			}
+			timer = time.NewTimer(wait)
+			expired = timer.C
+		}
+		var pk *packet
+		select {
+		case p := <-c.queue:
+			pk = &p
+		case <-c.closed:
+			err = c.opError("read", net.ErrClosed)
+		case <-expired:
+			err = c.opError("read", &timeoutError{})
+		case <-deadlineSet:
+			// check the new deadline
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if pk != nil {
+			n = copy(b, pk.data)
+			return n, pk.from, nil
+		}
+		if err != nil {
+			return 0, nil, err
+		}
+	}
+} //                                                                    ReadFrom
+
+// WriteTo sends a copy of packet 'b' to 'addr'. Like UDP, it doesn't
+// report an error if nothing is listening at 'addr'. It never blocks,
+// so the write deadline has no effect.
+func (c *Conn) WriteTo(b []byte, addr net.Addr) (n int, err error) {
+	c.mu.Lock()
+	isClosed := c.isClosed
+	c.mu.Unlock()
+	if isClosed {
+		return 0, c.opError("write", net.ErrClosed)
+	}
+	to, ok := addr.(*net.UDPAddr)
+	if !ok {
+		if addr == nil {
+			return 0, c.opError("write", errors.New("missing address"))
+		}
+		to, err = resolve(addr.String())
+		if err != nil {
+			return 0, c.opError("write", err)
+		}
+	}
+	c.network.deliver(c.laddr, to, b)
+	return len(b), nil
+} //                                                                     WriteTo
+
+// Close closes the connection and releases its address. Any
+// blocked ReadFrom() calls return an error wrapping net.ErrClosed.
+func (c *Conn) Close() error {
+	c.mu.Lock()
+	if c.isClosed {
+		c.mu.Unlock()
+		return c.opError("close", net.ErrClosed)
+	}
+	c.isClosed = true
+	close(c.closed)
+	c.mu.Unlock()
+	c.network.unbind(c)
+	return nil
+} //                                                                       Close
+
+// LocalAddr returns the virtual address to which the connection is bound.
+func (c *Conn) LocalAddr() net.Addr {
+	return c.laddr
+} //                                                                   LocalAddr
+
+// SetDeadline sets the read and write deadlines.
+func (c *Conn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+} //                                                                 SetDeadline
+
+// SetReadDeadline sets the deadline for ReadFrom() calls, including
+// calls that are already waiting. A zero value means no deadline.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readDeadline = t
+	close(c.deadlineSet)
+	c.deadlineSet = make(chan struct{})
+	return nil
+} //                                                             SetReadDeadline
+
+// SetWriteDeadline does nothing, since WriteTo() never blocks.
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	return nil
+} //                                                            SetWriteDeadline
+
+// -----------------------------------------------------------------------------
+// # Internal Methods (c *Conn)
+
+// enqueue adds a packet received from 'from' to the connection's queue,
+// or drops it if the queue is full or the connection is closed.
+func (c *Conn) enqueue(from *net.UDPAddr, b []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.isClosed {
+		return
+	}
+	select {
+	case c.queue <- packet{from: from, data: b}:
+	default:
+	}
+} //                                                                     enqueue
+
+// opError wraps 'err' in a *net.OpError, like the errors
+// returned by the connections of the net package.
+func (c *Conn) opError(op string, err error) error {
+	return &net.OpError{Op: op, Net: "udp", Addr: c.laddr, Err: err}
+} //                                                                     opError
+
+// -----------------------------------------------------------------------------
+// # Errors
+
+// timeoutError is returned when a read deadline passes.
+// It implements net.Error, like the net package's timeout errors.
+type timeoutError struct{}
+
+// Error returns the error message.
+func (*timeoutError) Error() string { return "i/o timeout" }
+
+// Timeout returns true, since this is a timeout error.
+func (*timeoutError) Timeout() bool { return true }
+
+// Temporary returns true, since the operation can be retried.
+func (*timeoutError) Temporary() bool { return true }
+
+// end
@@ -0,0 +1,144 @@
+// -----------------------------------------------------------------------------
+// github.com/balacode/udpt                              /udptest/[conn_test.go]
+// (c) balarabe@protonmail.com                                      License: MIT
+// -----------------------------------------------------------------------------
+
+package udptest
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// to run all tests in this file:
+// go test -v -run Test_Conn_*
+
+// -----------------------------------------------------------------------------
+
+// newTestConns returns two connections on a new Network
+func newTestConns(t *testing.T) (*Conn, *Conn) {
+	nw := NewNetwork()
+	a, err := nw.ListenPacket("10.0.0.1:1000")
+	if err != nil {
+		t.Fatal("0xE91594", err)
+	}
+	b, err := nw.ListenPacket("10.0.0.2:2000")
+	if err != nil {
+		t.Fatal("0xED3D1C", err)
+	}
+	return a.(*Conn), b.(*Conn)
+}
+
+// (c *Conn) ReadFrom(b []byte) (n int, addr net.Addr, err error)
+// (c *Conn) WriteTo(b []byte, addr net.Addr) (n int, err error)
+//
+// go test -run Test_Conn_ReadFrom_*
+
+// must deliver packets in the order they were written
+func Test_Conn_ReadFrom_1(t *testing.T) {
+	a, b := newTestConns(t)
+	for _, s := range []string{"one", "two", "three"} {
+		n, err := a.WriteTo([]byte(s), b.LocalAddr())
+		if n != len(s) || err != nil {
+			t.Error("0xE5C99B", err)
+		}
+	}
+	buf := make([]byte, 100)
+	for _, want := range []string{"one", "two", "three"} {
+		n, addr, err := b.ReadFrom(buf)
+		if string(buf[:n]) != want || err != nil {
+			t.Error("0xE5994F", want, err)
+		}
+		if addr.String() != "10.0.0.1:1000" {
+			t.Error("0xE7C937", addr)
+		}
+	}
+}
+
+// must fail with a timeout error when the read deadline passes
+func Test_Conn_ReadFrom_2(t *testing.T) {
+	_, b := newTestConns(t)
+	_ = b.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	start := time.Now()
+	_, _, err := b.ReadFrom(make([]byte, 10))
+	var ne net.Error
+	if !errors.As(err, &ne) || !ne.Timeout() ||
+		!strings.Contains(err.Error(), "i/o timeout") {
+		t.Error("0xEAF63C", "wrong error:", err)
+	}
+	if time.Since(start) < 50*time.Millisecond {
+		t.Error("0xE5595D")
+	}
+	// a deadline in the past must fail immediately
+	_ = b.SetReadDeadline(time.Unix(1, 0))
+	_, _, err = b.ReadFrom(make([]byte, 10))
+	if !errors.As(err, &ne) || !ne.Timeout() {
+		t.Error("0xE9EC9E", "wrong error:", err)
+	}
+}
+
+// changing the deadline or closing must interrupt a waiting ReadFrom
+func Test_Conn_ReadFrom_3(t *testing.T) {
+	_, b := newTestConns(t)
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		_ = b.SetReadDeadline(time.Unix(1, 0))
+	}()
+	_, _, err := b.ReadFrom(make([]byte, 10))
+	if !strings.Contains(err.Error(), "i/o timeout") {
+		t.Error("0xE4B989", "wrong error:", err)
+	}
+	_ = b.SetReadDeadline(time.Time{})
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		_ = b.Close()
+	}()
+	_, _, err = b.ReadFrom(make([]byte, 10))
+	if !errors.Is(err, net.ErrClosed) {
+		t.Error("0xE266D3", "wrong error:", err)
+	}
+}
+
+// must silently drop packets sent to unbound
+// addresses, and fail to write to a nil address
+func Test_Conn_WriteTo_(t *testing.T) {
+	a, _ := newTestConns(t)
+	n, err := a.WriteTo([]byte("abc"), &net.UDPAddr{
+		IP: net.ParseIP("10.9.9.9"), Port: 9})
+	if n != 3 || err != nil {
+		t.Error("0xEFAA26", err)
+	}
+	_, err = a.WriteTo([]byte("abc"), nil)
+	if err == nil || !strings.Contains(err.Error(), "missing address") {
+		t.Error("0xE00F02", "wrong error:", err)
+	}
+}
+
+// (c *Conn) Close() error
+//
+// go test -run Test_Conn_Close_
+//
+func Test_Conn_Close_(t *testing.T) {
+	a, b := newTestConns(t)
+	err := b.Close()
+	if err != nil {
+		t.Error("0xE5DDF6", err)
+	}
+	if !errors.Is(b.Close(), net.ErrClosed) {
+		t.Error("0xEC7139")
+	}
+	_, err = b.WriteTo([]byte("abc"), a.LocalAddr())
+	if !errors.Is(err, net.ErrClosed) {
+		t.Error("0xEB5D83", "wrong error:", err)
+	}
+	// the address must be free to bind again
+	c, err := a.network.ListenPacket("10.0.0.2:2000")
+	if c == nil || err != nil {
+		t.Error("0xEFC894", err)
+	}
+}
+
+// end
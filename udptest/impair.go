@@ -0,0 +1,331 @@
+// -----------------------------------------------------------------------------
+// github.com/balacode/udpt                                 /udptest/[impair.go]
+// (c) balarabe@protonmail.com                                      License: MIT
+// -----------------------------------------------------------------------------
+
+package udptest
+
+// # Types
+//   Impairment struct
+//   ImpairmentStats struct
+//   Transport interface
+//
+// # ImpairedConn Type
+//   ImpairedConn struct
+//   Impair(conn net.PacketConn, im Impairment) *ImpairedConn
+//   ) WriteTo(b []byte, addr net.Addr) (int, error)
+//   ) Close() error
+//   ) Stats() ImpairmentStats
+//
+// # ImpairedTransport Type
+//   ImpairedTransport struct
+//   ImpairTransport(tr Transport, im Impairment) *ImpairedTransport
+//   ) ListenPacket(address string) (net.PacketConn, error)
+//   ) DialPacket(address string) (net.PacketConn, net.Addr, error)
+//   ) Stats() ImpairmentStats
+//
+// # Internal Types
+//   impairer struct
+//   newImpairer(im Impairment) *impairer
+//   ) plan(b []byte) []delivery
+//   ) latency() time.Duration
+//   ) transmitDelay(size int, now time.Time) time.Duration
+
+import (
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+// defaultReorderDelay is the extra delay of reordered
+// packets when Impairment.ReorderDelay is not specified.
+const defaultReorderDelay = 10 * time.Millisecond
+
+// -----------------------------------------------------------------------------
+// # Types
+
+// Impairment specifies how a wrapped connection degrades the packets it
+// writes, to simulate a real network. All probabilities are between 0 and
+// 1, and all random decisions are made with a generator seeded with Seed,
+// so the same sequence of writes is always impaired in the same way.
+type Impairment struct {
+
+	// Loss is the probability that a packet is dropped.
+	Loss float64
+
+	// DropFunc is an optional script that decides which packets to drop,
+	// in addition to random Loss. 'seq' is the 0-based sequence number of
+	// the packet written through the wrapper, and 'b' is its content.
+	DropFunc func(seq int, b []byte) bool
+
+	// Delay is the fixed latency added to every packet.
+	Delay time.Duration
+
+	// Jitter is the maximum random latency added on top of Delay.
+	// The added latency is uniformly distributed between 0 and Jitter.
+	// Since each packet gets a different latency, jitter also
+	// reorders packets written in quick succession.
+	Jitter time.Duration
+
+	// DelayFunc optionally replaces Delay and Jitter with your own latency
+	// distribution, for example rnd.ExpFloat64() for exponential latency.
+	// It is called once for every packet with the seeded generator.
+	DelayFunc func(rnd *rand.Rand) time.Duration
+
+	// Reorder is the probability that a packet is held back for
+	// ReorderDelay, so that the packets written after it overtake it.
+	Reorder float64
+
+	// ReorderDelay is the extra delay of reordered packets.
+	// If you leave it zero, it defaults to 10 ms.
+	ReorderDelay time.Duration
+
+	// Duplicate is the probability that a packet is delivered twice.
+	Duplicate float64
+
+	// Corrupt is the probability that one random bit
+	// of the packet is flipped before delivery.
+	Corrupt float64
+
+	// Bandwidth is the maximum number of bytes per second that can be
+	// transmitted. Packets queue up behind each other when it's exceeded.
+	// Set it to zero for unlimited bandwidth.
+	Bandwidth int
+
+	// Seed initializes the random generator that makes all decisions.
+	Seed int64
+} //                                                                  Impairment
+
+// ImpairmentStats counts the packets affected by an Impairment.
+type ImpairmentStats struct {
+	Packets    int // number of packets written through the wrapper
+	Dropped    int // packets dropped by Loss or DropFunc
+	Duplicated int // packets delivered twice
+	Corrupted  int // packets with a flipped bit
+	Reordered  int // packets held back by Reorder
+} //                                                             ImpairmentStats
+
+// Transport has the same methods as udpt.Transport. Network, Host and
+// ImpairedTransport implement it, so they can be used as a udpt.Transport.
+type Transport interface {
+	ListenPacket(address string) (net.PacketConn, error)
+	DialPacket(address string) (net.PacketConn, net.Addr, error)
+} //                                                                   Transport
+
+// delivery is a planned write of 'data' after a delay
+type delivery struct {
+	delay time.Duration
+	data  []byte
+} //                                                                    delivery
+
+// -----------------------------------------------------------------------------
+// # ImpairedConn Type
+
+// ImpairedConn wraps a net.PacketConn and impairs the packets written
+// to it. Packets are read from the wrapped connection unchanged, so to
+// impair both directions, wrap the connections at both ends (or use
+// ImpairTransport).
+type ImpairedConn struct {
+	net.PacketConn
+	im *impairer
+	//
+	mu       sync.Mutex
+	isClosed bool
+} //                                                                ImpairedConn
+
+// Impair returns a wrapper of 'conn' that impairs the
+// packets written to it as specified by 'im'.
+func Impair(conn net.PacketConn, im Impairment) *ImpairedConn {
+	return &ImpairedConn{PacketConn: conn, im: newImpairer(im)}
+} //                                                                      Impair
+
+// WriteTo writes packet 'b' to 'addr', after applying the impairments.
+// Like UDP, it reports success even when the packet is dropped. Delayed
+// packets are written in the background, so WriteTo() never waits.
+func (ic *ImpairedConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	for _, dv := range ic.im.plan(b) {
+		if dv.delay <= 0 {
+			_, err := ic.PacketConn.WriteTo(dv.data, addr)
+			if err != nil {
+				return 0, err
+			}
+			continue
+		}
+		data := dv.data
+		time.AfterFunc(dv.delay, func() {
+			ic.mu.Lock()
+			isClosed := ic.isClosed
+			ic.mu.Unlock()
+			if !isClosed {
+				_, _ = ic.PacketConn.WriteTo(data, addr)
+			}
+		})
+	}
+	return len(b), nil
+} //                                                                     WriteTo
+
+// Close closes the wrapped connection. Delayed
+// packets that were not yet written are dropped.
+func (ic *ImpairedConn) Close() error {
+	ic.mu.Lock()
+	ic.isClosed = true
+	ic.mu.Unlock()
+	return ic.PacketConn.Close()
+} //                                                                       Close
+
+// Stats returns the number of packets affected by the impairments.
+func (ic *ImpairedConn) Stats() ImpairmentStats {
+	ic.im.mu.Lock()
+	defer ic.im.mu.Unlock()
+	return ic.im.stats
+} //                                                                       Stats
+
+// -----------------------------------------------------------------------------
+// # ImpairedTransport Type
+
+// ImpairedTransport wraps a Transport and impairs the packets written to
+// all the connections it creates, in both directions. The connections
+// share one random generator, packet sequence and bandwidth limit,
+// like several hosts sharing the same network link.
+type ImpairedTransport struct {
+	tr Transport
+	im *impairer
+} //                                                           ImpairedTransport
+
+// ImpairTransport returns a wrapper of Transport 'tr' (such as a Network)
+// whose connections impair the packets written to them as specified by
+// 'im'. Assign it to udpt.Configuration.Transport.
+func ImpairTransport(tr Transport, im Impairment) *ImpairedTransport {
+	return &ImpairedTransport{tr: tr, im: newImpairer(im)}
+} //                                                             ImpairTransport
+
+// ListenPacket returns an impaired connection
+// created by the wrapped Transport's ListenPacket().
+func (it *ImpairedTransport) ListenPacket(address string) (
+	net.PacketConn, error,
+) {
+	conn, err := it.tr.ListenPacket(address)
+	if err != nil {
+		return nil, err
+	}
+	return &ImpairedConn{PacketConn: conn, im: it.im}, nil
+} //                                                                ListenPacket
+
+// DialPacket returns an impaired connection
+// created by the wrapped Transport's DialPacket().
+func (it *ImpairedTransport) DialPacket(address string) (
+	net.PacketConn, net.Addr, error,
+) {
+	conn, raddr, err := it.tr.DialPacket(address)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &ImpairedConn{PacketConn: conn, im: it.im}, raddr, nil
+} //                                                                  DialPacket
+
+// Stats returns the number of packets affected by the
+// impairments, in all the connections created so far.
+func (it *ImpairedTransport) Stats() ImpairmentStats {
+	it.im.mu.Lock()
+	defer it.im.mu.Unlock()
+	return it.im.stats
+} //                                                                       Stats
+
+// -----------------------------------------------------------------------------
+// # Internal Types
+
+// impairer holds the settings and state shared
+// by the connections impaired in the same way.
+type impairer struct {
+	Impairment
+	mu       sync.Mutex
+	rnd      *rand.Rand
+	seq      int
+	stats    ImpairmentStats
+	nextFree time.Time // when the link finishes transmitting queued packets
+} //                                                                    impairer
+
+// newImpairer creates an impairer with a generator seeded with im.Seed
+func newImpairer(im Impairment) *impairer {
+	return &impairer{Impairment: im, rnd: rand.New(rand.NewSource(im.Seed))}
+} //                                                                 newImpairer
+
+// plan decides what happens to packet 'b' and returns the copies to
+// deliver with their delays. Returns no deliveries if it's dropped.
+//
+// To make the decisions reproducible, every packet draws the same
+// random numbers in the same order, whatever the outcome.
+//
+func (ir *impairer) plan(b []byte) []delivery {
+	ir.mu.Lock()
+	defer ir.mu.Unlock()
+	seq := ir.seq
+	ir.seq++
+	ir.stats.Packets++
+	var (
+		lost       = ir.rnd.Float64() < ir.Loss
+		corrupt    = ir.rnd.Float64() < ir.Corrupt
+		corruptBit = ir.rnd.Intn(8 * (len(b) + 1))
+		duplicate  = ir.rnd.Float64() < ir.Duplicate
+		reorder    = ir.rnd.Float64() < ir.Reorder
+		latency1   = ir.latency()
+		latency2   = ir.latency()
+	)
+	if lost || (ir.DropFunc != nil && ir.DropFunc(seq, b)) {
+		ir.stats.Dropped++
+		return nil
+	}
+	data := append([]byte{}, b...)
+	if corrupt && len(data) > 0 {
+		data[corruptBit/8%len(data)] ^= 1 << (corruptBit % 8)
+		ir.stats.Corrupted++
+	}
+	delay := ir.transmitDelay(len(data), time.Now()) + latency1
+	if reorder {
+		if ir.ReorderDelay > 0 {
+			delay += ir.ReorderDelay
+		} else {
+			delay += defaultReorderDelay
+		}
+		ir.stats.Reordered++
+	}
+	ret := []delivery{{delay: delay, data: data}}
+	if duplicate {
+		delay = ir.transmitDelay(len(data), time.Now()) + latency2
+		ret = append(ret, delivery{delay: delay, data: data})
+		ir.stats.Duplicated++
+	}
+	return ret
+} //                                                                        plan
+
+// latency returns the latency of a packet: the result of DelayFunc
+// if specified, or Delay plus a random part of Jitter.
+func (ir *impairer) latency() time.Duration {
+	if ir.DelayFunc != nil {
+		return ir.DelayFunc(ir.rnd)
+	}
+	ret := ir.Delay
+	if ir.Jitter > 0 {
+		ret += time.Duration(ir.rnd.Int63n(int64(ir.Jitter)))
+	}
+	return ret
+} //                                                                     latency
+
+// transmitDelay returns how long a packet of 'size' bytes written at
+// time 'now' waits until the link has transmitted it, when Bandwidth
+// is limited. The packets queue up behind each other.
+func (ir *impairer) transmitDelay(size int, now time.Time) time.Duration {
+	if ir.Bandwidth <= 0 {
+		return 0
+	}
+	start := ir.nextFree
+	if start.Before(now) {
+		start = now
+	}
+	ir.nextFree = start.Add(
+		time.Duration(size) * time.Second / time.Duration(ir.Bandwidth))
+	return ir.nextFree.Sub(now)
+} //                                                               transmitDelay
+
+// end
@@ -0,0 +1,303 @@
+// -----------------------------------------------------------------------------
+// github.com/balacode/udpt                            /udptest/[impair_test.go]
+// (c) balarabe@protonmail.com                                      License: MIT
+// -----------------------------------------------------------------------------
+
+package udptest
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"net"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// to run all tests in this file:
+// go test -v -run Test_Impair_*
+
+// -----------------------------------------------------------------------------
+
+// newImpairedPair returns a connection on a new Network impaired by 'im',
+// and the connection to which it writes, along with that connection's address
+func newImpairedPair(t *testing.T, im Impairment,
+) (*ImpairedConn, net.PacketConn, net.Addr) {
+	nw := NewNetwork()
+	dst, err := nw.ListenPacket("10.0.0.1:1000")
+	if err != nil {
+		t.Fatal("0xE2BC38", err)
+	}
+	src, _, err := nw.DialPacket("10.0.0.1:1000")
+	if err != nil {
+		t.Fatal("0xE28DC3", err)
+	}
+	return Impair(src, im), dst, dst.LocalAddr()
+}
+
+// writeNumbers writes packets containing the numbers 0 to n-1
+func writeNumbers(conn net.PacketConn, addr net.Addr, n int) {
+	for i := 0; i < n; i++ {
+		_, _ = conn.WriteTo([]byte(strconv.Itoa(i)), addr)
+	}
+}
+
+// readAll reads packets from 'conn' until no packet
+// arrives for 'idle' time, and returns their contents
+func readAll(conn net.PacketConn, idle time.Duration) []string {
+	var (
+		ret []string
+		buf = make([]byte, 65536)
+	)
+	for {
+		_ = conn.SetReadDeadline(time.Now().Add(idle))
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			return ret
+		}
+		ret = append(ret, string(buf[:n]))
+	}
+}
+
+// -----------------------------------------------------------------------------
+// # Packet Impairments
+
+// must drop about the specified fraction of packets
+func Test_Impair_Loss_1(t *testing.T) {
+	ic, dst, addr := newImpairedPair(t, Impairment{Loss: 0.2, Seed: 1})
+	writeNumbers(ic, addr, 1000)
+	got := readAll(dst, 20*time.Millisecond)
+	if len(got) < 750 || len(got) > 850 {
+		t.Error("0xE6669A", "received:", len(got))
+	}
+	st := ic.Stats()
+	if st.Packets != 1000 || st.Dropped != 1000-len(got) {
+		t.Error("0xECBF27", st)
+	}
+}
+
+// the same seed must drop the same packets
+func Test_Impair_Loss_2(t *testing.T) {
+	var results [3][]string
+	for i, seed := range []int64{7, 7, 8} {
+		ic, dst, addr := newImpairedPair(t, Impairment{Loss: 0.5, Seed: seed})
+		writeNumbers(ic, addr, 100)
+		results[i] = readAll(dst, 20*time.Millisecond)
+	}
+	if fmt.Sprint(results[0]) != fmt.Sprint(results[1]) {
+		t.Error("0xE1586F")
+	}
+	if fmt.Sprint(results[0]) == fmt.Sprint(results[2]) {
+		t.Error("0xE68515")
+	}
+}
+
+// DropFunc must drop the scripted packets
+func Test_Impair_DropFunc_(t *testing.T) {
+	ic, dst, addr := newImpairedPair(t, Impairment{
+		DropFunc: func(seq int, b []byte) bool { return seq%3 == 0 },
+	})
+	writeNumbers(ic, addr, 9)
+	got := readAll(dst, 20*time.Millisecond)
+	if fmt.Sprint(got) != "[1 2 4 5 7 8]" {
+		t.Error("0xEA67C3", got)
+	}
+}
+
+// must deliver every packet twice
+func Test_Impair_Duplicate_(t *testing.T) {
+	ic, dst, addr := newImpairedPair(t, Impairment{Duplicate: 1})
+	writeNumbers(ic, addr, 3)
+	got := readAll(dst, 20*time.Millisecond)
+	if fmt.Sprint(got) != "[0 0 1 1 2 2]" {
+		t.Error("0xE4F549", got)
+	}
+	if ic.Stats().Duplicated != 3 {
+		t.Error("0xE8C230")
+	}
+}
+
+// must flip exactly one bit of every packet
+func Test_Impair_Corrupt_(t *testing.T) {
+	ic, dst, addr := newImpairedPair(t, Impairment{Corrupt: 1, Seed: 3})
+	want := bytes.Repeat([]byte{0x55}, 100)
+	for i := 0; i < 20; i++ {
+		_, _ = ic.WriteTo(want, addr)
+	}
+	got := readAll(dst, 20*time.Millisecond)
+	if len(got) != 20 {
+		t.Error("0xE90AE1", len(got))
+	}
+	for _, s := range got {
+		bits := 0
+		for i := range want {
+			for x := want[i] ^ s[i]; x != 0; x &= x - 1 {
+				bits++
+			}
+		}
+		if bits != 1 {
+			t.Error("0xE52A72", "flipped bits:", bits)
+		}
+	}
+	// the original packet must not be modified
+	if !bytes.Equal(want, bytes.Repeat([]byte{0x55}, 100)) {
+		t.Error("0xEC50DF")
+	}
+}
+
+// must delay packets, and reorder them with jitter
+func Test_Impair_Delay_(t *testing.T) {
+	ic, dst, addr := newImpairedPair(t, Impairment{
+		Delay: 30 * time.Millisecond, Jitter: 20 * time.Millisecond, Seed: 5,
+	})
+	start := time.Now()
+	writeNumbers(ic, addr, 50)
+	buf := make([]byte, 10)
+	_ = dst.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := dst.ReadFrom(buf)
+	if err != nil || time.Since(start) < 30*time.Millisecond {
+		t.Error("0xE946AE", err, time.Since(start))
+	}
+	got := append([]string{string(buf[:n])},
+		readAll(dst, 100*time.Millisecond)...)
+	if len(got) != 50 {
+		t.Error("0xEA0C47", len(got))
+	}
+	if sort.SliceIsSorted(got, func(i, j int) bool {
+		a, _ := strconv.Atoi(got[i])
+		b, _ := strconv.Atoi(got[j])
+		return a < b
+	}) {
+		t.Error("0xE1FC5C", "packets not reordered")
+	}
+}
+
+// DelayFunc must replace Delay and Jitter
+func Test_Impair_DelayFunc_(t *testing.T) {
+	calls := 0
+	ic, dst, addr := newImpairedPair(t, Impairment{
+		Delay: time.Hour,
+		DelayFunc: func(rnd *rand.Rand) time.Duration {
+			calls++
+			return time.Duration(rnd.ExpFloat64() * float64(time.Millisecond))
+		},
+	})
+	writeNumbers(ic, addr, 10)
+	got := readAll(dst, 100*time.Millisecond)
+	if len(got) != 10 || calls != 20 {
+		t.Error("0xEE7295", len(got), calls)
+	}
+}
+
+// must hold back reordered packets so later packets overtake them
+func Test_Impair_Reorder_(t *testing.T) {
+	ic, dst, addr := newImpairedPair(t, Impairment{
+		DropFunc: func(seq int, b []byte) bool { return false },
+	})
+	ic.im.Reorder = 1
+	_, _ = ic.WriteTo([]byte("first"), addr)
+	ic.im.Reorder = 0
+	_, _ = ic.WriteTo([]byte("second"), addr)
+	got := readAll(dst, 50*time.Millisecond)
+	if fmt.Sprint(got) != "[second first]" {
+		t.Error("0xEDF24A", got)
+	}
+	if ic.Stats().Reordered != 1 {
+		t.Error("0xE9E231")
+	}
+}
+
+// must not transmit faster than the bandwidth limit
+func Test_Impair_Bandwidth_(t *testing.T) {
+	ic, dst, addr := newImpairedPair(t, Impairment{Bandwidth: 100 * 1000})
+	start := time.Now()
+	packet := make([]byte, 1000)
+	for i := 0; i < 10; i++ {
+		_, _ = ic.WriteTo(packet, addr) // 10 KB at 100 KB/s: 100 ms
+	}
+	got := readAll(dst, 50*time.Millisecond)
+	elapsed := time.Since(start) - 50*time.Millisecond
+	if len(got) != 10 || elapsed < 90*time.Millisecond {
+		t.Error("0xE61EC8", len(got), elapsed)
+	}
+}
+
+// delayed packets must be dropped when the connection is closed
+func Test_Impair_Close_(t *testing.T) {
+	ic, dst, addr := newImpairedPair(t, Impairment{
+		Delay: 20 * time.Millisecond,
+	})
+	writeNumbers(ic, addr, 5)
+	err := ic.Close()
+	if err != nil {
+		t.Error("0xEBB90E", err)
+	}
+	got := readAll(dst, 50*time.Millisecond)
+	if len(got) != 0 {
+		t.Error("0xE0451A", got)
+	}
+}
+
+// -----------------------------------------------------------------------------
+// # Transfers
+
+// transfers must complete under 20% packet loss in both directions
+func Test_Impair_transfer_1(t *testing.T) {
+	t.Parallel()
+	nw := NewNetwork()
+	tr := ImpairTransport(nw, Impairment{Loss: 0.2, Seed: 1})
+	items := map[string][]byte{}
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 10; i++ {
+		v := make([]byte, 20*1000) // random, so about 20 fragments each
+		_, _ = rnd.Read(v)
+		items[fmt.Sprint("item", i)] = v
+	}
+	got := testTransfer(t, tr, tr, "10.0.0.1:9876", "10.0.0.1:9876", items)
+	for k, v := range items {
+		if !bytes.Equal(got[k], v) {
+			t.Error("0xE121BF", "not received:", k)
+		}
+	}
+	st := tr.Stats()
+	if st.Dropped == 0 || float64(st.Dropped) > 0.3*float64(st.Packets) {
+		t.Error("0xE24D81", st)
+	}
+}
+
+// transfers must complete with delay, jitter, reordering,
+// duplication, corruption and some loss all together
+func Test_Impair_transfer_2(t *testing.T) {
+	t.Parallel()
+	nw := NewNetwork()
+	tr := ImpairTransport(nw, Impairment{
+		Loss:      0.05,
+		Delay:     2 * time.Millisecond,
+		Jitter:    3 * time.Millisecond,
+		Reorder:   0.05,
+		Duplicate: 0.05,
+		Corrupt:   0.02,
+		Seed:      2,
+	})
+	items := map[string][]byte{}
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 5; i++ {
+		v := make([]byte, 20*1000) // random, so it can't be compressed
+		_, _ = rnd.Read(v)
+		items[fmt.Sprint("item", i)] = v
+	}
+	got := testTransfer(t, tr, tr, "10.0.0.1:9876", "10.0.0.1:9876", items)
+	for k, v := range items {
+		if !bytes.Equal(got[k], v) {
+			t.Error("0xEA711C", "not received:", k)
+		}
+	}
+	st := tr.Stats()
+	if st.Duplicated == 0 || st.Corrupted == 0 || st.Reordered == 0 {
+		t.Error("0xE83957", st)
+	}
+}
+
+// end
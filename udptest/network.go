@@ -0,0 +1,321 @@
+// -----------------------------------------------------------------------------
+// github.com/balacode/udpt                                /udptest/[network.go]
+// (c) balarabe@protonmail.com                                      License: MIT
+// -----------------------------------------------------------------------------
+
+// Package udptest provides an in-memory packet network for testing code
+// that uses udpt, without opening real sockets.
+//
+// A Network implements the udpt.Transport interface, so you can assign
+// it to Configuration.Transport of a Sender and a Receiver:
+//
+//     nw := udptest.NewNetwork()
+//     cf := udpt.NewDefaultConfig()
+//     cf.Transport = nw
+//     rc := udpt.Receiver{Address: "10.0.0.1:9876", Config: cf, ...}
+//     sd := udpt.Sender{Address: "10.0.0.1:9876", Config: cf, ...}
+//
+// A Network also supports multicast: connections created by ListenMulticast()
+// receive every packet written to their group address, so a Receiver with
+// a MulticastGroup can be tested without a multicast-capable network.
+//
+// Packets are delivered immediately, in the order they are written,
+// and are never lost, duplicated or corrupted. To simulate a real
+// network, wrap the Network with ImpairTransport(). Each test can create
+// its own Network, so tests can run in parallel without port clashes.
+//
+package udptest
+
+// # Network Type
+//   Network struct
+//   NewNetwork() *Network
+//
+// # Methods (nw *Network)
+//   ) ListenPacket(address string) (net.PacketConn, error)
+//   ) DialPacket(address string) (net.PacketConn, net.Addr, error)
+//   ) ListenMulticast(group string) (net.PacketConn, error)
+//   ) Host(ip string) *Host
+//
+// # Host Type
+//   Host struct
+//   ) ListenPacket(address string) (net.PacketConn, error)
+//   ) ListenMulticast(group string) (net.PacketConn, error)
+//   ) DialPacket(address string) (net.PacketConn, net.Addr, error)
+//
+// # Internal Methods (nw *Network)
+//   ) bind(addr *net.UDPAddr) (*Conn, error)
+//   ) join(group *net.UDPAddr) *Conn
+//   ) deliver(from, to *net.UDPAddr, b []byte)
+//   ) unbind(c *Conn)
+//
+// # Helper Function
+//   resolve(address string) (*net.UDPAddr, error)
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+)
+
+// DefaultHost is the IP address of the virtual host used by
+// Network.DialPacket() and Network.ListenPacket() when the
+// address doesn't specify a host.
+const DefaultHost = "127.0.0.1"
+
+// QueueSize is the number of packets each connection can hold before
+// they are read. Like a UDP socket's buffer, further packets are dropped.
+const QueueSize = 4096
+
+// firstEphemeralPort is the first port number assigned to connections
+// created by DialPacket(), like the dynamic port range of real hosts.
+const firstEphemeralPort = 49152
+
+// -----------------------------------------------------------------------------
+// # Network Type
+
+// Network is an in-memory packet network which connects virtual hosts.
+// Each virtual host is identified by an IP address, which doesn't need
+// to exist on the local machine. A Network is safe for concurrent use.
+type Network struct {
+	mu       sync.Mutex
+	conns    map[string]*Conn
+	groups   map[string][]*Conn // members of each multicast group
+	nextPort int
+} //                                                                     Network
+
+// NewNetwork creates a new, empty in-memory network.
+func NewNetwork() *Network {
+	return &Network{
+		conns:    make(map[string]*Conn),
+		nextPort: firstEphemeralPort,
+	}
+} //                                                                  NewNetwork
+
+// -----------------------------------------------------------------------------
+// # Methods (nw *Network)
+
+// ListenPacket returns a connection bound to the local 'address',
+// for use by a Receiver. If the address doesn't specify a host, for
+// example ":9876", the connection receives packets sent to that port
+// on any host. It implements the udpt.Transport interface.
+func (nw *Network) ListenPacket(address string) (net.PacketConn, error) {
+	addr, err := resolve(address)
+	if err != nil {
+		return nil, err
+	}
+	return nw.bind(addr)
+} //                                                                ListenPacket
+
+// ListenMulticast returns a connection that joins the multicast 'group'
+// ("ip:port", where ip is a multicast address) on DefaultHost. It receives
+// every packet written to the group. Any number of connections can join
+// the same group. It implements the udpt.MulticastTransport interface.
+func (nw *Network) ListenMulticast(group string) (net.PacketConn, error) {
+	return nw.Host(DefaultHost).ListenMulticast(group)
+} //                                                             ListenMulticast
+
+// DialPacket returns a connection bound to a new port on DefaultHost,
+// for use by a Sender sending packets to 'address'. It implements
+// the udpt.Transport interface.
+func (nw *Network) DialPacket(address string) (
+	net.PacketConn, net.Addr, error,
+) {
+	return nw.Host(DefaultHost).DialPacket(address)
+} //                                                                  DialPacket
+
+// Host returns the virtual host with the IP address 'ip' on this network.
+// Use it when a test needs Senders with different source addresses.
+func (nw *Network) Host(ip string) *Host {
+	return &Host{network: nw, ip: net.ParseIP(ip)}
+} //                                                                        Host
+
+// -----------------------------------------------------------------------------
+// # Host Type
+
+// Host is a virtual host on a Network. It implements the udpt.Transport
+// interface, creating connections bound to the host's IP address.
+type Host struct {
+	network *Network
+	ip      net.IP
+} //                                                                        Host
+
+// ListenPacket returns a connection bound to the port in 'address',
+// on this host's IP address. The host part of 'address' is ignored.
+func (ht *Host) ListenPacket(address string) (net.PacketConn, error) {
+	addr, err := resolve(address)
+	if err != nil {
+		return nil, err
+	}
+	if ht.ip == nil {
+		return nil, errors.New("udptest: invalid host IP address")
+	}
+	addr.IP = ht.ip
+	return ht.network.bind(addr)
+} //                                                                ListenPacket
+
+// ListenMulticast returns a connection that joins the multicast 'group'
+// on this host. See Network.ListenMulticast().
+func (ht *Host) ListenMulticast(group string) (net.PacketConn, error) {
+	addr, err := resolve(group)
+	if err != nil {
+		return nil, err
+	}
+	if !addr.IP.IsMulticast() {
+		return nil, errors.New("udptest: not a multicast address: " + group)
+	}
+	if ht.ip == nil {
+		return nil, errors.New("udptest: invalid host IP address")
+	}
+	return ht.network.join(addr), nil
+} //                                                             ListenMulticast
+
+// DialPacket returns a connection bound to a new port on this host,
+// and the resolved remote 'address' to which it should send packets.
+func (ht *Host) DialPacket(address string) (
+	net.PacketConn, net.Addr, error,
+) {
+	raddr, err := resolve(address)
+	if err != nil {
+		return nil, nil, err
+	}
+	if raddr.IP == nil {
+		return nil, nil, errors.New("udptest: missing host in " + address)
+	}
+	if ht.ip == nil {
+		return nil, nil, errors.New("udptest: invalid host IP address")
+	}
+	conn, err := ht.network.bind(&net.UDPAddr{IP: ht.ip})
+	if err != nil {
+		return nil, nil, err
+	}
+	return conn, raddr, nil
+} //                                                                  DialPacket
+
+// -----------------------------------------------------------------------------
+// # Internal Methods (nw *Network)
+
+// bind creates a connection bound to 'addr'. If the
+// port is zero, assigns the next free ephemeral port.
+func (nw *Network) bind(addr *net.UDPAddr) (*Conn, error) {
+	nw.mu.Lock()
+	defer nw.mu.Unlock()
+	if nw.conns == nil {
+		nw.conns = make(map[string]*Conn)
+		nw.nextPort = firstEphemeralPort
+	}
+	if addr.Port == 0 {
+		for i := 0; ; i++ {
+			if i > 65535 {
+				return nil, errors.New("udptest: no free ports")
+			}
+			addr.Port = nw.nextPort
+			nw.nextPort++
+			if nw.nextPort > 65535 {
+				nw.nextPort = firstEphemeralPort
+			}
+			if nw.conns[addr.String()] == nil {
+				break
+			}
+		}
+	}
+	key := addr.String()
+	if nw.conns[key] != nil {
+		return nil, errors.New("udptest: address already in use: " + key)
+	}
+	c := newConn(nw, addr)
+	nw.conns[key] = c
+	return c, nil
+} //                                                                        bind
+
+// join creates a connection that is a member of multicast 'group'
+func (nw *Network) join(group *net.UDPAddr) *Conn {
+	nw.mu.Lock()
+	defer nw.mu.Unlock()
+	if nw.groups == nil {
+		nw.groups = make(map[string][]*Conn)
+	}
+	c := newConn(nw, group)
+	key := group.String()
+	nw.groups[key] = append(nw.groups[key], c)
+	return c
+} //                                                                        join
+
+// deliver queues a copy of packet 'b' from address 'from' on the
+// connection bound to address 'to', or to a connection listening on
+// the same port on any host. If 'to' is a multicast address, queues
+// a copy on every member of the group. If there is no such connection,
+// or its queue is full, the packet is silently dropped, like with UDP.
+func (nw *Network) deliver(from, to *net.UDPAddr, b []byte) {
+	nw.mu.Lock()
+	var targets []*Conn
+	if to.IP.IsMulticast() {
+		targets = append(targets, nw.groups[to.String()]...)
+	} else if c := nw.conns[to.String()]; c != nil {
+		targets = append(targets, c)
+	} else if c := nw.conns[(&net.UDPAddr{Port: to.Port}).String()]; c != nil {
+		targets = append(targets, c)
+	}
+	nw.mu.Unlock()
+	for _, c := range targets {
+		c.enqueue(from, append([]byte{}, b...))
+	}
+} //                                                                     deliver
+
+// unbind releases the address of connection 'c',
+// or removes it from its multicast group
+func (nw *Network) unbind(c *Conn) {
+	nw.mu.Lock()
+	defer nw.mu.Unlock()
+	key := c.laddr.String()
+	if c.laddr.IP.IsMulticast() {
+		members := nw.groups[key]
+		for i, member := range members {
+			if member == c {
+				members = append(members[:i:i], members[i+1:]...)
+				break
+			}
+		}
+		nw.groups[key] = members
+		if len(members) == 0 {
+			delete(nw.groups, key)
+		}
+		return
+	}
+	if nw.conns[key] == c {
+		delete(nw.conns, key)
+	}
+} //                                                                      unbind
+
+// -----------------------------------------------------------------------------
+// # Helper Function
+
+// resolve parses 'address' in "host:port" form, where host must be
+// an IP address or blank. Unspecified hosts like "0.0.0.0" or "::"
+// become a nil IP, which means any host.
+func resolve(address string) (*net.UDPAddr, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, errors.New("udptest: " + err.Error())
+	}
+	n, err := strconv.Atoi(port)
+	if err != nil || n < 0 || n > 65535 {
+		return nil, errors.New("udptest: invalid port in " + address)
+	}
+	ret := &net.UDPAddr{Port: n}
+	if host != "" {
+		ret.IP = net.ParseIP(host)
+		if ret.IP == nil {
+			return nil, errors.New("udptest: host must be an IP address: " +
+				host)
+		}
+		if ret.IP.IsUnspecified() {
+			ret.IP = nil
+		} else if ip4 := ret.IP.To4(); ip4 != nil {
+			ret.IP = ip4
+		}
+	}
+	return ret, nil
+} //                                                                     resolve
+
+// end
@@ -0,0 +1,258 @@
+// -----------------------------------------------------------------------------
+// github.com/balacode/udpt                           /udptest/[network_test.go]
+// (c) balarabe@protonmail.com                                      License: MIT
+// -----------------------------------------------------------------------------
+
+package udptest
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/balacode/udpt"
+)
+
+// to run all tests in this file:
+// go test -v -run Test_Network_*
+
+// -----------------------------------------------------------------------------
+
+// testCryptoKey is the AES-256 key used by transfer tests
+var testCryptoKey = []byte("aA2Xh41FiC4Wtj3e5b2LbytMdn6on7P0")
+
+// testTransfer sends 'items' from a Sender using Transport 'sdTransport' to
+// 'address', where a Receiver using 'rcTransport' listens on 'listenAddress'.
+// It returns the items received, mapped by key.
+func testTransfer(t *testing.T, rcTransport, sdTransport Transport,
+	listenAddress, address string, items map[string][]byte,
+) map[string][]byte {
+	var (
+		mu       sync.Mutex
+		received = make(map[string][]byte)
+	)
+	rcConfig := udpt.NewDefaultConfig()
+	rcConfig.Transport = rcTransport
+	rc := udpt.Receiver{Address: listenAddress, CryptoKey: testCryptoKey,
+		Config: rcConfig,
+		Receive: func(k string, v []byte) error {
+			mu.Lock()
+			received[k] = v
+			mu.Unlock()
+			return nil
+		},
+	}
+	go func() {
+		err := rc.Run()
+		if err != nil {
+			t.Error("0xE3078F", err)
+		}
+	}()
+	defer rc.Stop()
+	time.Sleep(50 * time.Millisecond)
+	//
+	sdConfig := udpt.NewDefaultConfig()
+	sdConfig.Transport = sdTransport
+	sdConfig.ReplyTimeout = 200 * time.Millisecond // resend lost packets sooner
+	sdConfig.SendRetryInterval = 50 * time.Millisecond
+	sd := udpt.Sender{Address: address, CryptoKey: testCryptoKey,
+		Config: sdConfig}
+	for k, v := range items {
+		err := sd.Send(k, v)
+		if err != nil {
+			t.Error("0xEF0F34", k, err)
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	return received
+}
+
+// testAddr is a net.Addr that is not a *net.UDPAddr
+type testAddr string
+
+// Network returns the name of the network
+func (ad testAddr) Network() string { return "udp" }
+
+// String returns the address in "host:port" form
+func (ad testAddr) String() string { return string(ad) }
+
+// -----------------------------------------------------------------------------
+// # Transfers
+
+// must transfer data items between a Sender and Receiver without sockets;
+// both tests listen on the same address, but on separate networks
+func Test_Network_transfer_1(t *testing.T) {
+	t.Parallel()
+	items := map[string][]byte{}
+	for i := 0; i < 20; i++ {
+		items[fmt.Sprint("item", i)] = bytes.Repeat([]byte{byte(i)}, i*1000)
+	}
+	nw := NewNetwork()
+	got := testTransfer(t, nw, nw.Host("10.0.0.2"),
+		"10.0.0.1:9876", "10.0.0.1:9876", items)
+	if len(got) != len(items) {
+		t.Error("0xE69D50", len(got))
+	}
+	for k, v := range items {
+		if !bytes.Equal(got[k], v) {
+			t.Error("0xEFC5E4", k)
+		}
+	}
+}
+
+// must transfer a large data item to a Receiver listening on any host
+func Test_Network_transfer_2(t *testing.T) {
+	t.Parallel()
+	v := []byte(strings.Repeat("0123456789", 200*1024)) // 2 MB
+	nw := NewNetwork()
+	got := testTransfer(t, nw, nw.Host("10.0.0.2"),
+		":9876", "10.0.0.1:9876", map[string][]byte{"big": v})
+	if !bytes.Equal(got["big"], v) {
+		t.Error("0xE53032")
+	}
+}
+
+// -----------------------------------------------------------------------------
+// # Methods (nw *Network)
+
+// (nw *Network) ListenPacket(address string) (net.PacketConn, error)
+//
+// go test -run Test_Network_ListenPacket_
+//
+func Test_Network_ListenPacket_(t *testing.T) {
+	nw := NewNetwork()
+	c, err := nw.ListenPacket("[::]:9876")
+	if err != nil || c.LocalAddr().String() != ":9876" {
+		t.Error("0xED03A4", err)
+	}
+	_, err = nw.ListenPacket("0.0.0.0:9876")
+	if err == nil || !strings.Contains(err.Error(), "already in use") {
+		t.Error("0xE0F086", "wrong error:", err)
+	}
+	c, err = nw.ListenPacket("[fd00::1]:9876")
+	if err != nil || c.LocalAddr().String() != "[fd00::1]:9876" {
+		t.Error("0xEABA16", err)
+	}
+	for _, address := range []string{
+		"10.0.0.1", "10.0.0.1:port", "10.0.0.1:65536", "localhost:9876",
+	} {
+		c, err = nw.ListenPacket(address)
+		if c != nil || err == nil {
+			t.Error("0xEF88B5", address)
+		}
+	}
+}
+
+// (nw *Network) DialPacket(address string) (
+//     net.PacketConn, net.Addr, error)
+//
+// go test -run Test_Network_DialPacket_
+//
+func Test_Network_DialPacket_(t *testing.T) {
+	nw := NewNetwork()
+	c1, raddr, err := nw.DialPacket("10.0.0.1:9876")
+	if err != nil || raddr.String() != "10.0.0.1:9876" {
+		t.Error("0xE88A53", err)
+	}
+	if c1.LocalAddr().String() != DefaultHost+":49152" {
+		t.Error("0xE086FF", c1.LocalAddr())
+	}
+	c2, _, _ := nw.Host("10.0.0.7").DialPacket("10.0.0.1:9876")
+	if c2.LocalAddr().String() != "10.0.0.7:49153" {
+		t.Error("0xECC8B7", c2.LocalAddr())
+	}
+	// must fail without a remote host or with an invalid local host
+	_, _, err = nw.DialPacket(":9876")
+	if err == nil || !strings.Contains(err.Error(), "missing host") {
+		t.Error("0xEC1DA1", "wrong error:", err)
+	}
+	_, _, err = nw.Host("bad").DialPacket("10.0.0.1:9876")
+	if err == nil || !strings.Contains(err.Error(), "invalid host") {
+		t.Error("0xEC28A7", "wrong error:", err)
+	}
+}
+
+// (ht *Host) ListenPacket(address string) (net.PacketConn, error)
+//
+// go test -run Test_Network_Host_ListenPacket_
+//
+func Test_Network_Host_ListenPacket_(t *testing.T) {
+	nw := NewNetwork()
+	c, err := nw.Host("10.0.0.5").ListenPacket(":9876")
+	if err != nil || c.LocalAddr().String() != "10.0.0.5:9876" {
+		t.Error("0xE6157F", err)
+	}
+	// packets sent to another host's address must not arrive
+	d, _, _ := nw.DialPacket("10.0.0.5:9876")
+	_, _ = d.WriteTo([]byte("x"), c.LocalAddr())
+	_, _ = d.WriteTo([]byte("y"), testAddr("10.0.0.6:9876"))
+	_, _ = d.WriteTo([]byte("z"), testAddr("10.0.0.5:9876"))
+	buf := make([]byte, 10)
+	for _, want := range []string{"x", "z"} {
+		_ = c.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+		n, _, err := c.ReadFrom(buf)
+		if string(buf[:n]) != want || err != nil {
+			t.Error("0xE64DEF", want, err)
+		}
+	}
+	_, err = nw.Host("bad").ListenPacket(":9876")
+	if err == nil {
+		t.Error("0xE2A4B6")
+	}
+}
+
+// (nw *Network) ListenMulticast(group string) (net.PacketConn, error)
+//
+// go test -run Test_Network_ListenMulticast_
+//
+func Test_Network_ListenMulticast_(t *testing.T) {
+	nw := NewNetwork()
+	m1, err := nw.ListenMulticast("239.1.2.3:9000")
+	if err != nil {
+		t.Fatal("0xE3D0C5", err)
+	}
+	m2, _ := nw.Host("10.0.0.7").ListenMulticast("239.1.2.3:9000")
+	other, _ := nw.ListenMulticast("239.1.2.4:9000")
+	//
+	// every member of the group must receive a copy
+	d, _, _ := nw.DialPacket("239.1.2.3:9000")
+	_, _ = d.WriteTo([]byte("x"), testAddr("239.1.2.3:9000"))
+	buf := make([]byte, 10)
+	for i, c := range []net.PacketConn{m1, m2} {
+		_ = c.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+		n, from, err := c.ReadFrom(buf)
+		if string(buf[:n]) != "x" || err != nil ||
+			from.String() != d.LocalAddr().String() {
+			t.Error("0xE2F7B1", i, err)
+		}
+	}
+	_ = other.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+	_, _, err = other.ReadFrom(buf)
+	if err == nil {
+		t.Error("0xEF1C8A", "received another group's packet")
+	}
+	// a member that left must not receive packets
+	_ = m1.Close()
+	_, _ = d.WriteTo([]byte("y"), testAddr("239.1.2.3:9000"))
+	_ = m2.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	n, _, _ := m2.ReadFrom(buf)
+	if string(buf[:n]) != "y" || len(nw.groups["239.1.2.3:9000"]) != 1 {
+		t.Error("0xE8A5D4")
+	}
+	// must only join multicast addresses
+	_, err = nw.ListenMulticast("10.0.0.1:9000")
+	if err == nil || !strings.Contains(err.Error(), "not a multicast") {
+		t.Error("0xE61BB3", "wrong error:", err)
+	}
+	_, err = nw.Host("bad").ListenMulticast("239.1.2.3:9000")
+	if err == nil || !strings.Contains(err.Error(), "invalid host") {
+		t.Error("0xE0D9F6", "wrong error:", err)
+	}
+}
+
+// end
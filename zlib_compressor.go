@@ -53,13 +53,25 @@ func (*zlibCompressor) compressDI(
 // Uncompress uncompresses bytes using zlib and returns the uncompressed bytes.
 // If there was an error, returns nil and the error instance.
 func (zc *zlibCompressor) Uncompress(comp []byte) ([]byte, error) {
-	return zc.uncompressDI(comp, zlib.NewReader)
+	return zc.uncompressDI(comp, 0, zlib.NewReader)
 } //                                                                  Uncompress
 
-// uncompressDI is only used by Uncompress() and provides parameters
-// for dependency injection, to enable mocking during testing.
+// uncompressLimit works like Uncompress(), but returns errUncompressedSize
+// if the uncompressed size stored after the compressed bytes exceeds
+// 'limit', before allocating anything for the result.
+func (zc *zlibCompressor) uncompressLimit(comp []byte, limit int) (
+	[]byte, error,
+) {
+	return zc.uncompressDI(comp, limit, zlib.NewReader)
+} //                                                             uncompressLimit
+
+// uncompressDI is only used by Uncompress() and uncompressLimit() and
+// provides parameters for dependency injection, to enable mocking during
+// testing. If 'limit' is more than zero, no more than 'limit' bytes are
+// uncompressed.
 func (*zlibCompressor) uncompressDI(
 	comp []byte,
+	limit int,
 	newReadCloser func(io.Reader) (io.ReadCloser, error),
 ) ([]byte, error) {
 	nc := len(comp)
@@ -67,20 +79,28 @@ func (*zlibCompressor) uncompressDI(
 		return nil, makeError(0xE41C29, "invalid 'comp'")
 	}
 	// read uncompressed data size (stored at the end of compressed bytes)
-	// to know the array size for the result
+	// to know the array size for the result. It's sent by the peer, so
+	// check it against the limit before allocating
 	nu := int64(binary.LittleEndian.Uint32(comp[nc-4:]))
+	if limit > 0 && nu > int64(limit) {
+		return nil, errUncompressedSize
+	}
 	comp = comp[:nc-4]
 	//
-	reader, err := newReadCloser(bytes.NewReader(comp))
+	rc, err := newReadCloser(bytes.NewReader(comp))
 	if err != nil {
 		return nil, makeError(0xE07EE6, err)
 	}
+	reader := io.Reader(rc)
+	if limit > 0 {
+		reader = io.LimitReader(rc, int64(limit)+1)
+	}
 	buf := bytes.NewBuffer(make([]byte, 0, nu))
 	_, err = io.CopyN(buf, reader, nu)
 	if err != nil {
 		return nil, makeError(0xE6A29D, err)
 	}
-	err = reader.Close()
+	err = rc.Close()
 	if err != nil {
 		return nil, makeError(0xE45AF8, err)
 	}
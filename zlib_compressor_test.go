@@ -76,7 +76,7 @@ func Test_zlibCompressor_5(t *testing.T) {
 	newMockReadCloser := func(io.Reader) (io.ReadCloser, error) {
 		return &mockReadCloser{failRead: true}, nil
 	}
-	uncomp, err := zc.uncompressDI(comp, newMockReadCloser)
+	uncomp, err := zc.uncompressDI(comp, 0, newMockReadCloser)
 	if uncomp != nil {
 		t.Error("0xE3DA4F")
 	}
@@ -91,7 +91,7 @@ func Test_zlibCompressor_6(t *testing.T) {
 	newMockReadCloser := func(io.Reader) (io.ReadCloser, error) {
 		return &mockReadCloser{failClose: true}, nil
 	}
-	uncomp, err := zc.uncompressDI(comp, newMockReadCloser)
+	uncomp, err := zc.uncompressDI(comp, 0, newMockReadCloser)
 	if uncomp != nil {
 		t.Error("0xEF3A01")
 	}
@@ -100,6 +100,34 @@ func Test_zlibCompressor_6(t *testing.T) {
 	}
 }
 
+// uncompressLimit() must refuse a declared size over the limit before
+// allocating it, and never uncompress more than the limit
+func Test_zlibCompressor_7(t *testing.T) {
+	zc := zlibCompressor{}
+	comp := zCompress(t)
+	forged := append(append([]byte{}, comp[:len(comp)-4]...),
+		0xFF, 0xFF, 0xFF, 0xFF)
+	uncomp, err := zc.uncompressLimit(forged, 1024)
+	if uncomp != nil || err != errUncompressedSize {
+		t.Error("0xEFE0DA", "wrong error:", err)
+	}
+	bomb, _ := zc.Compress(make([]byte, 1024*1024))
+	uncomp, err = zc.uncompressLimit(bomb, 1024)
+	if uncomp != nil || err != errUncompressedSize {
+		t.Error("0xECFC92", "wrong error:", err)
+	}
+	// a bomb that declares a small size only yields that many bytes
+	small := append(bomb[:len(bomb)-4], 10, 0, 0, 0)
+	uncomp, err = zc.uncompressLimit(small, 1024)
+	if len(uncomp) != 10 || err != nil {
+		t.Error("0xE936CC", len(uncomp), err)
+	}
+	uncomp, err = zc.uncompressLimit(comp, len(zInput()))
+	if !bytes.Equal(uncomp, zInput()) || err != nil {
+		t.Error("0xED2CA8", err)
+	}
+}
+
 // -----------------------------------------------------------------------------
 
 // mockReadCloser is a mock io.ReadCloser with methods you can make fail.